@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/servers"
+)
+
+const (
+	// distributionUniform 表示每个 key 被访问的概率都是一样的。
+	distributionUniform = "uniform"
+
+	// distributionZipf 表示 key 的访问符合 zipf 分布，少数的 key 会被频繁访问，更接近真实的缓存场景。
+	distributionZipf = "zipf"
+)
+
+// benchOptions 是压测的选项配置。
+type benchOptions struct {
+	// Address 是压测的节点地址，tcp 类型的服务器会自动发现集群中的其他节点。
+	Address string
+
+	// ServerType 是服务器的类型，可以是 tcp 或者 http。
+	ServerType string
+
+	// Duration 是压测持续的时间。
+	Duration time.Duration
+
+	// Concurrency 是并发发送请求的协程个数。
+	Concurrency int
+
+	// ReadRatio 是读请求的比例，范围是 0 到 1，其他的都是写请求。
+	ReadRatio float64
+
+	// Keys 是 key 的个数，key 的格式是 bench:<序号>。
+	Keys int
+
+	// Distribution 是 key 的访问分布，可以是 uniform 或者 zipf。
+	Distribution string
+
+	// ZipfS 是 zipf 分布的参数，必须大于 1，越大访问就越集中在少数的 key 上。
+	ZipfS float64
+
+	// ValueSize 是写入的 value 大小，单位是字节。
+	ValueSize int
+
+	// TTL 是写入的数据的寿命，单位是秒，0 表示永不过期。
+	TTL int64
+
+	// Preload 表示压测之前是否先写入所有的 key，这样读请求才会命中。
+	Preload bool
+}
+
+// newBenchFlagSet 返回解析压测参数使用的 FlagSet，解析出来的配置会保存到 opts 中。
+func newBenchFlagSet(opts *benchOptions) *flag.FlagSet {
+	flags := flag.NewFlagSet(os.Args[0]+" bench", flag.ContinueOnError)
+	flags.StringVar(&opts.Address, "address", "127.0.0.1:5837", "The address of one node. Tcp client will discover the other nodes in cluster.")
+	flags.StringVar(&opts.ServerType, "serverType", "tcp", "The type of server (http, tcp).")
+	flags.DurationVar(&opts.Duration, "duration", 10*time.Second, "The duration of benchmark, such as 30s.")
+	flags.IntVar(&opts.Concurrency, "concurrency", 16, "The number of goroutines sending requests.")
+	flags.Float64Var(&opts.ReadRatio, "readRatio", 0.8, "The ratio of reads between 0 and 1. The others are writes.")
+	flags.IntVar(&opts.Keys, "keys", 10000, "The number of keys.")
+	flags.StringVar(&opts.Distribution, "distribution", distributionUniform, "The distribution of keys (uniform, zipf).")
+	flags.Float64Var(&opts.ZipfS, "zipfS", 1.1, "The s parameter of zipf distribution which must be greater than 1.")
+	flags.IntVar(&opts.ValueSize, "valueSize", 100, "The size of values. The unit is Byte.")
+	flags.Int64Var(&opts.TTL, "ttl", 0, "The ttl of values. The unit is Second and 0 means never expired.")
+	flags.BoolVar(&opts.Preload, "preload", true, "Set all keys before benchmark so reads will hit.")
+	return flags
+}
+
+// validate 校验压测的选项配置。
+func (o *benchOptions) validate() error {
+	if o.ServerType != "tcp" && o.ServerType != "http" {
+		return fmt.Errorf("invalid serverType %q: must be one of tcp and http", o.ServerType)
+	}
+
+	if o.Duration <= 0 {
+		return fmt.Errorf("invalid duration %v: must be greater than 0", o.Duration)
+	}
+
+	if o.Concurrency <= 0 {
+		return fmt.Errorf("invalid concurrency %d: must be greater than 0", o.Concurrency)
+	}
+
+	if o.ReadRatio < 0 || o.ReadRatio > 1 {
+		return fmt.Errorf("invalid readRatio %v: must be between 0 and 1", o.ReadRatio)
+	}
+
+	if o.Keys <= 0 {
+		return fmt.Errorf("invalid keys %d: must be greater than 0", o.Keys)
+	}
+
+	if o.Distribution != distributionUniform && o.Distribution != distributionZipf {
+		return fmt.Errorf("invalid distribution %q: must be one of %s and %s", o.Distribution, distributionUniform, distributionZipf)
+	}
+
+	if o.Distribution == distributionZipf && o.ZipfS <= 1 {
+		return fmt.Errorf("invalid zipfS %v: must be greater than 1", o.ZipfS)
+	}
+
+	if o.ValueSize < 0 {
+		return fmt.Errorf("invalid valueSize %d: must not be negative", o.ValueSize)
+	}
+
+	if o.TTL < 0 {
+		return fmt.Errorf("invalid ttl %d: must not be negative", o.TTL)
+	}
+	return nil
+}
+
+// benchTarget 是压测的对象，读取不存在的 key 不算错误。
+type benchTarget interface {
+	get(key string) error
+	set(key string, value []byte, ttl int64) error
+	Close() error
+}
+
+// tcpTarget 使用 TCP 客户端压测，请求会按照一致性哈希发送到 key 所属的节点上。
+type tcpTarget struct {
+	client *servers.TCPClient
+}
+
+func (tt *tcpTarget) get(key string) error {
+	_, err := tt.client.Get(key)
+	if errors.Is(err, servers.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (tt *tcpTarget) set(key string, value []byte, ttl int64) error {
+	return tt.client.Set(key, value, ttl)
+}
+
+func (tt *tcpTarget) Close() error {
+	return tt.client.Close()
+}
+
+// httpTarget 使用 HTTP 接口压测，所有的请求都会发送到同一个节点上。
+type httpTarget struct {
+	// baseURL 是缓存接口的地址前缀，比如 http://127.0.0.1:5837/v1/cache/。
+	baseURL string
+
+	client *http.Client
+}
+
+// newHTTPTarget 返回一个 HTTP 接口的压测对象，空闲连接数和并发数一样，避免频繁地建立连接。
+func newHTTPTarget(address string, concurrency int) *httpTarget {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	return &httpTarget{
+		baseURL: "http://" + address + "/" + servers.APIVersion + "/cache/",
+		client:  &http.Client{Transport: transport},
+	}
+}
+
+// do 发送一个请求，2xx 和 404 以外的响应都算错误。
+func (ht *httpTarget) do(request *http.Request) error {
+	response, err := ht.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// 需要读完响应才能复用连接
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode/100 != 2 && response.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+func (ht *httpTarget) get(key string) error {
+	request, err := http.NewRequest(http.MethodGet, ht.baseURL+url.PathEscape(key), nil)
+	if err != nil {
+		return err
+	}
+	return ht.do(request)
+}
+
+func (ht *httpTarget) set(key string, value []byte, ttl int64) error {
+	request, err := http.NewRequest(http.MethodPut, ht.baseURL+url.PathEscape(key), bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+
+	if ttl != 0 {
+		request.Header.Set("Ttl", strconv.FormatInt(ttl, 10))
+	}
+	return ht.do(request)
+}
+
+func (ht *httpTarget) Close() error {
+	ht.client.CloseIdleConnections()
+	return nil
+}
+
+// newBenchTarget 按照服务器的类型返回压测对象。
+func newBenchTarget(opts *benchOptions) (benchTarget, error) {
+	if opts.ServerType == "http" {
+		return newHTTPTarget(opts.Address, opts.Concurrency), nil
+	}
+
+	client, err := servers.NewTCPClientWithPoolSize(opts.Address, opts.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpTarget{client: client}, nil
+}
+
+// benchKey 返回序号对应的 key。
+func benchKey(no uint64) string {
+	return "bench:" + strconv.FormatUint(no, 10)
+}
+
+// newKeyGenerator 返回一个按照 opts 中的分布生成 key 的函数，r 不是并发安全的，所以每个协程都需要一个自己的生成器。
+func newKeyGenerator(opts *benchOptions, r *rand.Rand) func() string {
+	if opts.Distribution == distributionZipf {
+		zipf := rand.NewZipf(r, opts.ZipfS, 1, uint64(opts.Keys-1))
+		return func() string {
+			return benchKey(zipf.Uint64())
+		}
+	}
+
+	return func() string {
+		return benchKey(uint64(r.Intn(opts.Keys)))
+	}
+}
+
+// latencies 是一组请求的耗时。
+type latencies []time.Duration
+
+// percentile 返回第 p 百分位的耗时，调用之前需要先排好序。
+func (l latencies) percentile(p float64) time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+
+	index := int(float64(len(l))*p/100+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(l) {
+		index = len(l) - 1
+	}
+	return l[index]
+}
+
+// String 返回常用的百分位耗时。
+func (l latencies) String() string {
+	sort.Slice(l, func(i, j int) bool {
+		return l[i] < l[j]
+	})
+	return fmt.Sprintf("p50=%v p90=%v p99=%v p99.9=%v max=%v",
+		l.percentile(50), l.percentile(90), l.percentile(99), l.percentile(99.9), l.percentile(100))
+}
+
+// benchWorker 记录着一个压测协程的结果，只有这个协程会修改，所以不需要加锁。
+type benchWorker struct {
+	reads  latencies
+	writes latencies
+	errors int64
+
+	// lastError 是最近一次发生的错误，用于输出错误的原因。
+	lastError error
+}
+
+// run 不断地发送请求，直到 deadline。
+func (bw *benchWorker) run(target benchTarget, opts *benchOptions, seed int64, deadline time.Time) {
+	r := rand.New(rand.NewSource(seed))
+	nextKey := newKeyGenerator(opts, r)
+	value := make([]byte, opts.ValueSize)
+	r.Read(value)
+
+	for time.Now().Before(deadline) {
+		key := nextKey()
+		beginTime := time.Now()
+		var err error
+		if r.Float64() < opts.ReadRatio {
+			err = target.get(key)
+			bw.reads = append(bw.reads, time.Since(beginTime))
+		} else {
+			err = target.set(key, value, opts.TTL)
+			bw.writes = append(bw.writes, time.Since(beginTime))
+		}
+
+		if err != nil {
+			bw.errors++
+			bw.lastError = err
+		}
+	}
+}
+
+// preload 并发地写入所有的 key。
+func preload(target benchTarget, opts *benchOptions) error {
+	value := make([]byte, opts.ValueSize)
+	next := int64(-1)
+	errs := make(chan error, opts.Concurrency)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for no := atomic.AddInt64(&next, 1); no < int64(opts.Keys); no = atomic.AddInt64(&next, 1) {
+				if err := target.set(benchKey(uint64(no)), value, opts.TTL); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// runBench 使用 args 解析压测参数，压测之后把吞吐量和耗时的百分位输出到 writer 中，比如：
+//
+//	kafo bench -address 127.0.0.1:5837 -concurrency 32 -readRatio 0.9 -distribution zipf -duration 30s
+func runBench(writer io.Writer, args []string) error {
+	opts := &benchOptions{}
+	if err := newBenchFlagSet(opts).Parse(args); err != nil {
+		return err
+	}
+
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	target, err := newBenchTarget(opts)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	if opts.Preload {
+		fmt.Fprintf(writer, "Preloading %d keys...\n", opts.Keys)
+		if err = preload(target, opts); err != nil {
+			return fmt.Errorf("failed to preload keys: %v", err)
+		}
+	}
+
+	fmt.Fprintf(writer, "Running %s benchmark on %s for %v with %d goroutines, %.0f%% reads, %d %s keys and %d bytes values...\n",
+		opts.ServerType, opts.Address, opts.Duration, opts.Concurrency, opts.ReadRatio*100, opts.Keys, opts.Distribution, opts.ValueSize)
+
+	workers := make([]*benchWorker, opts.Concurrency)
+	beginTime := time.Now()
+	deadline := beginTime.Add(opts.Duration)
+	wg := &sync.WaitGroup{}
+	for i := range workers {
+		workers[i] = &benchWorker{}
+		wg.Add(1)
+		go func(worker *benchWorker, seed int64) {
+			defer wg.Done()
+			worker.run(target, opts, seed, deadline)
+		}(workers[i], beginTime.UnixNano()+int64(i))
+	}
+	wg.Wait()
+	elapsed := time.Since(beginTime)
+
+	var reads, writes latencies
+	var errs int64
+	var lastError error
+	for _, worker := range workers {
+		reads = append(reads, worker.reads...)
+		writes = append(writes, worker.writes...)
+		errs += worker.errors
+		if worker.lastError != nil {
+			lastError = worker.lastError
+		}
+	}
+
+	total := len(reads) + len(writes)
+	fmt.Fprintf(writer, "requests:   %d in %v\n", total, elapsed.Round(time.Millisecond))
+	fmt.Fprintf(writer, "throughput: %.1f ops/s\n", float64(total)/elapsed.Seconds())
+	fmt.Fprintf(writer, "reads:      %d\t%v\n", len(reads), reads)
+	fmt.Fprintf(writer, "writes:     %d\t%v\n", len(writes), writes)
+	if errs > 0 {
+		fmt.Fprintf(writer, "errors:     %d\tlast error: %v\n", errs, lastError)
+	} else {
+		fmt.Fprintf(writer, "errors:     0\n")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestLatenciesPercentile$
+func TestLatenciesPercentile(t *testing.T) {
+	l := latencies{}
+	for i := 100; i >= 1; i-- {
+		l = append(l, time.Duration(i)*time.Millisecond)
+	}
+
+	if s := l.String(); s != "p50=50ms p90=90ms p99=99ms p99.9=100ms max=100ms" {
+		t.Fatalf("latencies %s is wrong", s)
+	}
+}
+
+// go test -v -run=^TestRunBench$
+func TestRunBench(t *testing.T) {
+	lock := &sync.Mutex{}
+	methods := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		lock.Lock()
+		methods[request.Method]++
+		lock.Unlock()
+
+		if !strings.HasPrefix(request.URL.Path, "/v1/cache/bench:") {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	output := &bytes.Buffer{}
+	args := []string{"-serverType", "http", "-address", strings.TrimPrefix(server.URL, "http://"), "-duration", "200ms",
+		"-concurrency", "4", "-keys", "100", "-distribution", "zipf", "-readRatio", "0.5"}
+	if err := runBench(output, args); err != nil {
+		t.Fatal(err)
+	}
+
+	// 预先写入的 100 个 key 加上压测时的写请求
+	if methods[http.MethodPut] <= 100 || methods[http.MethodGet] == 0 {
+		t.Fatalf("requests %v are wrong", methods)
+	}
+
+	if !strings.Contains(output.String(), "errors:     0\n") {
+		t.Fatalf("output %s should have no errors", output)
+	}
+
+	if err := runBench(output, []string{"-readRatio", "2"}); err == nil {
+		t.Fatal("bench with readRatio 2 should fail")
+	}
+}
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"context"
 	"encoding/binary"
-
-	"github.com/FishGoddess/vex"
+	"encoding/json"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -14,71 +16,298 @@ const (
 	deleteCommand = byte(3)
 
 	statusCommand = byte(4)
+
+	pingCommand = byte(7)
+
+	nodesCommand = byte(5)
+
+	handshakeCommand = byte(8)
+
+	protocolVersion = byte(1)
+
+	featureTrace = "trace"
 )
 
+const (
+	// defaultConnections 是异步客户端默认的连接数。
+	defaultConnections = 4
+)
+
+// AsyncClient 是异步客户端，请求会先放入队列中，然后由多个工作协程通过各自的连接发送出去。
+// 每个连接都支持流水线，发送请求之后不需要等待响应就可以发送下一个请求，所以吞吐量不会受到网络往返时间的限制。
 type AsyncClient struct {
-	client *vex.Client
+	// clients 是异步客户端的所有连接，每个连接都有一个工作协程负责发送请求。
+	clients []*conn
 
 	requestChan chan *request
+
+	// trace 表示和服务端是否协商了追踪特性，协商了的话每个请求的第一个参数都是追踪 ID。
+	trace bool
+
+	// metrics 是客户端的指标钩子。
+	metrics Metrics
+
+	// requestTimeout 是请求在队列中等待发送的最长时间，小于等于 0 表示没有限制。
+	requestTimeout time.Duration
+
+	// queuePolicy 是请求队列满了之后的处理策略。
+	queuePolicy QueuePolicy
+
+	// rejected、dropped 和 expired 分别是被拒绝、被丢弃和过期的请求个数，需要使用原子操作访问。
+	rejected uint64
+
+	dropped uint64
+
+	expired uint64
 }
 
 func NewAsyncClient(address string) (*AsyncClient, error) {
-	client, err := vex.NewClient("tcp", address)
-	if err != nil {
-		return nil, err
+	return NewAsyncClientWith(address, defaultConnections)
+}
+
+// NewAsyncClientWith 返回一个异步客户端，connections 是和节点建立的连接数。
+func NewAsyncClientWith(address string, connections int) (*AsyncClient, error) {
+	options := DefaultOptions()
+	options.Connections = connections
+	return NewAsyncClientWithOptions(address, options)
+}
+
+// NewAsyncClientWithOptions 返回一个使用 options 配置的异步客户端。
+func NewAsyncClientWithOptions(address string, options Options) (*AsyncClient, error) {
+	if options.Connections <= 0 {
+		options.Connections = defaultConnections
+	}
+
+	if options.QueueSize <= 0 {
+		options.QueueSize = defaultQueueSize
 	}
 
 	c := &AsyncClient{
-		client:      client,
-		requestChan: make(chan *request, 163840),
+		clients:        make([]*conn, 0, options.Connections),
+		requestChan:    make(chan *request, options.QueueSize),
+		metrics:        noopMetrics{},
+		requestTimeout: options.RequestTimeout,
+		queuePolicy:    options.QueuePolicy,
 	}
+
+	for i := 0; i < options.Connections; i++ {
+		client, err := dial(address, options.TLSConfig)
+		if err != nil {
+			c.closeClients()
+			return nil, err
+		}
+		c.clients = append(c.clients, client)
+	}
+
+	if err := c.handshake(); err != nil {
+		c.closeClients()
+		return nil, err
+	}
+
 	c.handleRequest()
 	return c, nil
 }
 
+// handshake 和服务端协商协议版本和特性，目前只会协商追踪特性。
+// 协商的结果是针对连接的，所以每个连接都需要握手，连接的都是同一个节点，所以协商的结果也是一样的。
+func (ac *AsyncClient) handshake() error {
+	for _, client := range ac.clients {
+		body, err := client.do(handshakeCommand, [][]byte{{protocolVersion}, []byte(featureTrace)})
+		if err != nil {
+			return err
+		}
+
+		handshake := &handshake{}
+		if err = json.Unmarshal(body, handshake); err != nil {
+			return err
+		}
+
+		for _, feature := range handshake.Features {
+			ac.trace = ac.trace || feature == featureTrace
+		}
+	}
+	return nil
+}
+
+// handleRequest 为每个连接开启一个工作协程，从队列中取出请求并发送。
 func (ac *AsyncClient) handleRequest() {
-	go func() {
-		for request := range ac.requestChan {
-			body, err := ac.client.Do(request.command, request.args)
-			request.resultChan <- &Response{
-				Body: body,
-				Err:  err,
-			}
+	for _, client := range ac.clients {
+		go ac.work(client)
+	}
+}
+
+// work 不断地从队列中取出请求，并通过 client 发送出去，直到队列被关闭。
+func (ac *AsyncClient) work(client *conn) {
+	for request := range ac.requestChan {
+		// 调用方已经放弃了的请求就不需要发送了，直接返回错误，避免在队列积压的时候执行过时的请求
+		if err := request.expired(time.Now()); err != nil {
+			atomic.AddUint64(&ac.expired, 1)
+			ac.finish(request, err)
+			continue
 		}
-	}()
+
+		args := request.args
+		if ac.trace {
+			args = append([][]byte{[]byte(request.traceID)}, args...)
+		}
+
+		// 连接支持流水线，所以这里只负责发送请求，不需要等待响应，响应会由连接的读取协程写入结果通道
+		r := request
+		client.send(r.command, args, func(response *Response) {
+			ac.metrics.ObserveOperation(commandName(r.command), time.Since(r.beginTime), response.Err)
+			r.callback(response)
+		})
+	}
+}
+
+// submit 将请求放入队列，响应返回之后会调用 callback，队列满了之后的处理方式取决于 Options.QueuePolicy。
+// 请求发送之前 ctx 被取消或者在队列中等待超时了，请求就不会发送，callback 会收到对应的错误。
+// callback 是在连接的读取协程中调用的，所以不能阻塞，否则同一个连接上后续的响应都会被阻塞。
+func (ac *AsyncClient) submit(ctx context.Context, command byte, args [][]byte, callback func(response *Response)) {
+	r := &request{
+		command:   command,
+		args:      args,
+		traceID:   newTraceID(),
+		beginTime: time.Now(),
+		ctx:       ctx,
+		callback:  callback,
+	}
+
+	if ac.requestTimeout > 0 {
+		r.deadline = r.beginTime.Add(ac.requestTimeout)
+	}
+	ac.enqueue(r)
 }
 
-func (ac *AsyncClient) do(command byte, args [][]byte) <-chan *Response {
+func (ac *AsyncClient) do(ctx context.Context, command byte, args [][]byte) <-chan *Response {
 	resultChan := make(chan *Response, 1)
-	ac.requestChan <- &request{
-		command:    command,
-		args:       args,
-		resultChan: resultChan,
+	ac.submit(ctx, command, args, func(response *Response) {
+		resultChan <- response
+	})
+	return resultChan
+}
+
+// doContext 和 do 一样，只是如果在响应返回之前 ctx 被取消或者超时了，返回的通道中就会是 ctx 的错误。
+// 这时候请求如果还在队列中，就不会再发送了。
+func (ac *AsyncClient) doContext(ctx context.Context, command byte, args [][]byte) <-chan *Response {
+	if err := ctx.Err(); err != nil {
+		resultChan := make(chan *Response, 1)
+		resultChan <- &Response{Err: err}
+		return resultChan
+	}
+
+	// 永远不会被取消的 ctx 就不需要额外的协程去等待了
+	if ctx.Done() == nil {
+		return ac.do(ctx, command, args)
 	}
+
+	responseChan := ac.do(ctx, command, args)
+	resultChan := make(chan *Response, 1)
+	go func() {
+		select {
+		case response := <-responseChan:
+			resultChan <- response
+		case <-ctx.Done():
+			resultChan <- &Response{Err: ctx.Err()}
+		}
+	}()
 	return resultChan
 }
 
 func (ac *AsyncClient) Get(key string) <-chan *Response {
-	return ac.do(getCommand, [][]byte{[]byte(key)})
+	return ac.GetContext(context.Background(), key)
+}
+
+// GetContext 和 Get 一样，只是会响应 ctx 的取消和超时，避免一个很慢的节点一直阻塞调用方。
+func (ac *AsyncClient) GetContext(ctx context.Context, key string) <-chan *Response {
+	return ac.doContext(ctx, getCommand, [][]byte{[]byte(key)})
 }
 
 func (ac *AsyncClient) Set(key string, value []byte, ttl int64) <-chan *Response {
+	return ac.SetContext(context.Background(), key, value, ttl)
+}
+
+// SetContext 和 Set 一样，只是会响应 ctx 的取消和超时。
+func (ac *AsyncClient) SetContext(ctx context.Context, key string, value []byte, ttl int64) <-chan *Response {
 	ttlBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
-	return ac.do(setCommand, [][]byte{
+	return ac.doContext(ctx, setCommand, [][]byte{
 		ttlBytes, []byte(key), value,
 	})
 }
 
 func (ac *AsyncClient) Delete(key string) <-chan *Response {
-	return ac.do(deleteCommand, [][]byte{[]byte(key)})
+	return ac.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext 和 Delete 一样，只是会响应 ctx 的取消和超时。
+func (ac *AsyncClient) DeleteContext(ctx context.Context, key string) <-chan *Response {
+	return ac.doContext(ctx, deleteCommand, [][]byte{[]byte(key)})
+}
+
+// GetAsync 获取指定 key 的 value，响应返回之后会调用 callback，不需要为每个请求开启一个协程等待响应。
+// callback 是在连接的读取协程中调用的，所以不能阻塞，耗时的处理需要交给其他协程。
+func (ac *AsyncClient) GetAsync(key string, callback func(response *Response)) {
+	ac.submit(context.Background(), getCommand, [][]byte{[]byte(key)}, callback)
+}
+
+// SetAsync 添加一个键值对到缓存中，响应返回之后会调用 callback，callback 不能阻塞。
+func (ac *AsyncClient) SetAsync(key string, value []byte, ttl int64, callback func(response *Response)) {
+	ttlBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
+	ac.submit(context.Background(), setCommand, [][]byte{ttlBytes, []byte(key), value}, callback)
+}
+
+// DeleteAsync 删除指定 key 的 value，响应返回之后会调用 callback，callback 不能阻塞。
+func (ac *AsyncClient) DeleteAsync(key string, callback func(response *Response)) {
+	ac.submit(context.Background(), deleteCommand, [][]byte{[]byte(key)}, callback)
+}
+
+// GetFuture 获取指定 key 的 value，返回一个 Future，适合发起大量请求之后使用 WaitAll 统一等待。
+func (ac *AsyncClient) GetFuture(key string) *Future {
+	future := newFuture()
+	ac.GetAsync(key, future.complete)
+	return future
+}
+
+// SetFuture 添加一个键值对到缓存中，返回一个 Future。
+func (ac *AsyncClient) SetFuture(key string, value []byte, ttl int64) *Future {
+	future := newFuture()
+	ac.SetAsync(key, value, ttl, future.complete)
+	return future
+}
+
+// DeleteFuture 删除指定 key 的 value，返回一个 Future。
+func (ac *AsyncClient) DeleteFuture(key string) *Future {
+	future := newFuture()
+	ac.DeleteAsync(key, future.complete)
+	return future
 }
 
 func (ac *AsyncClient) Status() <-chan *Response {
-	return ac.do(statusCommand, nil)
+	return ac.do(context.Background(), statusCommand, nil)
+}
+
+func (ac *AsyncClient) Ping() <-chan *Response {
+	return ac.do(context.Background(), pingCommand, nil)
+}
+
+// SetMetrics 设置客户端的指标钩子，需要在使用客户端之前设置。
+func (ac *AsyncClient) SetMetrics(metrics Metrics) {
+	ac.metrics = metrics
 }
 
 func (ac *AsyncClient) Close() error {
 	close(ac.requestChan)
-	return ac.client.Close()
+	return ac.closeClients()
+}
+
+// closeClients 关闭所有的连接。
+func (ac *AsyncClient) closeClients() (err error) {
+	for _, client := range ac.clients {
+		if closeErr := client.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
 }
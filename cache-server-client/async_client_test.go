@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"strconv"
 	"testing"
 	"time"
@@ -45,3 +46,24 @@ func TestAsyncClientPerformance(t *testing.T) {
 
 	time.Sleep(time.Second)
 }
+
+// go test -v -run=^TestRequestExpired$
+func TestRequestExpired(t *testing.T) {
+	now := time.Now()
+	r := &request{ctx: context.Background()}
+	if err := r.expired(now); err != nil {
+		t.Fatalf("request without deadline should not expire but got %v", err)
+	}
+
+	r.deadline = now.Add(-time.Millisecond)
+	if err := r.expired(now); err != ErrRequestExpired {
+		t.Fatalf("err %v should be %v", err, ErrRequestExpired)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = &request{ctx: ctx, deadline: now.Add(time.Second)}
+	if err := r.expired(now); err != context.Canceled {
+		t.Fatalf("err %v should be %v", err, context.Canceled)
+	}
+}
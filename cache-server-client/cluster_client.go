@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"stathat.com/c/consistent"
+)
+
+const (
+	// defaultVirtualNodes 是服务端没有返回一致性哈希配置时使用的虚拟节点数，和服务端的默认值一致。
+	defaultVirtualNodes = 1024
+
+	// hashCRC32 是使用 CRC32 作为哈希函数的一致性哈希，也是服务端目前唯一支持的哈希函数。
+	hashCRC32 = "crc32"
+)
+
+var (
+	// ErrUnsupportedHash 表示服务端使用了客户端不支持的一致性哈希函数。
+	ErrUnsupportedHash = errors.New("unsupported consistent hash function")
+
+	errNoNodes = errors.New("no nodes in cluster")
+)
+
+// ClusterClient 是感知集群拓扑的同步客户端，会使用和服务端一样的一致性哈希配置计算 key 所属的节点，
+// 然后把请求直接发送到这个节点上，省去了服务端重定向的开销。
+// 集群拓扑变化导致请求被重定向的时候，会刷新集群的节点并重试一次。
+// 每个节点只会建立一个连接，连接支持流水线，所以多个协程可以并发使用同一个 ClusterClient。
+type ClusterClient struct {
+	options Options
+
+	// circle 是一致性哈希环，和服务端的配置一样。
+	circle *consistent.Consistent
+
+	// clients 存储着到每个节点的连接，key 是节点地址。
+	clients map[string]*conn
+
+	// lock 用于保护 clients。
+	lock *sync.Mutex
+
+	// invoker 是串上了所有拦截器的命令执行函数。
+	invoker Invoker
+}
+
+// NewClusterClient 返回一个集群客户端，address 是集群中任意一个节点的地址，只有 Connections 以外的选项会被使用。
+func NewClusterClient(address string, options Options) (*ClusterClient, error) {
+	cc := &ClusterClient{
+		options: options,
+		circle:  consistent.New(),
+		clients: map[string]*conn{},
+		lock:    &sync.Mutex{},
+	}
+	cc.invoker = chainInterceptors(options.Interceptors, cc.invoke)
+
+	client, err := cc.clientOf(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cc.adoptHashConfig(client); err != nil {
+		cc.Close()
+		return nil, err
+	}
+
+	if err = cc.refresh(client); err != nil {
+		cc.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// adoptHashConfig 通过握手获取服务端的一致性哈希配置，并使用这个配置初始化一致性哈希环。
+func (cc *ClusterClient) adoptHashConfig(client *conn) error {
+	body, err := client.do(handshakeCommand, [][]byte{{protocolVersion}})
+	if err != nil {
+		return err
+	}
+
+	handshake := &handshake{}
+	if err = json.Unmarshal(body, handshake); err != nil {
+		return err
+	}
+
+	cc.circle.NumberOfReplicas = defaultVirtualNodes
+	if handshake.Hash != nil {
+		if handshake.Hash.Function != hashCRC32 {
+			return ErrUnsupportedHash
+		}
+		cc.circle.NumberOfReplicas = handshake.Hash.VirtualNodes
+	}
+	return nil
+}
+
+// refresh 从 client 连接的节点上获取集群的节点，并更新一致性哈希环。
+func (cc *ClusterClient) refresh(client *conn) error {
+	body, err := client.do(nodesCommand, nil)
+	if err != nil {
+		return err
+	}
+
+	var nodes []string
+	if err = json.Unmarshal(body, &nodes); err != nil {
+		return err
+	}
+
+	if len(nodes) == 0 {
+		return errNoNodes
+	}
+	cc.circle.Set(nodes)
+	return nil
+}
+
+// Refresh 重新获取集群的节点，会依次尝试已知的节点，直到有一个节点返回了结果。
+func (cc *ClusterClient) Refresh() (err error) {
+	err = errNoNodes
+	for _, node := range cc.circle.Members() {
+		var client *conn
+		if client, err = cc.clientOf(node); err != nil {
+			continue
+		}
+
+		if err = cc.refresh(client); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// clientOf 返回到节点的连接，连接不存在或者已经不可用了就建立一个新的连接。
+func (cc *ClusterClient) clientOf(node string) (*conn, error) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	if client, ok := cc.clients[node]; ok && !client.broken() {
+		return client, nil
+	}
+
+	client, err := dial(node, cc.options.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	cc.clients[node] = client
+	return client, nil
+}
+
+// do 将命令发送到 key 所属的节点上，命令会先经过所有的拦截器，最后由 invoke 执行。
+func (cc *ClusterClient) do(key string, command byte, args [][]byte) ([]byte, error) {
+	return cc.invoker(context.Background(), &Call{
+		Operation: commandName(command),
+		Key:       key,
+		Args:      args,
+		command:   command,
+	})
+}
+
+// invoke 将命令发送到 key 所属的节点上，被重定向的话会刷新集群的节点，然后到重定向的节点上重试一次。
+func (cc *ClusterClient) invoke(ctx context.Context, call *Call) ([]byte, error) {
+	command, args := call.command, call.Args
+	node, err := cc.circle.Get(call.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := cc.clientOf(node)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := client.do(command, args)
+	redirect := &ErrRedirect{}
+	if !errors.As(err, &redirect) {
+		return body, err
+	}
+
+	cc.refresh(client)
+	if client, err = cc.clientOf(redirect.Node); err != nil {
+		return nil, err
+	}
+	return client.do(command, args)
+}
+
+// Get 返回指定 key 的 value，key 不存在的时候返回 ErrNotFound。
+func (cc *ClusterClient) Get(key string) ([]byte, error) {
+	return cc.do(key, getCommand, [][]byte{[]byte(key)})
+}
+
+// Set 添加一个键值对到缓存中，ttl 的单位是秒，0 表示永不过期。
+func (cc *ClusterClient) Set(key string, value []byte, ttl int64) error {
+	ttlBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
+	_, err := cc.do(key, setCommand, [][]byte{ttlBytes, []byte(key), value})
+	return err
+}
+
+// Delete 删除指定 key 的 value。
+func (cc *ClusterClient) Delete(key string) error {
+	_, err := cc.do(key, deleteCommand, [][]byte{[]byte(key)})
+	return err
+}
+
+// Nodes 返回客户端已知的集群节点。
+func (cc *ClusterClient) Nodes() []string {
+	return cc.circle.Members()
+}
+
+// Close 关闭到所有节点的连接。
+func (cc *ClusterClient) Close() error {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	for node, client := range cc.clients {
+		client.Close()
+		delete(cc.clients, node)
+	}
+	return nil
+}
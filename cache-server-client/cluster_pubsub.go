@@ -0,0 +1,288 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	publishCommand = byte(16)
+
+	subscribeCommand = byte(17)
+
+	featurePubSub = "pubsub"
+
+	// subscriptionBufferSize 是订阅的消息通道的大小，通道满了之后会暂停接收消息，直到调用方取走消息。
+	subscriptionBufferSize = 1024
+
+	// resubscribeDuration 是订阅的连接断开之后，重新订阅之前等待的时间。
+	resubscribeDuration = time.Second
+
+	// unknownCursor 表示还没有游标，服务端收到这个游标会马上返回当前的游标。
+	unknownCursor = ^uint64(0)
+)
+
+var (
+	// ErrPubSubNotSupported 表示节点不支持发布订阅。
+	ErrPubSubNotSupported = errors.New("node doesn't support pub/sub")
+
+	errNoChannels = errors.New("subscribe needs at least one channel")
+
+	// errChannelMoved 表示订阅的频道已经不属于订阅的节点了，需要重新分组订阅。
+	errChannelMoved = errors.New("channel moved to another node")
+)
+
+// Message 是发布到频道中的一条消息。
+type Message struct {
+	// Seq 是消息在发布节点上的序号，同一个节点上的序号是递增的。
+	Seq uint64 `json:"seq"`
+
+	// Channel 是消息所属的频道。
+	Channel string `json:"channel"`
+
+	// Payload 是消息的内容。
+	Payload []byte `json:"payload"`
+}
+
+// pollResult 是 subscribe 命令的结果。
+type pollResult struct {
+	Cursor uint64 `json:"cursor"`
+
+	Messages []*Message `json:"messages"`
+}
+
+// Subscription 是对一个或多个频道的订阅，消息通过 Messages 返回的通道接收。
+// 频道会按照所属节点分组，每个节点使用一个单独的连接订阅，因为订阅会一直阻塞到有新的消息为止。
+// 连接断开之后会自动重新订阅，并且会带上之前的游标，所以节点保留的消息范围内不会丢失消息。
+// 集群拓扑变化导致频道换了节点的话，会从新的节点上当前的消息开始订阅，这段时间内发布的消息可能会丢失。
+type Subscription struct {
+	client *ClusterClient
+
+	channels []string
+
+	messages chan *Message
+
+	// cursors 存储着每个节点上的游标，key 是节点地址，只会在订阅的协程中访问。
+	cursors map[string]uint64
+
+	// err 是导致订阅结束的错误，订阅被关闭的话是 nil。
+	err error
+
+	// lock 用于保护 err。
+	lock *sync.Mutex
+
+	ctx context.Context
+
+	cancel context.CancelFunc
+}
+
+// nodeSubscription 是订阅某个节点的结果。
+type nodeSubscription struct {
+	node string
+
+	cursor uint64
+
+	err error
+}
+
+// Publish 发布一条消息到频道中，频道和 key 一样属于一致性哈希选出来的节点。
+func (cc *ClusterClient) Publish(channel string, payload []byte) error {
+	_, err := cc.do(channel, publishCommand, [][]byte{[]byte(channel), payload})
+	return err
+}
+
+// Subscribe 订阅一个或多个频道，只会收到订阅之后发布的消息，不再需要的时候需要调用 Close 关闭订阅。
+func (cc *ClusterClient) Subscribe(channels ...string) (*Subscription, error) {
+	if len(channels) == 0 {
+		return nil, errNoChannels
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Subscription{
+		client:   cc,
+		channels: channels,
+		messages: make(chan *Message, subscriptionBufferSize),
+		cursors:  map[string]uint64{},
+		lock:     &sync.Mutex{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	go s.run()
+	return s, nil
+}
+
+// Messages 返回接收消息的通道，订阅被关闭或者因为错误结束之后通道会被关闭。
+func (s *Subscription) Messages() <-chan *Message {
+	return s.messages
+}
+
+// Err 返回导致订阅结束的错误，比如节点不支持发布订阅。
+func (s *Subscription) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Close 关闭订阅。
+func (s *Subscription) Close() error {
+	s.cancel()
+	return nil
+}
+
+// run 不断地按照当前的集群拓扑订阅所有频道，直到订阅被关闭或者节点不支持发布订阅。
+func (s *Subscription) run() {
+	defer close(s.messages)
+	for {
+		err := s.subscribeOnce()
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		if err == ErrPubSubNotSupported {
+			s.lock.Lock()
+			s.err = err
+			s.lock.Unlock()
+			return
+		}
+
+		// 频道换了节点的话马上重新订阅，否则等待一段时间，避免节点不可用的时候一直重试
+		if err == errChannelMoved {
+			continue
+		}
+
+		select {
+		case <-time.After(resubscribeDuration):
+			s.client.Refresh()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// subscribeOnce 将频道按照所属节点分组，每个节点开启一个协程订阅，直到任意一个节点的订阅结束，返回结束的原因。
+func (s *Subscription) subscribeOnce() error {
+	groups := map[string][]string{}
+	for _, channel := range s.channels {
+		node, err := s.client.circle.Get(channel)
+		if err != nil {
+			return err
+		}
+		groups[node] = append(groups[node], channel)
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	results := make(chan *nodeSubscription, len(groups))
+	for node, channels := range groups {
+		cursor, ok := s.cursors[node]
+		if !ok {
+			cursor = unknownCursor
+		}
+		go func(node string, channels []string, cursor uint64) {
+			cursor, err := s.subscribeOn(ctx, node, channels, cursor)
+			results <- &nodeSubscription{node: node, cursor: cursor, err: err}
+		}(node, channels, cursor)
+	}
+
+	// 任意一个节点的订阅结束了，就结束所有节点的订阅，并记录下每个节点的游标，重新订阅的时候继续使用
+	var err error
+	for i := 0; i < len(groups); i++ {
+		result := <-results
+		s.cursors[result.node] = result.cursor
+		if i == 0 {
+			err = result.err
+			cancel()
+		}
+	}
+	return err
+}
+
+// subscribeOn 使用单独的连接不断地从 node 上订阅 channels，直到出错或者 ctx 被取消，返回最后的游标。
+func (s *Subscription) subscribeOn(ctx context.Context, node string, channels []string, cursor uint64) (uint64, error) {
+	client, err := dial(node, s.client.options.TLSConfig)
+	if err != nil {
+		return cursor, err
+	}
+	defer client.Close()
+
+	// ctx 被取消的时候关闭连接，结束正在等待的订阅
+	go func() {
+		<-ctx.Done()
+		client.Close()
+	}()
+
+	if err = checkPubSub(client); err != nil {
+		return cursor, err
+	}
+
+	for {
+		body, err := client.do(subscribeCommand, subscribeArgs(cursor, channels))
+		redirect := &ErrRedirect{}
+		if errors.As(err, &redirect) {
+			s.client.Refresh()
+			return cursor, errChannelMoved
+		}
+		if err != nil {
+			return cursor, err
+		}
+
+		result := &pollResult{}
+		if err = json.Unmarshal(body, result); err != nil {
+			return cursor, err
+		}
+
+		for _, message := range result.Messages {
+			select {
+			case s.messages <- message:
+				cursor = message.Seq
+			case <-ctx.Done():
+				return cursor, ctx.Err()
+			}
+		}
+		cursor = result.Cursor
+
+		for _, channel := range channels {
+			if owner, err := s.client.circle.Get(channel); err == nil && owner != node {
+				return cursor, errChannelMoved
+			}
+		}
+	}
+}
+
+// checkPubSub 通过握手确认节点支持发布订阅。
+func checkPubSub(client *conn) error {
+	body, err := client.do(handshakeCommand, [][]byte{{protocolVersion}, []byte(featurePubSub)})
+	if err != nil {
+		return err
+	}
+
+	handshake := &handshake{}
+	if err = json.Unmarshal(body, handshake); err != nil {
+		return err
+	}
+
+	for _, feature := range handshake.Features {
+		if feature == featurePubSub {
+			return nil
+		}
+	}
+	return ErrPubSubNotSupported
+}
+
+// subscribeArgs 返回 subscribe 命令的参数，依次是游标和订阅的频道。
+func subscribeArgs(cursor uint64, channels []string) [][]byte {
+	cursorBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(cursorBytes, cursor)
+
+	args := make([][]byte, 0, len(channels)+1)
+	args = append(args, cursorBytes)
+	for _, channel := range channels {
+		args = append(args, []byte(channel))
+	}
+	return args
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	mgetCommand = byte(10)
+
+	keysCommand = byte(15)
+
+	incrCommand = byte(18)
+
+	expireCommand = byte(19)
+
+	existsCommand = byte(20)
+)
+
+var (
+	errInvalidNumberBody = errors.New("invalid number in response body")
+)
+
+// MGetResult 是批量获取中一个 key 的结果。
+type MGetResult struct {
+	// Value 是 key 对应的数据。
+	Value []byte `json:"value,omitempty"`
+
+	// Found 表示 key 对应的数据是否存在。
+	Found bool `json:"found,omitempty"`
+
+	// Node 不为空说明这个 key 不属于连接的节点，需要到 Node 节点上获取。
+	Node string `json:"node,omitempty"`
+
+	// Error 是获取这个 key 时发生的错误。
+	Error string `json:"error,omitempty"`
+}
+
+// KeysPage 是分页获取 key 的一页结果。
+type KeysPage struct {
+	// Keys 是这一页的 key。
+	Keys []string `json:"keys"`
+
+	// Cursor 是下一页的游标，为 0 说明已经没有下一页了。
+	Cursor int `json:"cursor"`
+}
+
+// encodeInt64 使用大端的形式编码数字，服务端也是以大端的形式读取的。
+func encodeInt64(n int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+// Incr 将 key 对应的数据当作十进制的整数加上 delta，delta 为负数就是自减，使用 Response.ToInt64 获取加上之后的值。
+func (ac *AsyncClient) Incr(key string, delta int64) <-chan *Response {
+	return ac.IncrContext(context.Background(), key, delta)
+}
+
+// IncrContext 和 Incr 一样，只是会响应 ctx 的取消和超时。
+func (ac *AsyncClient) IncrContext(ctx context.Context, key string, delta int64) <-chan *Response {
+	return ac.doContext(ctx, incrCommand, [][]byte{[]byte(key), encodeInt64(delta)})
+}
+
+// Expire 重新设置 key 对应的数据的寿命，单位是秒，0 表示永不过期，使用 Response.ToBool 获取数据是否存在。
+func (ac *AsyncClient) Expire(key string, ttl int64) <-chan *Response {
+	return ac.ExpireContext(context.Background(), key, ttl)
+}
+
+// ExpireContext 和 Expire 一样，只是会响应 ctx 的取消和超时。
+func (ac *AsyncClient) ExpireContext(ctx context.Context, key string, ttl int64) <-chan *Response {
+	return ac.doContext(ctx, expireCommand, [][]byte{[]byte(key), encodeInt64(ttl)})
+}
+
+// Exists 判断 key 对应的数据是否存在，不会延长数据的寿命，使用 Response.ToBool 获取结果。
+func (ac *AsyncClient) Exists(key string) <-chan *Response {
+	return ac.ExistsContext(context.Background(), key)
+}
+
+// ExistsContext 和 Exists 一样，只是会响应 ctx 的取消和超时。
+func (ac *AsyncClient) ExistsContext(ctx context.Context, key string) <-chan *Response {
+	return ac.doContext(ctx, existsCommand, [][]byte{[]byte(key)})
+}
+
+// MGet 批量获取多个 key 的数据，使用 Response.ToMGet 获取每个 key 的结果，结果的顺序和 keys 一致。
+// 异步客户端只连接了一个节点，不属于这个节点的 key 会在结果中告知所属的节点。
+func (ac *AsyncClient) MGet(keys ...string) <-chan *Response {
+	return ac.MGetContext(context.Background(), keys...)
+}
+
+// MGetContext 和 MGet 一样，只是会响应 ctx 的取消和超时。
+func (ac *AsyncClient) MGetContext(ctx context.Context, keys ...string) <-chan *Response {
+	args := make([][]byte, len(keys))
+	for i, key := range keys {
+		args[i] = []byte(key)
+	}
+	return ac.doContext(ctx, mgetCommand, args)
+}
+
+// Keys 从 cursor 开始分页获取连接的节点上以 prefix 开头的 key，match 是 path.Match 格式的通配符，为空表示不过滤。
+// 第一次获取传 0 即可，使用 Response.ToKeys 获取这一页的结果，返回的游标为 0 说明已经获取完了。
+func (ac *AsyncClient) Keys(prefix string, cursor int, count int, match string) <-chan *Response {
+	return ac.KeysContext(context.Background(), prefix, cursor, count, match)
+}
+
+// KeysContext 和 Keys 一样，只是会响应 ctx 的取消和超时。
+func (ac *AsyncClient) KeysContext(ctx context.Context, prefix string, cursor int, count int, match string) <-chan *Response {
+	args := [][]byte{[]byte(prefix), encodeInt64(int64(cursor)), encodeInt64(int64(count))}
+	if match != "" {
+		args = append(args, []byte(match))
+	}
+	return ac.doContext(ctx, keysCommand, args)
+}
+
+// ToInt64 将 incr 命令的响应解析成数字。
+func (r *Response) ToInt64() (int64, error) {
+	if r.Err != nil {
+		return 0, r.Err
+	}
+
+	if len(r.Body) != 8 {
+		return 0, errInvalidNumberBody
+	}
+	return int64(binary.BigEndian.Uint64(r.Body)), nil
+}
+
+// ToBool 将 exists 和 expire 命令的响应解析成数据是否存在。
+func (r *Response) ToBool() (bool, error) {
+	if errors.Is(r.Err, ErrNotFound) {
+		return false, nil
+	}
+
+	if r.Err != nil {
+		return false, r.Err
+	}
+
+	// expire 命令成功的时候响应体是空的，exists 命令的响应体是一个字节
+	return len(r.Body) == 0 || r.Body[0] == 1, nil
+}
+
+// ToMGet 将 mget 命令的响应解析成每个 key 的结果。
+func (r *Response) ToMGet() ([]MGetResult, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	var results []MGetResult
+	return results, json.Unmarshal(r.Body, &results)
+}
+
+// ToKeys 将 keys 命令的响应解析成一页 key。
+func (r *Response) ToKeys() (*KeysPage, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	page := &KeysPage{}
+	return page, json.Unmarshal(r.Body, page)
+}
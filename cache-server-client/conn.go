@@ -0,0 +1,222 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// 这里的传输协议和服务端的协议是一致的，为了让客户端不依赖服务端的代码，所以单独实现了一份。
+// 请求帧的格式：版本号（1 字节）+ 命令（1 字节）+ 参数个数（4 字节）+ { 参数长度（4 字节）+ 参数内容 }...
+// 响应帧的格式：版本号（1 字节）+ 答复码（1 字节）+ 响应体长度（4 字节）+ 响应体内容
+const (
+	frameVersion = byte(1)
+
+	headerLength = 6
+
+	argLengthSize = 4
+
+	errorReply = byte(1)
+)
+
+var (
+	errFrameVersionMismatch = errors.New("protocol version between client and server doesn't match")
+
+	errConnClosed = errors.New("connection is closed")
+)
+
+// conn 是支持流水线的客户端连接，请求写入之后不需要等待响应就可以继续写入下一个请求。
+// 服务端会按照请求的顺序返回响应，所以读取协程只需要按照顺序把响应交给等待的请求就可以了。
+type conn struct {
+	conn net.Conn
+
+	writer *bufio.Writer
+
+	// writeLock 保证请求的入队和写入是原子的。
+	writeLock *sync.Mutex
+
+	// pendingLock 保护 pending 和 err，读取协程只会使用这个锁。
+	pendingLock *sync.Mutex
+
+	pendingCond *sync.Cond
+
+	// pending 是按照发送顺序排列的等待响应的请求，每个请求都对应一个接收响应的函数。
+	pending []func(response *Response)
+
+	// err 是导致连接不可用的错误。
+	err error
+}
+
+// dial 连接到 address 并返回一个连接，tlsConfig 不为 nil 的话会使用 TLS 建立连接。
+func dial(address string, tlsConfig *tls.Config) (*conn, error) {
+	var c net.Conn
+	var err error
+	if tlsConfig != nil {
+		c, err = tls.Dial("tcp", address, tlsConfig)
+	} else {
+		c, err = net.Dial("tcp", address)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	pendingLock := &sync.Mutex{}
+	pc := &conn{
+		conn:        c,
+		writer:      bufio.NewWriter(c),
+		writeLock:   &sync.Mutex{},
+		pendingLock: pendingLock,
+		pendingCond: sync.NewCond(pendingLock),
+	}
+	go pc.readLoop()
+	return pc, nil
+}
+
+// readLoop 按照顺序读取响应并交给等待的请求。
+func (c *conn) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		c.pendingLock.Lock()
+		for len(c.pending) == 0 && c.err == nil {
+			c.pendingCond.Wait()
+		}
+
+		if c.err != nil {
+			c.pendingLock.Unlock()
+			return
+		}
+		deliver := c.pending[0]
+		c.pendingLock.Unlock()
+
+		reply, body, err := readResponseFrom(reader)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.pendingLock.Lock()
+		c.pending = c.pending[1:]
+		c.pendingLock.Unlock()
+
+		if reply == errorReply {
+			deliver(&Response{Body: body, Err: newReplyError(string(body))})
+			continue
+		}
+		deliver(&Response{Body: body})
+	}
+}
+
+// fail 关闭连接，并通知所有等待响应的请求。
+func (c *conn) fail(err error) {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	c.conn.Close()
+	for _, deliver := range c.pending {
+		deliver(&Response{Err: err})
+	}
+	c.pending = nil
+	c.pendingCond.Broadcast()
+}
+
+// send 发送请求，响应会交给 deliver 处理，deliver 会在读取协程中被调用，所以不能阻塞。
+func (c *conn) send(command byte, args [][]byte, deliver func(response *Response)) {
+	c.writeLock.Lock()
+	c.pendingLock.Lock()
+	if c.err != nil {
+		c.pendingLock.Unlock()
+		c.writeLock.Unlock()
+		deliver(&Response{Err: c.err})
+		return
+	}
+
+	// 先入队再写入，否则响应可能会在入队之前就被读取协程读到
+	c.pending = append(c.pending, deliver)
+	c.pendingCond.Signal()
+	c.pendingLock.Unlock()
+
+	err := writeRequestTo(c.writer, command, args)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	c.writeLock.Unlock()
+
+	if err != nil {
+		c.fail(err)
+	}
+}
+
+// do 发送请求并等待响应。
+func (c *conn) do(command byte, args [][]byte) ([]byte, error) {
+	resultChan := make(chan *Response, 1)
+	c.send(command, args, func(response *Response) {
+		resultChan <- response
+	})
+	response := <-resultChan
+	return response.Body, response.Err
+}
+
+// broken 返回连接是否已经不可用了。
+func (c *conn) broken() bool {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+	return c.err != nil
+}
+
+func (c *conn) Close() error {
+	c.fail(errConnClosed)
+	return nil
+}
+
+// writeRequestTo 将命令和参数编码成请求帧写入 writer。
+func writeRequestTo(writer io.Writer, command byte, args [][]byte) error {
+	size := headerLength
+	for _, arg := range args {
+		size += argLengthSize + len(arg)
+	}
+
+	request := make([]byte, headerLength, size)
+	request[0] = frameVersion
+	request[1] = command
+	binary.BigEndian.PutUint32(request[2:], uint32(len(args)))
+
+	argLength := make([]byte, argLengthSize)
+	for _, arg := range args {
+		binary.BigEndian.PutUint32(argLength, uint32(len(arg)))
+		request = append(request, argLength...)
+		request = append(request, arg...)
+	}
+
+	_, err := writer.Write(request)
+	return err
+}
+
+// readResponseFrom 从 reader 中读取响应帧，并解析出答复码和响应体。
+func readResponseFrom(reader io.Reader) (reply byte, body []byte, err error) {
+	header := make([]byte, headerLength)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return errorReply, nil, err
+	}
+
+	if header[0] != frameVersion {
+		return errorReply, nil, errFrameVersionMismatch
+	}
+
+	body = make([]byte, binary.BigEndian.Uint32(header[2:]))
+	_, err = io.ReadFull(reader, body)
+	if err != nil {
+		return errorReply, nil, err
+	}
+	return header[1], body, nil
+}
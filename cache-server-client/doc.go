@@ -0,0 +1,16 @@
+// Package client 是 kafo 的 Go 客户端，是一个独立的模块，只依赖标准库和一致性哈希库，不依赖服务端的代码：
+//
+//	go get github.com/herrhu97/go-distributed-cache/cache-server-client
+//
+// 这个包提供了两种客户端：
+//
+//   - AsyncClient 是连接单个节点的异步客户端，支持流水线，适合高吞吐量的场景。
+//   - ClusterClient 是感知集群拓扑的同步客户端，会根据一致性哈希把请求发送到 key 所属的节点上。
+//
+// 模块使用语义化版本，版本号以 cache-server-client/vX.Y.Z 的格式打在仓库的标签上，和服务端的版本是独立的。
+package client
+
+const (
+	// Version 是客户端模块的版本。
+	Version = "v1.0.0"
+)
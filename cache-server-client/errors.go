@@ -0,0 +1,85 @@
+package client
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// redirectPrefix 是服务端返回的重定向信息的前缀。
+	redirectPrefix = "redirect to node"
+)
+
+var (
+	// ErrNotFound 表示 key 对应的数据不存在。
+	ErrNotFound = errors.New("not found")
+
+	// ErrServerBusy 表示服务端正在持久化，暂时拒绝了这个操作，可以稍后重试。
+	ErrServerBusy = errors.New("server is busy")
+
+	// ErrEntryTooLarge 表示数据太大了，超过了服务端的限制。
+	ErrEntryTooLarge = errors.New("entry is too large")
+
+	// ErrNotInteger 表示自增的数据不是整数，或者自增之后溢出了。
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+
+	// ErrRequestExpired 表示请求在队列中等待的时间超过了 Options.RequestTimeout，所以没有发送。
+	ErrRequestExpired = errors.New("request expired in queue")
+
+	// ErrQueueFull 表示请求队列满了，请求被拒绝或者被丢弃了，具体取决于 Options.QueuePolicy。
+	ErrQueueFull = errors.New("request queue is full")
+)
+
+// ErrRedirect 表示 key 不属于请求的节点，需要到 Node 节点上执行。
+type ErrRedirect struct {
+	// Node 是 key 所属的节点。
+	Node string
+}
+
+// Error 返回错误信息，和服务端返回的重定向信息是一样的。
+func (er *ErrRedirect) Error() string {
+	return redirectPrefix + " " + er.Node
+}
+
+// replyErrors 是服务端返回的错误信息对应的类型化错误，为了不依赖服务端的代码，这里直接使用了错误信息。
+var replyErrors = map[string]error{
+	"not found":              ErrNotFound,
+	"server busy persisting": ErrServerBusy,
+	"the entry size will exceed if you set this entry":             ErrEntryTooLarge,
+	"the entry count of segment will exceed if you set this entry": ErrEntryTooLarge,
+	"argument size exceeds the limit":                              ErrEntryTooLarge,
+	"frame size exceeds the limit":                                 ErrEntryTooLarge,
+	"value is not an integer or out of range":                      ErrNotInteger,
+}
+
+// replyError 是服务端通过响应帧返回的错误。
+type replyError struct {
+	message string
+
+	// err 是错误信息对应的类型化错误，不能识别的错误信息是 nil。
+	err error
+}
+
+// newReplyError 使用服务端返回的错误信息创建一个错误，能识别的错误信息会包装成对应的类型化错误，
+// 调用方可以使用 errors.Is 和 errors.As 判断错误的类型，而不需要解析错误信息。
+func newReplyError(message string) *replyError {
+	if err, ok := replyErrors[message]; ok {
+		return &replyError{message: message, err: err}
+	}
+
+	if strings.HasPrefix(message, redirectPrefix) {
+		node := strings.TrimSpace(strings.TrimPrefix(message, redirectPrefix))
+		return &replyError{message: message, err: &ErrRedirect{Node: node}}
+	}
+	return &replyError{message: message}
+}
+
+// Error 返回错误信息。
+func (re *replyError) Error() string {
+	return re.message
+}
+
+// Unwrap 返回错误信息对应的类型化错误。
+func (re *replyError) Unwrap() error {
+	return re.err
+}
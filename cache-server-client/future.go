@@ -0,0 +1,109 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrFutureTimeout 表示等待响应超时了，注意请求本身并没有被取消，之后还是可以继续等待的。
+	ErrFutureTimeout = errors.New("timed out waiting for the future")
+)
+
+// Future 代表一个还没返回的响应，可以在发起大量请求之后再统一等待。
+type Future struct {
+	// done 会在响应返回之后被关闭。
+	done chan struct{}
+
+	// response 是返回的响应，只有 done 被关闭之后才能读取。
+	response *Response
+}
+
+// newFuture 返回一个还没完成的 Future。
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// complete 使用 response 完成 Future，只能调用一次。
+func (f *Future) complete(response *Response) {
+	f.response = response
+	close(f.done)
+}
+
+// Done 返回一个在响应返回之后会被关闭的通道，可以和其他通道一起 select。
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait 等待响应返回，timeout 小于等于 0 表示一直等待，超时的话返回 ErrFutureTimeout。
+// 返回的错误就是响应中的错误，多次调用返回的都是同一个响应。
+func (f *Future) Wait(timeout time.Duration) (*Response, error) {
+	if timeout <= 0 {
+		<-f.done
+		return f.response, f.response.Err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-f.done:
+		return f.response, f.response.Err
+	case <-timer.C:
+		return nil, ErrFutureTimeout
+	}
+}
+
+// FuturesError 是等待多个 Future 时部分 Future 失败的错误，Errors 记录着每个失败的 Future 的下标和对应的错误。
+type FuturesError struct {
+	// Errors 记录着每个失败的 Future 对应的错误，key 是 Future 在参数中的下标。
+	Errors map[int]error
+}
+
+// Error 返回错误信息，会列出所有失败的 Future。
+func (fe *FuturesError) Error() string {
+	indexes := make([]int, 0, len(fe.Errors))
+	for index := range fe.Errors {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	messages := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		messages = append(messages, fmt.Sprintf("#%d: %v", index, fe.Errors[index]))
+	}
+	return fmt.Sprintf("%d futures failed (%s)", len(indexes), strings.Join(messages, "; "))
+}
+
+// WaitAll 等待所有的 Future 返回，如果有 Future 失败了，返回的错误是 *FuturesError。
+func WaitAll(futures ...*Future) error {
+	return WaitAllTimeout(0, futures...)
+}
+
+// WaitAllTimeout 和 WaitAll 一样，只是所有的 Future 一共最多等待 timeout 时间，timeout 小于等于 0 表示一直等待。
+// 超时的 Future 在 *FuturesError 中对应的错误是 ErrFutureTimeout。
+func WaitAllTimeout(timeout time.Duration, futures ...*Future) error {
+	deadline := time.Now().Add(timeout)
+	errs := make(map[int]error)
+	for i, future := range futures {
+		remaining := time.Duration(0)
+		if timeout > 0 {
+			// 已经超时了也还要检查剩下的 Future，已经返回的 Future 不应该算作超时
+			if remaining = time.Until(deadline); remaining <= 0 {
+				remaining = time.Nanosecond
+			}
+		}
+
+		if _, err := future.Wait(remaining); err != nil {
+			errs[i] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return &FuturesError{Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestFuture$
+func TestFuture(t *testing.T) {
+	future := newFuture()
+	if _, err := future.Wait(10 * time.Millisecond); err != ErrFutureTimeout {
+		t.Fatalf("err %v should be %v", err, ErrFutureTimeout)
+	}
+
+	future.complete(&Response{Body: []byte("value")})
+	response, err := future.Wait(0)
+	if err != nil || string(response.Body) != "value" {
+		t.Fatalf("response %+v should be value and err %v should be nil", response, err)
+	}
+}
+
+// go test -v -run=^TestWaitAll$
+func TestWaitAll(t *testing.T) {
+	futures := []*Future{newFuture(), newFuture(), newFuture()}
+	futures[0].complete(&Response{})
+	futures[1].complete(&Response{Err: ErrNotFound})
+
+	err := WaitAllTimeout(10*time.Millisecond, futures...)
+	futuresErr := &FuturesError{}
+	if !errors.As(err, &futuresErr) || len(futuresErr.Errors) != 2 {
+		t.Fatalf("err %v should be a FuturesError with 2 errors", err)
+	}
+
+	if futuresErr.Errors[1] != ErrNotFound || futuresErr.Errors[2] != ErrFutureTimeout {
+		t.Fatalf("errors %v don't match", futuresErr.Errors)
+	}
+
+	futures[2].complete(&Response{})
+	if err = WaitAll(futures[0], futures[2]); err != nil {
+		t.Fatal(err)
+	}
+}
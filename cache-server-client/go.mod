@@ -0,0 +1,5 @@
+module github.com/herrhu97/go-distributed-cache/cache-server-client
+
+go 1.14
+
+require stathat.com/c/consistent v1.0.0
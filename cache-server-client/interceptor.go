@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+)
+
+// Call 是 ClusterClient 执行的一个命令。
+type Call struct {
+	// Operation 是命令的名字，比如 get、set 和 delete。
+	Operation string
+
+	// Key 是命令操作的 key，发布消息的时候是频道。
+	Key string
+
+	// Args 是命令的参数，拦截器可以修改参数，但是需要保证参数的格式是正确的。
+	Args [][]byte
+
+	// command 是命令的编号。
+	command byte
+}
+
+// Invoker 执行一个命令并返回响应体。
+type Invoker func(ctx context.Context, call *Call) ([]byte, error)
+
+// Interceptor 是 ClusterClient 的拦截器，可以在命令执行的前后加入自己的逻辑，比如打印日志、上报指标、链路追踪和重试，
+// 调用 next 就是继续执行命令，不调用的话命令就不会执行。拦截器包裹的是整个操作，包括了重定向之后的重试。
+type Interceptor func(ctx context.Context, call *Call, next Invoker) ([]byte, error)
+
+// chainInterceptors 将拦截器串成一个 Invoker，第一个拦截器在最外层，最后调用的是 invoker。
+func chainInterceptors(interceptors []Interceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, call *Call) ([]byte, error) {
+			return interceptor(ctx, call, next)
+		}
+	}
+	return invoker
+}
@@ -0,0 +1,45 @@
+package client
+
+import "time"
+
+// Metrics 是客户端的指标钩子，用户可以实现这个接口，把客户端的指标上报到自己的监控系统中。
+// 这些方法会在读取响应的协程中被调用，所以实现需要是并发安全的，并且不能阻塞。
+type Metrics interface {
+	// ObserveOperation 上报一次操作的耗时和结果，耗时包括了请求在队列中等待的时间，err 为 nil 表示操作成功了。
+	ObserveOperation(operation string, duration time.Duration, err error)
+}
+
+// noopMetrics 是什么都不做的指标钩子，没有设置指标钩子的时候使用。
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveOperation(operation string, duration time.Duration, err error) {}
+
+// commandName 返回命令的名字。
+func commandName(command byte) string {
+	switch command {
+	case getCommand:
+		return "get"
+	case setCommand:
+		return "set"
+	case deleteCommand:
+		return "delete"
+	case statusCommand:
+		return "status"
+	case pingCommand:
+		return "ping"
+	case publishCommand:
+		return "publish"
+	case mgetCommand:
+		return "mget"
+	case keysCommand:
+		return "keys"
+	case incrCommand:
+		return "incr"
+	case expireCommand:
+		return "expire"
+	case existsCommand:
+		return "exists"
+	default:
+		return "unknown"
+	}
+}
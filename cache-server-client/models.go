@@ -1,6 +1,10 @@
 package client
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
 
 type Status struct {
 	Count int `json:"count"`
@@ -8,6 +12,12 @@ type Status struct {
 	KeySize int64 `json:"keySize"`
 
 	ValueSize int64 `json:"valueSize"`
+
+	// KeyLengths 和 ValueSizes 是 key 长度和 value 大小的分布，桶的上界依次是 16B、64B、256B、1KB、4KB、16KB、64KB、256KB 和 1MB，
+	// 最后一个元素是超过 1MB 的数据个数，旧版本的服务端不会返回这两个字段。
+	KeyLengths []int64 `json:"keyLengths"`
+
+	ValueSizes []int64 `json:"valueSizes"`
 }
 
 type request struct {
@@ -15,7 +25,46 @@ type request struct {
 
 	args [][]byte
 
-	resultChan chan *Response
+	traceID string
+
+	beginTime time.Time
+
+	// ctx 被取消或者超时的时候，还在队列中的请求就不会再发送了。
+	ctx context.Context
+
+	// deadline 是请求在队列中等待发送的截止时间，为零值表示没有限制。
+	deadline time.Time
+
+	// callback 会在响应返回之后被调用。
+	callback func(response *Response)
+}
+
+// expired 返回请求是否已经不需要发送了，也就是调用方已经放弃了这个请求，返回的是放弃的原因。
+func (r *request) expired(now time.Time) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	if !r.deadline.IsZero() && now.After(r.deadline) {
+		return ErrRequestExpired
+	}
+	return nil
+}
+
+type handshake struct {
+	Version byte `json:"version"`
+
+	Features []string `json:"features"`
+
+	// Hash 是服务端使用的一致性哈希配置，旧版本的服务端不会返回这个配置。
+	Hash *hashConfig `json:"hash,omitempty"`
+}
+
+// hashConfig 是服务端使用的一致性哈希配置。
+type hashConfig struct {
+	Function string `json:"function"`
+
+	VirtualNodes int `json:"virtualNodes"`
 }
 
 type Response struct {
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Options 是异步客户端的选项配置。
+type Options struct {
+	// Connections 是和节点建立的连接数。
+	Connections int
+
+	// TLSConfig 不为 nil 的话会使用 TLS 连接节点。
+	// 服务端本身还不支持 TLS，所以需要在节点前面部署 TLS 代理，比如 stunnel。
+	// 如果没有设置 ServerName，就会使用节点地址中的主机名校验证书。
+	TLSConfig *tls.Config
+
+	// RequestTimeout 是请求在队列中等待发送的最长时间，超过之后请求就不会再发送了，而是直接返回 ErrRequestExpired。
+	// 队列中积压了大量请求的时候，可以避免在调用方已经放弃之后很久才执行请求，小于等于 0 表示没有限制。
+	RequestTimeout time.Duration
+
+	// QueueSize 是请求队列的大小，小于等于 0 就使用默认的大小。
+	QueueSize int
+
+	// QueuePolicy 是请求队列满了之后的处理策略，默认是 QueueBlock。
+	QueuePolicy QueuePolicy
+
+	// Interceptors 是 ClusterClient 的拦截器，按照顺序包裹每个命令的执行，第一个拦截器在最外层。
+	// AsyncClient 是基于回调的，不会使用拦截器，可以使用 SetMetrics 上报指标。
+	Interceptors []Interceptor
+}
+
+// DefaultOptions 返回默认的选项配置。
+func DefaultOptions() Options {
+	return Options{
+		Connections: defaultConnections,
+		QueueSize:   defaultQueueSize,
+		QueuePolicy: QueueBlock,
+	}
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultQueueSize 是异步客户端默认的请求队列大小。
+	defaultQueueSize = 163840
+)
+
+// QueuePolicy 是请求队列满了之后的处理策略。
+type QueuePolicy int
+
+const (
+	// QueueBlock 会阻塞提交请求的协程，直到队列有空位或者请求的 ctx 被取消，这是默认的策略。
+	QueueBlock QueuePolicy = iota
+
+	// QueueFailFast 会直接拒绝新的请求，请求的结果是 ErrQueueFull。
+	QueueFailFast
+
+	// QueueDropOldest 会丢弃队列中最早的请求，腾出位置给新的请求，被丢弃的请求的结果是 ErrQueueFull。
+	// 适合只关心最新数据的场景，比如上报状态。
+	QueueDropOldest
+)
+
+// String 返回策略的名字。
+func (qp QueuePolicy) String() string {
+	switch qp {
+	case QueueBlock:
+		return "block"
+	case QueueFailFast:
+		return "fail-fast"
+	case QueueDropOldest:
+		return "drop-oldest"
+	default:
+		return "unknown"
+	}
+}
+
+// QueueStats 是请求队列的情况。
+type QueueStats struct {
+	// Depth 是队列中等待发送的请求个数。
+	Depth int `json:"depth"`
+
+	// Capacity 是队列的容量。
+	Capacity int `json:"capacity"`
+
+	// Rejected 是因为队列满了而被拒绝的请求个数。
+	Rejected uint64 `json:"rejected"`
+
+	// Dropped 是因为队列满了而被丢弃的旧请求个数。
+	Dropped uint64 `json:"dropped"`
+
+	// Expired 是在队列中等待超时或者 ctx 被取消而没有发送的请求个数。
+	Expired uint64 `json:"expired"`
+}
+
+// enqueue 按照队列的策略将请求放入队列，请求没能放入队列的话，会直接调用请求的 callback 返回错误。
+func (ac *AsyncClient) enqueue(r *request) {
+	switch ac.queuePolicy {
+	case QueueFailFast:
+		select {
+		case ac.requestChan <- r:
+		default:
+			atomic.AddUint64(&ac.rejected, 1)
+			ac.finish(r, ErrQueueFull)
+		}
+	case QueueDropOldest:
+		for {
+			select {
+			case ac.requestChan <- r:
+				return
+			default:
+			}
+
+			// 工作协程也在从队列中取请求，所以这里取不到也没关系，重新尝试放入就行了
+			select {
+			case old := <-ac.requestChan:
+				atomic.AddUint64(&ac.dropped, 1)
+				ac.finish(old, ErrQueueFull)
+			default:
+			}
+		}
+	default:
+		select {
+		case ac.requestChan <- r:
+		case <-r.ctx.Done():
+			atomic.AddUint64(&ac.expired, 1)
+			ac.finish(r, r.ctx.Err())
+		}
+	}
+}
+
+// finish 使用 err 结束一个没有发送的请求。
+func (ac *AsyncClient) finish(r *request, err error) {
+	ac.metrics.ObserveOperation(commandName(r.command), time.Since(r.beginTime), err)
+	r.callback(&Response{Err: err})
+}
+
+// QueueStats 返回请求队列的情况，可以定期采集队列的深度，用于发现请求积压。
+func (ac *AsyncClient) QueueStats() QueueStats {
+	return QueueStats{
+		Depth:    len(ac.requestChan),
+		Capacity: cap(ac.requestChan),
+		Rejected: atomic.LoadUint64(&ac.rejected),
+		Dropped:  atomic.LoadUint64(&ac.dropped),
+		Expired:  atomic.LoadUint64(&ac.expired),
+	}
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"testing"
+)
+
+// newQueueClient 返回一个没有连接的异步客户端，请求只会留在队列中，用于测试队列的策略。
+func newQueueClient(size int, policy QueuePolicy) *AsyncClient {
+	return &AsyncClient{
+		requestChan: make(chan *request, size),
+		metrics:     noopMetrics{},
+		queuePolicy: policy,
+	}
+}
+
+// go test -v -run=^TestQueueFailFast$
+func TestQueueFailFast(t *testing.T) {
+	client := newQueueClient(1, QueueFailFast)
+	first := client.do(context.Background(), pingCommand, nil)
+	second := client.do(context.Background(), pingCommand, nil)
+
+	if response := <-second; response.Err != ErrQueueFull {
+		t.Fatalf("err %v should be %v", response.Err, ErrQueueFull)
+	}
+
+	select {
+	case response := <-first:
+		t.Fatalf("first request should be queued but got %+v", response)
+	default:
+	}
+
+	if stats := client.QueueStats(); stats.Depth != 1 || stats.Capacity != 1 || stats.Rejected != 1 {
+		t.Fatalf("stats %+v should have 1 depth, 1 capacity and 1 rejected", stats)
+	}
+}
+
+// go test -v -run=^TestQueueDropOldest$
+func TestQueueDropOldest(t *testing.T) {
+	client := newQueueClient(1, QueueDropOldest)
+	first := client.do(context.Background(), pingCommand, nil)
+	client.do(context.Background(), statusCommand, nil)
+
+	if response := <-first; response.Err != ErrQueueFull {
+		t.Fatalf("err %v should be %v", response.Err, ErrQueueFull)
+	}
+
+	if r := <-client.requestChan; r.command != statusCommand {
+		t.Fatalf("command %d in queue should be %d", r.command, statusCommand)
+	}
+
+	if stats := client.QueueStats(); stats.Dropped != 1 {
+		t.Fatalf("stats %+v should have 1 dropped", stats)
+	}
+}
+
+// go test -v -run=^TestQueueBlock$
+func TestQueueBlock(t *testing.T) {
+	client := newQueueClient(1, QueueBlock)
+	client.do(context.Background(), pingCommand, nil)
+
+	// 队列满了之后会一直阻塞，直到 ctx 被取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if response := <-client.do(ctx, pingCommand, nil); response.Err != context.Canceled {
+		t.Fatalf("err %v should be %v", response.Err, context.Canceled)
+	}
+
+	if stats := client.QueueStats(); stats.Depth != 1 || stats.Expired != 1 {
+		t.Fatalf("stats %+v should have 1 depth and 1 expired", stats)
+	}
+}
@@ -0,0 +1,150 @@
+package caches
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// arenaSlabSize 是 arena 每次向操作系统申请的内存大小，一块内存会被切分成同样大小的多个块。
+	arenaSlabSize = 1 << 20 // 1 MB
+
+	// arenaMinChunkSize 和 arenaMaxChunkSize 是 arena 中最小和最大的块大小，块大小都是 2 的幂。
+	// 比最大的块还大的数据不会存储在 arena 中，这种数据一般很少，使用 Go 的堆内存就可以了。
+	arenaMinChunkSize = 64
+
+	arenaMaxChunkSize = arenaSlabSize
+)
+
+// arena 是存储数据的内存分配器，使用的内存不是从 Go 的堆中分配的，所以 GC 不需要扫描和回收这些内存。
+// 内存是按照块大小分级管理的，每个数据占用一个刚好能放下它的块，释放的块会被同样大小的数据复用，申请到的内存不会还给操作系统。
+//
+// 读操作是不加锁访问数据的，所以不能在删除数据的时候就释放块，否则正在读取的数据可能被新的数据覆盖。
+// 块是在持有它的 arenaSlot 被 Go 回收的时候才释放的，只要还有数据引用着 arenaSlot，块就不会被复用，
+// 所以数据在释放锁之后被访问的话，需要复制一份，或者保证访问期间数据一直被引用着。
+type arena struct {
+	// classes 是每种块大小的块，下标 i 的块大小是 arenaMinChunkSize << i。
+	classes []arenaClass
+
+	// lock 保护 classes。
+	lock *sync.Mutex
+
+	// mapped 是向操作系统申请的内存大小，单位是字节。
+	mapped int64
+
+	// used 是正在被数据使用的块的大小，单位是字节。
+	used int64
+}
+
+// arenaClass 管理着同样大小的块。
+type arenaClass struct {
+	// free 是被释放之后可以复用的块。
+	free [][]byte
+
+	// slab 是最近申请的内存中还没有被切分出去的部分，没有可以复用的块的时候才会从这里切分，
+	// 这样就不需要在申请内存的时候就把所有的块都记录下来。
+	slab []byte
+}
+
+// arenaSlot 记录着一个数据在 arena 中占用的块，被 Go 回收的时候会把块还给 arena。
+type arenaSlot struct {
+	// arena 是块所属的 arena。
+	arena *arena
+
+	// chunk 是占用的整个块。
+	chunk []byte
+
+	// class 是块大小的级别。
+	class int
+}
+
+// newArena 返回一个空的 arena。
+func newArena() *arena {
+	classes := 0
+	for size := arenaMinChunkSize; size <= arenaMaxChunkSize; size <<= 1 {
+		classes++
+	}
+	return &arena{classes: make([]arenaClass, classes), lock: &sync.Mutex{}}
+}
+
+// classOf 返回可以放下 size 个字节的最小的块大小级别，放不下的话返回 -1。
+func classOf(size int) int {
+	class := 0
+	for chunkSize := arenaMinChunkSize; chunkSize < size; chunkSize <<= 1 {
+		class++
+	}
+
+	if arenaMinChunkSize<<class > arenaMaxChunkSize {
+		return -1
+	}
+	return class
+}
+
+// copyOf 把 data 复制到 arena 中，返回复制之后的数据和占用的块，放不下的数据会复制到 Go 的堆内存中，返回的块为 nil。
+func (a *arena) copyOf(data []byte) ([]byte, *arenaSlot) {
+	class := classOf(len(data))
+	if class < 0 {
+		return append([]byte(nil), data...), nil
+	}
+
+	slot := &arenaSlot{arena: a, chunk: a.alloc(class), class: class}
+	runtime.SetFinalizer(slot, (*arenaSlot).free)
+	return append(slot.chunk[:0:len(data)], data...), slot
+}
+
+// alloc 分配一个 class 级别的块，优先复用被释放的块，都用完了的话就申请一块新的内存。
+func (a *arena) alloc(class int) []byte {
+	chunkSize := arenaMinChunkSize << class
+	atomic.AddInt64(&a.used, int64(chunkSize))
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	c := &a.classes[class]
+	if n := len(c.free); n > 0 {
+		chunk := c.free[n-1]
+		c.free = c.free[:n-1]
+		return chunk
+	}
+
+	if len(c.slab) < chunkSize {
+		c.slab = mapSlab(arenaSlabSize)
+		atomic.AddInt64(&a.mapped, int64(len(c.slab)))
+	}
+
+	chunk := c.slab[:chunkSize:chunkSize]
+	c.slab = c.slab[chunkSize:]
+	return chunk
+}
+
+// free 把块还给 arena，由 Go 在回收 arenaSlot 的时候调用。
+func (s *arenaSlot) free() {
+	s.arena.lock.Lock()
+	c := &s.arena.classes[s.class]
+	c.free = append(c.free, s.chunk)
+	s.arena.lock.Unlock()
+	atomic.AddInt64(&s.arena.used, -int64(len(s.chunk)))
+}
+
+// ArenaStatus 是数据 arena 的内存使用情况，没有开启 ValueArena 的话都是 0。
+type ArenaStatus struct {
+	// Mapped 是向操作系统申请的内存大小，单位是字节，申请到的内存不会还给操作系统。
+	Mapped int64 `json:"mapped"`
+
+	// Used 是正在被数据使用的内存大小，单位是字节，块的大小是 2 的幂，所以会比数据的实际大小大一些。
+	Used int64 `json:"used"`
+}
+
+// status 返回 arena 的内存使用情况，a 为 nil 的话返回空的使用情况。
+func (a *arena) status() ArenaStatus {
+	if a == nil {
+		return ArenaStatus{}
+	}
+	return ArenaStatus{Mapped: atomic.LoadInt64(&a.mapped), Used: atomic.LoadInt64(&a.used)}
+}
+
+// ArenaStatus 返回数据 arena 的内存使用情况。
+func (c *Cache) ArenaStatus() ArenaStatus {
+	return c.arena.status()
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package caches
+
+// mapSlab 在不支持 mmap 的平台上使用 Go 的堆内存，数据还是会被集中存储在大块的内存中，只是 GC 仍然需要管理这些内存。
+func mapSlab(size int) []byte {
+	return make([]byte, size)
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package caches
+
+import "syscall"
+
+// mapSlab 使用匿名的 mmap 向操作系统申请 size 个字节的内存，这块内存不在 Go 的堆中，GC 不会扫描也不会回收它。
+// 申请失败的话说明内存已经耗尽了，和 Go 分配内存失败一样直接 panic。
+func mapSlab(size int) []byte {
+	slab, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		panic("caches: failed to map arena slab: " + err.Error())
+	}
+	return slab
+}
@@ -0,0 +1,93 @@
+package caches
+
+import (
+	"context"
+	"time"
+)
+
+// Loader 从后端存储中加载缓存中没有的数据，比如从数据库中查询。
+// 设置了 Loader 之后，Get 未命中的时候就会调用它加载数据并存储到缓存中，同一个 key 的并发未命中只会加载一次。
+type Loader interface {
+	// Load 加载 key 对应的数据，返回的数据会以 ttl 存储到缓存中，ok 为 false 表示数据在后端也不存在。
+	Load(ctx context.Context, key string) (value []byte, ttl int64, ok bool, err error)
+}
+
+// Writer 把缓存中的写操作同步到后端存储中。
+// 设置了 Writer 之后，Set 会先写入后端存储，成功之后才会写入缓存，Delete 也是一样，所以后端存储失败的话缓存不会被修改。
+// SetIf 是在写入缓存之后才写入后端存储的，因为判断和写入缓存需要在 segment 的锁中原子地完成，写入后端存储失败的话会删除缓存中的数据，下一次读取的时候重新加载。
+// Incr、Expire 和事务中的操作不会同步到后端存储。
+type Writer interface {
+	// Write 把 key 对应的数据写入后端存储。
+	Write(ctx context.Context, key string, value []byte, ttl int64) error
+
+	// Delete 从后端存储中删除 key 对应的数据，数据不存在的话不算失败。
+	Delete(ctx context.Context, key string) error
+}
+
+// backing 是缓存使用的后端存储。
+type backing struct {
+	// loader 是加载数据使用的 Loader，没有设置的话为 nil。
+	loader Loader
+
+	// writer 是同步写操作使用的 Writer，没有设置的话为 nil。
+	writer Writer
+}
+
+// skipWriterKey 是 context 中表示不需要同步到后端存储的 key。
+type skipWriterKey struct{}
+
+// WithoutWriter 返回一个不会把写操作同步到后端存储的 ctx，比如副本节点复制所属节点的写操作时，后端存储已经由所属节点写过了。
+func WithoutWriter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipWriterKey{}, true)
+}
+
+// SetLoader 设置缓存未命中时加载数据使用的 Loader，传 nil 表示不再加载。
+// 需要在缓存开始提供服务之前设置，和 SetWriter 并发调用的话其中一个设置可能会丢失。
+func (c *Cache) SetLoader(loader Loader) {
+	b := *c.backing()
+	b.loader = loader
+	c.backingStore.Store(&b)
+}
+
+// SetWriter 设置同步写操作使用的 Writer，传 nil 表示不再同步。
+// 需要在缓存开始提供服务之前设置，和 SetLoader 并发调用的话其中一个设置可能会丢失。
+func (c *Cache) SetWriter(writer Writer) {
+	b := *c.backing()
+	b.writer = writer
+	c.backingStore.Store(&b)
+}
+
+// backing 返回缓存当前使用的后端存储。
+func (c *Cache) backing() *backing {
+	if b, ok := c.backingStore.Load().(*backing); ok {
+		return b
+	}
+	return &backing{}
+}
+
+// writerOf 返回 ctx 中的写操作需要同步到的 Writer，不需要同步的话返回 nil。
+func (c *Cache) writerOf(ctx context.Context) Writer {
+	if skip, _ := ctx.Value(skipWriterKey{}).(bool); skip {
+		return nil
+	}
+	return c.backing().writer
+}
+
+// loadEntry 使用 Loader 加载 key 对应的数据，并返回数据和它的元数据，没有设置 Loader 或者数据不存在的话返回 false。
+func (c *Cache) loadEntry(ctx context.Context, key string) (entry *Entry, ok bool, err error) {
+	loader := c.backing().loader
+	if loader == nil {
+		return nil, false, nil
+	}
+
+	loaded, err := c.load(ctx, key, loader.Load)
+	if err != nil || !loaded.ok {
+		return nil, false, err
+	}
+
+	// 加载到的数据可能因为太大没有存储成功，这时使用加载的结果构造元数据
+	if entry, ok = c.segmentOf(key).getEntry(key); ok {
+		return entry, true, nil
+	}
+	return &Entry{Key: key, Value: loaded.value, Ttl: loaded.ttl, Ctime: time.Now().Unix()}, true, nil
+}
@@ -1,12 +1,36 @@
 package caches
 
 import (
+	"context"
+	"errors"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+	"github.com/herrhu97/go-distributed-cache/tracing"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrBusyDumping 表示缓存正在持久化，当前的操作被拒绝了，调用方可以稍后重试。
+	// 现在持久化的时候不会再拒绝任何操作了，保留这个错误是为了识别旧版本的服务器返回的错误。
+	ErrBusyDumping = errors.New("server busy persisting")
+
+	// ErrEntryTooLarge 表示添加这个键值对之后，数据占用的空间会超过 MaxEntrySize 的限制。
+	ErrEntryTooLarge = errors.New("the entry size will exceed if you set this entry")
+
+	// ErrTooManyEntries 表示添加这个键值对之后，segment 中的数据个数会超过 MaxEntriesPerSegment 或者 MaxCount 的限制。
+	ErrTooManyEntries = errors.New("the entry count of segment will exceed if you set this entry")
 )
 
 // Cache是一个结构体，用于封装缓存底层结构的
+//
+// 过期的数据是惰性清理的，除了 GC 任务定期清理之外，读操作发现数据过期了也会顺便清理掉。
+// 读操作是在读锁中发现数据过期的，清理的时候需要重新获取写锁，这期间数据可能被其他写操作覆盖了，
+// 所以清理之前会在写锁中确认 key 对应的还是读到的那个数据（指针相同，或者创建时间、寿命和数据都相同）并且仍然是过期的，
+// 保证并发写入的新数据即使也已经过期了，也不会被读操作误删。
 type Cache struct {
 	// segmentSize 是segment的数量
 	segmentSize int
@@ -14,13 +38,73 @@ type Cache struct {
 	// segments 存储着所有的segment实例
 	segments []*segment
 
-	// options 缓存配置。
-	options *Options
+	// options 缓存配置，存储的是 *Options，热加载的时候会整体替换成新的配置，所以读取的时候不需要加锁。
+	options atomic.Value
+
+	// dumpLock 保证同一时间只有一个持久化任务在执行。
+	// 持久化的时候是逐个 segment 复制数据的，每个 segment 只会在复制的时候持有读锁，所以持久化不会阻塞其他的读写操作。
+	dumpLock *sync.Mutex
+
+	// reloadLock 保证热加载配置的时候读取、修改和替换配置是原子的，否则并发的热加载会丢失其中一些修改。
+	reloadLock *sync.Mutex
+
+	// gcRecorder 记录着 GC 任务的执行情况。
+	gcRecorder *taskRecorder
+
+	// dumpRecorder 记录着持久化任务的执行情况。
+	dumpRecorder *taskRecorder
+
+	// closed 会在缓存关闭的时候被关闭，用于通知定时任务退出。
+	closed chan struct{}
+
+	// closeOnce 保证 closed 只会被关闭一次。
+	closeOnce *sync.Once
+
+	// gcReloaded 和 dumpReloaded 会在热加载之后收到通知，用于让定时任务使用新的时间间隔。
+	gcReloaded chan struct{}
+
+	dumpReloaded chan struct{}
+
+	// hooks 是注册的数据变更钩子，所有的 segment 共用一份。
+	hooks *hooks
+
+	// arena 是存储数据的内存分配器，所有的 segment 共用一份，没有开启 ValueArena 的话为 nil。
+	arena *arena
+
+	// loads 用于合并并发加载同一个 key 的调用。
+	loads *helpers.SingleFlight
+
+	// backingStore 存储的是 *backing，设置 Loader 或者 Writer 的时候会整体替换，所以读取的时候不需要加锁。
+	backingStore atomic.Value
 
-	// dumping 标识当前缓存是否除于持久化状态，1表示除于持久化状态
-	// 因为现在的 cache 是没有全局锁的，而持久化需要记录下当前的状态，不允许有更新，所以使用一个变量记录着，
-	// 如果处于持久化状态，就让所有更新操作进入自旋状态，等待持久化完成再进行。
-	dumping int32
+	// negatives 记录着后端存储中不存在的 key，只有设置了 NegativeTTL 才会使用。
+	negatives *negatives
+
+	// refreshes 记录着正在后台重新加载的旧数据的 key，保证同一个 key 只有一个协程在重新加载。
+	refreshes *sync.Map
+
+	// spill 是存储溢出数据的磁盘日志，所有的 segment 共用一份，没有设置 SpillDir 的话为 nil。
+	spill *spillLog
+
+	// db 是 bolt 引擎使用的数据库，所有的 segment 共用一份，Engine 不是 bolt 的话为 nil。
+	db *bolt.DB
+}
+
+// New 返回一个使用 opts 配置的缓存对象，并开启自动淘汰和自动持久化的定时任务，适合直接嵌入到 Go 程序中使用。
+// 不再使用的时候需要调用 Close 停止定时任务。
+//
+//	cache := caches.New(caches.WithDumpFile(""), caches.WithGcDuration(10*time.Minute))
+//	defer cache.Close()
+func New(opts ...Option) *Cache {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	cache := NewCacheWith(options)
+	cache.AutoGc()
+	cache.AutoDump()
+	return cache
 }
 
 // NewCache 返回一个缓存对象
@@ -28,23 +112,95 @@ func NewCache() *Cache {
 	return NewCacheWith(DefaultOptions())
 }
 
+// NewCacheWith 返回一个使用 options 配置的缓存对象，持久化文件存在的话会从中恢复数据。
+// 从持久化文件中恢复的时候使用的是 options，而不是持久化文件中记录的选项配置，这样重启之后修改的配置才会生效，
+// SegmentSize 和持久化文件中的不一样的话，数据会重新分配到新的 segment 中。
+// 使用 bolt 引擎的话数据本身就在磁盘上，不会从持久化文件中恢复。
 func NewCacheWith(options Options) *Cache {
-	if cache, ok := recoverFromDumpFile(options.DumpFile); ok {
-		return cache
+	// segmentOf 依赖 SegmentSize 是 2 的幂，所以就算没有校验过选项配置，这里也要保证这一点
+	if options.SegmentSize > 0 {
+		options.SegmentSize = roundUpToPowerOfTwo(options.SegmentSize)
+	}
+
+	if options.Engine != EngineBolt {
+		if cache, ok := recoverFromDumpFile(&options); ok {
+			return cache
+		}
+	}
+	return newCache(options.SegmentSize, newSegments(&options), &options)
+}
+
+// newCache 使用 segments 和 options 初始化一个缓存对象。
+func newCache(segmentSize int, segments []*segment, options *Options) *Cache {
+	cache := &Cache{
+		segmentSize: segmentSize,
+		segments:    segments,
+		dumpLock:    &sync.Mutex{},
+		reloadLock:  &sync.Mutex{},
+
+		gcRecorder:   &taskRecorder{},
+		dumpRecorder: &taskRecorder{},
+		closed:       make(chan struct{}),
+		closeOnce:    &sync.Once{},
+		gcReloaded:   make(chan struct{}, 1),
+		dumpReloaded: make(chan struct{}, 1),
+		hooks:        newHooks(),
+		loads:        helpers.NewSingleFlight(),
+		negatives:    newNegatives(),
+		refreshes:    &sync.Map{},
+	}
+
+	if options.Engine == EngineBolt {
+		db, err := openBolt(options.EnginePath, len(segments))
+		if err != nil {
+			helpers.Error("Failed to open bolt database, entries will be stored in memory", "path", options.EnginePath, "err", err)
+		}
+		cache.db = db
 	}
-	return &Cache{
-		segmentSize: options.SegmentSize,
 
-		segments: newSegments(&options),
-		options:  &options,
-		dumping:  0,
+	// arena 中的数据不会写入 bolt 引擎，所以使用 bolt 引擎的时候不会开启
+	if options.ValueArena && cache.db == nil {
+		cache.arena = newArena()
 	}
+
+	if options.SpillDir != "" {
+		spill, err := openSpillLog(options.SpillDir)
+		if err != nil {
+			helpers.Error("Failed to open spill log, values will not be spilled to disk", "dir", options.SpillDir, "err", err)
+		}
+		cache.spill = spill
+	}
+
+	for i, segment := range segments {
+		// 旧版本的持久化文件恢复出来的 segment 只有 Data，使用的是内存引擎
+		if segment.engine == nil {
+			segment.engine = mapEngine(segment.Data)
+		}
+
+		if cache.db != nil {
+			segment.useBolt(cache.db, i)
+		}
+
+		segment.hooks = cache.hooks
+		segment.contention = &lockContention{}
+		segment.arena = cache.arena
+		segment.spill = cache.spill
+		segment.moveToArena()
+	}
+	cache.options.Store(options)
+	registerGauges(cache)
+	return cache
 }
 
-// recoverFromDumpFile 从dumpFile中回复缓存
+// recoverFromDumpFile 从 options.DumpFile 中恢复缓存，恢复出来的缓存使用 options 作为选项配置
+// 替换持久化文件的过程中崩溃的话，持久化文件可能不存在，这时候会从替换时留下的备份中恢复
 // 如果恢复不成功，就返回nil和false
-func recoverFromDumpFile(dumpFile string) (*Cache, bool) {
-	cache, err := newEmptyDump().from(dumpFile)
+func recoverFromDumpFile(options *Options) (*Cache, bool) {
+	cache, err := newEmptyDump().from(options.DumpFile, options)
+	if os.IsNotExist(err) {
+		cache, err = newEmptyDump().from(options.DumpFile+dumpBackupSuffix, options)
+	}
+
 	if err != nil {
 		return nil, false
 	}
@@ -64,11 +220,12 @@ func newSegments(options *Options) []*segment {
 // index 是选择 segment 的“特殊算法”。
 // 这里参考了 Java 中的哈希生成逻辑，尽可能避免重复。不用去纠结为什么这么写，因为没有唯一的写法。
 // 为了能使用到哈希值的全部数据，这里使用高位和低位进行异或操作。
+// 这里直接按下标遍历字符串中的字节，不需要先转换成 []byte，所以每次计算都不会分配内存。
+// 注意持久化文件中的数据是按照 segment 存放的，修改这个算法会导致旧的持久化文件恢复出来之后找不到数据。
 func index(key string) int {
 	index := 0
-	keyBytes := []byte(key)
-	for _, b := range keyBytes {
-		index = 31*index + int(b&0xff)
+	for i := 0; i < len(key); i++ {
+		index = 31*index + int(key[i])
 	}
 	return index ^ (index >> 16)
 }
@@ -81,9 +238,97 @@ func (c *Cache) segmentOf(key string) *segment {
 
 // Get 返回指定key的value，如果找不到就返回false
 func (c *Cache) Get(key string) ([]byte, bool) {
-	// 等待持久化完成
-	c.waitForDumping()
-	return c.segmentOf(key).get(key)
+	value, ok, _ := c.GetContext(context.Background(), key)
+	return value, ok
+}
+
+// GetContext 返回指定key的value，如果找不到就返回false。
+// 如果 ctx 已经被取消或者超时了，就返回 ctx 的错误。设置了 Loader 的话，找不到的数据会先从后端存储中加载。
+// 同时设置了 StaleTTL 的话，过期不久的数据会直接作为旧数据返回，并在后台重新加载。
+func (c *Cache) GetContext(ctx context.Context, key string) (value []byte, ok bool, err error) {
+	if value, ok, err = c.getContext(ctx, key); ok || err != nil {
+		return value, ok, err
+	}
+
+	if entry, ok, err := c.spilledEntry(key); ok || err != nil {
+		if !ok {
+			return nil, false, err
+		}
+		return entry.Value, true, nil
+	}
+
+	if entry, ok := c.staleEntry(key); ok {
+		return entry.Value, true, nil
+	}
+
+	entry, ok, err := c.loadEntry(ctx, key)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	return entry.Value, true, nil
+}
+
+// getContext 返回指定key的value，不会从后端存储中加载数据。
+func (c *Cache) getContext(ctx context.Context, key string) (value []byte, ok bool, err error) {
+	span := c.startSpan(ctx, "get", key)
+	defer func() {
+		span.End(err)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	value, ok = c.segmentOf(key).get(key)
+	observeGet(ok)
+	return value, ok, nil
+}
+
+// GetEntryContext 返回指定key的数据以及它的元数据，如果找不到就返回false。
+// 和 GetContext 一样，设置了 Loader 的话，找不到的数据会先从后端存储中加载。
+func (c *Cache) GetEntryContext(ctx context.Context, key string) (entry *Entry, ok bool, err error) {
+	span := c.startSpan(ctx, "get", key)
+	defer func() {
+		span.End(err)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	entry, ok = c.segmentOf(key).getEntry(key)
+	observeGet(ok)
+	if ok {
+		return entry, true, nil
+	}
+
+	if entry, ok, err = c.spilledEntry(key); ok || err != nil {
+		return entry, ok, err
+	}
+
+	if entry, ok = c.staleEntry(key); ok {
+		return entry, true, nil
+	}
+	return c.loadEntry(ctx, key)
+}
+
+// Keys 从 cursor 指定的位置开始遍历缓存中以 prefix 开头的 key，返回遍历到的 key 和下一次遍历的 cursor。
+// cursor 是 segment 的下标，第一次遍历传 0 即可，返回的 cursor 为 0 说明已经遍历完了。
+// 每次都是以 segment 为单位进行遍历的，所以 count 只是一个参考值，返回的 key 个数可能会比 count 多。
+func (c *Cache) Keys(prefix string, cursor int, count int) ([]string, int) {
+	if cursor < 0 || cursor >= len(c.segments) {
+		return nil, 0
+	}
+
+	keys := make([]string, 0, count)
+	for ; cursor < len(c.segments) && len(keys) < count; cursor++ {
+		keys = append(keys, c.segments[cursor].keys(prefix)...)
+	}
+
+	if cursor >= len(c.segments) {
+		cursor = 0
+	}
+	return keys, cursor
 }
 
 // Set 添加一个键值对到缓存中，不设定 ttl，也就意味着数据不会过期。
@@ -94,48 +339,169 @@ func (c *Cache) Set(key string, value []byte) error {
 
 // SetWithTTL 添加一个键值对到缓存中，使用给定的 ttl 去设定过期时间。
 func (c *Cache) SetWithTTL(key string, value []byte, ttl int64) error {
-	c.waitForDumping()
+	return c.SetWithTTLContext(context.Background(), key, value, ttl)
+}
+
+// SetWithTTLContext 和 SetWithTTL 一样，只是 ctx 已经被取消或者超时的话就不会添加，而是返回 ctx 的错误。
+func (c *Cache) SetWithTTLContext(ctx context.Context, key string, value []byte, ttl int64) (err error) {
+	return c.set(ctx, key, value, ttl, false)
+}
+
+// SetOwnedWithTTLContext 和 SetWithTTLContext 一样，只是 value 的所有权交给了缓存，缓存会直接存储它而不是复制一份，
+// 适合分块上传这种已经专门为这个数据分配好了内存的场景，可以避免大数据在添加的时候占用两倍的内存。
+// 调用之后调用方就不能再修改 value 了，开启了 ValueArena 的话数据仍然会复制到 arena 中。
+func (c *Cache) SetOwnedWithTTLContext(ctx context.Context, key string, value []byte, ttl int64) error {
+	return c.set(ctx, key, value, ttl, true)
+}
+
+// set 添加一个键值对到缓存中，owned 为 true 说明 value 已经交给了缓存，不需要再复制。
+func (c *Cache) set(ctx context.Context, key string, value []byte, ttl int64, owned bool) (err error) {
+	span := c.startSpan(ctx, "set", key)
+	defer func() {
+		span.End(err)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if writer := c.writerOf(ctx); writer != nil {
+		if err := writer.Write(ctx, key, value, ttl); err != nil {
+			return err
+		}
+	}
+	sets.Inc()
+	c.forgetNegative(key)
+	if owned {
+		return c.segmentOf(key).setOwned(key, value, ttl)
+	}
 	return c.segmentOf(key).set(key, value, ttl)
 }
 
+// SetIf 在 condition 返回 true 的时候才添加键值对到缓存中，返回键值对是否被添加了。
+// condition 的参数是当前存活的旧数据，如果旧数据不存在或者已经过期，ok 就是 false。
+// 判断和添加是原子的，可以用来实现乐观并发控制，比如 HTTP 的 If-Match。
+func (c *Cache) SetIf(ctx context.Context, key string, value []byte, ttl int64, condition func(oldValue []byte, ok bool) bool) (ok bool, err error) {
+	span := c.startSpan(ctx, "set", key)
+	defer func() {
+		span.End(err)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	ok, err = c.segmentOf(key).setIf(key, value, ttl, condition)
+	if ok {
+		c.forgetNegative(key)
+	}
+
+	if writer := c.writerOf(ctx); ok && err == nil && writer != nil {
+		if err = writer.Write(ctx, key, value, ttl); err != nil {
+			c.segmentOf(key).delete(key)
+			return false, err
+		}
+	}
+	return ok, err
+}
+
 // Delete删除指定key的键值对数据
 func (c *Cache) Delete(key string) error {
-	c.waitForDumping()
-	c.segmentOf(key).delete(key)
-	return nil
+	_, err := c.DeleteContext(context.Background(), key)
+	return err
+}
+
+// DeleteContext 和 Delete 一样，只是 ctx 已经被取消或者超时的话就不会删除，而是返回 ctx 的错误。
+// 返回的 bool 表示数据在删除前是否存在。
+func (c *Cache) DeleteContext(ctx context.Context, key string) (ok bool, err error) {
+	span := c.startSpan(ctx, "delete", key)
+	defer func() {
+		span.End(err)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	if writer := c.writerOf(ctx); writer != nil {
+		if err := writer.Delete(ctx, key); err != nil {
+			return false, err
+		}
+	}
+	deletes.Inc()
+	return c.segmentOf(key).delete(key), nil
 }
 
 // Status 返回缓存信息。
 func (c *Cache) Status() Status {
 	result := NewStatus()
 	for _, segment := range c.segments {
-		status := segment.status()
-		result.Count += status.Count
-		result.KeySize += status.KeySize
-		result.ValueSize += status.ValueSize
+		result.Add(segment.status())
 	}
 	return *result
 }
 
+// SegmentStatus 返回每个 segment 的数据情况，下标就是 segment 的下标，可以用来观察数据分布得是否均匀。
+func (c *Cache) SegmentStatus() []Status {
+	result := make([]Status, len(c.segments))
+	for i, segment := range c.segments {
+		result[i] = segment.status()
+	}
+	return result
+}
+
+// Options 返回缓存的选项配置。
+func (c *Cache) Options() Options {
+	return *c.currentOptions()
+}
+
+// currentOptions 返回缓存当前的选项配置，返回的配置是只读的，不能修改。
+func (c *Cache) currentOptions() *Options {
+	return c.options.Load().(*Options)
+}
+
+// GcStatus 返回 GC 任务的执行情况。
+func (c *Cache) GcStatus() TaskStatus {
+	return c.gcRecorder.snapshot()
+}
+
+// DumpStatus 返回持久化任务的执行情况。
+func (c *Cache) DumpStatus() TaskStatus {
+	return c.dumpRecorder.snapshot()
+}
+
 // gc 会触发数据清理任务，主要是清理过期的数据。
 func (c *Cache) gc() {
-	c.waitForDumping()
+	_, span := tracing.Start(context.Background(), "kafo.cache gc")
+	beginTime := time.Now()
+	cleaned := int64(0)
 	wg := &sync.WaitGroup{}
 	for _, seg := range c.segments {
 		wg.Add(1)
 		go func(s *segment) {
 			defer wg.Done()
-			s.gc()
+			atomic.AddInt64(&cleaned, int64(s.gc()))
 		}(seg)
 	}
 	wg.Wait()
+	c.negatives.gc()
+	c.gcRecorder.record(beginTime, int(cleaned), 0, nil)
+	gcDuration.Observe(time.Since(beginTime).Seconds())
+	gcCleaned.Add(cleaned)
+	span.SetAttributes(tracing.Attr("kafo.cleaned", cleaned))
+	span.End(nil)
+	helpers.Debug("GC finished", "cleaned", cleaned, "cost", time.Since(beginTime))
 }
 
-// AutoGc 会开启一个定时 GC 的异步任务。
+// AutoGc 会开启一个定时 GC 的异步任务，直到缓存被关闭。
 func (c *Cache) AutoGc() {
 	go func() {
 		// 根据配置中的 GcDuration 来设置定时的间隔
-		ticker := time.NewTicker(time.Duration(c.options.GcDuration) * time.Minute)
+		ticker := time.NewTicker(c.currentOptions().GcDuration)
+		defer func() {
+			ticker.Stop()
+		}()
+
 		for {
 			// 使用 select 来判断是否达到了定时器的触发点
 			// 当定时器的时间还没到的时候，ticker.C 管道会被阻塞
@@ -143,6 +509,12 @@ func (c *Cache) AutoGc() {
 			select {
 			case <-ticker.C:
 				c.gc()
+			case <-c.gcReloaded:
+				// 热加载之后使用新的时间间隔重新计时
+				ticker.Stop()
+				ticker = time.NewTicker(c.currentOptions().GcDuration)
+			case <-c.closed:
+				return
 			}
 		}
 	}()
@@ -150,30 +522,72 @@ func (c *Cache) AutoGc() {
 
 // dump 持久化缓存方法
 func (c *Cache) dump() error {
-	// 这边使用 atomic 包中的原子操作完成状态的切换
-	atomic.StoreInt32(&c.dumping, 1)
-	defer atomic.StoreInt32(&c.dumping, 0)
-	return newDump(c).to(c.options.DumpFile)
+	c.dumpLock.Lock()
+	defer c.dumpLock.Unlock()
+	_, span := tracing.Start(context.Background(), "kafo.cache dump", tracing.Attr("kafo.dump_file", c.currentOptions().DumpFile))
+	beginTime := time.Now()
+	written, err := newDump(c).to(c.currentOptions().DumpFile)
+	span.SetAttributes(tracing.Attr("kafo.bytes", written))
+	span.End(err)
+	c.dumpRecorder.record(beginTime, 0, written, err)
+	dumpDuration.Observe(time.Since(beginTime).Seconds())
+	if err != nil {
+		dumpErrors.Inc()
+		helpers.Error("Dump failed", "file", c.currentOptions().DumpFile, "err", err)
+	} else {
+		helpers.Debug("Dump finished", "file", c.currentOptions().DumpFile, "bytes", written, "cost", time.Since(beginTime))
+	}
+	return err
 }
 
-// AutoDump 开启定时任务去持久化缓存。
+// AutoDump 开启定时任务去持久化缓存，直到缓存被关闭，没有设置持久化文件或者使用的是 bolt 引擎的话就什么也不做。
 // 和自动 Gc 的原理是一样的，这里就不再赘述了。
 func (c *Cache) AutoDump() {
+	if c.currentOptions().DumpFile == "" || c.db != nil {
+		return
+	}
+
 	go func() {
-		ticker := time.NewTicker(time.Duration(c.options.DumpDuration) * time.Minute)
+		ticker := time.NewTicker(c.currentOptions().DumpDuration)
+		defer func() {
+			ticker.Stop()
+		}()
+
 		for {
 			select {
 			case <-ticker.C:
 				c.dump()
+			case <-c.dumpReloaded:
+				ticker.Stop()
+				ticker = time.NewTicker(c.currentOptions().DumpDuration)
+			case <-c.closed:
+				return
 			}
 		}
 	}()
 }
 
-// waitForDumping 会等待持久化完成才返回
-func (c *Cache) waitForDumping() {
-	for atomic.LoadInt32(&c.dumping) != 0 {
-		// 每次循环都会等待一定的时间，如果不睡眠，会导致 CPU 空转消耗资源
-		time.Sleep(time.Duration(c.options.CasSleepTime) * time.Microsecond)
+// Close 关闭缓存，停止自动淘汰和自动持久化的定时任务，缓存中的数据还是可以继续访问的。
+// 需要在关闭之前保存数据的话，可以在关闭之前调用 Dump。使用 bolt 引擎的话会关闭数据库，之后就不能再访问数据了。
+func (c *Cache) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		if c.spill != nil {
+			err = c.spill.close()
+		}
+
+		if c.db != nil {
+			err = c.db.Close()
+		}
+	})
+	return err
+}
+
+// Dump 马上将缓存持久化到持久化文件中，没有设置持久化文件或者使用的是 bolt 引擎的话就什么也不做。
+func (c *Cache) Dump() error {
+	if c.currentOptions().DumpFile == "" || c.db != nil {
+		return nil
 	}
+	return c.dump()
 }
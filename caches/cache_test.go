@@ -1,10 +1,23 @@
 package caches
 
 import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/herrhu97/go-distributed-cache/tracing"
 )
 
 const (
@@ -48,3 +61,1485 @@ func TestCacheSetGet(t *testing.T) {
 
 	t.Logf("读取的消耗是时间为%s", readTime)
 }
+
+// go test -v -run=^TestCacheGcStatus$
+func TestCacheGcStatus(t *testing.T) {
+	cache := NewCache()
+	cache.SetWithTTL("key", []byte("value"), 1)
+	time.Sleep(2 * time.Second)
+	cache.gc()
+
+	status := cache.GcStatus()
+	if status.Count != 1 || status.LastCleaned != 1 {
+		t.Fatalf("gc status %+v is wrong", status)
+	}
+}
+
+// go test -v -run=^TestCacheGcLockTime$
+func TestCacheGcLockTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 每遍历一小批就会超过持有写锁的时间，所以清理会分成很多批进行
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 1
+	options.MaxGcCount = 1 << 20
+	options.GcLockTime = time.Nanosecond
+	memory := NewCacheWith(options)
+
+	options.Engine = EngineBolt
+	options.EnginePath = filepath.Join(dir, "kafo.db")
+	bolt := NewCacheWith(options)
+	defer bolt.Close()
+
+	for name, cache := range map[string]*Cache{"memory": memory, "bolt": bolt} {
+		seg := cache.segments[0]
+		for i := 0; i < 4*gcCheckInterval; i++ {
+			ttl := int64(NeverDie)
+			if i%2 == 0 {
+				ttl = 1
+			}
+
+			v := newValue([]byte("value"), ttl)
+			v.Ctime = time.Now().Unix() - 10
+			key := "key" + strconv.Itoa(i)
+			seg.engine.put(key, v)
+			seg.Status.addEntry(key, v.Data)
+		}
+
+		// bolt 引擎会从上一批停下的地方继续，一次 gc 就可以清理完，内存引擎每一批都是随机开始的，可能需要多次 gc
+		for i := 0; i < 100 && cache.Status().Count > 2*gcCheckInterval; i++ {
+			cache.gc()
+			if name == EngineBolt && cache.Status().Count != 2*gcCheckInterval {
+				t.Fatalf("%s: count %d is wrong", name, cache.Status().Count)
+			}
+		}
+
+		if count := cache.Status().Count; count != 2*gcCheckInterval {
+			t.Fatalf("%s: count %d is wrong", name, count)
+		}
+	}
+}
+
+// go test -v -run=^TestCacheDumpStatus$
+func TestCacheDumpStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(dir, "kafo.dump")
+	cache := NewCacheWith(options)
+	cache.Set("key", []byte("value"))
+	for i := 0; i < taskHistorySize; i++ {
+		if err = cache.Dump(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 持久化文件所在的目录被删除之后持久化会失败，失败的记录排在最前面
+	os.RemoveAll(dir)
+	if err = cache.Dump(); err == nil {
+		t.Fatal("dump to a missing dir should fail")
+	}
+
+	status := cache.DumpStatus()
+	if status.Count != taskHistorySize+1 || status.Failures != 1 || len(status.History) != taskHistorySize {
+		t.Fatalf("dump status %+v is wrong", status)
+	}
+
+	if status.History[0].Error == "" || status.History[1].Error != "" || status.History[1].Bytes <= 0 {
+		t.Fatalf("dump history %+v is wrong", status.History)
+	}
+}
+
+// go test -v -run=^TestCacheKeys$
+func TestCacheKeys(t *testing.T) {
+	cache := NewCache()
+	for i := 0; i < 100; i++ {
+		cache.Set("key"+strconv.Itoa(i), []byte("value"))
+		cache.Set("other"+strconv.Itoa(i), []byte("value"))
+	}
+
+	keys := map[string]bool{}
+	cursor := 0
+	for {
+		var page []string
+		page, cursor = cache.Keys("key", cursor, 10)
+		for _, key := range page {
+			keys[key] = true
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if len(keys) != 100 {
+		t.Fatalf("len(keys) %d is wrong", len(keys))
+	}
+}
+
+// go test -v -run=^TestCacheExec$
+func TestCacheExec(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 1
+	options.MaxEntrySize = 4
+	cache := NewCacheWith(options)
+	cache.Set("key1", []byte("value1"))
+
+	results, err := cache.Exec(context.Background(), []Operation{
+		{Type: OpGet, Key: "key1"},
+		{Type: OpSet, Key: "key2", Value: []byte("value2")},
+		{Type: OpDelete, Key: "key1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(results[0].Value) != "value1" || !results[2].Found {
+		t.Fatalf("results %+v are wrong", results)
+	}
+
+	// 写满保护导致事务失败的时候，之前的修改需要被回滚
+	_, err = cache.Exec(context.Background(), []Operation{
+		{Type: OpDelete, Key: "key2"},
+		{Type: OpSet, Key: "key3", Value: make([]byte, 8*1024*1024)},
+	})
+	if err == nil {
+		t.Fatal("exec should fail")
+	}
+
+	if value, ok := cache.Get("key2"); !ok || string(value) != "value2" {
+		t.Fatalf("value %s of key2 should be rolled back", value)
+	}
+
+	if status := cache.Status(); status.Count != 1 {
+		t.Fatalf("status %+v is wrong", status)
+	}
+}
+
+// go test -v -run=^TestCacheIncrAndExpire$
+func TestCacheIncrAndExpire(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache := NewCacheWith(options)
+	ctx := context.Background()
+
+	if n, err := cache.Incr(ctx, "counter", 5); err != nil || n != 5 {
+		t.Fatalf("n %d or err %v is wrong", n, err)
+	}
+
+	if n, err := cache.Incr(ctx, "counter", -7); err != nil || n != -2 {
+		t.Fatalf("n %d or err %v is wrong", n, err)
+	}
+
+	if value, ok := cache.Get("counter"); !ok || string(value) != "-2" {
+		t.Fatalf("value %s is wrong", value)
+	}
+
+	cache.Set("key", []byte("value"))
+	if _, err := cache.Incr(ctx, "key", 1); err != ErrNotInteger {
+		t.Fatalf("err %v is wrong", err)
+	}
+
+	if !cache.Exists("key") || cache.Exists("missing") {
+		t.Fatal("exists is wrong")
+	}
+
+	if ok, err := cache.Expire(ctx, "key", 100); err != nil || !ok {
+		t.Fatalf("ok %v or err %v is wrong", ok, err)
+	}
+
+	if entry, ok, _ := cache.GetEntryContext(ctx, "key"); !ok || entry.Ttl != 100 {
+		t.Fatalf("entry %+v is wrong", entry)
+	}
+
+	if ok, _ := cache.Expire(ctx, "missing", 100); ok {
+		t.Fatal("expire of missing key should return false")
+	}
+}
+
+// go test -v -run=^TestCacheReload$
+func TestCacheReload(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 1
+	cache := NewCacheWith(options)
+
+	if err := cache.SetWithTTL("key1", []byte("value1"), NeverDie); err != nil {
+		t.Fatal(err)
+	}
+
+	// 写满保护的阈值改成 1 MB 之后就不能再添加更大的数据了，但是 segment 的个数不会被修改
+	options.MaxEntrySize = 1
+	options.SegmentSize = 16
+	if err := cache.Reload(options); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.SetWithTTL("key2", make([]byte, 2<<20), NeverDie); err != ErrEntryTooLarge {
+		t.Fatalf("err %v should be ErrEntryTooLarge", err)
+	}
+
+	reloaded := cache.Options()
+	if reloaded.SegmentSize != 1 || reloaded.MaxEntrySize != 1 {
+		t.Fatalf("reloaded options %+v are wrong", reloaded)
+	}
+
+	// 不合法的配置会导致定时任务创建 ticker 的时候崩溃，所以不会被热加载
+	options.GcDuration = 0
+	if err := cache.Reload(options); err == nil {
+		t.Fatal("reload with invalid GcDuration should fail")
+	}
+
+	if reloaded = cache.Options(); reloaded.GcDuration != DefaultOptions().GcDuration {
+		t.Fatalf("GcDuration %v should not be reloaded", reloaded.GcDuration)
+	}
+}
+
+// go test -v -run=^TestCacheConcurrentReload$
+func TestCacheConcurrentReload(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache := NewCacheWith(options)
+
+	// 修改淘汰策略和热加载其他配置同时进行的时候，任何一个修改都不能丢失
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			cache.SetEvictionPolicy(EvictionPolicyLFU)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			cache.reloadLock.Lock()
+			reloaded := cache.Options()
+			reloaded.MaxGcCount++
+			cache.reload(reloaded)
+			cache.reloadLock.Unlock()
+		}
+	}()
+	wg.Wait()
+
+	reloaded := cache.Options()
+	if reloaded.EvictionPolicy != EvictionPolicyLFU || reloaded.MaxGcCount != options.MaxGcCount+100 {
+		t.Fatalf("reloaded options %+v lost some updates", reloaded)
+	}
+}
+
+// go test -v -run=^TestCacheDumpReplace$
+func TestCacheDumpReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo-dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(dir, "kafo.dump")
+	cache := NewCacheWith(options)
+	for i := 0; i < 3; i++ {
+		cache.Set("key", []byte(strconv.Itoa(i)))
+		if err = cache.Dump(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 替换之后只剩下持久化文件本身，不会留下临时文件
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0].Name() != "kafo.dump" {
+		t.Fatalf("files %d in dump dir should be kafo.dump only", len(files))
+	}
+
+	// 替换的过程中崩溃的话只剩下备份，这时候会从备份中恢复
+	if err = os.Rename(options.DumpFile, options.DumpFile+dumpBackupSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := NewCacheWith(options)
+	if value, ok := recovered.Get("key"); !ok || string(value) != "2" {
+		t.Fatalf("value %s should be recovered from backup", value)
+	}
+}
+
+// go test -v -run=^TestCacheRecoverWithOptions$
+func TestCacheRecoverWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo-dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(dir, "kafo.dump")
+	cache := NewCacheWith(options)
+	for i := 0; i < 100; i++ {
+		cache.Set(strconv.Itoa(i), []byte(strconv.Itoa(i)))
+	}
+
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	// 重启之后使用的是新的选项配置，而不是持久化文件中记录的，SegmentSize 变了的话数据会重新分配
+	options.SegmentSize = 16
+	options.MaxCount = 500
+	options.ValueArena = true
+	options.SpillDir = filepath.Join(dir, "spill")
+	recovered := NewCacheWith(options)
+	defer recovered.Close()
+
+	got := recovered.Options()
+	if got.SegmentSize != 16 || got.MaxCount != 500 || !got.ValueArena || got.SpillDir != options.SpillDir {
+		t.Fatalf("options %+v should be the ones passed in", got)
+	}
+
+	if status := recovered.Status(); status.Count != 100 {
+		t.Fatalf("count %d should be 100", status.Count)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if value, ok := recovered.Get(key); !ok || string(value) != key {
+			t.Fatalf("value %s of key %s is wrong", value, key)
+		}
+	}
+}
+
+// go test -v -run=^TestCacheDumpConcurrently$
+func TestCacheDumpConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(dir, "kafo.dump")
+	cache := NewCacheWith(options)
+	for i := 0; i < 1000; i++ {
+		cache.Set("key"+strconv.Itoa(i), []byte("value"))
+	}
+
+	// 持久化的时候读写操作都不会被拒绝，也不需要等待持久化完成
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(no int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				key := "other" + strconv.Itoa(no) + "-" + strconv.Itoa(j%100)
+				if err := cache.SetWithTTLContext(context.Background(), key, []byte("value"), NeverDie); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, _, err := cache.GetContext(context.Background(), "key"+strconv.Itoa(j%1000)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err = cache.Dump(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	recovered := NewCacheWith(options)
+	for i := 0; i < 1000; i++ {
+		if value, ok := recovered.Get("key" + strconv.Itoa(i)); !ok || string(value) != "value" {
+			t.Fatalf("key%d is not recovered", i)
+		}
+	}
+}
+
+// go test -v -run=^TestNew$
+func TestNew(t *testing.T) {
+	cache := New(WithDumpFile(""), WithSegments(16, 8), WithGcDuration(10*time.Minute))
+	defer cache.Close()
+
+	options := cache.Options()
+	if options.SegmentSize != 16 || options.MapSizeOfSegment != 8 || options.GcDuration != 10*time.Minute || options.DumpFile != "" {
+		t.Fatalf("options %+v doesn't match the given options", options)
+	}
+
+	if err := cache.Set("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 关闭之后只是停止了定时任务，数据还是可以继续访问的，重复关闭也是安全的
+	cache.Close()
+	if value, ok := cache.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("value %q should be %q", value, "value")
+	}
+
+	if err := cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// go test -v -run=^TestOptionsValidate$
+func TestOptionsValidate(t *testing.T) {
+	options := DefaultOptions()
+	options.SegmentSize = 100
+	if err := options.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不是 2 的幂的 SegmentSize 会被向上取整
+	if options.SegmentSize != 128 {
+		t.Fatalf("segment size %d should be 128", options.SegmentSize)
+	}
+
+	options.GcDuration = 0
+	if err := options.Validate(); err == nil {
+		t.Fatal("validate with zero GcDuration should fail")
+	}
+
+	cache := NewCacheWith(Options{DumpFile: "", SegmentSize: 3, MapSizeOfSegment: 4, MaxEntrySize: 1})
+	if len(cache.segments) != 4 {
+		t.Fatalf("segments length %d should be 4", len(cache.segments))
+	}
+}
+
+// go test -v -run=^TestOptionsJSON$
+func TestOptionsJSON(t *testing.T) {
+	options := DefaultOptions()
+	if err := json.Unmarshal([]byte(`{"GcDuration": "90s", "CasSleepTime": 2000, "MaxGcCount": 5}`), &options); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有出现在 JSON 中的配置会保留原来的值
+	if options.GcDuration != 90*time.Second || options.CasSleepTime != 2*time.Microsecond || options.MaxGcCount != 5 || options.DumpDuration != 30*time.Minute {
+		t.Fatalf("options %+v are wrong", options)
+	}
+
+	data, err := json.Marshal(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := Options{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded != options {
+		t.Fatalf("decoded options %+v should be %+v", decoded, options)
+	}
+
+	if strings.Contains(string(data), "DumpPolicy") {
+		t.Fatalf("options %s should not contain the removed DumpPolicy", data)
+	}
+
+	// 旧的配置文件中已经删除的持久化背压策略不会导致解析失败
+	if err = json.Unmarshal([]byte(`{"DumpPolicy": "fail-fast", "DumpWaitTimeout": 100, "MaxGcCount": 6}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.MaxGcCount != 6 {
+		t.Fatalf("MaxGcCount %d should be 6", decoded.MaxGcCount)
+	}
+}
+
+// go test -v -run=^TestCacheEviction$
+func TestCacheEviction(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 1
+	options.MaxEntrySize = 1
+	cache := NewCacheWith(options)
+
+	// 每个 segment 最多只能放下 2 个这么大的数据
+	value := make([]byte, 400*1024)
+	cache.Set("key1", value)
+	cache.Set("key2", value)
+	if err := cache.Set("key3", value); err != ErrEntryTooLarge {
+		t.Fatalf("err %v should be ErrEntryTooLarge", err)
+	}
+
+	if err := cache.SetEvictionPolicy("unknown"); err != ErrUnknownEvictionPolicy {
+		t.Fatalf("err %v should be ErrUnknownEvictionPolicy", err)
+	}
+
+	if err := cache.SetEvictionPolicy(EvictionPolicyLFU); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Get("key1")
+	if err := cache.Set("key3", value); err != nil {
+		t.Fatal(err)
+	}
+
+	// key2 没有被访问过，所以被淘汰的是 key2
+	if _, ok := cache.Get("key2"); ok {
+		t.Fatal("key2 should be evicted")
+	}
+
+	if _, ok := cache.Get("key1"); !ok {
+		t.Fatal("key1 should not be evicted")
+	}
+
+	if err := cache.Set("key4", make([]byte, 2*1024*1024)); err != ErrEntryTooLarge {
+		t.Fatalf("err %v should be ErrEntryTooLarge", err)
+	}
+}
+
+// go test -v -run=^TestProfileOptions$
+func TestProfileOptions(t *testing.T) {
+	for _, profile := range []string{ProfileSmall, ProfileMedium, ProfileLarge} {
+		options, err := ProfileOptions(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 预设中的 SegmentSize 需要是 2 的幂，校验的时候不能被修改
+		segmentSize := options.SegmentSize
+		if err = options.Validate(); err != nil || options.SegmentSize != segmentSize {
+			t.Fatalf("options %+v of profile %s are invalid, err: %v", options, profile, err)
+		}
+
+		// 预设的写满保护需要能放下正常大小的数据
+		options.DumpFile = ""
+		cache := NewCacheWith(options)
+		if err = cache.Set("key", make([]byte, 1024*1024)); err != nil {
+			t.Fatalf("profile %s should store a 1 MB value, err: %v", profile, err)
+		}
+		cache.Close()
+	}
+
+	if _, err := ProfileOptions("unknown"); err == nil {
+		t.Fatal("unknown profile should fail")
+	}
+}
+
+// go test -v -run=^TestCacheMaxEntriesPerSegment$
+func TestCacheMaxEntriesPerSegment(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 1
+	options.MaxEntriesPerSegment = 2
+	cache := NewCacheWith(options)
+
+	cache.Set("key1", []byte("value1"))
+	cache.Set("key2", []byte("value2"))
+	if err := cache.Set("key3", []byte("value3")); err != ErrTooManyEntries {
+		t.Fatalf("err %v should be ErrTooManyEntries", err)
+	}
+
+	// 覆盖已经存在的数据不会增加数据个数
+	if err := cache.Set("key2", []byte("value22")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.SetEvictionPolicy(EvictionPolicyRandom); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Set("key3", []byte("value3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := cache.Status(); status.Count != 2 {
+		t.Fatalf("count %d should be 2", status.Count)
+	}
+}
+
+// go test -v -run=^TestCacheMaxCount$
+func TestCacheMaxCount(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 4
+	options.MaxCount = 16
+	options.EvictionPolicy = EvictionPolicyLRU
+	cache := NewCacheWith(options)
+
+	for i := 0; i < 100; i++ {
+		if err := cache.Set("key"+strconv.Itoa(i), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status := cache.Status(); status.Count > options.MaxCount {
+		t.Fatalf("count %d should not be greater than %d", status.Count, options.MaxCount)
+	}
+
+	// 最后写入的数据不会被淘汰
+	if _, ok := cache.Get("key99"); !ok {
+		t.Fatal("key99 should not be evicted")
+	}
+
+	// MaxCount 比 segment 的个数还少的话，每个 segment 至少可以存放一个数据
+	options.MaxCount = 1
+	options.EvictionPolicy = EvictionPolicyNone
+	cache = NewCacheWith(options)
+	if err := cache.Set("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	seg := cache.segmentOf("key")
+	for i := 0; ; i++ {
+		key := "key" + strconv.Itoa(i)
+		if cache.segmentOf(key) == seg {
+			if err := cache.Set(key, []byte("value")); err != ErrTooManyEntries {
+				t.Fatalf("err %v should be ErrTooManyEntries", err)
+			}
+			break
+		}
+	}
+}
+
+// recordingTracer 会记录开启过的 span 的名字。
+type recordingTracer struct {
+	lock  sync.Mutex
+	names []string
+}
+
+func (rt *recordingTracer) Start(ctx context.Context, name string, attributes ...tracing.Attribute) (context.Context, tracing.Span) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	rt.names = append(rt.names, name)
+	return ctx, recordingSpan{}
+}
+
+// recordingSpan 是 recordingTracer 开启的 span。
+type recordingSpan struct{}
+
+func (recordingSpan) SetAttributes(attributes ...tracing.Attribute) {}
+
+func (recordingSpan) TraceID() string { return "" }
+
+func (recordingSpan) End(err error) {}
+
+// go test -v -run=^TestCacheTracing$
+func TestCacheTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(nil)
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache := NewCacheWith(options)
+	cache.Set("key", []byte("value"))
+	cache.Get("key")
+	cache.Delete("key")
+	cache.gc()
+
+	tracing.SetTracer(nil)
+	want := []string{"kafo.segment set", "kafo.segment get", "kafo.segment delete", "kafo.cache gc"}
+	if len(tracer.names) != len(want) {
+		t.Fatalf("span names %v should be %v", tracer.names, want)
+	}
+	for i, name := range want {
+		if tracer.names[i] != name {
+			t.Fatalf("span names %v should be %v", tracer.names, want)
+		}
+	}
+}
+
+// go test -v -run=^TestCacheHooks$
+func TestCacheHooks(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 1
+	options.MaxEntriesPerSegment = 2
+	options.EvictionPolicy = EvictionPolicyLRU
+	cache := NewCacheWith(options)
+
+	var events []string
+	cache.OnSet(func(key string, value []byte) {
+		// 钩子是在释放锁之后调用的，所以可以访问缓存
+		cache.Exists(key)
+		events = append(events, "set "+key+"="+string(value))
+	})
+	cache.OnDelete(func(key string) {
+		events = append(events, "delete "+key)
+	})
+	cache.OnEvict(func(key string, value []byte) {
+		events = append(events, "evict "+key)
+	})
+	cache.OnExpire(func(key string, value []byte) {
+		events = append(events, "expire "+key)
+	})
+
+	cache.Set("key1", []byte("value1"))
+	cache.SetWithTTL("key2", []byte("value2"), 1)
+	cache.Delete("key1")
+	cache.Set("key3", []byte("value3"))
+	time.Sleep(1100 * time.Millisecond)
+	cache.Get("key2")
+	cache.Set("key4", []byte("value4"))
+	cache.Set("key5", []byte("value5"))
+
+	// 回滚的事务不会调用钩子
+	cache.Exec(context.Background(), []Operation{{Type: OpDelete, Key: "key4"}, {Type: 0, Key: "key4"}})
+
+	want := []string{"set key1=value1", "set key2=value2", "delete key1", "set key3=value3", "expire key2", "set key4=value4", "evict key3", "set key5=value5"}
+	if len(events) != len(want) {
+		t.Fatalf("events %v should be %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events %v should be %v", events, want)
+		}
+	}
+}
+
+// go test -v -run=^TestCacheHooksKeepValue$
+func TestCacheHooksKeepValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	plain := DefaultOptions()
+	plain.DumpFile = ""
+
+	arena := plain
+	arena.ValueArena = true
+
+	spill := plain
+	spill.SpillDir = dir
+	spill.SpillThreshold = 4
+
+	for _, options := range []Options{plain, arena, spill} {
+		cache := NewCacheWith(options)
+
+		// 钩子保留了 value，模拟异步处理数据变更的钩子
+		var kept []byte
+		cache.OnSet(func(key string, value []byte) {
+			kept = value
+		})
+
+		// 调用方在 Set 之后复用了自己的切片，就像 TCP 服务器复用请求的缓冲区一样
+		buf := []byte("value")
+		if err := cache.Set("key", buf); err != nil {
+			t.Fatal(err)
+		}
+		copy(buf, "xxxxx")
+
+		if string(kept) != "value" {
+			t.Fatalf("value %q kept by hook with options %+v should be %q", kept, options, "value")
+		}
+		cache.Close()
+	}
+}
+
+// go test -v -run=^TestStatusSizeHistograms$
+func TestStatusSizeHistograms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(dir, "kafo.dump")
+	options.SegmentSize = 1
+	cache := NewCacheWith(options)
+	cache.Set("key1", []byte("value1"))
+	cache.Set("key2", make([]byte, 100))
+	cache.Set(string(make([]byte, 20)), make([]byte, 2<<20))
+	cache.Delete("key2")
+
+	want := Status{Count: 2, KeySize: 24, ValueSize: 6 + 2<<20}
+	want.KeyLengths[0], want.KeyLengths[1] = 1, 1
+	want.ValueSizes[0], want.ValueSizes[len(SizeBuckets)] = 1, 1
+	if status := cache.Status(); status != want {
+		t.Fatalf("status %+v should be %+v", status, want)
+	}
+
+	// 从持久化文件中恢复出来的大小分布要和持久化之前的一样
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := NewCacheWith(options).Status(); status != want {
+		t.Fatalf("status %+v should be %+v", status, want)
+	}
+}
+
+// go test -v -run=^TestCacheHotKeys$
+func TestCacheHotKeys(t *testing.T) {
+	cache := NewCache()
+	for i := 0; i < 50; i++ {
+		key := strconv.Itoa(i)
+		cache.Set(key, []byte(key))
+		for j := 0; j < i%10; j++ {
+			cache.Get(key)
+		}
+	}
+
+	want := []HotKey{{Key: "19", Hits: 9}, {Key: "29", Hits: 9}, {Key: "39", Hits: 9}, {Key: "49", Hits: 9}, {Key: "9", Hits: 9}, {Key: "18", Hits: 8}}
+	if hotKeys := cache.HotKeys(6); !reflect.DeepEqual(hotKeys, want) {
+		t.Fatalf("hotKeys %+v should be %+v", hotKeys, want)
+	}
+
+	// 没有被访问过的数据不是热点数据
+	if hotKeys := cache.HotKeys(100); len(hotKeys) != 45 {
+		t.Fatalf("len(hotKeys) %d should be 45", len(hotKeys))
+	}
+}
+
+// go test -v -run=^TestCacheLazyExpiration$
+func TestCacheLazyExpiration(t *testing.T) {
+	cache := NewCache()
+	seg := cache.segmentOf("key")
+	expire := func() *value {
+		v := seg.Data["key"]
+		v.Ctime = time.Now().Unix() - 10
+		return v
+	}
+
+	cache.SetWithTTL("key", []byte("old"), 1)
+	expire()
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("expired key should not be found")
+	}
+
+	if status := cache.Status(); status.Count != 0 {
+		t.Fatalf("expired key should be removed, but count is %d", status.Count)
+	}
+
+	// 模拟读操作释放读锁之后、获取写锁之前，数据被其他写操作覆盖了
+	cache.SetWithTTL("key", []byte("old"), 1)
+	read := expire()
+	cache.Set("key", []byte("new"))
+	seg.removeExpired("key", read)
+	if value, ok := cache.Get("key"); !ok || string(value) != "new" {
+		t.Fatalf("new value %s, %v should not be removed", value, ok)
+	}
+
+	// 覆盖的新数据也已经过期了的话，同样不能被读到旧数据的读操作删除，只能由读到它的读操作或者 GC 任务删除
+	cache.SetWithTTL("key", []byte("old"), 1)
+	read = expire()
+	cache.SetWithTTL("key", []byte("new"), 1)
+	newer := expire()
+	seg.removeExpired("key", read)
+	if status := cache.Status(); status.Count != 1 {
+		t.Fatalf("expired new value should not be removed, but count is %d", status.Count)
+	}
+
+	seg.removeExpired("key", newer)
+	if status := cache.Status(); status.Count != 0 {
+		t.Fatalf("expired new value should be removed, but count is %d", status.Count)
+	}
+}
+
+// go test -v -run=^TestValueSameAs$
+func TestValueSameAs(t *testing.T) {
+	v := &value{Data: []byte("value"), Ttl: 10, Ctime: 100}
+	if !v.sameAs(v) {
+		t.Fatal("value should be the same as itself")
+	}
+
+	// 不是内存引擎的话每次读到的都是新解码的数据
+	if decoded := *v; !v.sameAs(&decoded) {
+		t.Fatal("decoded value should be the same as the original one")
+	}
+
+	others := []*value{
+		{Data: []byte("other"), Ttl: 10, Ctime: 100},
+		{Data: []byte("value"), Ttl: 20, Ctime: 100},
+		{Data: []byte("value"), Ttl: 10, Ctime: 101},
+		{Data: []byte("value"), Ttl: 10, Ctime: 100, slot: &arenaSlot{}},
+	}
+	for _, other := range others {
+		if v.sameAs(other) {
+			t.Fatalf("value %+v should not be the same as %+v", v, other)
+		}
+	}
+}
+
+// indexSink 保存基准测试的结果，避免 index 的调用被编译器优化掉。
+var indexSink int
+
+// go test -v -run=^$ -bench=^BenchmarkIndex$ -benchmem
+func BenchmarkIndex(b *testing.B) {
+	key := "user:profile:1234567890:settings:notifications"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		indexSink = index(key)
+	}
+}
+
+// go test -v -run=^TestIndexAllocations$
+func TestIndexAllocations(t *testing.T) {
+	key := strings.Repeat("key", 100)
+	if allocs := testing.AllocsPerRun(100, func() { index(key) }); allocs != 0 {
+		t.Fatalf("index allocates %v times", allocs)
+	}
+
+	// 修改算法会导致旧的持久化文件中的数据落在错误的 segment 中
+	if got := index("key"); got != 106079^(106079>>16) {
+		t.Fatalf("index of key %d is changed", got)
+	}
+}
+
+// go test -v -run=^TestCacheLockContention$
+func TestCacheLockContention(t *testing.T) {
+	options := DefaultOptions()
+	options.SegmentSize = 1
+	cache := NewCacheWith(options)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10*lockSampleRate; j++ {
+				key := strconv.Itoa(i*1000 + j)
+				cache.Set(key, []byte(key))
+				cache.Get(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	contention := cache.SegmentContention()
+	if len(contention) != 1 {
+		t.Fatalf("len(contention) %d should be 1", len(contention))
+	}
+
+	// 一共获取了 8 * 20 * lockSampleRate 次锁，所以一定会被抽样 160 次
+	lc := contention[0]
+	if lc.Samples != 160 || lc.WaitTime < lc.MaxWait || lc.MaxWait < 0 {
+		t.Fatalf("contention %+v is wrong", lc)
+	}
+}
+
+// go test -v -run=^TestCacheDumpFormat$
+func TestCacheDumpFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo-dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(dir, "kafo.dump")
+	options.SegmentSize = 4
+	cache := NewCacheWith(options)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		cache.SetWithTTL(key, []byte(strings.Repeat(key, i)), int64(i))
+	}
+	cache.Get("1")
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(options.DumpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), dumpMagic) {
+		t.Fatalf("dump file should start with %s", dumpMagic)
+	}
+
+	check := func(recovered *Cache) {
+		if status := recovered.Status(); status != cache.Status() {
+			t.Fatalf("status %+v should be %+v", status, cache.Status())
+		}
+		for i := 1; i < 100; i++ {
+			key := strconv.Itoa(i)
+			if value, ok := recovered.Get(key); !ok || string(value) != strings.Repeat(key, i) {
+				t.Fatalf("key %s is not recovered", key)
+			}
+		}
+	}
+
+	recovered, err := newEmptyDump().from(options.DumpFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(recovered)
+
+	// 写到一半的文件不能被当作完整的持久化文件恢复
+	if err = ioutil.WriteFile(options.DumpFile, data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newEmptyDump().from(options.DumpFile, nil); err != errCorruptDump {
+		t.Fatalf("err %v should be %v", err, errCorruptDump)
+	}
+
+	// 旧版本的持久化文件是整个 dump 结构 Gob 序列化的，也要能恢复
+	legacy := &dump{Version: 2, Options: cache.currentOptions(), SegmentSize: len(cache.segments)}
+	for _, segment := range cache.segments {
+		legacy.Segments = append(legacy.Segments, segment.snapshot())
+	}
+	file, err := os.Create(options.DumpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gob.NewEncoder(file).Encode(legacy)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recovered, err = newEmptyDump().from(options.DumpFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(recovered)
+}
+
+// go test -v -run=^TestCacheValueArena$
+func TestCacheValueArena(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 1
+	options.ValueArena = true
+	cache := NewCacheWith(options)
+
+	cache.Set("key", []byte("value"))
+	value, ok := cache.Get("key")
+	if !ok || string(value) != "value" {
+		t.Fatalf("value %s is wrong", value)
+	}
+
+	// 读取到的数据是复制出来的，数据被覆盖并且块被复用之后也不会变
+	for i := 0; i < 1000; i++ {
+		cache.Set("key", []byte("other"+strconv.Itoa(i%10)))
+		if i%100 == 0 {
+			runtime.GC()
+		}
+	}
+	if string(value) != "value" {
+		t.Fatalf("value %s should not be changed", value)
+	}
+
+	// 被覆盖的数据占用的块会在回收之后还给 arena，回收是在单独的协程中进行的，所以需要等一会
+	status := cache.ArenaStatus()
+	for i := 0; i < 100 && status.Used != arenaMinChunkSize; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+		status = cache.ArenaStatus()
+	}
+	if status.Mapped != arenaSlabSize || status.Used != arenaMinChunkSize {
+		t.Fatalf("arena status %+v is wrong", status)
+	}
+
+	// 太大的数据不会存储在 arena 中
+	cache.Set("large", make([]byte, arenaMaxChunkSize+1))
+	if value, ok = cache.Get("large"); !ok || len(value) != arenaMaxChunkSize+1 {
+		t.Fatalf("len(value) %d is wrong", len(value))
+	}
+}
+
+// go test -v -run=^TestCacheSetOwned$
+func TestCacheSetOwned(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache := NewCacheWith(options)
+
+	// 交给缓存的数据直接存储，不会再复制一份
+	data := []byte("value")
+	if err := cache.SetOwnedWithTTLContext(context.Background(), "key", data, NeverDie); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, ok := cache.segmentOf("key").lookup("key")
+	if !ok || &stored.Data[0] != &data[0] {
+		t.Fatalf("stored data %s should share memory with the owned value", stored.Data)
+	}
+
+	// 开启了 ValueArena 的话数据仍然会复制到 arena 中
+	options.ValueArena = true
+	cache = NewCacheWith(options)
+	data = []byte("value")
+	if err := cache.SetOwnedWithTTLContext(context.Background(), "key", data, NeverDie); err != nil {
+		t.Fatal(err)
+	}
+
+	copy(data, "other")
+	if value, ok := cache.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("value %s should be value", value)
+	}
+}
+
+// go test -v -run=^TestCacheGetOrLoad$
+func TestCacheGetOrLoad(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache := NewCacheWith(options)
+
+	var calls int32
+	release := make(chan struct{})
+	load := func(ctx context.Context, key string) ([]byte, int64, bool, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("value"), NeverDie, key == "key", nil
+	}
+
+	// 并发未命中的调用只会加载一次
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, ok, err := cache.GetOrLoad("key", load)
+			if err != nil || !ok || string(value) != "value" {
+				t.Errorf("value %s, ok %+v or err %+v is wrong", value, ok, err)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&calls); calls != 1 {
+		t.Fatalf("calls %d should be 1", calls)
+	}
+
+	if value, ok := cache.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("value %s should be stored", value)
+	}
+
+	// 后端也不存在的数据不会被存储
+	if _, ok, err := cache.GetOrLoad("missing", load); ok || err != nil {
+		t.Fatalf("ok %+v or err %+v is wrong", ok, err)
+	}
+
+	if _, ok := cache.Get("missing"); ok {
+		t.Fatal("missing should not be stored")
+	}
+}
+
+// testBackingStore 是测试使用的后端存储。
+type testBackingStore struct {
+	data map[string][]byte
+
+	err error
+}
+
+func (tbs *testBackingStore) Load(ctx context.Context, key string) ([]byte, int64, bool, error) {
+	value, ok := tbs.data[key]
+	return value, NeverDie, ok, tbs.err
+}
+
+func (tbs *testBackingStore) Write(ctx context.Context, key string, value []byte, ttl int64) error {
+	if tbs.err == nil {
+		tbs.data[key] = value
+	}
+	return tbs.err
+}
+
+func (tbs *testBackingStore) Delete(ctx context.Context, key string) error {
+	if tbs.err == nil {
+		delete(tbs.data, key)
+	}
+	return tbs.err
+}
+
+// go test -v -run=^TestCacheBackingStore$
+func TestCacheBackingStore(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache := NewCacheWith(options)
+
+	store := &testBackingStore{data: map[string][]byte{"key": []byte("value")}}
+	cache.SetLoader(store)
+	cache.SetWriter(store)
+
+	if value, ok := cache.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("value %s should be loaded", value)
+	}
+
+	if entry, ok, err := cache.GetEntryContext(context.Background(), "missing"); ok || err != nil {
+		t.Fatalf("entry %+v, ok %+v or err %+v is wrong", entry, ok, err)
+	}
+
+	// 写操作会先同步到后端存储
+	cache.Set("key", []byte("new"))
+	if string(store.data["key"]) != "new" {
+		t.Fatalf("store value %s should be new", store.data["key"])
+	}
+
+	cache.Delete("key")
+	if _, ok := store.data["key"]; ok {
+		t.Fatal("key should be deleted from store")
+	}
+
+	// 复制过来的写操作不会同步到后端存储
+	cache.SetWithTTLContext(WithoutWriter(context.Background()), "replica", []byte("value"), NeverDie)
+	if _, ok := store.data["replica"]; ok {
+		t.Fatal("replica should not be written to store")
+	}
+
+	// 后端存储失败的话缓存不会被修改
+	store.err = errors.New("store is down")
+	if err := cache.Set("replica", []byte("new")); err != store.err {
+		t.Fatalf("err %+v should be store.err", err)
+	}
+
+	if value, ok := cache.Get("replica"); !ok || string(value) != "value" {
+		t.Fatalf("value %s should not be changed", value)
+	}
+
+	if ok, err := cache.SetIf(context.Background(), "replica", []byte("new"), NeverDie, func([]byte, bool) bool { return true }); ok || err != store.err {
+		t.Fatalf("ok %+v or err %+v is wrong", ok, err)
+	}
+
+	if _, ok := cache.Get("replica"); ok {
+		t.Fatal("replica should be deleted after store failed")
+	}
+}
+
+// go test -v -run=^TestCacheNegativeTTL$
+func TestCacheNegativeTTL(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.NegativeTTL = 50 * time.Millisecond
+	cache := NewCacheWith(options)
+
+	calls := 0
+	load := func(ctx context.Context, key string) ([]byte, int64, bool, error) {
+		calls++
+		return nil, 0, false, nil
+	}
+
+	// 不存在的 key 在 NegativeTTL 之内只会加载一次
+	for i := 0; i < 3; i++ {
+		if _, ok, err := cache.GetOrLoad("missing", load); ok || err != nil {
+			t.Fatalf("ok %+v or err %+v is wrong", ok, err)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("calls %d should be 1", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	cache.GetOrLoad("missing", load)
+	if calls != 2 {
+		t.Fatalf("calls %d should be 2", calls)
+	}
+
+	// 写入之后记录就被删除了，数据过期之后会重新加载
+	cache.SetWithTTL("missing", []byte("value"), 1)
+	if cache.negatives.contains("missing") {
+		t.Fatal("missing should be forgotten after set")
+	}
+}
+
+// go test -v -run=^TestCacheStaleTTL$
+func TestCacheStaleTTL(t *testing.T) {
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.StaleTTL = time.Minute
+	cache := NewCacheWith(options)
+
+	refreshed := make(chan struct{})
+	store := &testBackingStore{data: map[string][]byte{"key": []byte("new")}}
+	cache.SetLoader(loaderFunc(func(ctx context.Context, key string) ([]byte, int64, bool, error) {
+		defer close(refreshed)
+		return store.Load(ctx, key)
+	}))
+
+	cache.SetWithTTL("key", []byte("old"), 1)
+	time.Sleep(1100 * time.Millisecond)
+
+	// 过期的数据会作为旧数据返回，并在后台重新加载
+	entry, ok, err := cache.GetEntryContext(context.Background(), "key")
+	if err != nil || !ok || !entry.Stale || string(entry.Value) != "old" {
+		t.Fatalf("entry %+v, ok %+v or err %+v is wrong", entry, ok, err)
+	}
+
+	<-refreshed
+	for i := 0; i < 100; i++ {
+		if entry, ok, _ = cache.GetEntryContext(context.Background(), "key"); ok && !entry.Stale {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if !ok || entry.Stale || string(entry.Value) != "new" {
+		t.Fatalf("entry %+v should be refreshed", entry)
+	}
+
+	// GC 不会清理还在 StaleTTL 之内的数据
+	cache.SetWithTTL("other", []byte("old"), 1)
+	time.Sleep(1100 * time.Millisecond)
+	cache.gc()
+	if _, ok := cache.segmentOf("other").lookupStale("other"); !ok {
+		t.Fatal("other should not be cleaned by gc")
+	}
+}
+
+// loaderFunc 把函数转换成 Loader。
+type loaderFunc func(ctx context.Context, key string) ([]byte, int64, bool, error)
+
+func (lf loaderFunc) Load(ctx context.Context, key string) ([]byte, int64, bool, error) {
+	return lf(ctx, key)
+}
+
+// go test -v -run=^TestCacheSpill$
+func TestCacheSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 1
+	options.MaxEntriesPerSegment = 2
+	options.EvictionPolicy = EvictionPolicyLRU
+	options.SpillDir = dir
+	options.SpillThreshold = 1024
+	cache := NewCacheWith(options)
+
+	// 被淘汰的数据会溢出到磁盘上，访问的时候再提升回内存
+	for i := 0; i < 3; i++ {
+		if err := cache.Set("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status := cache.SpillStatus(); cache.Status().Count != 2 || status.Count != 1 {
+		t.Fatalf("count %d or spill status %+v is wrong", cache.Status().Count, status)
+	}
+
+	for i := 0; i < 3; i++ {
+		if value, ok := cache.Get("key" + strconv.Itoa(i)); !ok || string(value) != "value"+strconv.Itoa(i) {
+			t.Fatalf("value %s is wrong", value)
+		}
+	}
+
+	// 大数据直接存储在磁盘上，不会占用内存
+	large := make([]byte, 1024)
+	if err := cache.Set("large", large); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := cache.Get("large"); !ok || len(value) != len(large) {
+		t.Fatalf("len(value) %d is wrong", len(value))
+	}
+
+	if status := cache.SpillStatus(); cache.Status().Count != 2 || status.Count != 2 {
+		t.Fatalf("count %d or spill status %+v is wrong", cache.Status().Count, status)
+	}
+
+	// 删除之后磁盘上的数据也不会再被读到
+	cache.Delete("large")
+	if _, ok := cache.Get("large"); ok {
+		t.Fatal("large should be deleted")
+	}
+
+	// 重新打开之后可以从日志中恢复磁盘上的数据
+	spilled := cache.SpillStatus()
+	cache.Close()
+	cache = NewCacheWith(options)
+	defer cache.Close()
+	if status := cache.SpillStatus(); status.Count != spilled.Count || status.FileSize != spilled.FileSize {
+		t.Fatalf("spill status %+v should be %+v", status, spilled)
+	}
+}
+
+// go test -v -run=^TestCacheBoltEngine$
+func TestCacheBoltEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 4
+	options.Engine = EngineBolt
+	options.EnginePath = filepath.Join(dir, "kafo.db")
+	if err := options.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCacheWith(options)
+	for i := 0; i < 10; i++ {
+		if err := cache.Set("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cache.SetWithTTL("expired", []byte("value"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.DeleteContext(context.Background(), "key9"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开之后数据和数据情况都还在
+	cache = NewCacheWith(options)
+	if status := cache.Status(); status.Count != 10 {
+		t.Fatalf("count %d is wrong", status.Count)
+	}
+
+	for i := 0; i < 9; i++ {
+		if value, ok := cache.Get("key" + strconv.Itoa(i)); !ok || string(value) != "value"+strconv.Itoa(i) {
+			t.Fatalf("value %s is wrong", value)
+		}
+	}
+
+	if _, ok := cache.Get("key9"); ok {
+		t.Fatal("deleted key should not be found")
+	}
+
+	seg := cache.segmentOf("expired")
+	v, _ := seg.engine.get("expired")
+	v.Ctime = time.Now().Unix() - 10
+	if err := seg.engine.put("expired", v); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.gc()
+	if status := cache.Status(); status.Count != 9 {
+		t.Fatalf("expired key should be removed, but count is %d", status.Count)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	options.SegmentSize = 8
+	other := NewCacheWith(options)
+	defer other.Close()
+	if other.db != nil {
+		t.Fatal("bolt database with a different segment size should not be opened")
+	}
+}
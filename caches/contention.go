@@ -0,0 +1,111 @@
+package caches
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/metrics"
+)
+
+const (
+	// lockSampleRate 是锁竞争的抽样频率，每获取这么多次锁才会统计一次等待的时间，避免统计本身拖慢读写操作。
+	lockSampleRate = 64
+)
+
+var (
+	// lockWaitBuckets 是获取锁的等待时间的桶，大部分时候都是微秒级别的，所以比默认的桶更细。
+	lockWaitBuckets = []float64{0.000001, 0.00001, 0.0001, 0.001, 0.01, 0.1, 1}
+
+	// readLockWait 和 writeLockWait 是抽样统计的获取 segment 读锁和写锁的等待时间。
+	readLockWait = metrics.Default.Histogram("kafo_segment_lock_wait_seconds", "The sampled time waiting for segment locks.", lockWaitBuckets, "mode", "read")
+
+	writeLockWait = metrics.Default.Histogram("kafo_segment_lock_wait_seconds", "The sampled time waiting for segment locks.", lockWaitBuckets, "mode", "write")
+)
+
+// LockContention 是一个 segment 的锁竞争情况，是抽样统计的，等待时间长的 segment 说明访问太集中了，可以考虑调大 SegmentSize。
+type LockContention struct {
+	// Samples 是抽样统计的次数。
+	Samples int64 `json:"samples"`
+
+	// WaitTime 是抽样统计到的获取锁的总等待时间，单位是纳秒。
+	WaitTime int64 `json:"waitTime"`
+
+	// MaxWait 是抽样统计到的获取锁的最长等待时间，单位是纳秒。
+	MaxWait int64 `json:"maxWait"`
+}
+
+// lockContention 记录着一个 segment 的锁竞争情况，所有的字段都使用原子操作更新。
+type lockContention struct {
+	// acquisitions 是获取锁的次数，用于抽样。
+	acquisitions uint64
+
+	samples int64
+
+	waitTime int64
+
+	maxWait int64
+}
+
+// sampled 返回这一次获取锁是否需要统计等待的时间，lc 为 nil 的话不统计。
+func (lc *lockContention) sampled() bool {
+	return lc != nil && atomic.AddUint64(&lc.acquisitions, 1)%lockSampleRate == 0
+}
+
+// observe 记录一次获取锁的等待时间。
+func (lc *lockContention) observe(wait time.Duration, histogram *metrics.Histogram) {
+	atomic.AddInt64(&lc.samples, 1)
+	atomic.AddInt64(&lc.waitTime, int64(wait))
+	for {
+		maxWait := atomic.LoadInt64(&lc.maxWait)
+		if int64(wait) <= maxWait || atomic.CompareAndSwapInt64(&lc.maxWait, maxWait, int64(wait)) {
+			break
+		}
+	}
+	histogram.Observe(wait.Seconds())
+}
+
+// snapshot 返回当前的锁竞争情况。
+func (lc *lockContention) snapshot() LockContention {
+	if lc == nil {
+		return LockContention{}
+	}
+
+	return LockContention{
+		Samples:  atomic.LoadInt64(&lc.samples),
+		WaitTime: atomic.LoadInt64(&lc.waitTime),
+		MaxWait:  atomic.LoadInt64(&lc.maxWait),
+	}
+}
+
+// rlock 获取 segment 的读锁，并抽样统计等待的时间。
+func (s *segment) rlock() {
+	if !s.contention.sampled() {
+		s.lock.RLock()
+		return
+	}
+
+	beginTime := time.Now()
+	s.lock.RLock()
+	s.contention.observe(time.Since(beginTime), readLockWait)
+}
+
+// wlock 获取 segment 的写锁，并抽样统计等待的时间。
+func (s *segment) wlock() {
+	if !s.contention.sampled() {
+		s.lock.Lock()
+		return
+	}
+
+	beginTime := time.Now()
+	s.lock.Lock()
+	s.contention.observe(time.Since(beginTime), writeLockWait)
+}
+
+// SegmentContention 返回每个 segment 的锁竞争情况，下标就是 segment 的下标。
+func (c *Cache) SegmentContention() []LockContention {
+	result := make([]LockContention, len(c.segments))
+	for i, segment := range c.segments {
+		result[i] = segment.contention.snapshot()
+	}
+	return result
+}
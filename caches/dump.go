@@ -1,10 +1,17 @@
 package caches
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"io"
 	"os"
 	"sync"
 	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
 )
 
 // dump 是我们需要进行持久化的一个结构。
@@ -14,29 +21,59 @@ import (
 // 于是，dump 结构的成员就和 Cache 的差不多，只不过都是导出字段用于 Gob 序列化。
 // 如果某个结构体中没有导出字段，那么在 Gob 序列化的时候就会出错。
 // 比如我们在 dump 中引用了 value 结构，那么 value 结构中就必须至少有一个导出字段，否则序列化就会出错。
+// 版本 3 开始整个 dump 结构只用于旧版本的持久化文件，新版本只会序列化除了 Segments 以外的字段作为文件头，数据是一条一条写入的。
 type dump struct {
 
+	// Version 是持久化文件的格式版本，旧版本的持久化文件中没有这个字段，所以解析出来是 0。
+	Version int
+
 	// Options 记录着缓存的选项配置。
 	Options *Options
 
 	// SegmentSize 是segment的数量
 	SegmentSize int
 
-	// Segments 存储所有的segment实例
+	// Segments 存储所有的segment实例，版本 3 开始只有旧版本的持久化文件中才有。
 	Segments []*segment
+
+	// cache 是需要持久化的缓存，不会被序列化。
+	cache *Cache
 }
 
+// dumpVersion 是当前持久化文件的格式版本。
+// 版本 1 开始选项配置中的时间间隔都是 time.Duration，之前的版本中 GcDuration 和 DumpDuration 的单位是分钟，CasSleepTime 的单位是微秒。
+// 版本 2 开始数据情况中记录了 key 和 value 的大小分布，之前的版本需要在恢复的时候重新统计。
+// 版本 3 开始数据是按 segment 一条一条流式写入的，不会再把所有的数据一次性 Gob 序列化，避免持久化的时候内存翻倍。
+const dumpVersion = 3
+
+const (
+	// dumpMagic 是版本 3 开始的持久化文件的开头，用于和旧版本的 Gob 格式区分开。
+	// 旧版本的文件是以 Gob 的消息长度开头的，不可能是这几个字符。
+	dumpMagic = "KAFODUMP"
+
+	// dumpBufferSize 是读写持久化文件的缓冲区大小。
+	dumpBufferSize = 1 << 16
+)
+
+var (
+	// errCorruptDump 表示持久化文件的内容不完整或者被破坏了。
+	errCorruptDump = errors.New("caches: dump file is corrupt")
+)
+
 // newEmptyDump 创建一个空的dump结构对象并返回
 func newEmptyDump() *dump {
 	return &dump{}
 }
 
 // newDump 创建一个dump对象并使用指定的Cache对象初始化
+// 每个 segment 都是在写入的时候才在自己的读锁中单独复制的，同一时间只会锁住一个 segment，其他的 segment 可以正常读写。
+// 所以持久化的数据并不是整个缓存在同一时刻的快照，但是每个 segment 的数据都是一致的。
 func newDump(c *Cache) *dump {
 	return &dump{
+		Version:     dumpVersion,
 		SegmentSize: c.segmentSize,
-		Options:     c.options,
-		Segments:    c.segments,
+		Options:     c.currentOptions(),
+		cache:       c,
 	}
 }
 
@@ -45,40 +82,96 @@ func nowSuffix() string {
 	return "." + time.Now().Format("20060102150405")
 }
 
-// to 会将 dump 持久化到 dumpFile 中。
+// countingWriter 会统计写入的字节数。
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	cw.written += int64(n)
+	return n, err
+}
+
+// to 会将 dump 持久化到 dumpFile 中，返回写入的字节数。
 // 这个 dumpFile 是指定的一个文件。
-func (d *dump) to(dumpFile string) error {
+func (d *dump) to(dumpFile string) (int64, error) {
 	// 使用 os.OpenFile 打开一个文件，os.O_CREATE 表示如果文件不存在就新建
 	// 由于持久化文件是需要写入，而且每次写入时必须是空文件，否则会和上次的持久化数据混淆，所以需要指定 os.O_TRUNC
 	// 这样一旦在持久化的过程中出现问题，没有持久化成功，而原本的持久化文件已经被清空了，就会导致之前的持久化数据全部毁于一旦
-	// 这是很可怕的一件事情，所以需要生成新的持久化文件，并且持久化到新的文件中，持久化成功之后再删除原本的持久化文件
-	// 这样就可以保证持久化数据至少有个备份，更安全
+	// 这是很可怕的一件事情，所以需要生成新的持久化文件，并且持久化到新的文件中，持久化成功之后再替换原本的持久化文件
+	// 新文件会先同步到磁盘，然后原子地替换掉原本的持久化文件，所以任何时候崩溃都至少有一个完整的持久化文件，更安全
 	newDumpFile := dumpFile + nowSuffix()
 	file, err := os.OpenFile(newDumpFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer file.Close()
 
-	err = gob.NewEncoder(file).Encode(d)
+	writer := &countingWriter{writer: file}
+	err = d.writeTo(writer)
+	if err == nil {
+		// 替换之前需要保证新文件的内容已经写入了磁盘，否则断电之后替换过去的可能是一个不完整的文件
+		err = file.Sync()
+	}
+
+	// 注意这里需要先把文件关闭了，不然 os.Remove 和 os.Rename 是没有权限删除和重命名这个文件的
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
 	if err != nil {
-		// 注意这里需要先把文件关闭了，不然 os.Remove 是没有权限删除这个文件的
-		file.Close()
 		os.Remove(newDumpFile)
+		return writer.written, err
+	}
+
+	// 将新的持久化文件改名为旧的持久化名字，相当于替换，这样可以保证持久化文件的名字不变
+	if err = replaceFile(newDumpFile, dumpFile); err != nil {
+		os.Remove(newDumpFile)
+		return writer.written, err
+	}
+	return writer.written, nil
+}
+
+// writeTo 把持久化文件写入 writer，文件的格式是 dumpMagic 加上一条一条带长度前缀的记录。
+// 第一条记录是 Gob 序列化的文件头，然后每个 segment 先是一条记录着数据个数的记录，接着是这个 segment 的所有数据。
+// 每次只会复制一个 segment，写完之后就可以被回收了，所以持久化占用的额外内存和 segment 的大小有关，和整个缓存的大小无关。
+func (d *dump) writeTo(w io.Writer) error {
+	writer := bufio.NewWriterSize(w, dumpBufferSize)
+	if _, err := writer.WriteString(dumpMagic); err != nil {
 		return err
 	}
 
-	// 将旧的持久化文件删除
-	os.Remove(dumpFile)
+	header := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(header).Encode(&dump{Version: d.Version, Options: d.Options, SegmentSize: d.SegmentSize}); err != nil {
+		return err
+	}
+	if err := writeRecord(writer, header.Bytes()); err != nil {
+		return err
+	}
 
-	// 将新的持久化文件改名为旧的持久化名字，相当于替换，这样可以保证持久化文件的名字不变
-	// 注意这里需要先把文件关闭了，不然 os.Rename 是没有权限重命名这个文件的
-	file.Close()
-	return os.Rename(newDumpFile, dumpFile)
+	var record []byte
+	for _, segment := range d.cache.segments {
+		snapshot := segment.snapshot()
+		record = appendUvarint(record[:0], uint64(len(snapshot.Data)))
+		if err := writeRecord(writer, record); err != nil {
+			return err
+		}
+
+		for key, value := range snapshot.Data {
+			record = appendEntry(record[:0], key, value)
+			if err := writeRecord(writer, record); err != nil {
+				return err
+			}
+		}
+	}
+	return writer.Flush()
 }
 
 // from 会从 dumpFile 中恢复数据到一个 Cache 结构对象并返回。
-func (d *dump) from(dumpFile string) (*Cache, error) {
+// 恢复出来的缓存使用 options 作为选项配置，options 为 nil 的话就使用持久化文件中记录的选项配置。
+func (d *dump) from(dumpFile string, options *Options) (*Cache, error) {
 	// 读取 dumpFile 文件并使用反序列化器进行反序列化
 	file, err := os.Open(dumpFile)
 	if err != nil {
@@ -86,21 +179,179 @@ func (d *dump) from(dumpFile string) (*Cache, error) {
 	}
 	defer file.Close()
 
-	if err = gob.NewDecoder(file).Decode(d); err != nil {
+	reader := bufio.NewReaderSize(file, dumpBufferSize)
+	if magic, err := reader.Peek(len(dumpMagic)); err == nil && string(magic) == dumpMagic {
+		reader.Discard(len(dumpMagic))
+		return d.readFrom(reader, options)
+	}
+
+	if err = gob.NewDecoder(reader).Decode(d); err != nil {
 		return nil, err
 	}
 
+	// 旧版本的持久化文件中时间间隔是按照分钟和微秒记录的整数，需要换算成 time.Duration
+	if d.Version < 1 {
+		d.Options.GcDuration *= time.Minute
+		d.Options.DumpDuration *= time.Minute
+		d.Options.CasSleepTime *= time.Microsecond
+	}
+
 	// 恢复出segment之后需要为每一个segment的未导出字段进行初始化
 	for _, segment := range d.Segments {
 		segment.options = d.Options
 		segment.lock = &sync.RWMutex{}
+		if d.Version < 2 {
+			segment.Status.KeyLengths, segment.Status.ValueSizes = segment.sizeHistograms()
+		}
 	}
 
 	// 然后初始化一个缓存对象并返回
-	return &Cache{
-		segmentSize: d.SegmentSize,
-		segments:    d.Segments,
-		options:     d.Options,
-		dumping:     0,
-	}, nil
+	return d.restore(d.Segments, options), nil
+}
+
+// readFrom 从 reader 中读取 writeTo 写入的持久化文件，reader 需要已经跳过了 dumpMagic。
+// 数据情况不会被持久化，而是在读取数据的时候重新统计的。
+func (d *dump) readFrom(reader *bufio.Reader, options *Options) (*Cache, error) {
+	record, err := readRecord(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = gob.NewDecoder(bytes.NewReader(record)).Decode(d); err != nil {
+		return nil, err
+	}
+	if d.Options == nil || d.SegmentSize <= 0 {
+		return nil, errCorruptDump
+	}
+
+	segments := make([]*segment, d.SegmentSize)
+	for i := range segments {
+		if record, err = readRecord(reader, record); err != nil {
+			return nil, err
+		}
+
+		count, n := binary.Uvarint(record)
+		if n <= 0 {
+			return nil, errCorruptDump
+		}
+
+		segment := newSegment(d.Options)
+		for j := uint64(0); j < count; j++ {
+			if record, err = readRecord(reader, record); err != nil {
+				return nil, err
+			}
+
+			key, value, err := decodeEntry(record)
+			if err != nil {
+				return nil, err
+			}
+			segment.Data[key] = value
+			segment.Status.addEntry(key, value.Data)
+		}
+		segments[i] = segment
+	}
+	return d.restore(segments, options), nil
+}
+
+// restore 使用 options 把恢复出来的 segments 初始化成一个缓存对象，options 为 nil 的话使用持久化文件中记录的选项配置。
+// segment 的个数和 options.SegmentSize 不一样的话，会按照新的 SegmentSize 把数据重新分配到新的 segment 中。
+func (d *dump) restore(segments []*segment, options *Options) *Cache {
+	if options == nil {
+		options = d.Options
+	}
+
+	if options.SegmentSize > 0 && options.SegmentSize != len(segments) {
+		segments = rebucket(segments, options)
+	}
+
+	for _, segment := range segments {
+		segment.options = options
+	}
+	return newCache(len(segments), segments, options)
+}
+
+// rebucket 把 segments 中的数据按照 options.SegmentSize 重新分配到新的 segment 中，options.SegmentSize 需要是 2 的幂。
+// 这时候缓存还没有初始化完成，segment 的数据都还在 Data 中，所以不需要加锁，也不需要经过存储引擎。
+func rebucket(segments []*segment, options *Options) []*segment {
+	rebucketed := newSegments(options)
+	for _, segment := range segments {
+		for key, value := range segment.Data {
+			target := rebucketed[index(key)&(len(rebucketed)-1)]
+			target.Data[key] = value
+			target.Status.addEntry(key, value.Data)
+		}
+	}
+	return rebucketed
+}
+
+// appendEntry 把一条数据记录追加到 buffer 后面，格式是带长度前缀的 key 加上 Ttl、Ctime、Hits 三个变长整数，最后是数据本身。
+func appendEntry(buffer []byte, key string, v *value) []byte {
+	buffer = appendUvarint(buffer, uint64(len(key)))
+	buffer = append(buffer, key...)
+	buffer = appendVarint(buffer, v.Ttl)
+	buffer = appendVarint(buffer, v.Ctime)
+	buffer = appendVarint(buffer, v.Hits)
+	return append(buffer, v.Data...)
+}
+
+// decodeEntry 解析一条数据记录，返回的数据不会引用 record 的内存。
+func decodeEntry(record []byte) (string, *value, error) {
+	keyLength, n := binary.Uvarint(record)
+	if n <= 0 || uint64(len(record)-n) < keyLength {
+		return "", nil, errCorruptDump
+	}
+	key := string(record[n : n+int(keyLength)])
+	record = record[n+int(keyLength):]
+
+	var fields [3]int64
+	for i := range fields {
+		if fields[i], n = binary.Varint(record); n <= 0 {
+			return "", nil, errCorruptDump
+		}
+		record = record[n:]
+	}
+	return key, &value{Data: helpers.Copy(record), Ttl: fields[0], Ctime: fields[1], Hits: fields[2]}, nil
+}
+
+// writeRecord 写入一条带长度前缀的记录。
+func writeRecord(writer *bufio.Writer, record []byte) error {
+	var prefix [binary.MaxVarintLen64]byte
+	if _, err := writer.Write(prefix[:binary.PutUvarint(prefix[:], uint64(len(record)))]); err != nil {
+		return err
+	}
+	_, err := writer.Write(record)
+	return err
+}
+
+// readRecord 读取一条带长度前缀的记录，会尽量复用 buffer 的内存，文件在记录中间结束的话返回 errCorruptDump。
+func readRecord(reader *bufio.Reader, buffer []byte) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		if err == io.EOF {
+			err = errCorruptDump
+		}
+		return nil, err
+	}
+
+	if uint64(cap(buffer)) < length {
+		buffer = make([]byte, length)
+	}
+	buffer = buffer[:length]
+	if _, err = io.ReadFull(reader, buffer); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errCorruptDump
+		}
+		return nil, err
+	}
+	return buffer, nil
+}
+
+// appendUvarint 和 appendVarint 把整数编码之后追加到 buffer 后面。
+func appendUvarint(buffer []byte, x uint64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	return append(buffer, encoded[:binary.PutUvarint(encoded[:], x)]...)
+}
+
+func appendVarint(buffer []byte, x int64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	return append(buffer, encoded[:binary.PutVarint(encoded[:], x)]...)
 }
@@ -0,0 +1,64 @@
+package caches
+
+const (
+	// EngineMemory 表示数据存储在内存中的 map 里，这是默认的存储引擎。
+	EngineMemory = "memory"
+
+	// EngineBolt 表示数据存储在 EnginePath 指定的 bbolt 数据库文件中，每个 segment 是一个 bucket。
+	EngineBolt = "bolt"
+)
+
+// engine 是 segment 存储数据的引擎，所有的方法都是在 segment 的锁中调用的，所以不需要自己保证并发安全。
+// 内存引擎返回的就是存储的数据本身，访问时间和访问次数的更新会直接生效，
+// 其他的引擎每次返回的都是解码出来的新数据，更新访问时间和访问次数之后需要重新写入才会生效。
+type engine interface {
+	// get 返回 key 对应的数据。
+	get(key string) (*value, bool)
+
+	// put 存储 key 对应的数据，覆盖原来的数据。
+	put(key string, v *value) error
+
+	// remove 删除 key 对应的数据。
+	remove(key string)
+
+	// each 遍历所有的数据，fn 返回 false 的时候停止遍历，fn 中不能修改引擎中的数据。
+	each(fn func(key string, v *value) bool)
+
+	// scan 从不小于 start 的 key 开始遍历数据，用于分批遍历，上一批停在哪个 key，下一批就从哪个 key 开始。
+	// 内存引擎没有顺序，会忽略 start，每次都从随机的位置开始遍历。
+	scan(start string, fn func(key string, v *value) bool)
+}
+
+// mapEngine 是内存引擎，直接使用 segment 的 Data 存储数据，所以持久化的时候可以直接编码 Data。
+type mapEngine map[string]*value
+
+func (me mapEngine) get(key string) (*value, bool) {
+	v, ok := me[key]
+	return v, ok
+}
+
+func (me mapEngine) put(key string, v *value) error {
+	me[key] = v
+	return nil
+}
+
+func (me mapEngine) remove(key string) {
+	delete(me, key)
+}
+
+func (me mapEngine) each(fn func(key string, v *value) bool) {
+	for key, v := range me {
+		if !fn(key, v) {
+			return
+		}
+	}
+}
+
+func (me mapEngine) scan(start string, fn func(key string, v *value) bool) {
+	me.each(fn)
+}
+
+// validEngine 返回 engine 是不是合法的存储引擎，旧版本的持久化文件中没有存储引擎，空字符串会被当成 memory。
+func validEngine(engine string) bool {
+	return engine == "" || engine == EngineMemory || engine == EngineBolt
+}
@@ -0,0 +1,149 @@
+package caches
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// boltOpenTimeout 是打开 bolt 数据库文件时等待文件锁的时间，避免文件被另一个进程打开的时候一直卡住。
+	boltOpenTimeout = 3 * time.Second
+)
+
+var (
+	// boltMetaBucket 是存储数据库元信息的 bucket。
+	boltMetaBucket = []byte("meta")
+
+	// boltSegmentSizeKey 记录着创建数据库时的 segment 个数，key 是按照 segment 个数分到各个 bucket 中的，所以个数不能改变。
+	boltSegmentSizeKey = []byte("segmentSize")
+
+	// errSegmentSizeChanged 说明数据库文件是使用不同的 SegmentSize 创建的。
+	errSegmentSizeChanged = errors.New("bolt database was created with a different segment size")
+)
+
+// boltEngine 是 bolt 引擎，每个 segment 的数据存储在同一个数据库文件中不同的 bucket 里。
+// 数据使用和持久化文件一样的格式编码，每次读取都会解码出一份新的数据。
+type boltEngine struct {
+	// db 是所有 segment 共用的数据库。
+	db *bolt.DB
+
+	// bucket 是这个 segment 使用的 bucket 名字。
+	bucket []byte
+}
+
+// openBolt 打开 path 对应的 bolt 数据库，并为 segmentSize 个 segment 创建好 bucket。
+func openBolt(path string, segmentSize int) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+
+		size := []byte(strconv.Itoa(segmentSize))
+		if old := meta.Get(boltSegmentSizeKey); old != nil && string(old) != string(size) {
+			return errSegmentSizeChanged
+		}
+
+		if err = meta.Put(boltSegmentSizeKey, size); err != nil {
+			return err
+		}
+
+		for i := 0; i < segmentSize; i++ {
+			if _, err = tx.CreateBucketIfNotExists(boltBucketOf(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// boltBucketOf 返回第 index 个 segment 使用的 bucket 名字。
+func boltBucketOf(index int) []byte {
+	return []byte(fmt.Sprintf("segment-%d", index))
+}
+
+func (be *boltEngine) get(key string) (*value, bool) {
+	var v *value
+	err := be.db.View(func(tx *bolt.Tx) error {
+		record := tx.Bucket(be.bucket).Get([]byte(key))
+		if record == nil {
+			return nil
+		}
+
+		var err error
+		_, v, err = decodeEntry(record)
+		return err
+	})
+	if err != nil {
+		helpers.Error("Failed to read entry from bolt", "key", key, "err", err)
+		return nil, false
+	}
+	return v, v != nil
+}
+
+// put 写入的时候会同步到磁盘，所以比内存引擎慢得多。
+// 存储的记录里 key 是空的，因为 bucket 里的 key 已经是完整的 key 了。
+func (be *boltEngine) put(key string, v *value) error {
+	return be.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(be.bucket).Put([]byte(key), appendEntry(nil, "", v))
+	})
+}
+
+func (be *boltEngine) remove(key string) {
+	err := be.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(be.bucket).Delete([]byte(key))
+	})
+	if err != nil {
+		helpers.Error("Failed to remove entry from bolt", "key", key, "err", err)
+	}
+}
+
+func (be *boltEngine) each(fn func(key string, v *value) bool) {
+	be.scan("", fn)
+}
+
+func (be *boltEngine) scan(start string, fn func(key string, v *value) bool) {
+	err := be.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(be.bucket).Cursor()
+		for key, record := cursor.Seek([]byte(start)); key != nil; key, record = cursor.Next() {
+			_, v, err := decodeEntry(record)
+			if err != nil {
+				return err
+			}
+
+			if !fn(string(key), v) {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		helpers.Error("Failed to iterate entries in bolt", "bucket", string(be.bucket), "err", err)
+	}
+}
+
+// useBolt 让 segment 使用 db 中第 index 个 bucket 存储数据，并重新统计数据情况，只会在缓存初始化的时候调用，所以不需要加锁。
+func (s *segment) useBolt(db *bolt.DB, index int) {
+	s.Data = nil
+	s.engine = &boltEngine{db: db, bucket: boltBucketOf(index)}
+	s.Status = NewStatus()
+	s.engine.each(func(key string, v *value) bool {
+		s.Status.addEntry(key, v.Data)
+		return true
+	})
+}
@@ -0,0 +1,20 @@
+package caches
+
+// Entry 是一个键值对以及它的元数据。
+type Entry struct {
+	// Key 是数据的键。
+	Key string `json:"key"`
+
+	// Value 是数据的值。
+	Value []byte `json:"value"`
+
+	// Ttl 是数据剩余的寿命，单位是秒，NeverDie 表示永不过期。
+	Ttl int64 `json:"ttl"`
+
+	// Ctime 是数据的创建时间，是一个 Unix 时间戳，单位是秒。
+	// 注意访问数据的时候会更新这个时间，所以它其实是数据最后一次被访问或者被创建的时间。
+	Ctime int64 `json:"ctime"`
+
+	// Stale 表示数据已经过期了，但是还在 StaleTTL 之内，所以作为旧数据返回，后台正在从 Loader 中重新加载。
+	Stale bool `json:"stale,omitempty"`
+}
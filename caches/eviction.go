@@ -0,0 +1,109 @@
+package caches
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+const (
+	// EvictionPolicyNone 表示不淘汰数据，触发写满保护的时候直接返回 ErrEntryTooLarge 或者 ErrTooManyEntries 错误。
+	EvictionPolicyNone = "none"
+
+	// EvictionPolicyLRU 表示触发写满保护的时候淘汰最久没有被访问的数据。
+	EvictionPolicyLRU = "lru"
+
+	// EvictionPolicyLFU 表示触发写满保护的时候淘汰访问次数最少的数据。
+	EvictionPolicyLFU = "lfu"
+
+	// EvictionPolicyRandom 表示触发写满保护的时候随机淘汰数据。
+	EvictionPolicyRandom = "random"
+
+	// evictionSamples 是每次淘汰时采样的数据个数。
+	// 和 Redis 一样，这里的 LRU 和 LFU 都是近似的，每次只在采样的数据中挑选出最应该被淘汰的，避免遍历整个 segment。
+	evictionSamples = 5
+)
+
+var (
+	// ErrUnknownEvictionPolicy 是设置了未知的淘汰策略时返回的错误。
+	ErrUnknownEvictionPolicy = errors.New("unknown eviction policy, should be one of none, lru, lfu and random")
+)
+
+// validEvictionPolicy 返回 policy 是不是合法的淘汰策略，旧版本的持久化文件中没有淘汰策略，空字符串会被当成 none。
+func validEvictionPolicy(policy string) bool {
+	switch policy {
+	case "", EvictionPolicyNone, EvictionPolicyLRU, EvictionPolicyLFU, EvictionPolicyRandom:
+		return true
+	default:
+		return false
+	}
+}
+
+// evict 按照淘汰策略淘汰数据，直到可以放下新的键值对为止，返回是否腾出了足够的空间和数据个数，调用者需要持有写锁。
+// newKey 对应的旧数据不会被淘汰，因为它马上就会被新数据替换掉。
+func (s *segment) evict(newKey string, newValue []byte) bool {
+	policy := s.options.EvictionPolicy
+	if policy == "" || policy == EvictionPolicyNone {
+		return false
+	}
+
+	// 数据本身就超过了 segment 的上限的话，淘汰再多的数据也放不下
+	if int64(len(newKey))+int64(len(newValue)) > s.maxEntrySize() {
+		return false
+	}
+
+	for !s.checkEntrySize(newKey, newValue) || !s.checkEntryCount() {
+		key, ok := s.victim(policy, newKey)
+		if !ok {
+			return false
+		}
+
+		victimValue, _ := s.engine.get(key)
+		s.Status.subEntry(key, victimValue.Data)
+		s.engine.remove(key)
+		evictions.Inc()
+		if victimValue.alive() {
+			// 设置了 SpillDir 的话，被淘汰的数据会溢出到磁盘上，之后访问的时候再提升回内存
+			if s.spill != nil {
+				s.spillVictim(key, victimValue)
+			}
+			s.record(eventEvict, key, victimValue.Data)
+		} else {
+			s.record(eventExpire, key, victimValue.Data)
+		}
+	}
+	return true
+}
+
+// victim 按照淘汰策略挑选出一个需要淘汰的 key，已经过期的数据会被优先淘汰，segment 中没有可以淘汰的数据时返回 false。
+// 内存引擎的遍历顺序是随机的，所以遍历得到的前几个数据就是随机采样的结果，bolt 引擎是按照 key 的顺序遍历的，采样的是最前面的几个数据。
+func (s *segment) victim(policy string, newKey string) (string, bool) {
+	victim := ""
+	var victimValue *value
+	samples := 0
+	s.engine.each(func(key string, value *value) bool {
+		if key == newKey {
+			return true
+		}
+
+		if !value.alive() {
+			victim, victimValue = key, value
+			return false
+		}
+
+		if victimValue == nil || worseThan(policy, value, victimValue) {
+			victim, victimValue = key, value
+		}
+
+		samples++
+		return policy != EvictionPolicyRandom && samples < evictionSamples
+	})
+	return victim, victimValue != nil
+}
+
+// worseThan 返回在淘汰策略下 v 是不是比 other 更应该被淘汰。
+func worseThan(policy string, v *value, other *value) bool {
+	if policy == EvictionPolicyLFU {
+		return atomic.LoadInt64(&v.Hits) < atomic.LoadInt64(&other.Hits)
+	}
+	return atomic.LoadInt64(&v.Ctime) < atomic.LoadInt64(&other.Ctime)
+}
@@ -0,0 +1,181 @@
+package caches
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+const (
+	// eventSet 是添加或者覆盖数据的事件。
+	eventSet = byte(1)
+
+	// eventDelete 是主动删除数据的事件。
+	eventDelete = byte(2)
+
+	// eventEvict 是写满之后按照淘汰策略淘汰数据的事件。
+	eventEvict = byte(3)
+
+	// eventExpire 是过期的数据被清理掉的事件，包括 GC 清理的和访问的时候发现过期了清理的。
+	eventExpire = byte(4)
+)
+
+// KeyValueHook 是添加、淘汰和过期数据时调用的钩子，value 是缓存内部存储的数据或者它的副本，不会是调用 Set 时传入的切片，
+// 所以调用方之后复用自己的切片也不会影响钩子，钩子可以保留 value，包括交给其他协程异步处理，但是不能修改它。
+type KeyValueHook func(key string, value []byte)
+
+// KeyHook 是删除数据时调用的钩子。
+type KeyHook func(key string)
+
+// event 是一个数据变更事件。
+type event struct {
+	// typ 是事件的类型。
+	typ byte
+
+	// key 是变更的键。
+	key string
+
+	// value 是添加的新数据，或者被删除、淘汰和过期的旧数据。
+	value []byte
+}
+
+// hookList 是注册的所有钩子，注册之后就不会再修改了，新注册钩子的时候会整体替换。
+type hookList struct {
+	set    []KeyValueHook
+	delete []KeyHook
+	evict  []KeyValueHook
+	expire []KeyValueHook
+}
+
+// hooks 存储着缓存注册的钩子，读取的时候不需要加锁。
+type hooks struct {
+	// list 存储的是 *hookList，没有注册过钩子的时候是 nil。
+	list atomic.Value
+
+	// lock 保证注册钩子的并发安全。
+	lock *sync.Mutex
+}
+
+// newHooks 返回一个没有注册任何钩子的 hooks。
+func newHooks() *hooks {
+	return &hooks{lock: &sync.Mutex{}}
+}
+
+// load 返回当前注册的钩子，没有注册过的话返回 nil。
+func (h *hooks) load() *hookList {
+	list, _ := h.list.Load().(*hookList)
+	return list
+}
+
+// register 复制一份当前的钩子，使用 add 添加新的钩子之后整体替换掉旧的。
+func (h *hooks) register(add func(list *hookList)) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	list := &hookList{}
+	if old := h.load(); old != nil {
+		*list = *old
+	}
+	add(list)
+	h.list.Store(list)
+}
+
+// fire 按照事件发生的顺序调用对应的钩子。
+func (h *hooks) fire(events []event) {
+	list := h.load()
+	if list == nil {
+		return
+	}
+
+	for _, e := range events {
+		switch e.typ {
+		case eventSet:
+			callKeyValueHooks(list.set, e)
+		case eventDelete:
+			for _, hook := range list.delete {
+				hook(e.key)
+			}
+		case eventEvict:
+			callKeyValueHooks(list.evict, e)
+		case eventExpire:
+			callKeyValueHooks(list.expire, e)
+		}
+	}
+}
+
+// callKeyValueHooks 使用事件的键值调用 hooks。
+func callKeyValueHooks(hooks []KeyValueHook, e event) {
+	for _, hook := range hooks {
+		hook(e.key, e.value)
+	}
+}
+
+// OnSet 注册一个添加数据时调用的钩子，包括 Set、SetIf、Incr 和事务中的添加操作，覆盖旧数据也会调用。
+// 钩子是在释放 segment 的锁之后，由执行写操作的协程同步调用的，所以钩子中可以访问缓存，但是不能太耗时，否则会拖慢写操作。
+func (c *Cache) OnSet(hook KeyValueHook) {
+	c.hooks.register(func(list *hookList) {
+		list.set = append(list.set, hook)
+	})
+}
+
+// OnDelete 注册一个主动删除数据时调用的钩子，删除已经过期的数据调用的是 OnExpire 注册的钩子。
+func (c *Cache) OnDelete(hook KeyHook) {
+	c.hooks.register(func(list *hookList) {
+		list.delete = append(list.delete, hook)
+	})
+}
+
+// OnEvict 注册一个写满之后按照淘汰策略淘汰数据时调用的钩子，value 是被淘汰的数据。
+func (c *Cache) OnEvict(hook KeyValueHook) {
+	c.hooks.register(func(list *hookList) {
+		list.evict = append(list.evict, hook)
+	})
+}
+
+// OnExpire 注册一个过期数据被清理时调用的钩子，value 是过期的数据。
+// 过期的数据只有在被 GC 清理或者被访问的时候才会清理，所以钩子调用的时间会比数据过期的时间晚。
+func (c *Cache) OnExpire(hook KeyValueHook) {
+	c.hooks.register(func(list *hookList) {
+		list.expire = append(list.expire, hook)
+	})
+}
+
+// record 记录一个数据变更事件，释放锁之后再调用钩子，没有注册钩子的话什么也不做，调用者需要持有写锁。
+func (s *segment) record(typ byte, key string, value []byte) {
+	if !s.hooked() {
+		return
+	}
+	// 钩子是在释放写锁之后调用的，那时候 arena 中的块可能已经被复用了，所以需要复制一份
+	if s.arena != nil {
+		value = helpers.Copy(value)
+	}
+	s.events = append(s.events, event{typ: typ, key: key, value: value})
+}
+
+// hooked 返回是否注册了钩子，没有注册的话就不需要记录事件了。
+func (s *segment) hooked() bool {
+	return s.hooks != nil && s.hooks.load() != nil
+}
+
+// unlock 释放写锁，然后调用钩子处理持有写锁期间记录的事件，这样钩子中访问缓存也不会死锁。
+func (s *segment) unlock() {
+	events := s.events
+	s.events = nil
+	s.lock.Unlock()
+
+	if len(events) > 0 {
+		s.hooks.fire(events)
+	}
+}
+
+// discardEvents 丢弃从 mark 开始记录的添加和删除事件，用于事务回滚，淘汰和过期的数据是不会恢复的，所以这些事件需要保留。
+func (s *segment) discardEvents(mark int) {
+	kept := s.events[:mark]
+	for _, e := range s.events[mark:] {
+		if e.typ == eventEvict || e.typ == eventExpire {
+			kept = append(kept, e)
+		}
+	}
+	s.events = kept
+}
@@ -0,0 +1,72 @@
+package caches
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// HotKey 是一个被频繁访问的 key。
+type HotKey struct {
+	// Key 是数据的 key。
+	Key string `json:"key"`
+
+	// Hits 是数据被访问的次数，覆盖数据之后会重新计数。
+	Hits int64 `json:"hits"`
+}
+
+// sortHotKeys 按照访问次数从多到少排序，访问次数一样的按照 key 排序，保证结果是稳定的。
+func sortHotKeys(hotKeys []HotKey) {
+	sort.Slice(hotKeys, func(i, j int) bool {
+		if hotKeys[i].Hits != hotKeys[j].Hits {
+			return hotKeys[i].Hits > hotKeys[j].Hits
+		}
+		return hotKeys[i].Key < hotKeys[j].Key
+	})
+}
+
+// hotKeys 返回 segment 中访问次数最多的 count 个存活的数据，没有被访问过的数据不算。
+func (s *segment) hotKeys(count int) []HotKey {
+	s.rlock()
+	defer s.lock.RUnlock()
+
+	hotKeys := make([]HotKey, 0, count)
+	s.engine.each(func(key string, value *value) bool {
+		hits := atomic.LoadInt64(&value.Hits)
+		if hits <= 0 || !value.alive() {
+			return true
+		}
+
+		hotKeys = append(hotKeys, HotKey{Key: key, Hits: hits})
+		// 攒够两倍之后再排序截断，避免每个数据都排序一次，也避免把整个 segment 的数据都复制出来
+		if len(hotKeys) >= 2*count {
+			sortHotKeys(hotKeys)
+			hotKeys = hotKeys[:count]
+		}
+		return true
+	})
+
+	sortHotKeys(hotKeys)
+	if len(hotKeys) > count {
+		hotKeys = hotKeys[:count]
+	}
+	return hotKeys
+}
+
+// HotKeys 返回缓存中访问次数最多的 count 个数据，按照访问次数从多到少排序。
+// 访问次数使用的是 LFU 淘汰策略统计的次数，需要遍历所有的数据，所以不适合频繁调用。
+func (c *Cache) HotKeys(count int) []HotKey {
+	if count <= 0 {
+		return nil
+	}
+
+	hotKeys := make([]HotKey, 0, count)
+	for _, segment := range c.segments {
+		hotKeys = append(hotKeys, segment.hotKeys(count)...)
+	}
+
+	sortHotKeys(hotKeys)
+	if len(hotKeys) > count {
+		hotKeys = hotKeys[:count]
+	}
+	return hotKeys
+}
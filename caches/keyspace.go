@@ -0,0 +1,82 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+var (
+	// ErrNotInteger 说明自增的数据不是一个十进制的整数，或者自增之后溢出了。
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+)
+
+// Incr 将 key 对应的数据当作十进制的整数加上 delta，返回加上之后的值，delta 为负数就是自减。
+// 数据不存在或者已经过期的话会从 0 开始加，并且永不过期，存在的话会保留原本剩余的寿命。
+// 数据是以十进制字符串的形式存储的，所以使用 Get 获取到的也是字符串，比如 "42"。
+func (c *Cache) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.segmentOf(key).incr(key, delta)
+}
+
+// Expire 重新设置 key 对应的数据的寿命，单位是秒，NeverDie 表示永不过期，返回数据是否存在。
+// 新的寿命是从现在开始计算的，而不是从数据创建的时候开始计算。
+func (c *Cache) Expire(ctx context.Context, key string, ttl int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return c.segmentOf(key).expire(key, ttl), nil
+}
+
+// Exists 返回 key 对应的数据是否存在，和 Get 不同的是，它不会更新数据的访问时间，所以也不会延长数据的寿命。
+func (c *Cache) Exists(key string) bool {
+	_, ok := c.segmentOf(key).peekEntry(key)
+	return ok
+}
+
+// incr 在写锁中将数据加上 delta，返回加上之后的值。
+func (s *segment) incr(key string, delta int64) (int64, error) {
+	s.wlock()
+	defer s.unlock()
+
+	current, ttl := int64(0), int64(NeverDie)
+	if oldValue, ok := s.engine.get(key); ok && oldValue.alive() {
+		n, err := strconv.ParseInt(string(oldValue.Data), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		current, ttl = n, oldValue.remainingTTL()
+	}
+
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrNotInteger
+	}
+
+	current += delta
+	if err := s.store(key, []byte(strconv.FormatInt(current, 10)), ttl); err != nil {
+		return 0, err
+	}
+	return current, nil
+}
+
+// expire 在写锁中重新设置数据的寿命，返回数据是否存在。
+func (s *segment) expire(key string, ttl int64) bool {
+	s.wlock()
+	defer s.lock.Unlock()
+
+	oldValue, ok := s.engine.get(key)
+	if !ok || !oldValue.alive() {
+		return false
+	}
+
+	if err := s.engine.put(key, s.newValue(oldValue.Data, ttl)); err != nil {
+		helpers.Warn("Failed to reset ttl", "key", key, "err", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package caches
+
+import (
+	"context"
+)
+
+// LoadFunc 是缓存未命中时加载数据的函数，比如从数据库中查询。
+// 加载到的数据会以 ttl 存储到缓存中，ok 为 false 表示数据在后端也不存在，这时不会存储任何数据。
+type LoadFunc func(ctx context.Context, key string) (value []byte, ttl int64, ok bool, err error)
+
+// loadResult 是一次加载的结果，会被等待同一个 key 的所有调用共享。
+type loadResult struct {
+	value []byte
+
+	ttl int64
+
+	ok bool
+}
+
+// GetOrLoad 返回指定 key 的 value，如果 key 不存在，就调用 load 加载数据并存储到缓存中，然后返回加载的数据。
+func (c *Cache) GetOrLoad(key string, load LoadFunc) ([]byte, bool, error) {
+	return c.GetOrLoadContext(context.Background(), key, load)
+}
+
+// GetOrLoadContext 和 GetOrLoad 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+// 同一时间同一个 key 只会有一个 load 在执行，其他未命中的调用会等待并共享它的结果，
+// 所以热点 key 过期的时候，不管有多少请求同时未命中，后端都只会收到一次查询。
+// 存储加载的数据失败并不会影响返回的结果，下一次获取的时候会重新加载。加载的数据不会同步到 Writer 中。
+// 设置了 NegativeTTL 的话，load 返回不存在的 key 在 NegativeTTL 之内都不会再去加载。
+func (c *Cache) GetOrLoadContext(ctx context.Context, key string, load LoadFunc) (value []byte, ok bool, err error) {
+	if value, ok, err = c.getContext(ctx, key); ok || err != nil {
+		return value, ok, err
+	}
+
+	loaded, err := c.load(ctx, key, load)
+	return loaded.value, loaded.ok, err
+}
+
+// load 调用 load 加载 key 对应的数据并存储到缓存中，同一时间同一个 key 只会有一个 load 在执行。
+func (c *Cache) load(ctx context.Context, key string, load LoadFunc) (loadResult, error) {
+	negativeTTL := c.currentOptions().NegativeTTL
+	if negativeTTL > 0 && c.negatives.contains(key) {
+		negativeHits.Inc()
+		return loadResult{}, nil
+	}
+
+	result, err, shared := c.loads.DoContext(ctx, key, func() (interface{}, error) {
+		// 等待执行的过程中其他调用可能刚刚加载好，所以再获取一次
+		if entry, ok := c.segmentOf(key).getEntry(key); ok {
+			return loadResult{value: entry.Value, ttl: entry.Ttl, ok: true}, nil
+		}
+
+		loads.Inc()
+		value, ttl, ok, err := load(ctx, key)
+		if err != nil {
+			return loadResult{}, err
+		}
+
+		if !ok {
+			if negativeTTL > 0 {
+				c.negatives.add(key, negativeTTL)
+			}
+			return loadResult{}, nil
+		}
+
+		// 加载的数据本来就来自后端存储，所以直接写入 segment，不需要再同步回去
+		sets.Inc()
+		c.segmentOf(key).set(key, value, ttl)
+		return loadResult{value: value, ttl: ttl, ok: true}, nil
+	})
+
+	if shared {
+		// 共享的是其他调用的结果，如果是因为它的 ctx 被取消或者超时而失败的，当前的调用需要重新加载
+		if ctx.Err() == nil && (err == context.Canceled || err == context.DeadlineExceeded) {
+			return c.load(ctx, key, load)
+		}
+		sharedLoads.Inc()
+	}
+
+	loaded, _ := result.(loadResult)
+	return loaded, err
+}
@@ -0,0 +1,97 @@
+package caches
+
+import (
+	"github.com/herrhu97/go-distributed-cache/metrics"
+)
+
+var (
+	// getHits 和 getMisses 是读取数据时命中和没有命中的次数。
+	getHits = metrics.Default.Counter("kafo_cache_gets_total", "The number of get operations.", "result", "hit")
+
+	getMisses = metrics.Default.Counter("kafo_cache_gets_total", "The number of get operations.", "result", "miss")
+
+	// sets 和 deletes 是写入和删除数据的次数。
+	sets = metrics.Default.Counter("kafo_cache_sets_total", "The number of set operations.")
+
+	deletes = metrics.Default.Counter("kafo_cache_deletes_total", "The number of delete operations.")
+
+	// evictions 是触发写满保护之后按照淘汰策略淘汰的数据个数。
+	evictions = metrics.Default.Counter("kafo_cache_evictions_total", "The number of entries evicted by the eviction policy.")
+
+	// loads 和 sharedLoads 是缓存未命中时调用加载函数的次数和共享其他调用加载结果的次数。
+	loads = metrics.Default.Counter("kafo_cache_loads_total", "The number of calls to the load function on cache misses.")
+
+	sharedLoads = metrics.Default.Counter("kafo_cache_shared_loads_total", "The number of cache misses served by a concurrent load of the same key.")
+
+	// negativeHits 是未命中的 key 因为被记录为不存在而没有去加载的次数。
+	negativeHits = metrics.Default.Counter("kafo_cache_negative_hits_total", "The number of cache misses answered by a cached not-found result.")
+
+	// staleHits 是返回过期的旧数据并在后台重新加载的次数。
+	staleHits = metrics.Default.Counter("kafo_cache_stale_hits_total", "The number of expired entries served stale while being refreshed.")
+
+	// unspills 是溢出到磁盘上的数据被访问之后提升回内存的次数。
+	unspills = metrics.Default.Counter("kafo_cache_unspills_total", "The number of spilled entries promoted back to memory on access.")
+
+	// gcDuration 和 gcCleaned 是 GC 任务的耗时和清理的数据个数。
+	gcDuration = metrics.Default.Histogram("kafo_cache_gc_duration_seconds", "The duration of gc tasks.", nil)
+
+	gcCleaned = metrics.Default.Counter("kafo_cache_gc_cleaned_total", "The number of entries cleaned by gc tasks.")
+
+	// dumpDuration 和 dumpErrors 是持久化任务的耗时和失败的次数。
+	dumpDuration = metrics.Default.Histogram("kafo_cache_dump_duration_seconds", "The duration of dump tasks.", nil)
+
+	dumpErrors = metrics.Default.Counter("kafo_cache_dump_errors_total", "The number of failed dump tasks.")
+)
+
+// registerGauges 注册缓存的数据情况相关的仪表，只会在上报的时候才去统计。
+// 一个程序中一般只有一个缓存，如果创建了多个缓存，仪表上报的是最后创建的那个缓存的数据情况。
+func registerGauges(c *Cache) {
+	metrics.Default.GaugeFunc("kafo_cache_entries", "The number of entries in cache.", func() float64 {
+		return float64(c.Status().Count)
+	})
+
+	metrics.Default.GaugeFunc("kafo_cache_entry_bytes", "The size of keys and values in cache.", func() float64 {
+		status := c.Status()
+		return float64(status.entrySize())
+	})
+
+	metrics.Default.GaugeFunc("kafo_cache_spilled_entries", "The number of entries spilled to disk.", func() float64 {
+		return float64(c.SpillStatus().Count)
+	})
+
+	metrics.Default.GaugeFunc("kafo_cache_spilled_bytes", "The size of keys and values spilled to disk.", func() float64 {
+		return float64(c.SpillStatus().Bytes)
+	})
+
+	metrics.Default.GaugeFunc("kafo_cache_arena_mapped_bytes", "The size of memory mapped by the value arena.", func() float64 {
+		return float64(c.ArenaStatus().Mapped)
+	})
+
+	metrics.Default.GaugeFunc("kafo_cache_arena_used_bytes", "The size of arena chunks used by values.", func() float64 {
+		return float64(c.ArenaStatus().Used)
+	})
+}
+
+// observeGet 记录一次读取数据是否命中了。
+func observeGet(hit bool) {
+	if hit {
+		getHits.Inc()
+		return
+	}
+	getMisses.Inc()
+}
+
+// HitStats 返回读取数据时命中和没有命中的次数，统计的是整个程序中所有缓存的读取操作。
+func HitStats() (hits int64, misses int64) {
+	return getHits.Value(), getMisses.Value()
+}
+
+// EvictionCount 返回按照淘汰策略淘汰的数据个数，统计的是整个程序中所有缓存的淘汰操作。
+func EvictionCount() int64 {
+	return evictions.Value()
+}
+
+// DumpErrorCount 返回持久化失败的次数，统计的是整个程序中所有缓存的持久化任务。
+func DumpErrorCount() int64 {
+	return dumpErrors.Value()
+}
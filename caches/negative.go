@@ -0,0 +1,83 @@
+package caches
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxNegativeEntries 是最多记录的不存在的 key 的个数，不存在的 key 是无穷无尽的，不限制的话随机的 key 会让记录无限增长。
+	// 记录满了之后新的 key 不会再被记录，直到 GC 清理掉过期的记录。
+	maxNegativeEntries = 1 << 16
+)
+
+// negatives 记录着后端存储中不存在的 key，在过期之前这些 key 未命中的时候不会再去加载。
+type negatives struct {
+	// expires 存储着 key 的过期时间，是以纳秒为单位的 Unix 时间戳。
+	expires map[string]int64
+
+	// lock 保护 expires。
+	lock *sync.Mutex
+}
+
+// newNegatives 返回一个空的 negatives。
+func newNegatives() *negatives {
+	return &negatives{
+		expires: map[string]int64{},
+		lock:    &sync.Mutex{},
+	}
+}
+
+// add 记录 key 在后端存储中不存在，ttl 之后过期。
+func (n *negatives) add(key string, ttl time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if _, ok := n.expires[key]; ok || len(n.expires) < maxNegativeEntries {
+		n.expires[key] = time.Now().Add(ttl).UnixNano()
+	}
+}
+
+// contains 返回 key 是否被记录为不存在并且还没有过期。
+func (n *negatives) contains(key string) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	expire, ok := n.expires[key]
+	if ok && expire <= time.Now().UnixNano() {
+		delete(n.expires, key)
+		return false
+	}
+	return ok
+}
+
+// remove 删除 key 的记录，key 被写入之后就不再是不存在的了。
+func (n *negatives) remove(key string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.expires, key)
+}
+
+// gc 清理掉所有过期的记录，返回清理的个数。
+func (n *negatives) gc() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	now := time.Now().UnixNano()
+	cleaned := 0
+	for key, expire := range n.expires {
+		if expire <= now {
+			delete(n.expires, key)
+			cleaned++
+		}
+	}
+	return cleaned
+}
+
+// forgetNegative 删除 key 不存在的记录，写入 key 之后需要调用，避免数据过期之后仍然被当成不存在。
+func (c *Cache) forgetNegative(key string) {
+	// 没有开启的时候不需要去竞争 negatives 的锁
+	if c.currentOptions().NegativeTTL > 0 {
+		c.negatives.remove(key)
+	}
+}
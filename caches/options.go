@@ -1,27 +1,54 @@
 package caches
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+// 持久化的背压策略，持久化已经不会阻塞读写操作了，这些策略不再有作用，保留只是为了兼容使用了 WithDumpPolicy 的代码。
+//
+// Deprecated: 持久化的时候只会逐个 segment 短暂地持有读锁复制数据，已经不会阻塞读写操作了。
+const (
+	// DumpPolicyBlock 表示持久化的时候所有的读写操作都会阻塞等待，直到持久化完成或者等待超时。
+	DumpPolicyBlock = "block"
+
+	// DumpPolicyFailFast 表示持久化的时候所有的读写操作都会直接返回 ErrBusyDumping 错误，由调用方决定是否重试。
+	DumpPolicyFailFast = "fail-fast"
+
+	// DumpPolicyAllowReads 表示持久化的时候读操作可以正常进行，只有写操作会阻塞等待。
+	// 持久化的时候读操作不会更新数据的访问时间，也不会清理过期的数据，避免修改正在持久化的数据。
+	DumpPolicyAllowReads = "allow-reads"
+)
+
 // Options 是一些选项的结构体
 type Options struct {
 	// MaxEntrySize 是写满保护的一个阈值，当缓存中的键值对占用空间达到这个值，就会触发写满保护。
-	// 这个值的单位是 GB。
+	// 这个值的单位是 MB。
 	MaxEntrySize int
 
 	// MaxGcCount 是自动淘汰机制的一个阈值，当清理的数据达到了这个值后就会停止清理了。
 	MaxGcCount int
 
 	// GcDuration 是自动淘汰机制的时间间隔，每隔固定的 GcDuration 时间就会进行一次自动淘汰。
-	// 这个值的单位是分钟。
-	GcDuration int
+	GcDuration time.Duration
+
+	// GcLockTime 是自动淘汰的时候每一批最多持有写锁的时间，超过之后会释放写锁让其他操作先执行，然后再继续清理下一批。
+	// 数据很多的 segment 在自动淘汰的时候就不会长时间阻塞写操作了，0 表示不限制，一次持有写锁清理完。
+	GcLockTime time.Duration
 
 	// DumpFile 是持久化文件的路径。
+	// 通过程序入口启动的时候，路径中的 {node} 和 {port} 会被替换成节点的地址和端口，避免同一台机器上的多个节点互相覆盖持久化文件。
 	DumpFile string
 
 	// DumpDuration 是持久化的时间间隔。
 	// 我们知道持久化是需要定时执行的，这个定时的间隔可以是很大，也可以是很小。
 	// 很大会导致持久化的数据不够新，假设设置为 1 个星期持久化一次，那么一旦在下一次持久化执行之前缓存崩了，那就丢失一个星期的数据了。
 	// 很小会导致持久化太频繁占用性能，假设设置为 1 秒持久化一次，那这个缓存的就几乎一直在进行持久化了。
-	// 所以这个值的设定是需要考量的，最起码需要根据业务来定，这里就需要给用户去配置。这个值的单位是分钟。
-	DumpDuration int
+	// 所以这个值的设定是需要考量的，最起码需要根据业务来定，这里就需要给用户去配置。
+	DumpDuration time.Duration
 
 	// MapSizeOfSegment 指 segment 中 map 的初始化大小。
 	MapSizeOfSegment int
@@ -30,20 +57,349 @@ type Options struct {
 	SegmentSize int
 
 	// CasSleepTime 指每一次 CAS 自旋需要等待的时间。
-    // 单位是微秒。
-	CasSleepTime int
+	CasSleepTime time.Duration
+
+	// MaxEntriesPerSegment 是单个 segment 中最多可以存放的数据个数，用于限制 map 的增长以及 GC 扫描的时间。
+	// 超过这个值的时候会按照 EvictionPolicy 淘汰数据，不淘汰的话就拒绝写入，如果设置为 0 就表示不限制。
+	MaxEntriesPerSegment int
+
+	// MaxCount 是整个缓存中最多可以存放的数据个数，适合数据个数而不是数据大小才是瓶颈的场景，超过之后的处理和 MaxEntriesPerSegment 一样。
+	// 和 MaxEntrySize 一样是平均分到每个 segment 上判断的，每个 segment 最多存放 MaxCount / SegmentSize 个数据（向上取整），
+	// 所以数据分布不均匀的时候，总数没有达到上限也可能触发淘汰。如果设置为 0 就表示不限制。
+	MaxCount int
+
+	// EvictionPolicy 是触发写满保护时的淘汰策略，可选值有 none、lru、lfu 和 random，none 表示不淘汰数据而是拒绝写入。
+	EvictionPolicy string
+
+	// ValueArena 表示是否把数据存储在 Go 的堆以外的 arena 中，缓存中有几百万个数据的时候可以明显减少 GC 的工作量。
+	// 代价是读取数据的时候需要复制一份，并且 arena 申请到的内存不会还给操作系统。
+	// 和 SegmentSize 一样决定了缓存的结构，需要重启才会生效，从持久化文件中恢复的时候使用的是持久化文件中的配置。
+	ValueArena bool
+
+	// NegativeTTL 是 Loader 返回不存在的 key 被记录的时间，这段时间内这个 key 未命中的时候不会再去加载，避免不存在的 key 一直打到后端存储。
+	// 一般设置得比较短，比如 30s，0 表示不记录。写入这个 key 的时候会删除它的记录。
+	NegativeTTL time.Duration
+
+	// StaleTTL 是数据过期之后还可以作为旧数据返回的时间，返回旧数据的同时会在后台使用 Loader 重新加载，热点数据过期的时候读操作就不需要等待加载了。
+	// 只有设置了 Loader 才会生效，过期的数据会多保留 StaleTTL 才被清理，精度是秒，0 表示不返回旧数据。HTTP 接口会使用 Warning 头部标记返回的旧数据。
+	StaleTTL time.Duration
+
+	// SpillDir 是溢出数据存储的目录，设置之后按照淘汰策略淘汰的数据不会被丢弃，而是溢出到这个目录中的日志文件里，
+	// 之后访问的时候再提升回内存，这样比内存稍大一点的数据集也可以完整地提供服务，需要配合 lru、lfu 或者 random 淘汰策略使用。
+	// 只有 Get 会读取磁盘上的数据，Incr、Expire、事务以及遍历 key 都只能看到内存中的数据。为空表示不溢出，需要重启才会生效。
+	SpillDir string
+
+	// SpillThreshold 是直接存储在磁盘上的数据大小，不小于这个值的数据不会占用内存，每次读取的时候都从磁盘上读取。
+	// 单位是字节，只有设置了 SpillDir 才会生效，0 表示只有被淘汰的数据才会溢出到磁盘上。
+	SpillThreshold int
+
+	// Engine 是 segment 存储数据的引擎，可选值有 memory 和 bolt，bolt 会把数据存储在 EnginePath 指定的 bbolt 数据库文件中。
+	// 使用 bolt 的时候数据本身就在磁盘上，重启之后不需要从持久化文件中恢复，所以不会使用 DumpFile，也不能和 ValueArena 以及 SpillDir 一起使用。
+	// bolt 引擎不会保存访问时间和访问次数，所以数据不会因为被访问而延长寿命，lru 和 lfu 淘汰策略也只能看到写入时的情况。需要重启才会生效。
+	Engine string
+
+	// EnginePath 是 bolt 引擎的数据库文件路径，只有 Engine 是 bolt 的时候才会使用。
+	EnginePath string
 }
 
 // DefaultOptions 返回一个默认的选项设置对象
 func DefaultOptions() Options {
 	return Options{
-		MaxEntrySize: 4, // 4 GB
+		MaxEntrySize: 4096, // 4 GB
 		MaxGcCount:   10,
-		GcDuration:   60, // 1 hour
+		GcDuration:   time.Hour,
+		GcLockTime:   time.Millisecond,
 		DumpFile:     "cache-server.dump",
-		DumpDuration: 30, // 30 minutes
+		DumpDuration: 30 * time.Minute,
 		MapSizeOfSegment: 256,
 		SegmentSize: 1024,
-		CasSleepTime: 1000, // 1ms
+		CasSleepTime: time.Millisecond,
+		EvictionPolicy: EvictionPolicyNone,
+		Engine: EngineMemory,
+	}
+}
+
+// Option 是缓存的可选配置，用于 New 函数，没有设置的配置都会使用 DefaultOptions 中的默认值。
+type Option func(options *Options)
+
+// WithOptions 使用 options 替换所有的配置，后面的 Option 还可以继续修改其中的某些配置。
+func WithOptions(options Options) Option {
+	return func(o *Options) {
+		*o = options
+	}
+}
+
+// WithMaxEntrySize 设置写满保护的阈值，单位是 MB。
+func WithMaxEntrySize(maxEntrySize int) Option {
+	return func(options *Options) {
+		options.MaxEntrySize = maxEntrySize
+	}
+}
+
+// WithMaxGcCount 设置每次自动淘汰最多清理的数据个数。
+func WithMaxGcCount(maxGcCount int) Option {
+	return func(options *Options) {
+		options.MaxGcCount = maxGcCount
+	}
+}
+
+// WithGcLockTime 设置自动淘汰的时候每一批最多持有写锁的时间，0 表示不限制。
+func WithGcLockTime(lockTime time.Duration) Option {
+	return func(options *Options) {
+		options.GcLockTime = lockTime
+	}
+}
+
+// WithGcDuration 设置自动淘汰的时间间隔。
+func WithGcDuration(duration time.Duration) Option {
+	return func(options *Options) {
+		options.GcDuration = duration
+	}
+}
+
+// WithDumpFile 设置持久化文件的路径，设置为空的话就不会持久化，也不会从持久化文件中恢复数据。
+func WithDumpFile(dumpFile string) Option {
+	return func(options *Options) {
+		options.DumpFile = dumpFile
+	}
+}
+
+// WithDumpDuration 设置持久化的时间间隔。
+func WithDumpDuration(duration time.Duration) Option {
+	return func(options *Options) {
+		options.DumpDuration = duration
+	}
+}
+
+// WithSegments 设置 segment 的个数以及每个 segment 中 map 的初始化大小，segment 的个数不是 2 的幂的话会被向上取整到 2 的幂。
+func WithSegments(segmentSize int, mapSizeOfSegment int) Option {
+	return func(options *Options) {
+		options.SegmentSize = segmentSize
+		options.MapSizeOfSegment = mapSizeOfSegment
+	}
+}
+
+// WithCasSleepTime 设置每一次 CAS 自旋需要等待的时间。
+func WithCasSleepTime(duration time.Duration) Option {
+	return func(options *Options) {
+		options.CasSleepTime = duration
+	}
+}
+
+// WithDumpPolicy 原本用于设置持久化时的背压策略，现在不会修改任何配置，只会打印一条警告日志。
+//
+// Deprecated: 持久化已经不会阻塞读写操作了，这个配置不再有作用。
+func WithDumpPolicy(policy string, waitTimeout time.Duration) Option {
+	return func(options *Options) {
+		warnDeprecatedDumpPolicy()
+	}
+}
+
+// warnDeprecatedDumpPolicy 提醒使用者持久化的背压策略已经不再有作用了，避免设置了之后以为它还在生效。
+func warnDeprecatedDumpPolicy() {
+	helpers.Warn("DumpPolicy and DumpWaitTimeout are deprecated and have no effect, dumping no longer blocks operations")
+}
+
+// WithMaxEntriesPerSegment 设置单个 segment 中最多可以存放的数据个数，0 表示不限制。
+func WithMaxEntriesPerSegment(maxEntries int) Option {
+	return func(options *Options) {
+		options.MaxEntriesPerSegment = maxEntries
+	}
+}
+
+// WithMaxCount 设置整个缓存中最多可以存放的数据个数，0 表示不限制。
+func WithMaxCount(maxCount int) Option {
+	return func(options *Options) {
+		options.MaxCount = maxCount
+	}
+}
+
+// WithEvictionPolicy 设置触发写满保护时的淘汰策略。
+func WithEvictionPolicy(policy string) Option {
+	return func(options *Options) {
+		options.EvictionPolicy = policy
+	}
+}
+
+// WithValueArena 设置是否把数据存储在 Go 的堆以外的 arena 中。
+func WithValueArena(enabled bool) Option {
+	return func(options *Options) {
+		options.ValueArena = enabled
+	}
+}
+
+// WithNegativeTTL 设置 Loader 返回不存在的 key 被记录的时间，0 表示不记录。
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(options *Options) {
+		options.NegativeTTL = ttl
+	}
+}
+
+// WithStaleTTL 设置数据过期之后还可以作为旧数据返回的时间，0 表示不返回旧数据。
+func WithStaleTTL(ttl time.Duration) Option {
+	return func(options *Options) {
+		options.StaleTTL = ttl
+	}
+}
+
+// WithSpill 设置溢出数据存储的目录以及直接存储在磁盘上的数据大小，dir 为空表示不溢出。
+func WithSpill(dir string, threshold int) Option {
+	return func(options *Options) {
+		options.SpillDir = dir
+		options.SpillThreshold = threshold
+	}
+}
+
+// WithEngine 设置 segment 存储数据的引擎，path 是 bolt 引擎的数据库文件路径。
+func WithEngine(engine string, path string) Option {
+	return func(options *Options) {
+		options.Engine = engine
+		options.EnginePath = path
+	}
+}
+
+// Validate 校验选项配置，不合法的配置会返回说明原因的错误。
+// SegmentSize 不是 2 的幂的时候，segmentOf 会有一部分 segment 永远用不到，所以会被向上取整到最近的 2 的幂，而不是返回错误。
+func (o *Options) Validate() error {
+	if o.MaxEntrySize <= 0 {
+		return fmt.Errorf("invalid MaxEntrySize %d: must be greater than 0", o.MaxEntrySize)
+	}
+
+	if o.MaxGcCount < 0 {
+		return fmt.Errorf("invalid MaxGcCount %d: must not be negative", o.MaxGcCount)
+	}
+
+	if o.GcDuration <= 0 {
+		return fmt.Errorf("invalid GcDuration %v: must be greater than 0", o.GcDuration)
+	}
+
+	if o.GcLockTime < 0 {
+		return fmt.Errorf("invalid GcLockTime %v: must not be negative", o.GcLockTime)
+	}
+
+	// 没有设置持久化文件的时候不会开启自动持久化，所以也就不需要校验持久化的时间间隔
+	if o.DumpFile != "" && o.DumpDuration <= 0 {
+		return fmt.Errorf("invalid DumpDuration %v: must be greater than 0 when DumpFile is set", o.DumpDuration)
+	}
+
+	if o.MapSizeOfSegment < 0 {
+		return fmt.Errorf("invalid MapSizeOfSegment %d: must not be negative", o.MapSizeOfSegment)
+	}
+
+	if o.SegmentSize <= 0 {
+		return fmt.Errorf("invalid SegmentSize %d: must be greater than 0", o.SegmentSize)
+	}
+	o.SegmentSize = roundUpToPowerOfTwo(o.SegmentSize)
+
+	if o.CasSleepTime <= 0 {
+		return fmt.Errorf("invalid CasSleepTime %v: must be greater than 0", o.CasSleepTime)
+	}
+
+	if o.MaxEntriesPerSegment < 0 {
+		return fmt.Errorf("invalid MaxEntriesPerSegment %d: must not be negative", o.MaxEntriesPerSegment)
+	}
+
+	if o.MaxCount < 0 {
+		return fmt.Errorf("invalid MaxCount %d: must not be negative", o.MaxCount)
+	}
+
+	if !validEvictionPolicy(o.EvictionPolicy) {
+		return fmt.Errorf("invalid EvictionPolicy %q: must be one of %s, %s, %s and %s", o.EvictionPolicy, EvictionPolicyNone, EvictionPolicyLRU, EvictionPolicyLFU, EvictionPolicyRandom)
+	}
+
+	if o.NegativeTTL < 0 {
+		return fmt.Errorf("invalid NegativeTTL %v: must not be negative", o.NegativeTTL)
+	}
+
+	if o.StaleTTL < 0 {
+		return fmt.Errorf("invalid StaleTTL %v: must not be negative", o.StaleTTL)
+	}
+
+	if o.SpillThreshold < 0 {
+		return fmt.Errorf("invalid SpillThreshold %d: must not be negative", o.SpillThreshold)
+	}
+
+	if !validEngine(o.Engine) {
+		return fmt.Errorf("invalid Engine %q: must be one of %s and %s", o.Engine, EngineMemory, EngineBolt)
+	}
+
+	if o.Engine == EngineBolt {
+		if o.EnginePath == "" {
+			return fmt.Errorf("invalid EnginePath %q: must not be empty when Engine is %s", o.EnginePath, EngineBolt)
+		}
+
+		if o.ValueArena || o.SpillDir != "" {
+			return fmt.Errorf("invalid Engine %q: can't be used with ValueArena or SpillDir", o.Engine)
+		}
 	}
+	return nil
+}
+
+// roundUpToPowerOfTwo 返回大于等于 n 的最小的 2 的幂，n 需要大于 0。
+func roundUpToPowerOfTwo(n int) int {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	return size
+}
+
+// optionsJSON 是 Options 在 JSON 中的格式，时间间隔使用 "90s"、"2h" 这样的字符串表示。
+type optionsJSON struct {
+	*jsonOptions
+	GcDuration   helpers.Duration
+	GcLockTime   helpers.Duration
+	DumpDuration helpers.Duration
+	CasSleepTime helpers.Duration
+	NegativeTTL  helpers.Duration
+	StaleTTL     helpers.Duration
+
+	// DumpPolicy 和 DumpWaitTimeout 已经从 Options 中删除了，旧的配置文件中还有的话只会打印一条警告日志。
+	DumpPolicy      json.RawMessage `json:",omitempty"`
+	DumpWaitTimeout json.RawMessage `json:",omitempty"`
+}
+
+// jsonOptions 和 Options 的字段一样，但是没有 Options 的方法，避免序列化的时候无限递归。
+type jsonOptions Options
+
+// MarshalJSON 把 Options 序列化成 JSON，时间间隔会序列化成字符串。
+func (o Options) MarshalJSON() ([]byte, error) {
+	return json.Marshal(optionsJSON{
+		jsonOptions:  (*jsonOptions)(&o),
+		GcDuration:   helpers.Duration(o.GcDuration),
+		GcLockTime:   helpers.Duration(o.GcLockTime),
+		DumpDuration: helpers.Duration(o.DumpDuration),
+		CasSleepTime: helpers.Duration(o.CasSleepTime),
+		NegativeTTL:  helpers.Duration(o.NegativeTTL),
+		StaleTTL:     helpers.Duration(o.StaleTTL),
+	})
+}
+
+// UnmarshalJSON 从 JSON 中解析出 Options，时间间隔可以是字符串也可以是以纳秒为单位的数字，JSON 中没有出现的字段会保留原来的值。
+func (o *Options) UnmarshalJSON(data []byte) error {
+	aux := optionsJSON{
+		jsonOptions:  (*jsonOptions)(o),
+		GcDuration:   helpers.Duration(o.GcDuration),
+		GcLockTime:   helpers.Duration(o.GcLockTime),
+		DumpDuration: helpers.Duration(o.DumpDuration),
+		CasSleepTime: helpers.Duration(o.CasSleepTime),
+		NegativeTTL:  helpers.Duration(o.NegativeTTL),
+		StaleTTL:     helpers.Duration(o.StaleTTL),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.DumpPolicy != nil || aux.DumpWaitTimeout != nil {
+		warnDeprecatedDumpPolicy()
+	}
+
+	o.GcDuration = time.Duration(aux.GcDuration)
+	o.GcLockTime = time.Duration(aux.GcLockTime)
+	o.DumpDuration = time.Duration(aux.DumpDuration)
+	o.CasSleepTime = time.Duration(aux.CasSleepTime)
+	o.NegativeTTL = time.Duration(aux.NegativeTTL)
+	o.StaleTTL = time.Duration(aux.StaleTTL)
+	return nil
 }
@@ -0,0 +1,50 @@
+package caches
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// ProfileSmall 适合内存在 2 GB 左右的小机器，比如开发环境或者边缘节点。
+	ProfileSmall = "small"
+
+	// ProfileMedium 适合内存在 8 GB 左右的普通机器，和默认的配置差不多。
+	ProfileMedium = "medium"
+
+	// ProfileLarge 适合内存在 32 GB 以上的大机器，数据多了之后需要更多的 segment 来降低锁的竞争。
+	ProfileLarge = "large"
+)
+
+// ProfileOptions 返回预设 profile 对应的选项配置，没有在预设中设置的配置都使用 DefaultOptions 中的默认值。
+// 预设只是一个调优的起点，其中的配置都可以再单独修改。MaxEntrySize 大概是机器内存的一半，剩下的内存留给持久化和其他开销。
+func ProfileOptions(profile string) (Options, error) {
+	options := DefaultOptions()
+	switch profile {
+	case ProfileSmall:
+		options.MaxEntrySize = 1024 // 1 GB
+		options.MaxGcCount = 10
+		options.GcDuration = 30 * time.Minute
+		options.DumpDuration = 30 * time.Minute
+		options.SegmentSize = 256
+		options.MapSizeOfSegment = 64
+	case ProfileMedium:
+		options.MaxEntrySize = 4096 // 4 GB
+		options.MaxGcCount = 100
+		options.GcDuration = 20 * time.Minute
+		options.DumpDuration = 30 * time.Minute
+		options.SegmentSize = 1024
+		options.MapSizeOfSegment = 256
+	case ProfileLarge:
+		// 数据越多持久化越慢，所以持久化的间隔要长一些，而 GC 要更频繁一些，避免过期的数据堆积
+		options.MaxEntrySize = 16384 // 16 GB
+		options.MaxGcCount = 1000
+		options.GcDuration = 10 * time.Minute
+		options.DumpDuration = time.Hour
+		options.SegmentSize = 4096
+		options.MapSizeOfSegment = 1024
+	default:
+		return options, fmt.Errorf("unknown profile %q: must be one of %s, %s and %s", profile, ProfileSmall, ProfileMedium, ProfileLarge)
+	}
+	return options, nil
+}
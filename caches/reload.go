@@ -0,0 +1,71 @@
+package caches
+
+// Reload 热加载缓存的选项配置，只有运行过程中可以修改的配置才会生效，包括写满保护的阈值、单个 segment 以及整个缓存的数据个数上限、自动淘汰和自动持久化的配置、
+// CAS 自旋的等待时间、淘汰策略、不存在的 key 的记录时间、返回旧数据的时间以及直接存储在磁盘上的数据大小。
+// DumpFile、SegmentSize、MapSizeOfSegment、ValueArena、SpillDir、Engine 和 EnginePath 决定了缓存的结构，需要重启才会生效，重启之后从持久化文件中恢复的数据也会使用新的配置。
+// 自动淘汰和自动持久化的时间间隔修改之后会重新开始计时。
+// 热加载之后的配置不合法的话会返回说明原因的错误，这时候缓存会继续使用原来的配置。
+func (c *Cache) Reload(options Options) error {
+	c.reloadLock.Lock()
+	defer c.reloadLock.Unlock()
+	return c.reload(options)
+}
+
+// reload 热加载缓存的选项配置，调用方需要持有 reloadLock。
+func (c *Cache) reload(options Options) error {
+	oldOptions := c.currentOptions()
+	newOptions := *oldOptions
+	newOptions.MaxEntrySize = options.MaxEntrySize
+	newOptions.MaxEntriesPerSegment = options.MaxEntriesPerSegment
+	newOptions.MaxCount = options.MaxCount
+	newOptions.MaxGcCount = options.MaxGcCount
+	newOptions.GcDuration = options.GcDuration
+	newOptions.GcLockTime = options.GcLockTime
+	newOptions.DumpDuration = options.DumpDuration
+	newOptions.CasSleepTime = options.CasSleepTime
+	newOptions.EvictionPolicy = options.EvictionPolicy
+	newOptions.NegativeTTL = options.NegativeTTL
+	newOptions.StaleTTL = options.StaleTTL
+	newOptions.SpillThreshold = options.SpillThreshold
+	if err := newOptions.Validate(); err != nil {
+		return err
+	}
+
+	// segment 是在自己的锁中读取配置的，所以替换的时候也需要持有对应的锁
+	for _, segment := range c.segments {
+		segment.wlock()
+		segment.options = &newOptions
+		segment.lock.Unlock()
+	}
+	c.options.Store(&newOptions)
+
+	if newOptions.GcDuration != oldOptions.GcDuration {
+		notifyReloaded(c.gcReloaded)
+	}
+
+	if newOptions.DumpDuration != oldOptions.DumpDuration {
+		notifyReloaded(c.dumpReloaded)
+	}
+	return nil
+}
+
+// notifyReloaded 通知定时任务配置已经热加载了，已经有通知没被处理的话就不需要重复通知了。
+func notifyReloaded(reloaded chan struct{}) {
+	select {
+	case reloaded <- struct{}{}:
+	default:
+	}
+}
+
+// SetEvictionPolicy 修改缓存的淘汰策略，马上就会对之后的写入生效。
+func (c *Cache) SetEvictionPolicy(policy string) error {
+	if policy == "" || !validEvictionPolicy(policy) {
+		return ErrUnknownEvictionPolicy
+	}
+
+	c.reloadLock.Lock()
+	defer c.reloadLock.Unlock()
+	options := c.Options()
+	options.EvictionPolicy = policy
+	return c.reload(options)
+}
@@ -0,0 +1,33 @@
+package caches
+
+import (
+	"path/filepath"
+	"time"
+)
+
+const (
+	// replaceRetries 是替换持久化文件的最多尝试次数，Windows 上持久化文件被其他进程打开的时候替换会暂时失败，等一会儿再试就可以了。
+	replaceRetries = 5
+
+	// replaceRetryDelay 是两次替换之间等待的时间。
+	replaceRetryDelay = 100 * time.Millisecond
+
+	// dumpBackupSuffix 是替换持久化文件的过程中旧文件的备份后缀，只有不支持直接覆盖的时候才会使用。
+	// 替换的过程中崩溃的话，持久化文件可能不存在，这个时候会从备份中恢复，所以任何时候都至少有一个可用的持久化文件。
+	dumpBackupSuffix = ".bak"
+)
+
+// replaceFile 使用 newPath 原子地替换 path，失败的话会重试，替换成功之后会同步 path 所在的目录，保证重命名本身也写入了磁盘。
+// 具体的替换方式和平台有关，见 replace 的实现。
+func replaceFile(newPath string, path string) (err error) {
+	for i := 0; i < replaceRetries; i++ {
+		if i > 0 {
+			time.Sleep(replaceRetryDelay)
+		}
+
+		if err = replace(newPath, path); err == nil {
+			return syncDir(filepath.Dir(path))
+		}
+	}
+	return err
+}
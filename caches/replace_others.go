@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package caches
+
+import (
+	"os"
+)
+
+// replace 使用重命名覆盖 path，POSIX 保证重命名是原子的，其他进程看到的要么是旧文件，要么是新文件。
+func replace(newPath string, path string) error {
+	return os.Rename(newPath, path)
+}
+
+// syncDir 同步目录，重命名修改的是目录的内容，不同步的话断电之后重命名可能会丢失。
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
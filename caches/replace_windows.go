@@ -0,0 +1,37 @@
+//go:build windows
+// +build windows
+
+package caches
+
+import (
+	"os"
+)
+
+// replace 使用重命名覆盖 path，Windows 上的重命名使用的是 MoveFileEx，目标文件存在的时候也可以覆盖。
+// 目标文件被其他进程打开的时候覆盖会失败，这个时候先把旧文件改名成备份，再把新文件改名过去，最后删除备份，
+// 新文件改名失败的话会把备份恢复回去，中途崩溃的话恢复的时候也会读取备份。
+func replace(newPath string, path string) error {
+	err := os.Rename(newPath, path)
+	if err == nil {
+		return nil
+	}
+
+	backup := path + dumpBackupSuffix
+	os.Remove(backup)
+	if backupErr := os.Rename(path, backup); backupErr != nil && !os.IsNotExist(backupErr) {
+		return err
+	}
+
+	if err = os.Rename(newPath, path); err != nil {
+		os.Rename(backup, path)
+		return err
+	}
+
+	os.Remove(backup)
+	return nil
+}
+
+// syncDir 在 Windows 上什么也不做，Windows 不支持同步目录，重命名的元数据由文件系统的日志保证。
+func syncDir(dir string) error {
+	return nil
+}
@@ -1,15 +1,26 @@
 package caches
 
 import (
-	"errors"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// gcCheckInterval 是 gc 检查持有写锁的时间是否超过 GcLockTime 的间隔，每遍历这么多个数据检查一次。
+	gcCheckInterval = 64
 )
 
 // segment 数据块结构体
 type segment struct {
-	// Data 存储这个数据块的数据。
+	// Data 存储这个数据块的数据，只有内存引擎会使用，持久化的时候会编码这个字段。
 	Data map[string]*value
 
+	// engine 是这个数据块存储数据的引擎，所有对数据的访问都需要经过它。
+	engine engine
+
 	// Status 记录着这个数据块的情况。
 	Status *Status
 
@@ -18,92 +29,427 @@ type segment struct {
 
 	// lock 用于保证这个数据块的并发安全。
 	lock *sync.RWMutex
+
+	// hooks 是缓存注册的钩子。
+	hooks *hooks
+
+	// events 记录着持有写锁期间发生的数据变更事件，释放写锁的时候会调用钩子处理。
+	events []event
+
+	// contention 记录着这个 segment 的锁竞争情况。
+	contention *lockContention
+
+	// arena 是存储数据的内存分配器，没有开启 ValueArena 的话为 nil。
+	arena *arena
+
+	// spill 是存储溢出数据的磁盘日志，所有的 segment 共用一份，没有设置 SpillDir 的话为 nil。
+	spill *spillLog
+
+	// gcCursor 是下一批 gc 开始遍历的 key，只对有顺序的引擎有意义，遍历到末尾之后会重新从头开始。
+	gcCursor string
 }
 
 // newSegment 返回一个使用options初始化过的segment实例
 func newSegment(options *Options) *segment {
+	data := make(map[string]*value, options.MapSizeOfSegment)
 	return &segment{
-		Data:    make(map[string]*value, options.MapSizeOfSegment),
+		Data:    data,
+		engine:  mapEngine(data),
 		Status:  NewStatus(),
 		options: options,
 		lock:    &sync.RWMutex{},
 	}
 }
 
+// newValue 返回一个包装之后的数据，开启了 ValueArena 的话数据会被复制到 arena 中。
+func (s *segment) newValue(data []byte, ttl int64) *value {
+	if s.arena == nil {
+		return newValue(data, ttl)
+	}
+
+	v := newValue(nil, ttl)
+	v.Data, v.slot = s.arena.copyOf(data)
+	return v
+}
+
+// newStoredValue 返回存储 data 使用的数据，owned 为 true 说明 data 已经交给了缓存，没有使用 arena 的话直接存储 data，不再复制一份。
+func (s *segment) newStoredValue(data []byte, ttl int64, owned bool) *value {
+	if !owned || s.arena != nil {
+		return s.newValue(data, ttl)
+	}
+
+	v := newValue(nil, ttl)
+	v.Data = data
+	return v
+}
+
+// moveToArena 把从持久化文件中恢复的数据复制到 arena 中，只会在缓存初始化的时候调用，所以不需要加锁。
+func (s *segment) moveToArena() {
+	if s.arena == nil {
+		return
+	}
+
+	s.engine.each(func(key string, v *value) bool {
+		if v.slot == nil {
+			v.Data, v.slot = s.arena.copyOf(v.Data)
+		}
+		return true
+	})
+}
+
+// lookup 在读锁中查找 key 对应的存活的数据，找到的数据已经过期的话，会在释放读锁之后清理掉这个数据，然后返回 false。
+// 过期的数据还在 StaleTTL 之内的话不会被清理，因为还可以作为旧数据返回。
+// 数据写入之后就不会被修改了，只有访问时间和访问次数是使用原子操作更新的，所以返回的数据在释放读锁之后也可以安全地访问。
+func (s *segment) lookup(key string) (*value, bool) {
+	s.rlock()
+	value, ok := s.engine.get(key)
+	alive := ok && value.alive()
+	servable := ok && value.servable(s.staleSeconds())
+	s.lock.RUnlock()
+
+	if ok && !servable {
+		s.removeExpired(key, value)
+	}
+	return value, alive
+}
+
+// lookupStale 在读锁中查找 key 对应的已经过期但是还在 StaleTTL 之内的数据，返回数据的元数据。
+// 不会更新访问时间，否则过期的数据会因为被访问而重新存活。
+func (s *segment) lookupStale(key string) (*Entry, bool) {
+	s.rlock()
+	defer s.lock.RUnlock()
+	value, ok := s.engine.get(key)
+	if !ok || value.alive() || !value.servable(s.staleSeconds()) {
+		return nil, false
+	}
+
+	return &Entry{
+		Key:   key,
+		Value: value.bytes(),
+		Ttl:   value.remainingTTL(),
+		Ctime: atomic.LoadInt64(&value.Ctime),
+		Stale: true,
+	}, true
+}
+
+// staleSeconds 返回过期的数据还可以作为旧数据返回的秒数，需要在锁中调用。
+func (s *segment) staleSeconds() int64 {
+	return int64(s.options.StaleTTL / time.Second)
+}
+
 // get 返回指定key的数据
 func (s *segment) get(key string) ([]byte, bool) {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
-	value, ok := s.Data[key]
+	value, ok := s.lookup(key)
 	if !ok {
 		return nil, false
 	}
+	return value.visit(), true
+}
 
-	if !value.alive() {
-		s.lock.RUnlock()
-		s.delete(key)
-		s.lock.RLock()
+// getEntry 返回指定key的数据以及它的元数据
+func (s *segment) getEntry(key string) (*Entry, bool) {
+	value, ok := s.lookup(key)
+	if !ok {
 		return nil, false
 	}
-	return value.visit(), true
+
+	// 注意需要在访问数据之前获取元数据，因为访问数据会更新创建时间
+	entry := &Entry{
+		Key:   key,
+		Ttl:   value.remainingTTL(),
+		Ctime: atomic.LoadInt64(&value.Ctime),
+	}
+	entry.Value = value.visit()
+	return entry, true
+}
+
+// peekEntry 返回指定key的数据以及它的元数据，和 getEntry 不同的是，它不会更新访问时间，也不会删除过期的数据。
+func (s *segment) peekEntry(key string) (*Entry, bool) {
+	s.rlock()
+	defer s.lock.RUnlock()
+	value, ok := s.engine.get(key)
+	if !ok || !value.alive() {
+		return nil, false
+	}
+
+	return &Entry{
+		Key:   key,
+		Value: value.bytes(),
+		Ttl:   value.remainingTTL(),
+		Ctime: atomic.LoadInt64(&value.Ctime),
+	}, true
+}
+
+// keys 返回segment中所有以prefix开头并且存活的key
+func (s *segment) keys(prefix string) []string {
+	s.rlock()
+	defer s.lock.RUnlock()
+	keys := make([]string, 0, s.Status.Count)
+	s.engine.each(func(key string, value *value) bool {
+		if strings.HasPrefix(key, prefix) && value.alive() {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
 }
 
 // set 添加一个数据进segment
 func (s *segment) set(key string, value []byte, ttl int64) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	if oldValue, ok := s.Data[key]; ok {
+	s.wlock()
+	defer s.unlock()
+	return s.store(key, value, ttl)
+}
+
+// setOwned 和 set 一样，只是 value 已经交给了缓存，不需要再复制一份。
+func (s *segment) setOwned(key string, value []byte, ttl int64) error {
+	s.wlock()
+	defer s.unlock()
+	return s.storeValue(key, value, ttl, true)
+}
+
+// setIf 在 condition 返回 true 的时候才添加数据进segment，返回数据是否被添加了。
+// condition 的参数是当前存活的旧数据，如果旧数据不存在或者已经过期，ok 就是 false。
+// 整个判断和添加的过程都是在写锁中进行的，所以可以用来实现乐观并发控制。
+func (s *segment) setIf(key string, value []byte, ttl int64, condition func(oldValue []byte, ok bool) bool) (bool, error) {
+	s.wlock()
+	defer s.unlock()
+
+	var old []byte
+	oldValue, ok := s.engine.get(key)
+	if ok && oldValue.alive() {
+		old = oldValue.Data
+	} else {
+		ok = false
+	}
+
+	if !condition(old, ok) {
+		return false, nil
+	}
+	return true, s.store(key, value, ttl)
+}
+
+// store 添加一个数据进segment，调用者需要持有写锁
+// 设置了 SpillDir 的话，不小于 SpillThreshold 的数据会直接存储在磁盘上，存储在内存中的数据会删除磁盘上的旧数据。
+func (s *segment) store(key string, value []byte, ttl int64) error {
+	return s.storeValue(key, value, ttl, false)
+}
+
+// storeValue 添加一个数据进segment，owned 为 true 说明 value 已经交给了缓存，存储的时候不会再复制，调用者需要持有写锁。
+func (s *segment) storeValue(key string, value []byte, ttl int64, owned bool) error {
+	if s.oversized(value) {
+		return s.spillOut(key, value, ttl)
+	}
+
+	oldValue, exists := s.engine.get(key)
+	if exists {
 		s.Status.subEntry(key, oldValue.Data)
 	}
 
-	if !s.checkEntrySize(key, value) {
-		if oldValue, ok := s.Data[key]; ok {
+	// 旧数据已经从 Status 中减去了，所以覆盖旧数据不会增加 segment 中的数据个数
+	if (!s.checkEntrySize(key, value) || !s.checkEntryCount()) && !s.evict(key, value) {
+		if exists {
 			s.Status.addEntry(key, oldValue.Data)
 		}
-		return errors.New("the entry size will exceed if you set this entry")
+
+		if !s.checkEntrySize(key, value) {
+			return ErrEntryTooLarge
+		}
+		return ErrTooManyEntries
+	}
+
+	stored := s.newStoredValue(value, ttl, owned)
+	if err := s.engine.put(key, stored); err != nil {
+		// 淘汰不会淘汰 key 对应的旧数据，所以旧数据还在引擎中
+		if exists {
+			s.Status.addEntry(key, oldValue.Data)
+		}
+		return err
 	}
 
 	s.Status.addEntry(key, value)
-	s.Data[key] = newValue(value, ttl)
+	s.forgetSpilled(key)
+
+	// value 是调用方的切片，比如 TCP 服务器中会被下一个请求复用的缓冲区，所以钩子拿到的是缓存中存储的副本
+	s.record(eventSet, key, stored.Data)
 	return nil
 }
 
-// delete 从segment中删除指定key的数据
-func (s *segment) delete(key string) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	if oldValue, ok := s.Data[key]; ok {
+// delete 从segment中删除指定key的数据，返回数据删除前是否存在
+func (s *segment) delete(key string) bool {
+	s.wlock()
+	defer s.unlock()
+	spilled := s.forgetSpilled(key)
+	oldValue, ok := s.engine.get(key)
+	if !ok {
+		if spilled {
+			s.record(eventDelete, key, nil)
+		}
+		return spilled
+	}
+
+	s.Status.subEntry(key, oldValue.Data)
+	s.engine.remove(key)
+	if !oldValue.alive() {
+		s.record(eventExpire, key, oldValue.Data)
+		return false
+	}
+	s.record(eventDelete, key, oldValue.Data)
+	return true
+}
+
+// removeExpired 删除读操作发现的已经过期并且超过了 StaleTTL 的数据，read 是读操作读到的数据。
+// 释放读锁再获取写锁的期间，数据可能被其他写操作覆盖了，也可能被访问之后又存活了，
+// 所以只有在写锁中确认 key 对应的还是 read 并且仍然是过期的才会删除，新写入的数据即使也过期了也不会被误删。
+func (s *segment) removeExpired(key string, read *value) {
+	s.wlock()
+	defer s.unlock()
+	if oldValue, ok := s.engine.get(key); ok && oldValue.sameAs(read) && !oldValue.servable(s.staleSeconds()) {
 		s.Status.subEntry(key, oldValue.Data)
-		delete(s.Data, key)
+		s.engine.remove(key)
+		s.record(eventExpire, key, oldValue.Data)
 	}
 }
 
+// removeStale 删除后端存储中已经不存在的旧数据，key 对应的数据已经存活的话说明被重新写入了，不会删除。
+func (s *segment) removeStale(key string) {
+	s.wlock()
+	defer s.unlock()
+	if oldValue, ok := s.engine.get(key); ok && !oldValue.alive() {
+		s.Status.subEntry(key, oldValue.Data)
+		s.engine.remove(key)
+		s.record(eventExpire, key, oldValue.Data)
+	}
+}
+
+// snapshot 在读锁中复制一份 segment 用于持久化，复制之后就可以在不持有锁的情况下编码了。
+// 数据写入之后就不会被修改了，覆盖的时候是整体替换成新的数据，所以只需要复制数据的元信息，数据本身是共享的。
+func (s *segment) snapshot() *segment {
+	s.rlock()
+	defer s.lock.RUnlock()
+
+	data := make(map[string]*value, s.Status.Count)
+	s.engine.each(func(key string, v *value) bool {
+		data[key] = &value{
+			Data:  v.Data,
+			Ttl:   v.Ttl,
+			Ctime: atomic.LoadInt64(&v.Ctime),
+			Hits:  atomic.LoadInt64(&v.Hits),
+			slot:  v.slot,
+		}
+		return true
+	})
+
+	status := *s.Status
+	return &segment{Data: data, Status: &status}
+}
+
 // Status 返回这个segment的情况
 func (s *segment) status() Status {
-	s.lock.RLock()
+	s.rlock()
 	defer s.lock.RUnlock()
 	return *s.Status
 }
 
+// sizeHistograms 重新统计 segment 中 key 和 value 的大小分布，调用者需要持有锁。
+func (s *segment) sizeHistograms() (keyLengths SizeHistogram, valueSizes SizeHistogram) {
+	s.engine.each(func(key string, value *value) bool {
+		keyLengths[sizeBucketOf(len(key))]++
+		valueSizes[sizeBucketOf(len(value.Data))]++
+		return true
+	})
+	return keyLengths, valueSizes
+}
+
 // checkEntrySize 会判断数据容量是否已经达到了设定的上限
 // 因为这个配置是针对整个缓存的，而这边判断大小是针对单个 segment 的，所以需要算出单个 segment 的上限来判断。
 func (s *segment) checkEntrySize(newKey string, newValue []byte) bool  {
-	return s.Status.entrySize()+int64(len(newKey))+int64(len(newValue)) <= int64((s.options.MaxEntrySize*1024*1024) / s.options.SegmentSize)
-}
-
-// gc 会清理segment中过期的数据
-func (s *segment) gc() {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	count := 0
-	for key, value := range s.Data {
-		if !value.alive() {
-			s.Status.subEntry(key, value.Data)
-			delete(s.Data, key)
-			count++
-			if count >= s.options.MaxGcCount {
-				break
-			}
+	return s.Status.entrySize()+int64(len(newKey))+int64(len(newValue)) <= s.maxEntrySize()
+}
+
+// checkEntryCount 会判断 segment 中的数据个数是否还没有达到 MaxEntriesPerSegment 和 MaxCount 分到单个 segment 的上限，调用者需要持有锁。
+func (s *segment) checkEntryCount() bool {
+	if s.options.MaxEntriesPerSegment > 0 && s.Status.Count >= s.options.MaxEntriesPerSegment {
+		return false
+	}
+	return s.options.MaxCount <= 0 || s.Status.Count < s.maxCount()
+}
+
+// maxCount 返回 MaxCount 分到单个 segment 的数据个数上限，向上取整，保证每个 segment 至少可以存放一个数据。
+func (s *segment) maxCount() int {
+	return (s.options.MaxCount + s.options.SegmentSize - 1) / s.options.SegmentSize
+}
+
+// maxEntrySize 返回单个 segment 中键值对最多可以占用的空间大小，MaxEntrySize 的单位是 MB，需要先转成 int64 再计算，避免溢出。
+func (s *segment) maxEntrySize() int64 {
+	return (int64(s.options.MaxEntrySize) << 20) / int64(s.options.SegmentSize)
+}
+
+// gc 会清理segment中过期并且超过了 StaleTTL 的数据，并返回清理的数据个数
+// 清理是分批进行的，每一批持有写锁的时间不会超过 GcLockTime，批与批之间会释放写锁让其他操作先执行，
+// 这样数据很多的 segment 在 gc 的时候也不会长时间阻塞写操作。清理了 MaxGcCount 个数据，或者遍历完整个 segment 之后就会停止。
+func (s *segment) gc() int {
+	cleaned := 0
+	scanned := 0
+	for {
+		n, m, done := s.gcBatch(cleaned)
+		cleaned += n
+		scanned += m
+		if done || scanned >= s.status().Count+cleaned {
+			return cleaned
 		}
+		runtime.Gosched()
+	}
+}
+
+// gcBatch 在一次写锁中清理一批数据，cleaned 是这一轮 gc 已经清理的数据个数，返回这一批清理和遍历的数据个数，以及这一轮 gc 是否应该结束了。
+// 遍历的时候不能修改引擎中的数据，所以先收集需要清理的数据，遍历结束之后再删除。
+func (s *segment) gcBatch(cleaned int) (int, int, bool) {
+	s.wlock()
+	defer s.unlock()
+
+	// MaxGcCount 为 0 的时候和以前一样，每次最多清理一个数据
+	maxCount := s.options.MaxGcCount
+	if maxCount <= 0 {
+		maxCount = 1
+	}
+
+	grace := s.staleSeconds()
+	lockTime := s.options.GcLockTime
+	beginTime := time.Now()
+	expired := make(map[string]*value)
+	scanned := 0
+	finished := true
+	s.engine.scan(s.gcCursor, func(key string, value *value) bool {
+		// 获取当前时间也是有开销的，所以每遍历一小批才检查一次
+		if lockTime > 0 && scanned > 0 && scanned%gcCheckInterval == 0 && time.Since(beginTime) >= lockTime {
+			s.gcCursor = key
+			finished = false
+			return false
+		}
+
+		scanned++
+		if !value.servable(grace) {
+			expired[key] = value
+		}
+
+		if cleaned+len(expired) >= maxCount {
+			// 下一批从 key 之后开始，因为 key 已经遍历过了
+			s.gcCursor = key + "\x00"
+			finished = false
+			return false
+		}
+		return true
+	})
+
+	if finished {
+		s.gcCursor = ""
+	}
+
+	for key, value := range expired {
+		s.Status.subEntry(key, value.Data)
+		s.engine.remove(key)
+		s.record(eventExpire, key, value.Data)
 	}
+	return len(expired), scanned, finished || cleaned+len(expired) >= maxCount
 }
\ No newline at end of file
@@ -0,0 +1,401 @@
+package caches
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+const (
+	// spillFileName 是溢出日志的文件名，compactingFileName 是压缩日志时写入的临时文件名。
+	spillFileName = "spill.log"
+
+	compactingFileName = "spill.log.compacting"
+
+	// spillHeaderSize 是溢出日志中每条记录的头部大小，依次是 1 个字节的操作、4 个字节的 key 长度、4 个字节的 value 长度和 8 个字节的过期时间。
+	spillHeaderSize = 17
+
+	// spillPut 和 spillDelete 是溢出日志中记录的操作，删除只是一个墓碑，真正的空间要等到压缩的时候才会回收。
+	spillPut = byte(1)
+
+	spillDelete = byte(2)
+
+	// compactMinGarbage 是触发压缩的最小垃圾字节数，垃圾超过这个值并且超过了存活的数据大小才会压缩，避免频繁地重写小文件。
+	compactMinGarbage = 64 << 20
+)
+
+// SpillStatus 是溢出到磁盘上的数据的情况。
+type SpillStatus struct {
+	// Count 是磁盘上存活的数据个数。
+	Count int `json:"count"`
+
+	// Bytes 是磁盘上存活的数据占用的空间大小，包括 key 和 value。
+	Bytes int64 `json:"bytes"`
+
+	// FileSize 是溢出日志文件的大小，包括还没有被压缩掉的旧数据和墓碑。
+	FileSize int64 `json:"fileSize"`
+}
+
+// spillRecord 是溢出日志中一条存活的数据在文件中的位置。
+type spillRecord struct {
+	// offset 是 value 在文件中的偏移量。
+	offset int64
+
+	// keySize 和 valueSize 是 key 和 value 的长度。
+	keySize int
+
+	valueSize int
+
+	// expireAt 是数据的过期时间，是以秒为单位的 Unix 时间戳，0 表示永不过期。
+	expireAt int64
+}
+
+// size 返回这条记录在文件中占用的字节数。
+func (sr spillRecord) size() int64 {
+	return spillHeaderSize + int64(sr.keySize) + int64(sr.valueSize)
+}
+
+// spillLog 是存储溢出数据的追加日志，内存中只保留每个 key 在文件中的位置，所有的 segment 共用一个日志。
+// 覆盖和删除都只是在文件末尾追加记录，旧的记录变成垃圾，垃圾太多的时候会重写整个文件进行压缩。
+type spillLog struct {
+	// dir 是日志文件所在的目录。
+	dir string
+
+	// file 是打开的日志文件。
+	file *os.File
+
+	// index 存储着每个存活的 key 在文件中的位置。
+	index map[string]spillRecord
+
+	// size 是文件的大小，也就是下一条记录的偏移量。
+	size int64
+
+	// live 是存活的记录占用的字节数，文件中剩下的都是垃圾。
+	live int64
+
+	// lock 保护以上所有字段。
+	lock *sync.Mutex
+}
+
+// openSpillLog 打开 dir 中的溢出日志，会扫描整个文件重建索引，文件末尾不完整的记录会被截断。
+func openSpillLog(dir string) (*spillLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, spillFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	sl := &spillLog{dir: dir, file: file, index: map[string]spillRecord{}, lock: &sync.Mutex{}}
+	if err = sl.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return sl, nil
+}
+
+// recover 扫描日志文件重建索引，只会在打开日志的时候调用，所以不需要加锁。
+func (sl *spillLog) recover() error {
+	reader := bufio.NewReaderSize(io.NewSectionReader(sl.file, 0, 1<<62), dumpBufferSize)
+	header := make([]byte, spillHeaderSize)
+	key := make([]byte, 0, 64)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			break
+		}
+
+		record := spillRecord{
+			offset:    sl.size + spillHeaderSize + int64(binary.BigEndian.Uint32(header[1:5])),
+			keySize:   int(binary.BigEndian.Uint32(header[1:5])),
+			valueSize: int(binary.BigEndian.Uint32(header[5:9])),
+			expireAt:  int64(binary.BigEndian.Uint64(header[9:17])),
+		}
+
+		if cap(key) < record.keySize {
+			key = make([]byte, record.keySize)
+		}
+		key = key[:record.keySize]
+		if _, err := io.ReadFull(reader, key); err != nil {
+			break
+		}
+
+		if _, err := reader.Discard(record.valueSize); err != nil {
+			break
+		}
+
+		sl.forget(string(key))
+		if header[0] == spillPut {
+			sl.index[string(key)] = record
+			sl.live += record.size()
+		}
+		sl.size += record.size()
+	}
+
+	// 崩溃的时候可能只写了半条记录，截断之后新的记录才能接在完整的记录后面
+	return sl.file.Truncate(sl.size)
+}
+
+// put 把 key 和 value 追加到日志中，覆盖 key 原来的数据。
+func (sl *spillLog) put(key string, value []byte, expireAt int64) error {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	record, err := sl.append(spillPut, key, value, expireAt)
+	if err != nil {
+		return err
+	}
+
+	sl.forget(key)
+	sl.index[key] = record
+	sl.live += record.size()
+	return sl.compactIfNeeded()
+}
+
+// contains 返回 key 是否溢出到了磁盘上，只查询内存中的索引。
+func (sl *spillLog) contains(key string) bool {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	_, ok := sl.index[key]
+	return ok
+}
+
+// get 返回 key 对应的数据和过期时间，已经过期的数据会被删除。
+func (sl *spillLog) get(key string) ([]byte, int64, bool, error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	record, ok := sl.index[key]
+	if !ok {
+		return nil, 0, false, nil
+	}
+
+	if record.expireAt > 0 && record.expireAt <= time.Now().Unix() {
+		return nil, 0, false, sl.removeLocked(key)
+	}
+
+	value := make([]byte, record.valueSize)
+	if _, err := sl.file.ReadAt(value, record.offset); err != nil {
+		return nil, 0, false, err
+	}
+	return value, record.expireAt, true, nil
+}
+
+// remove 删除 key 对应的数据，key 不在日志中的话不会写入任何东西。
+func (sl *spillLog) remove(key string) error {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	return sl.removeLocked(key)
+}
+
+// removeLocked 和 remove 一样，调用者需要持有锁。
+func (sl *spillLog) removeLocked(key string) error {
+	if _, ok := sl.index[key]; !ok {
+		return nil
+	}
+
+	// 先写墓碑再删除索引，写入失败的话数据仍然可以读到，不会出现内存和文件不一致的情况
+	if _, err := sl.append(spillDelete, key, nil, 0); err != nil {
+		return err
+	}
+	sl.forget(key)
+	return sl.compactIfNeeded()
+}
+
+// forget 从索引中删除 key，它原来的记录就变成了垃圾。
+func (sl *spillLog) forget(key string) {
+	if record, ok := sl.index[key]; ok {
+		sl.live -= record.size()
+		delete(sl.index, key)
+	}
+}
+
+// append 在文件末尾追加一条记录，返回这条记录的位置，调用者需要持有锁。
+func (sl *spillLog) append(op byte, key string, value []byte, expireAt int64) (spillRecord, error) {
+	record := spillRecord{offset: sl.size + spillHeaderSize + int64(len(key)), keySize: len(key), valueSize: len(value), expireAt: expireAt}
+	buffer := make([]byte, spillHeaderSize, record.size())
+	buffer[0] = op
+	binary.BigEndian.PutUint32(buffer[1:5], uint32(len(key)))
+	binary.BigEndian.PutUint32(buffer[5:9], uint32(len(value)))
+	binary.BigEndian.PutUint64(buffer[9:17], uint64(expireAt))
+	buffer = append(append(buffer, key...), value...)
+
+	if _, err := sl.file.WriteAt(buffer, sl.size); err != nil {
+		// 写了一半的记录会被下一条记录覆盖，所以不需要截断
+		return spillRecord{}, err
+	}
+	sl.size += record.size()
+	return record, nil
+}
+
+// compactIfNeeded 在垃圾太多的时候把存活的数据重写到一个新文件中，然后替换掉旧文件，调用者需要持有锁。
+// 压缩的过程中会一直持有锁，所以这段时间内访问溢出数据的操作都会阻塞，但是内存中的数据不受影响。
+func (sl *spillLog) compactIfNeeded() error {
+	garbage := sl.size - sl.live
+	if garbage < compactMinGarbage || garbage < sl.live {
+		return nil
+	}
+
+	path := filepath.Join(sl.dir, compactingFileName)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	compacted := &spillLog{dir: sl.dir, file: file, index: make(map[string]spillRecord, len(sl.index)), lock: sl.lock}
+	now := time.Now().Unix()
+	for key, record := range sl.index {
+		if record.expireAt > 0 && record.expireAt <= now {
+			continue
+		}
+
+		value := make([]byte, record.valueSize)
+		if _, err = sl.file.ReadAt(value, record.offset); err == nil {
+			record, err = compacted.append(spillPut, key, value, record.expireAt)
+		}
+
+		if err != nil {
+			file.Close()
+			os.Remove(path)
+			return err
+		}
+		compacted.index[key] = record
+		compacted.live += record.size()
+	}
+
+	if err = os.Rename(path, filepath.Join(sl.dir, spillFileName)); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+
+	sl.file.Close()
+	*sl = *compacted
+	return nil
+}
+
+// status 返回溢出数据的情况。
+func (sl *spillLog) status() SpillStatus {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	return SpillStatus{Count: len(sl.index), Bytes: sl.live, FileSize: sl.size}
+}
+
+// close 关闭日志文件。
+func (sl *spillLog) close() error {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	return sl.file.Close()
+}
+
+// SpillStatus 返回溢出到磁盘上的数据的情况，没有设置 SpillDir 的话返回的都是 0。
+func (c *Cache) SpillStatus() SpillStatus {
+	if c.spill == nil {
+		return SpillStatus{}
+	}
+	return c.spill.status()
+}
+
+// expireAtOf 返回数据的过期时间，是以秒为单位的 Unix 时间戳，永不过期的数据返回 0。
+func expireAtOf(v *value) int64 {
+	if v.Ttl == NeverDie {
+		return 0
+	}
+	return atomic.LoadInt64(&v.Ctime) + v.Ttl
+}
+
+// spilledEntry 返回溢出到磁盘上的 key 对应的数据，没有设置 SpillDir 或者 key 不在磁盘上的话返回 false。
+func (c *Cache) spilledEntry(key string) (*Entry, bool, error) {
+	if c.spill == nil || !c.spill.contains(key) {
+		return nil, false, nil
+	}
+	return c.segmentOf(key).unspill(key)
+}
+
+// oversized 返回 value 是不是需要直接存储在磁盘上的大数据，调用者需要持有锁。
+func (s *segment) oversized(value []byte) bool {
+	return s.spill != nil && s.options.SpillThreshold > 0 && len(value) >= s.options.SpillThreshold
+}
+
+// spillOut 把 key 和 value 直接写入磁盘，并删除内存中的旧数据，调用者需要持有写锁。
+func (s *segment) spillOut(key string, value []byte, ttl int64) error {
+	expireAt := int64(0)
+	if ttl != NeverDie {
+		expireAt = time.Now().Unix() + ttl
+	}
+
+	if err := s.spill.put(key, value, expireAt); err != nil {
+		return err
+	}
+
+	if oldValue, ok := s.engine.get(key); ok {
+		s.Status.subEntry(key, oldValue.Data)
+		s.engine.remove(key)
+	}
+
+	// 磁盘上的数据没有内存中的副本，value 又是调用方的切片，所以有钩子的时候需要复制一份给钩子
+	if s.hooked() {
+		s.record(eventSet, key, helpers.Copy(value))
+	}
+	return nil
+}
+
+// spillVictim 把被淘汰的存活数据写入磁盘，写入失败的话数据就真的被淘汰了，调用者需要持有写锁。
+func (s *segment) spillVictim(key string, victim *value) {
+	if err := s.spill.put(key, victim.bytes(), expireAtOf(victim)); err != nil {
+		helpers.Warn("Failed to spill evicted entry", "key", key, "err", err)
+	}
+}
+
+// forgetSpilled 删除 key 在磁盘上的旧数据，key 被写入或者删除之后需要调用，避免内存中的数据消失之后旧数据又被读到，调用者需要持有写锁。
+func (s *segment) forgetSpilled(key string) bool {
+	if s.spill == nil || !s.spill.contains(key) {
+		return false
+	}
+
+	if err := s.spill.remove(key); err != nil {
+		helpers.Warn("Failed to remove spilled entry", "key", key, "err", err)
+	}
+	return true
+}
+
+// unspill 从磁盘上读取 key 对应的数据，小于 SpillThreshold 的数据会被提升回内存，大数据每次都直接从磁盘上读取。
+// 整个过程都持有写锁，所以不会和同一个 key 的写入和删除交错，旧数据不会覆盖掉新写入的数据。
+func (s *segment) unspill(key string) (*Entry, bool, error) {
+	s.wlock()
+	defer s.unlock()
+
+	// 获取写锁之前可能已经被其他读操作提升回内存了
+	if v, ok := s.engine.get(key); ok && v.alive() {
+		entry := &Entry{Key: key, Ttl: v.remainingTTL(), Ctime: atomic.LoadInt64(&v.Ctime)}
+		entry.Value = v.visit()
+		return entry, true, nil
+	}
+
+	value, expireAt, ok, err := s.spill.get(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	now := time.Now().Unix()
+	entry := &Entry{Key: key, Value: value, Ttl: NeverDie, Ctime: now}
+	if expireAt > 0 {
+		entry.Ttl = expireAt - now
+	}
+
+	if !s.oversized(value) {
+		// 内存放不下的话会淘汰其他的数据到磁盘上，还是放不下的话数据仍然留在磁盘上
+		if err = s.store(key, value, entry.Ttl); err == nil {
+			unspills.Inc()
+		}
+	}
+	return entry, true, nil
+}
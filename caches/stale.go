@@ -0,0 +1,53 @@
+package caches
+
+import (
+	"context"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+const (
+	// refreshTimeout 是后台重新加载一个旧数据的超时时间，避免卡住的 Loader 让这个 key 再也不会被重新加载。
+	refreshTimeout = time.Minute
+)
+
+// staleEntry 返回 key 对应的已经过期但是还在 StaleTTL 之内的数据，并在后台使用 Loader 重新加载。
+// 没有设置 StaleTTL 或者 Loader 的话，过期的数据不会被当成旧数据返回。
+func (c *Cache) staleEntry(key string) (*Entry, bool) {
+	loader := c.backing().loader
+	if loader == nil || c.currentOptions().StaleTTL <= 0 {
+		return nil, false
+	}
+
+	s := c.segmentOf(key)
+	entry, ok := s.lookupStale(key)
+	if !ok {
+		return nil, false
+	}
+
+	staleHits.Inc()
+	if _, refreshing := c.refreshes.LoadOrStore(key, struct{}{}); !refreshing {
+		go c.refresh(s, key, loader)
+	}
+	return entry, true
+}
+
+// refresh 使用 loader 重新加载 key 对应的旧数据，同一个 key 同一时间只会有一个 refresh 在执行。
+// 加载失败的话旧数据会继续返回，直到超过 StaleTTL，后端存储中已经不存在的话会删除旧数据。
+func (c *Cache) refresh(s *segment, key string, loader Loader) {
+	defer c.refreshes.Delete(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+
+	loaded, err := c.load(ctx, key, loader.Load)
+	if err != nil {
+		helpers.Warn("Failed to refresh stale entry", "key", key, "err", err)
+		return
+	}
+
+	if !loaded.ok {
+		s.removeStale(key)
+	}
+}
@@ -1,5 +1,24 @@
 package caches
 
+var (
+	// SizeBuckets 是统计 key 和 value 大小分布使用的桶的上界，单位是字节。
+	SizeBuckets = [...]int64{16, 64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+)
+
+// SizeHistogram 是数据大小的分布，第 i 个元素是大小在 (SizeBuckets[i-1], SizeBuckets[i]] 之间的数据个数，
+// 最后一个元素是超过了所有上界，也就是大于 1 MB 的数据个数。注意和 Prometheus 的直方图不同，每个桶中的个数不是累计的。
+type SizeHistogram [len(SizeBuckets) + 1]int64
+
+// sizeBucketOf 返回大小为 size 的数据所在的桶。
+func sizeBucketOf(size int) int {
+	for i, bucket := range SizeBuckets {
+		if int64(size) <= bucket {
+			return i
+		}
+	}
+	return len(SizeBuckets)
+}
+
 // Status 是一个代表缓存信息的结构体
 type Status struct {
 	// Count 记录着缓存中的数据个数。
@@ -10,6 +29,12 @@ type Status struct {
 
 	// ValueSize 记录着 value 占用的空间大小。
 	ValueSize int64 `json:"valueSize"`
+
+	// KeyLengths 记录着 key 长度的分布，用于容量规划，只看平均值的话很容易被少数特别大的数据误导。
+	KeyLengths SizeHistogram `json:"keyLengths"`
+
+	// ValueSizes 记录着 value 大小的分布。
+	ValueSizes SizeHistogram `json:"valueSizes"`
 }
 
 // NewStatus 返回一个缓存信息对象指针
@@ -26,6 +51,8 @@ func (s *Status) addEntry(key string, value []byte) {
 	s.Count++
 	s.KeySize += int64(len(key))
 	s.ValueSize += int64(len(value))
+	s.KeyLengths[sizeBucketOf(len(key))]++
+	s.ValueSizes[sizeBucketOf(len(value))]++
 }
 
 // subEntry可以将key和value的信息从Status中减去
@@ -33,6 +60,19 @@ func (s *Status) subEntry(key string, value []byte) {
 	s.Count--
 	s.KeySize -= int64(len(key))
 	s.ValueSize -= int64(len(value))
+	s.KeyLengths[sizeBucketOf(len(key))]--
+	s.ValueSizes[sizeBucketOf(len(value))]--
+}
+
+// Add 把 other 中的数据情况累加到 s 上，用于汇总多个 segment 或者多个节点的数据情况。
+func (s *Status) Add(other Status) {
+	s.Count += other.Count
+	s.KeySize += other.KeySize
+	s.ValueSize += other.ValueSize
+	for i := range s.KeyLengths {
+		s.KeyLengths[i] += other.KeyLengths[i]
+		s.ValueSizes[i] += other.ValueSizes[i]
+	}
 }
 
 // entrySize 返回键值对占用的空间的大小
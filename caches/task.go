@@ -0,0 +1,107 @@
+package caches
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// taskHistorySize 是每一类后台任务保留的最近执行记录的个数。
+	taskHistorySize = 10
+)
+
+// TaskRun 是后台任务的一次执行记录。
+type TaskRun struct {
+	// StartTime 是任务开始的时间，是一个 Unix 时间戳，单位是秒。
+	StartTime int64 `json:"startTime"`
+
+	// Duration 是任务的耗时，单位是毫秒。
+	Duration int64 `json:"duration"`
+
+	// Cleaned 是任务清理的数据个数，只有 GC 任务会记录这个值。
+	Cleaned int64 `json:"cleaned"`
+
+	// Bytes 是任务写入的字节数，只有持久化任务会记录这个值。
+	Bytes int64 `json:"bytes"`
+
+	// Error 是任务发生的错误，如果没有发生错误就是空字符串。
+	Error string `json:"error,omitempty"`
+}
+
+// TaskStatus 记录着某一类后台任务的执行情况，比如 GC 任务和持久化任务。
+type TaskStatus struct {
+	// Count 是任务执行的总次数。
+	Count int64 `json:"count"`
+
+	// Cleaned 是任务累计清理的数据个数，只有 GC 任务会记录这个值。
+	Cleaned int64 `json:"cleaned"`
+
+	// LastTime 是最后一次执行任务的开始时间，是一个 Unix 时间戳，单位是秒。
+	LastTime int64 `json:"lastTime"`
+
+	// LastDuration 是最后一次执行任务的耗时，单位是毫秒。
+	LastDuration int64 `json:"lastDuration"`
+
+	// LastCleaned 是最后一次任务清理的数据个数，只有 GC 任务会记录这个值。
+	LastCleaned int64 `json:"lastCleaned"`
+
+	// LastError 是最后一次执行任务发生的错误，如果没有发生错误就是空字符串。
+	LastError string `json:"lastError"`
+
+	// Failures 是任务执行失败的总次数。
+	Failures int64 `json:"failures"`
+
+	// History 是最近几次执行任务的记录，最近的一次排在最前面，最多保留 taskHistorySize 个。
+	History []TaskRun `json:"history"`
+}
+
+// taskRecorder 用于记录后台任务的执行情况，是并发安全的。
+type taskRecorder struct {
+	// status 是任务的执行情况。
+	status TaskStatus
+
+	// lock 用于保证记录的并发安全。
+	lock sync.RWMutex
+}
+
+// record 记录一次任务的执行情况，beginTime 是任务开始的时间，cleaned 是清理的数据个数，bytes 是写入的字节数。
+func (tr *taskRecorder) record(beginTime time.Time, cleaned int, bytes int64, err error) {
+	run := TaskRun{
+		StartTime: beginTime.Unix(),
+		Duration:  time.Since(beginTime).Milliseconds(),
+		Cleaned:   int64(cleaned),
+		Bytes:     bytes,
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	tr.status.Count++
+	tr.status.Cleaned += run.Cleaned
+	tr.status.LastTime = run.StartTime
+	tr.status.LastDuration = run.Duration
+	tr.status.LastCleaned = run.Cleaned
+	tr.status.LastError = run.Error
+	if err != nil {
+		tr.status.Failures++
+	}
+
+	// 新的记录放在最前面，超出的旧记录直接丢弃
+	history := make([]TaskRun, 0, taskHistorySize)
+	history = append(history, run)
+	if len(tr.status.History) >= taskHistorySize {
+		tr.status.History = tr.status.History[:taskHistorySize-1]
+	}
+	tr.status.History = append(history, tr.status.History...)
+}
+
+// snapshot 返回任务执行情况的一个副本，执行记录也会复制一份。
+func (tr *taskRecorder) snapshot() TaskStatus {
+	tr.lock.RLock()
+	defer tr.lock.RUnlock()
+	status := tr.status
+	status.History = append([]TaskRun{}, tr.status.History...)
+	return status
+}
@@ -0,0 +1,13 @@
+package caches
+
+import (
+	"context"
+
+	"github.com/herrhu97/go-distributed-cache/tracing"
+)
+
+// startSpan 开启一个 segment 操作的 span，属性中会带上 key 所在的 segment。
+func (c *Cache) startSpan(ctx context.Context, operation string, key string) tracing.Span {
+	_, span := tracing.Start(ctx, "kafo.segment "+operation, tracing.Attr("kafo.segment", index(key)&(c.segmentSize-1)))
+	return span
+}
@@ -0,0 +1,146 @@
+package caches
+
+import (
+	"context"
+	"errors"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+const (
+	// OpGet 是事务中获取数据的操作。
+	OpGet = byte(1)
+
+	// OpSet 是事务中添加数据的操作。
+	OpSet = byte(2)
+
+	// OpDelete 是事务中删除数据的操作。
+	OpDelete = byte(3)
+)
+
+var (
+	// ErrCrossSegment 说明事务中的 key 不属于同一个 segment，这样的事务是没办法保证原子性的。
+	ErrCrossSegment = errors.New("keys in transaction don't belong to the same segment")
+
+	// ErrUnknownOperation 说明事务中有无法识别的操作。
+	ErrUnknownOperation = errors.New("unknown operation in transaction")
+)
+
+// Operation 是事务中的一个操作。
+type Operation struct {
+	// Type 是操作的类型，可以是 OpGet、OpSet 和 OpDelete。
+	Type byte
+
+	// Key 是操作的键。
+	Key string
+
+	// Value 是操作的值，只有 OpSet 会使用。
+	Value []byte
+
+	// Ttl 是操作的寿命，只有 OpSet 会使用，单位是秒。
+	Ttl int64
+}
+
+// Result 是事务中一个操作的结果。
+type Result struct {
+	// Value 是 OpGet 获取到的数据。
+	Value []byte `json:"value"`
+
+	// Found 表示 OpGet 获取的数据或者 OpDelete 删除的数据是否存在。
+	Found bool `json:"found"`
+}
+
+// undo 记录着事务执行过程中被修改的数据原本的样子，用于回滚。
+type undo struct {
+	// key 是被修改的键。
+	key string
+
+	// value 是修改之前的数据，为 nil 说明修改之前数据不存在。
+	value *value
+}
+
+// Exec 原子性地执行事务中的所有操作，并按顺序返回每个操作的结果。
+// 因为缓存没有全局锁，只有 segment 级别的锁，所以事务中所有的 key 都必须属于同一个 segment，否则返回 ErrCrossSegment。
+// 如果某个操作执行失败了，比如触发了写满保护机制，那么之前所有的修改都会被回滚。
+func (c *Cache) Exec(ctx context.Context, ops []Operation) ([]Result, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	seg := c.segmentOf(ops[0].Key)
+	for _, op := range ops[1:] {
+		if c.segmentOf(op.Key) != seg {
+			return nil, ErrCrossSegment
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return seg.exec(ops)
+}
+
+// exec 在写锁中执行事务中的所有操作，失败的时候会回滚之前的修改。
+func (s *segment) exec(ops []Operation) ([]Result, error) {
+	s.wlock()
+	defer s.unlock()
+
+	mark := len(s.events)
+
+	results := make([]Result, len(ops))
+	undos := make([]undo, 0, len(ops))
+	for i, op := range ops {
+		oldValue, ok := s.engine.get(op.Key)
+		alive := ok && oldValue.alive()
+
+		switch op.Type {
+		case OpGet:
+			if alive {
+				results[i] = Result{Value: oldValue.visit(), Found: true}
+			}
+		case OpSet:
+			undos = append(undos, undo{key: op.Key, value: oldValue})
+			if err := s.store(op.Key, op.Value, op.Ttl); err != nil {
+				s.rollback(undos)
+				s.discardEvents(mark)
+				return nil, err
+			}
+		case OpDelete:
+			if ok {
+				undos = append(undos, undo{key: op.Key, value: oldValue})
+				s.Status.subEntry(op.Key, oldValue.Data)
+				s.engine.remove(op.Key)
+				if alive {
+					s.record(eventDelete, op.Key, oldValue.Data)
+				} else {
+					s.record(eventExpire, op.Key, oldValue.Data)
+				}
+			}
+			results[i] = Result{Found: alive}
+		default:
+			s.rollback(undos)
+			s.discardEvents(mark)
+			return nil, ErrUnknownOperation
+		}
+	}
+	return results, nil
+}
+
+// rollback 按照相反的顺序恢复被修改的数据，调用者需要持有写锁。
+func (s *segment) rollback(undos []undo) {
+	for i := len(undos) - 1; i >= 0; i-- {
+		u := undos[i]
+		if current, ok := s.engine.get(u.key); ok {
+			s.Status.subEntry(u.key, current.Data)
+			s.engine.remove(u.key)
+		}
+
+		if u.value != nil {
+			if err := s.engine.put(u.key, u.value); err != nil {
+				helpers.Error("Failed to roll back transaction", "key", u.key, "err", err)
+				continue
+			}
+			s.Status.addEntry(u.key, u.value.Data)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package caches
 
 import (
-	"cache-server/helpers"
+	"bytes"
+	"runtime"
 	"sync/atomic"
 	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
 )
 
 const (
@@ -20,6 +23,11 @@ type value struct {
 	Ttl int64
 	// ctime 代表这个数据的创建时间。
 	Ctime int64
+	// Hits 代表这个数据被访问的次数，LFU 淘汰策略会使用这个值。
+	Hits int64
+	// slot 是数据在 arena 中占用的块，没有开启 ValueArena 或者数据太大的话为 nil。
+	// 复制 value 结构体的时候也需要复制这个字段，这样块在复制出来的 value 被回收之前都不会被复用。
+	slot *arenaSlot
 }
 
 // newValue 返回一个包装之后的数据。
@@ -31,9 +39,42 @@ func newValue(data []byte, ttl int64) *value {
 	}
 }
 
-// alive 返回这个数据是否存活。
+// alive 返回这个数据是否存活，访问数据的时候会在读锁中更新创建时间，所以需要使用原子操作读取。
 func (v *value) alive() bool {
-	return v.Ttl == NeverDie || time.Now().Unix() - v.Ctime < v.Ttl
+	return v.Ttl == NeverDie || time.Now().Unix() - atomic.LoadInt64(&v.Ctime) < v.Ttl
+}
+
+// servable 返回这个数据是否还可以被读取，过期之后 grace 秒之内的数据虽然不再存活，但是仍然可以作为旧数据返回。
+func (v *value) servable(grace int64) bool {
+	return v.Ttl == NeverDie || time.Now().Unix() - atomic.LoadInt64(&v.Ctime) < v.Ttl + grace
+}
+
+// sameAs 返回 v 和 other 是不是同一个数据，用于判断数据在释放锁之后有没有被覆盖。
+// 不是内存引擎的话每次读到的都是新解码的数据，所以不能只比较指针，还需要比较创建时间、寿命和数据本身。
+// 使用了 arena 的数据只会存储在内存引擎中，指针不同就说明被覆盖了，而且原来的块可能已经被复用了，不能再比较数据。
+func (v *value) sameAs(other *value) bool {
+	if v == other {
+		return true
+	}
+
+	if v.slot != nil || other.slot != nil {
+		return false
+	}
+	return v.Ttl == other.Ttl && atomic.LoadInt64(&v.Ctime) == atomic.LoadInt64(&other.Ctime) && bytes.Equal(v.Data, other.Data)
+}
+
+// remainingTTL 返回这个数据剩余的寿命，单位是秒，永不过期的数据返回 NeverDie。
+func (v *value) remainingTTL() int64 {
+	if v.Ttl == NeverDie {
+		return NeverDie
+	}
+
+	remaining := v.Ttl - (time.Now().Unix() - atomic.LoadInt64(&v.Ctime))
+	if remaining < 1 {
+		// 剩余寿命不足 1 秒的数据马上就会过期了，但是返回 0 会被误认为永不过期，所以至少返回 1
+		remaining = 1
+	}
+	return remaining
 }
 
 // visit 返回这个数据的实际存储数据。
@@ -47,5 +88,18 @@ func (v *value) visit() []byte {
     // 后交换成功的会把先交换成功的时间改掉，所以这里不保证交换的时间一定是更加新的时间
     // 有兴趣的童鞋可以尝试使用 CAS 的方式去更新，注意 CAS 的重试次数限制，防止高并发的时候 CPU 浪费严重
 	atomic.SwapInt64(&v.Ctime, time.Now().Unix())
-	return v.Data
+	atomic.AddInt64(&v.Hits, 1)
+	return v.bytes()
+}
+
+// bytes 返回释放锁之后还可以继续使用的数据，存储在 arena 中的数据被删除之后块会被复用，所以需要复制一份。
+func (v *value) bytes() []byte {
+	if v.slot == nil {
+		return v.Data
+	}
+
+	data := helpers.Copy(v.Data)
+	// 复制完之前 v 都不能被回收，否则块可能在复制的过程中被复用
+	runtime.KeepAlive(v)
+	return data
 }
\ No newline at end of file
@@ -0,0 +1,247 @@
+// kafo-cli 是 kafo 的命令行客户端，不需要写 Go 代码就可以查看和修改集群中的数据。
+//
+// 不带命令运行会进入交互模式，带上命令运行只会执行这一个命令：
+//
+//	kafo-cli -address 127.0.0.1:5837
+//	kafo-cli -address 127.0.0.1:5837 get key
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/herrhu97/go-distributed-cache/servers"
+)
+
+const (
+	// prompt 是交互模式的提示符。
+	prompt = "kafo> "
+
+	// usage 是所有命令的用法。
+	usage = `Commands:
+  get <key>                     Get the value of key.
+  set <key> <value> [ttl]       Set key to value, ttl is in seconds and 0 means never expired.
+  delete <key>                  Delete key.
+  status                        Show the status of every node and the total.
+  nodes                         Show the nodes in cluster.
+  scan [prefix] [match]         Scan keys in cluster, match is a pattern like user:*:name.
+  reload <node>                 Reload the config file of node.
+  eviction-policy <node> <p>    Set the eviction policy (none, lru, lfu, random) of node.
+  config <node>                 Show the effective config of node as json.
+  log-level <node> <level>      Set the log level (debug, info, warn, error) of node.
+  clients <node>                Show the client connections of node.
+  help                          Show this message.
+  exit                          Exit the interactive mode.`
+)
+
+var (
+	errWrongArguments = errors.New("wrong number of arguments, see help")
+)
+
+func main() {
+	address := flag.String("address", "127.0.0.1:5837", "The address of one node in cluster.")
+	flag.Parse()
+
+	options := servers.DefaultClientOptions()
+	options.VirtualNodeCount = 0
+	options.HashFunction = ""
+	client, err := servers.NewTCPClientWith(*address, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to %s: %v\n", *address, err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	// 带上命令运行的话只执行这一个命令，执行失败的退出码是 1，方便在脚本中使用
+	if flag.NArg() > 0 {
+		if err = execute(client, os.Stdout, flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "(error) %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	repl(client, os.Stdin, os.Stdout)
+}
+
+// repl 是交互模式，不断读取并执行命令，直到输入 exit 或者输入结束。
+func repl(client *servers.TCPClient, reader io.Reader, writer io.Writer) {
+	scanner := bufio.NewScanner(reader)
+	fmt.Fprint(writer, prompt)
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		if len(args) > 0 {
+			if args[0] == "exit" || args[0] == "quit" {
+				return
+			}
+
+			if err := execute(client, writer, args); err != nil {
+				fmt.Fprintf(writer, "(error) %v\n", err)
+			}
+		}
+		fmt.Fprint(writer, prompt)
+	}
+}
+
+// execute 执行一个命令，args 的第一个元素是命令的名字，并将结果输出到 writer。
+func execute(client *servers.TCPClient, writer io.Writer, args []string) error {
+	command, args := strings.ToLower(args[0]), args[1:]
+	switch command {
+	case "get":
+		if len(args) != 1 {
+			return errWrongArguments
+		}
+
+		value, err := client.Get(args[0])
+		if errors.Is(err, servers.ErrNotFound) {
+			fmt.Fprintln(writer, "(nil)")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(writer, "%q\n", value)
+	case "set":
+		if len(args) != 2 && len(args) != 3 {
+			return errWrongArguments
+		}
+
+		ttl := int64(0)
+		if len(args) == 3 {
+			var err error
+			if ttl, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+				return fmt.Errorf("invalid ttl %s", args[2])
+			}
+		}
+
+		if err := client.Set(args[0], []byte(args[1]), ttl); err != nil {
+			return err
+		}
+		fmt.Fprintln(writer, "OK")
+	case "delete", "del":
+		if len(args) != 1 {
+			return errWrongArguments
+		}
+
+		if err := client.Delete(args[0]); err != nil {
+			return err
+		}
+		fmt.Fprintln(writer, "OK")
+	case "status":
+		status, err := client.Status()
+		for node, nodeStatus := range status.Nodes {
+			data, _ := json.Marshal(nodeStatus)
+			fmt.Fprintf(writer, "%s\t%s\n", node, data)
+		}
+
+		total, _ := json.Marshal(status.Status)
+		if status.Partial {
+			fmt.Fprintf(writer, "total\t%s\t(partial)\n", total)
+		} else {
+			fmt.Fprintf(writer, "total\t%s\n", total)
+		}
+		return err
+	case "nodes":
+		nodes, err := client.Nodes()
+		if err != nil {
+			return err
+		}
+
+		for _, node := range nodes {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\tcommit=%s\tbuilt=%s\tcount=%d\tmemory=%d\tuptime=%ds\n",
+				node.Address, node.Role, node.State, node.Version, node.Commit, node.BuildTime, node.Count, node.Memory, node.Uptime)
+		}
+	case "scan":
+		if len(args) > 2 {
+			return errWrongArguments
+		}
+
+		options := servers.ScanOptions{}
+		if len(args) > 0 {
+			options.Prefix = args[0]
+		}
+		if len(args) > 1 {
+			options.Match = args[1]
+		}
+
+		count := 0
+		scanner := client.Scan(context.Background(), options)
+		for scanner.Next() {
+			fmt.Fprintln(writer, scanner.Key())
+			count++
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		fmt.Fprintf(writer, "(%d keys)\n", count)
+	case "reload":
+		if len(args) != 1 {
+			return errWrongArguments
+		}
+
+		if err := client.Reload(args[0]); err != nil {
+			return err
+		}
+		fmt.Fprintln(writer, "OK")
+	case "config":
+		if len(args) != 1 {
+			return errWrongArguments
+		}
+
+		config, err := client.Config(args[0])
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(config, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(writer, string(data))
+	case "eviction-policy":
+		if len(args) != 2 {
+			return errWrongArguments
+		}
+
+		if err := client.SetEvictionPolicy(args[0], args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintln(writer, "OK")
+	case "clients":
+		if len(args) != 1 {
+			return errWrongArguments
+		}
+
+		clients, err := client.Clients(args[0])
+		if err != nil {
+			return err
+		}
+
+		for _, c := range clients {
+			fmt.Fprintf(writer, "id=%d\taddr=%s\tage=%ds\tidle=%ds\tcmd=%s\tcommands=%d\tin=%d\tout=%d\n",
+				c.ID, c.Address, c.Age, c.Idle, c.LastCommand, c.Commands, c.BytesIn, c.BytesOut)
+		}
+	case "log-level":
+		if len(args) != 2 {
+			return errWrongArguments
+		}
+
+		if err := client.SetLogLevel(args[0], args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintln(writer, "OK")
+	case "help":
+		fmt.Fprintln(writer, usage)
+	default:
+		return fmt.Errorf("unknown command %s, see help", command)
+	}
+	return nil
+}
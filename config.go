@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+	"github.com/herrhu97/go-distributed-cache/helpers"
+	"github.com/herrhu97/go-distributed-cache/metrics"
+	"github.com/herrhu97/go-distributed-cache/servers"
+)
+
+// options 是程序的所有选项配置，也是配置文件的格式：
+//
+//	{
+//	    "server": {"Port": 5837, "UpdateCircleDuration": "3s", "RequestTimeout": "5s"},
+//	    "cache": {"GcDuration": "10m", "EvictionPolicy": "lru"},
+//	    "log": {"Level": "info", "Output": "kafo.log", "MaxSize": 100, "MaxAge": "168h"},
+//	    "metrics": {"Reporter": "prometheus", "Address": ":9100"}
+//	}
+//
+// 配置文件中没有出现的配置会使用默认值。
+// 每个 flag 也可以通过环境变量设置，环境变量的名字是 KAFO_ 加上 flag 名字的大写下划线形式，比如 -maxEntrySize 对应 KAFO_MAX_ENTRY_SIZE。
+type options struct {
+	// Server 是服务器的选项配置。
+	Server servers.Options `json:"server"`
+
+	// Cache 是缓存的选项配置。
+	Cache caches.Options `json:"cache"`
+
+	// Log 是日志的选项配置。
+	Log helpers.LogOptions `json:"log"`
+
+	// Metrics 是指标上报的选项配置。
+	Metrics metrics.Options `json:"metrics"`
+
+	// config 是配置文件的路径，只能通过命令行设置。
+	config string
+
+	// profile 是缓存配置使用的预设，只能通过命令行设置。
+	profile string
+
+	// cluster 是命令行中使用 "," 分割的集群信息，配置文件中直接使用 Server.Cluster 数组。
+	cluster string
+
+	// enable 是命令行中使用 "," 分割的实验性功能，配置文件中直接使用 Server.Features 数组。
+	enable string
+}
+
+// newOptions 返回使用默认值初始化的选项配置。
+func newOptions() *options {
+	return &options{
+		Server:  servers.DefaultOptions(),
+		Cache:   caches.DefaultOptions(),
+		Log:     helpers.DefaultLogOptions(),
+		Metrics: metrics.DefaultOptions(),
+	}
+}
+
+// loadOptions 从命令行参数、环境变量和配置文件中加载选项配置，优先级从高到低依次是命令行中的 flag、环境变量、配置文件、预设和默认值。
+// 加载之后会校验选项配置，不合法的配置会返回错误，所以热加载的时候也不会使用不合法的配置。
+// 热加载的时候也是使用同样的命令行参数和环境变量重新加载一遍，这样命令行中的 flag 在热加载之后仍然是生效的。
+func loadOptions(args []string) (*options, error) {
+	// 先解析一次环境变量和命令行参数拿到配置文件的路径和预设
+	parsed := newOptions()
+	if err := parseFlags(parsed, args); err != nil {
+		return nil, err
+	}
+
+	if parsed.config == "" && parsed.profile == "" {
+		return validateOptions(parsed)
+	}
+
+	opts := newOptions()
+	opts.config = parsed.config
+	opts.profile = parsed.profile
+	if opts.profile != "" {
+		cacheOptions, err := caches.ProfileOptions(opts.profile)
+		if err != nil {
+			return nil, err
+		}
+		opts.Cache = cacheOptions
+	}
+
+	if opts.config != "" {
+		data, err := ioutil.ReadFile(opts.config)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal(data, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// 再解析一次环境变量和命令行参数，覆盖掉配置文件和预设中的配置
+	if err := parseFlags(opts, args); err != nil {
+		return nil, err
+	}
+	return validateOptions(opts)
+}
+
+// parseFlags 先使用环境变量设置 flag，再解析命令行参数，所以命令行中的 flag 会覆盖环境变量。
+func parseFlags(opts *options, args []string) error {
+	flags := newFlagSet(opts)
+	if err := applyEnv(flags, os.LookupEnv); err != nil {
+		return err
+	}
+	return flags.Parse(args)
+}
+
+// applyEnv 使用 lookup 找到每个 flag 对应的环境变量，并设置到 flags 中，没有设置的环境变量会被忽略。
+func applyEnv(flags *flag.FlagSet, lookup func(name string) (string, bool)) (err error) {
+	flags.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		value, ok := lookup(name)
+		if !ok || err != nil {
+			return
+		}
+
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid environment variable %s: %v", name, setErr)
+		}
+	})
+	return err
+}
+
+// envName 返回 flag 对应的环境变量名字，比如 maxEntrySize 对应 KAFO_MAX_ENTRY_SIZE，连续的大写字母算作一个单词，比如 staleTTL 对应 KAFO_STALE_TTL。
+func envName(flagName string) string {
+	var builder strings.Builder
+	builder.WriteString("KAFO_")
+	previous := rune(0)
+	for _, r := range flagName {
+		if unicode.IsUpper(r) && (unicode.IsLower(previous) || unicode.IsDigit(previous)) {
+			builder.WriteByte('_')
+		}
+		builder.WriteRune(unicode.ToUpper(r))
+		previous = r
+	}
+	return builder.String()
+}
+
+// validateOptions 解析出命令行中的集群信息和实验性功能，然后校验选项配置，不合法的配置会返回错误。
+func validateOptions(opts *options) (*options, error) {
+	if opts.cluster != "" {
+		opts.Server.Cluster = nodesInCluster(opts.cluster)
+	}
+
+	if opts.enable != "" {
+		opts.Server.Features = strings.Split(opts.enable, ",")
+	}
+
+	opts.Cache.DumpFile = expandDumpFile(opts.Cache.DumpFile, &opts.Server)
+
+	if err := opts.Log.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Metrics.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Server.Validate(); err != nil {
+		return nil, err
+	}
+
+	segmentSize := opts.Cache.SegmentSize
+	if err := opts.Cache.Validate(); err != nil {
+		return nil, err
+	}
+
+	if opts.Cache.SegmentSize != segmentSize {
+		helpers.Warn("SegmentSize is not a power of 2 and has been rounded up", "segmentSize", segmentSize, "roundedUpTo", opts.Cache.SegmentSize)
+	}
+	return opts, nil
+}
+
+// expandDumpFile 替换持久化文件路径中的占位符，{node} 会被替换成节点的地址和端口，{port} 会被替换成节点的端口。
+// 同一台机器上运行多个节点的时候，使用 cache-{node}.dump 这样的路径就不会互相覆盖对方的持久化文件了。
+// 地址中的冒号和方括号在有些系统上不能出现在文件名中，所以 {node} 使用的是 127.0.0.1_5837 这样的格式。
+func expandDumpFile(dumpFile string, serverOptions *servers.Options) string {
+	node := helpers.JoinAddressAndPort(serverOptions.Address, serverOptions.Port)
+	node = strings.NewReplacer(":", "_", "[", "", "]", "").Replace(node)
+	return strings.NewReplacer("{node}", node, "{port}", strconv.Itoa(serverOptions.Port)).Replace(dumpFile)
+}
+
+// printOptions 使用 args 加载选项配置，然后以 JSON 的格式输出到 writer 中，输出的内容可以直接作为配置文件使用。
+func printOptions(writer io.Writer, args []string) error {
+	opts, err := loadOptions(args)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(opts, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(writer, string(data))
+	return err
+}
+
+// reloadOptions 重新加载选项配置，并热加载到缓存和服务器中，只有运行过程中可以修改的配置才会生效。
+func reloadOptions(cache *caches.Cache, server servers.Server) error {
+	opts, err := loadOptions(os.Args[1:])
+	if err != nil {
+		helpers.Error("Failed to reload options", "err", err)
+		return err
+	}
+
+	// 日志的输出可能是文件，切换失败的话继续使用原来的输出
+	if err = helpers.SetupLog(opts.Log); err != nil {
+		helpers.Error("Failed to reload log options", "err", err)
+		return err
+	}
+
+	if err = cache.Reload(opts.Cache); err != nil {
+		helpers.Error("Failed to reload cache options", "err", err)
+		return err
+	}
+	server.Reload(opts.Server)
+	helpers.Info("Reloaded options", "config", opts.config, "logLevel", opts.Log.Level)
+	return nil
+}
+
+// reloadOnSignal 在收到 SIGHUP 信号的时候调用 reload。
+func reloadOnSignal(reload func() error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			reload()
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestEnvName$
+func TestEnvName(t *testing.T) {
+	names := map[string]string{
+		"port":         "KAFO_PORT",
+		"maxEntrySize": "KAFO_MAX_ENTRY_SIZE",
+		"staleTTL":     "KAFO_STALE_TTL",
+		"http2Enabled": "KAFO_HTTP2_ENABLED",
+	}
+
+	for flagName, want := range names {
+		if name := envName(flagName); name != want {
+			t.Fatalf("env name %s of %s should be %s", name, flagName, want)
+		}
+	}
+}
+
+// go test -v -run=^TestLoadOptionsFromEnv$
+func TestLoadOptionsFromEnv(t *testing.T) {
+	os.Setenv("KAFO_PORT", "6837")
+	os.Setenv("KAFO_GC_DURATION", "5m")
+	defer os.Unsetenv("KAFO_PORT")
+	defer os.Unsetenv("KAFO_GC_DURATION")
+
+	// 环境变量会覆盖默认值，命令行中的 flag 会覆盖环境变量
+	opts, err := loadOptions([]string{"-gcDuration", "10m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Server.Port != 6837 {
+		t.Fatalf("port %d should be 6837", opts.Server.Port)
+	}
+
+	if opts.Cache.GcDuration != 10*time.Minute {
+		t.Fatalf("gc duration %v should be 10m", opts.Cache.GcDuration)
+	}
+
+	os.Setenv("KAFO_PORT", "not a port")
+	if _, err = loadOptions(nil); err == nil {
+		t.Fatal("invalid environment variable should fail")
+	}
+}
+
+// go test -v -run=^TestLoadOptionsDurations$
+func TestLoadOptionsDurations(t *testing.T) {
+	file, err := ioutil.TempFile("", "kafo-config-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(`{"server": {"RequestTimeout": "2s", "AuditMaxAge": "48h"}, "log": {"MaxAge": "24h"}}`)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := loadOptions([]string{"-config", file.Name(), "-idleTimeout", "10m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Server.RequestTimeout != 2*time.Second {
+		t.Fatalf("request timeout %v should be 2s", opts.Server.RequestTimeout)
+	}
+
+	if opts.Server.AuditMaxAge != 48*time.Hour {
+		t.Fatalf("audit max age %v should be 48h", opts.Server.AuditMaxAge)
+	}
+
+	if opts.Server.IdleTimeout != 10*time.Minute {
+		t.Fatalf("idle timeout %v should be 10m", opts.Server.IdleTimeout)
+	}
+
+	if opts.Log.MaxAge != 24*time.Hour {
+		t.Fatalf("log max age %v should be 24h", opts.Log.MaxAge)
+	}
+}
@@ -1,12 +1,10 @@
-module cache-server
+module github.com/herrhu97/go-distributed-cache
 
 go 1.14
 
 require (
-	github.com/FishGoddess/cachego v0.1.1
-	github.com/FishGoddess/vex v0.1.3
-	github.com/gomodule/redigo v2.0.0+incompatible
 	github.com/hashicorp/memberlist v0.3.1
 	github.com/julienschmidt/httprouter v1.3.0
+	go.etcd.io/bbolt v1.3.6
 	stathat.com/c/consistent v1.0.0
 )
@@ -0,0 +1,38 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration 是可以从 JSON 中解析出来的 time.Duration，既可以是 "90s"、"2h" 这样的字符串，也可以是以纳秒为单位的数字。
+type Duration time.Duration
+
+// UnmarshalJSON 从 JSON 的字符串或者数字中解析出时间间隔。
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(v)
+		return nil
+	case string:
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(duration)
+		return nil
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+}
+
+// MarshalJSON 把时间间隔序列化成 "1m30s" 这样的字符串，方便阅读。
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
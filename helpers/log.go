@@ -0,0 +1,410 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// LogLevelDebug 会输出所有的日志，包括每次 GC 和持久化的执行情况。
+	LogLevelDebug = "debug"
+
+	// LogLevelInfo 会输出 info 以及更高级别的日志，比如启动信息和集群的成员变化。
+	LogLevelInfo = "info"
+
+	// LogLevelWarn 会输出 warn 以及更高级别的日志，比如慢日志和复制队列满了之类的异常情况。
+	LogLevelWarn = "warn"
+
+	// LogLevelError 只会输出错误日志。
+	LogLevelError = "error"
+
+	// LogOutputStdout 表示日志输出到标准输出。
+	LogOutputStdout = "stdout"
+
+	// LogOutputStderr 表示日志输出到标准错误，这也是标准库 log 的默认输出。
+	LogOutputStderr = "stderr"
+)
+
+var (
+	// logLevels 是日志级别对应的数字，数字越大级别越高。
+	logLevels = map[string]int32{
+		LogLevelDebug: 0,
+		LogLevelInfo:  1,
+		LogLevelWarn:  2,
+		LogLevelError: 3,
+	}
+
+	// currentLogLevel 是当前的日志级别，低于这个级别的日志不会输出。
+	currentLogLevel = logLevels[LogLevelInfo]
+
+	// logOutput 是当前的日志输出，如果是文件的话，切换输出的时候需要关闭旧的文件。
+	logOutput io.Writer = os.Stderr
+
+	// logOutputLock 保证切换日志输出的并发安全。
+	logOutputLock = &sync.Mutex{}
+)
+
+// LogOptions 是日志的选项配置。
+type LogOptions struct {
+	// Level 是日志级别，可选值有 debug、info、warn 和 error。
+	Level string
+
+	// Output 是日志的输出，可以是 stdout、stderr 或者文件路径。
+	Output string
+
+	// MaxSize 是日志文件的最大大小，超过之后就会切分出一个新的文件，只有输出到文件的时候才生效。
+	// 单位是 MB，如果设置为 0 就表示不按照大小切分。
+	MaxSize int
+
+	// MaxAge 是切分出来的旧日志文件保留的时间，超过这个时间的旧文件会在切分的时候被删除。
+	// 如果设置为 0 就表示一直保留。
+	MaxAge time.Duration
+}
+
+// DefaultLogOptions 返回默认的日志选项配置。
+func DefaultLogOptions() LogOptions {
+	return LogOptions{
+		Level:   LogLevelInfo,
+		Output:  LogOutputStderr,
+		MaxSize: 100,                // 100 MB
+		MaxAge:  7 * 24 * time.Hour, // 7 days
+	}
+}
+
+// Validate 校验日志的选项配置，不合法的配置会返回说明原因的错误。
+func (lo *LogOptions) Validate() error {
+	if _, ok := logLevels[lo.Level]; !ok {
+		return fmt.Errorf("invalid log Level %q: must be one of debug, info, warn and error", lo.Level)
+	}
+
+	if lo.Output == "" {
+		return fmt.Errorf("invalid log Output: must be stdout, stderr or a file path")
+	}
+
+	if lo.MaxSize < 0 {
+		return fmt.Errorf("invalid log MaxSize %d: must not be negative", lo.MaxSize)
+	}
+
+	if lo.MaxAge < 0 {
+		return fmt.Errorf("invalid log MaxAge %v: must not be negative", lo.MaxAge)
+	}
+	return nil
+}
+
+// logOptionsJSON 是 LogOptions 在 JSON 中的格式，MaxAge 使用 "168h" 这样的字符串表示。
+type logOptionsJSON struct {
+	*jsonLogOptions
+	MaxAge Duration
+}
+
+// jsonLogOptions 和 LogOptions 的字段一样，但是没有 LogOptions 的方法，避免序列化的时候无限递归。
+type jsonLogOptions LogOptions
+
+// MarshalJSON 把 LogOptions 序列化成 JSON，MaxAge 会序列化成字符串。
+func (lo LogOptions) MarshalJSON() ([]byte, error) {
+	return json.Marshal(logOptionsJSON{jsonLogOptions: (*jsonLogOptions)(&lo), MaxAge: Duration(lo.MaxAge)})
+}
+
+// UnmarshalJSON 从 JSON 中解析出 LogOptions，MaxAge 可以是字符串也可以是以纳秒为单位的数字，JSON 中没有出现的字段会保留原来的值。
+func (lo *LogOptions) UnmarshalJSON(data []byte) error {
+	aux := logOptionsJSON{jsonLogOptions: (*jsonLogOptions)(lo), MaxAge: Duration(lo.MaxAge)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	lo.MaxAge = time.Duration(aux.MaxAge)
+	return nil
+}
+
+// SetupLog 使用 options 设置日志级别和日志输出，替换掉旧的输出，旧的日志文件会被关闭。
+func SetupLog(options LogOptions) error {
+	if err := SetLogLevel(options.Level); err != nil {
+		return err
+	}
+
+	var output io.Writer
+	switch options.Output {
+	case LogOutputStdout:
+		output = os.Stdout
+	case LogOutputStderr:
+		output = os.Stderr
+	default:
+		file, err := newRotatingFile(options.Output, options.MaxSize, options.MaxAge)
+		if err != nil {
+			return err
+		}
+		output = file
+	}
+
+	logOutputLock.Lock()
+	defer logOutputLock.Unlock()
+	log.SetOutput(output)
+	// 只有日志文件需要关闭，标准输出和标准错误是不能关闭的
+	if file, ok := logOutput.(*rotatingFile); ok && file != output {
+		file.Close()
+	}
+	logOutput = output
+	return nil
+}
+
+// SetLogLevel 设置日志级别，低于这个级别的日志不会输出。
+func SetLogLevel(level string) error {
+	value, ok := logLevels[level]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	atomic.StoreInt32(&currentLogLevel, value)
+	return nil
+}
+
+// LogLevel 返回当前的日志级别。
+func LogLevel() string {
+	level := atomic.LoadInt32(&currentLogLevel)
+	for name, value := range logLevels {
+		if value == level {
+			return name
+		}
+	}
+	return LogLevelInfo
+}
+
+// logEnabled 返回 level 级别的日志是否需要输出。
+func logEnabled(level string) bool {
+	return logLevels[level] >= atomic.LoadInt32(&currentLogLevel)
+}
+
+// logw 在 level 不低于当前日志级别的时候输出一条结构化日志，格式是 [LEVEL] msg key1=value1 key2=value2。
+// keysAndValues 是成对的字段名和字段值，最后一个字段没有值的话会输出 (MISSING)。
+func logw(level string, msg string, keysAndValues ...interface{}) {
+	if !logEnabled(level) {
+		return
+	}
+	log.Print(formatLog(level, msg, keysAndValues))
+}
+
+// formatLog 把日志格式化为 [LEVEL] msg key1=value1 key2=value2 的形式，方便使用 grep 和日志收集工具按照字段检索。
+func formatLog(level string, msg string, keysAndValues []interface{}) string {
+	builder := &strings.Builder{}
+	builder.WriteString("[" + strings.ToUpper(level) + "] ")
+	builder.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+
+		builder.WriteByte(' ')
+		builder.WriteString(fmt.Sprint(keysAndValues[i]))
+		builder.WriteByte('=')
+		builder.WriteString(formatLogValue(value))
+	}
+	return builder.String()
+}
+
+// formatLogValue 格式化字段的值，值为空或者包含空白、引号和等号的话会加上引号，保证一行日志可以被无歧义地解析。
+func formatLogValue(value interface{}) string {
+	s := fmt.Sprintf("%+v", value)
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Debug 输出 debug 级别的日志，keysAndValues 是成对的字段名和字段值，比如 Debug("GC finished", "cleaned", 10)。
+func Debug(msg string, keysAndValues ...interface{}) {
+	logw(LogLevelDebug, msg, keysAndValues...)
+}
+
+// Info 输出 info 级别的日志。
+func Info(msg string, keysAndValues ...interface{}) {
+	logw(LogLevelInfo, msg, keysAndValues...)
+}
+
+// Warn 输出 warn 级别的日志。
+func Warn(msg string, keysAndValues ...interface{}) {
+	logw(LogLevelWarn, msg, keysAndValues...)
+}
+
+// Error 输出 error 级别的日志。
+func Error(msg string, keysAndValues ...interface{}) {
+	logw(LogLevelError, msg, keysAndValues...)
+}
+
+// Fatal 不管当前的日志级别是什么都会输出一条 error 级别的日志，然后退出程序，用于启动失败这种无法继续运行的情况。
+func Fatal(msg string, keysAndValues ...interface{}) {
+	log.Print(formatLog(LogLevelError, msg, keysAndValues))
+	os.Exit(1)
+}
+
+// LogWriter 返回一个把写入的每一行都作为日志输出的 io.Writer，用于接管第三方库的日志，keysAndValues 会附加到每一行日志上。
+// 行首带有 [DEBUG]、[INFO]、[WARN] 或者 [ERR] 之类的级别的话就使用对应的级别输出，否则使用 info 级别。
+func LogWriter(keysAndValues ...interface{}) io.Writer {
+	return &logWriter{fields: keysAndValues}
+}
+
+// logWriter 是把写入的内容转成结构化日志的 io.Writer。
+type logWriter struct {
+	// fields 是附加到每一行日志上的字段。
+	fields []interface{}
+}
+
+// logWriterLevels 是第三方库日志中的级别前缀对应的日志级别。
+var logWriterLevels = []struct {
+	prefix string
+	level  string
+}{
+	{prefix: "[DEBUG]", level: LogLevelDebug},
+	{prefix: "[INFO]", level: LogLevelInfo},
+	{prefix: "[WARN]", level: LogLevelWarn},
+	{prefix: "[ERR]", level: LogLevelError},
+	{prefix: "[ERROR]", level: LogLevelError},
+}
+
+// Write 把 p 中的每一行作为一条日志输出。
+func (lw *logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		level := LogLevelInfo
+		for _, candidate := range logWriterLevels {
+			if index := strings.Index(line, candidate.prefix); index >= 0 {
+				level = candidate.level
+				line = line[index+len(candidate.prefix):]
+				break
+			}
+		}
+		logw(level, strings.TrimSpace(line), lw.fields...)
+	}
+	return len(p), nil
+}
+
+// rotatingFile 是会自动切分的日志文件，文件大小超过 maxSize 之后会被改名为带时间后缀的旧文件，然后重新创建一个新的文件。
+type rotatingFile struct {
+	// path 是日志文件的路径。
+	path string
+
+	// maxSize 是日志文件的最大字节数，0 表示不切分。
+	maxSize int64
+
+	// maxAge 是旧日志文件保留的时间，0 表示一直保留。
+	maxAge time.Duration
+
+	// file 是当前正在写入的文件。
+	file *os.File
+
+	// size 是当前文件已经写入的字节数。
+	size int64
+
+	// lock 保证写入和切分的并发安全。
+	lock *sync.Mutex
+}
+
+// NewRotatingFile 打开 path 对应的文件，写入的内容会追加到文件的末尾，文件大小超过 maxSize MB 之后会自动切分，
+// 切分出来的旧文件保留 maxAge 的时间，两者为 0 分别表示不切分和一直保留，除了日志之外，审计日志也是使用它写入的。
+func NewRotatingFile(path string, maxSize int, maxAge time.Duration) (io.WriteCloser, error) {
+	return newRotatingFile(path, maxSize, maxAge)
+}
+
+// newRotatingFile 打开 path 对应的日志文件，日志会追加到文件的末尾。
+func newRotatingFile(path string, maxSize int, maxAge time.Duration) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:    path,
+		maxSize: int64(maxSize) << 20,
+		maxAge:  maxAge,
+		lock:    &sync.Mutex{},
+	}
+
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open 打开日志文件并记录下文件现有的大小。
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+// Write 写入一条日志，写入之后超过了最大大小的话就切分文件。
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate 把当前的日志文件改名为带时间后缀的旧文件，然后重新打开一个新的文件，并删除过期的旧文件。
+// 改名失败的话会继续写入原来的文件，不能因为切分失败就丢掉日志。
+func (rf *rotatingFile) rotate() error {
+	rf.file.Close()
+	rf.file = nil
+	if err := os.Rename(rf.path, rf.path+"."+time.Now().Format("20060102150405.000")); err == nil {
+		rf.removeExpired()
+	}
+	return rf.open()
+}
+
+// removeExpired 删除修改时间超过 maxAge 的旧日志文件。
+func (rf *rotatingFile) removeExpired() {
+	if rf.maxAge <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return
+	}
+
+	for _, backup := range backups {
+		info, err := os.Stat(backup)
+		if err == nil && time.Since(info.ModTime()) > rf.maxAge {
+			os.Remove(backup)
+		}
+	}
+}
+
+// Close 关闭当前的日志文件。
+func (rf *rotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
@@ -1,7 +1,21 @@
 package helpers
 
-import "strconv"
+import (
+	"net"
+	"strconv"
+	"strings"
+)
 
+// JoinAddressAndPort 将地址和端口拼接起来，IPv6 的地址会被方括号包起来，比如 "[::1]:5837"。
+// 传入的 IPv6 地址带不带方括号都可以。
 func JoinAddressAndPort(address string, port int) string {
-	return address + ":" + strconv.Itoa(port)
+	return net.JoinHostPort(TrimBrackets(address), strconv.Itoa(port))
+}
+
+// TrimBrackets 去掉 IPv6 地址两边的方括号，比如 "[::1]" 会变成 "::1"，其他地址保持不变。
+func TrimBrackets(address string) string {
+	if strings.HasPrefix(address, "[") && strings.HasSuffix(address, "]") {
+		return address[1 : len(address)-1]
+	}
+	return address
 }
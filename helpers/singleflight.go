@@ -0,0 +1,70 @@
+package helpers
+
+import (
+	"context"
+	"sync"
+)
+
+// flight 是一次正在执行的调用。
+type flight struct {
+	// done 会在调用结束之后被关闭。
+	done chan struct{}
+
+	value interface{}
+
+	err error
+}
+
+// SingleFlight 用于合并对同一个 key 的并发调用，同一时间同一个 key 只会执行一次调用，其他调用会等待并共享这次调用的结果。
+// 常用于防止热点 key 失效的时候大量的请求同时打到后端。
+type SingleFlight struct {
+	// flights 存储着正在执行的调用，key 是调用的 key。
+	flights map[string]*flight
+
+	// lock 用于保护 flights。
+	lock *sync.Mutex
+}
+
+// NewSingleFlight 返回一个 SingleFlight 实例。
+func NewSingleFlight() *SingleFlight {
+	return &SingleFlight{
+		flights: map[string]*flight{},
+		lock:    &sync.Mutex{},
+	}
+}
+
+// Do 执行 key 对应的调用，如果已经有相同 key 的调用正在执行，就等待它的结果，shared 表示结果是不是和其他调用共享的。
+func (sf *SingleFlight) Do(key string, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	return sf.DoContext(context.Background(), key, fn)
+}
+
+// DoContext 和 Do 一样，只是等待其他调用的结果时 ctx 被取消或者超时了，就直接返回 ctx 的错误。
+// 注意 fn 只会在发起调用的协程中执行，它不会因为等待方的 ctx 被取消而停止。
+func (sf *SingleFlight) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	sf.lock.Lock()
+	if f, ok := sf.flights[key]; ok {
+		sf.lock.Unlock()
+
+		select {
+		case <-f.done:
+			return f.value, f.err, true
+		case <-ctx.Done():
+			return nil, ctx.Err(), true
+		}
+	}
+
+	f := &flight{done: make(chan struct{})}
+	sf.flights[key] = f
+	sf.lock.Unlock()
+
+	// 调用结束之后马上删除，之后的调用就会重新执行，所以只有并发的调用才会被合并
+	defer func() {
+		sf.lock.Lock()
+		delete(sf.flights, key)
+		sf.lock.Unlock()
+		close(f.done)
+	}()
+
+	f.value, f.err = fn()
+	return f.value, f.err, false
+}
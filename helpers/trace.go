@@ -0,0 +1,24 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// traceSequence 是随机数生成失败时用于生成追踪 ID 的序号。
+	traceSequence uint64
+)
+
+// NewTraceID 生成一个新的追踪 ID，是 16 个字符的十六进制字符串。
+func NewTraceID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		// 随机数生成失败的概率很小，这时候使用时间戳和序号拼接，保证追踪 ID 尽量不重复
+		return strconv.FormatInt(time.Now().UnixNano(), 16) + strconv.FormatUint(atomic.AddUint64(&traceSequence, 1), 16)
+	}
+	return hex.EncodeToString(id)
+}
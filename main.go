@@ -2,38 +2,53 @@ package main
 
 import (
     "flag"
-    "log"
+    "os"
     "strings"
 
-    "cache-server/caches"
-    "cache-server/servers"
+    "github.com/herrhu97/go-distributed-cache/caches"
+    "github.com/herrhu97/go-distributed-cache/helpers"
+    "github.com/herrhu97/go-distributed-cache/metrics"
+    "github.com/herrhu97/go-distributed-cache/servers"
 )
 
 func main() {
 
-    // 准备服务器的选项配置
-    serverOptions := servers.DefaultOptions()
-    flag.StringVar(&serverOptions.Address, "address", serverOptions.Address, "The address used to listen, such as 127.0.0.1.")
-    flag.IntVar(&serverOptions.Port, "port", serverOptions.Port, "The port used to listen, such as 5837.")
-    flag.StringVar(&serverOptions.ServerType, "serverType", serverOptions.ServerType, "The type of server (http, tcp).")
-    flag.IntVar(&serverOptions.VirtualNodeCount, "virtualNodeCount", serverOptions.VirtualNodeCount, "The number of virtual nodes in consistent hash.")
-    flag.IntVar(&serverOptions.UpdateCircleDuration, "updateCircleDuration", serverOptions.UpdateCircleDuration, "The duration between two circle updating operations. The unit is second.")
-    cluster := flag.String("cluster", "", "The cluster of servers. One node in cluster will be ok.")
-
-    // 准备缓存的选项配置
-    cacheOptions := caches.DefaultOptions()
-    flag.IntVar(&cacheOptions.MaxEntrySize, "maxEntrySize", cacheOptions.MaxEntrySize, "The max memory size that entries can use. The unit is GB.")
-    flag.IntVar(&cacheOptions.MaxGcCount, "maxGcCount", cacheOptions.MaxGcCount, "The max count of entries that gc will clean.")
-    flag.IntVar(&cacheOptions.GcDuration, "gcDuration", cacheOptions.GcDuration, "The duration between two gc tasks. The unit is Minute.")
-    flag.StringVar(&cacheOptions.DumpFile, "dumpFile", cacheOptions.DumpFile, "The file used to dump the cache.")
-    flag.IntVar(&cacheOptions.DumpDuration, "dumpDuration", cacheOptions.DumpDuration, "The duration between two dump tasks. The unit is Minute.")
-    flag.IntVar(&cacheOptions.MapSizeOfSegment, "mapSizeOfSegment", cacheOptions.MapSizeOfSegment, "The map size of segment.")
-    flag.IntVar(&cacheOptions.SegmentSize, "segmentSize", cacheOptions.SegmentSize, "The number of segment in a cache. This value should be the pow of 2 for precision.")
-    flag.IntVar(&cacheOptions.CasSleepTime, "casSleepTime", cacheOptions.CasSleepTime, "The time of sleep in one cas step. The unit is Microsecond.")
-    flag.Parse()
-
-    // 从 flag 中解析出集群信息
-    serverOptions.Cluster = nodesInCluster(*cluster)
+    // config 子命令只输出合并之后的选项配置，不会启动服务器，比如 kafo config -config kafo.json -port 5838
+    if len(os.Args) > 1 && os.Args[1] == "config" {
+        if err := printOptions(os.Stdout, os.Args[2:]); err != nil && err != flag.ErrHelp {
+            helpers.Fatal("Failed to load options", "err", err)
+        }
+        return
+    }
+
+    // bench 子命令是内置的压测工具，会对指定的节点或者集群进行压测，比如 kafo bench -address 127.0.0.1:5837 -distribution zipf
+    if len(os.Args) > 1 && os.Args[1] == "bench" {
+        if err := runBench(os.Stdout, os.Args[2:]); err != nil && err != flag.ErrHelp {
+            helpers.Fatal("Benchmark failed", "err", err)
+        }
+        return
+    }
+
+    // 准备服务器和缓存的选项配置，配置文件中的配置会被命令行中的 flag 覆盖
+    opts, err := loadOptions(os.Args[1:])
+    if err == flag.ErrHelp {
+        return
+    }
+    if err != nil {
+        helpers.Fatal("Failed to load options", "err", err)
+    }
+    serverOptions, cacheOptions := opts.Server, opts.Cache
+
+    if err = helpers.SetupLog(opts.Log); err != nil {
+        helpers.Fatal("Failed to setup log", "err", err)
+    }
+
+    // 开启指标上报，缓存和服务器的指标都记录在默认的注册表中
+    reporter, err := metrics.StartReporter(metrics.Default, opts.Metrics)
+    if err != nil {
+        helpers.Fatal("Failed to start metrics reporter", "reporter", opts.Metrics.Reporter, "err", err)
+    }
+    defer reporter.Close()
 
     // 使用选项配置初始化缓存
     cache := caches.NewCacheWith(cacheOptions)
@@ -43,18 +58,106 @@ func main() {
     // 使用选项配置初始化服务器
     server, err := servers.NewServer(cache, serverOptions)
     if err != nil {
-        panic(err)
+        helpers.Fatal("Failed to create server", "type", serverOptions.ServerType, "err", err)
+    }
+
+    // 收到 SIGHUP 信号或者热加载请求的时候，重新读取配置文件并热加载
+    reload := func() error {
+        return reloadOptions(cache, server)
     }
+    server.OnReload(reload)
+    reloadOnSignal(reload)
 
-    log.Printf("Using server options %+v\n", serverOptions)
-    log.Printf("Using cache options %+v\n", cacheOptions)
-    log.Printf("Kafo is running on %s at %s:%d.", serverOptions.ServerType, serverOptions.Address, serverOptions.Port)
+    helpers.Info("Using server options", "options", serverOptions)
+    helpers.Info("Using cache options", "options", cache.Options())
+    helpers.Info("Using log options", "options", opts.Log)
+    helpers.Info("Using metrics options", "options", opts.Metrics)
+    helpers.Info("Kafo is running", "version", servers.Version, "commit", servers.Commit, "buildTime", servers.BuildTime, "type", serverOptions.ServerType, "address", helpers.JoinAddressAndPort(serverOptions.Address, serverOptions.Port))
     err = server.Run()
     if err != nil {
-        panic(err)
+        helpers.Fatal("Server stopped", "err", err)
     }
 }
 
+// newFlagSet 返回解析命令行参数使用的 FlagSet，解析出来的配置会保存到 opts 中，flag 的默认值就是 opts 中现有的值。
+func newFlagSet(opts *options) *flag.FlagSet {
+    flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+    flags.StringVar(&opts.config, "config", opts.config, "The json config file with server and cache sections. Flags take precedence over the file and the file will be reloaded on SIGHUP.")
+
+    flags.StringVar(&opts.profile, "profile", opts.profile, "The preset of cache options for common machine sizes (small, medium, large). Explicit flags and the config file take precedence over it.")
+
+    // 服务器的选项配置
+    flags.StringVar(&opts.Server.Address, "address", opts.Server.Address, "The address used to listen, such as 127.0.0.1 or ::1.")
+    flags.StringVar(&opts.Server.Network, "network", opts.Server.Network, "The network used to listen (tcp, tcp4, tcp6). Use tcp with address :: to listen on both IPv4 and IPv6.")
+    flags.IntVar(&opts.Server.Port, "port", opts.Server.Port, "The port used to listen, such as 5837.")
+    flags.IntVar(&opts.Server.Acceptors, "acceptors", opts.Server.Acceptors, "The number of listeners accepting connections in parallel. SO_REUSEPORT will be used if it's greater than 1.")
+    flags.BoolVar(&opts.Server.SocketActivation, "socketActivation", opts.Server.SocketActivation, "Use the listeners passed by systemd socket activation (LISTEN_FDS).")
+    flags.StringVar(&opts.Server.ServerType, "serverType", opts.Server.ServerType, "The type of server (http, tcp).")
+    flags.IntVar(&opts.Server.VirtualNodeCount, "virtualNodeCount", opts.Server.VirtualNodeCount, "The number of virtual nodes in consistent hash.")
+    flags.DurationVar(&opts.Server.UpdateCircleDuration, "updateCircleDuration", opts.Server.UpdateCircleDuration, "The duration between two circle updating operations, such as 3s.")
+    flags.DurationVar(&opts.Server.RequestTimeout, "requestTimeout", opts.Server.RequestTimeout, "The deadline of one request, such as 5s. 0 means no deadline.")
+    flags.DurationVar(&opts.Server.IdleTimeout, "idleTimeout", opts.Server.IdleTimeout, "The idle time before closing a tcp connection, such as 30m. 0 means never.")
+    flags.DurationVar(&opts.Server.KeepAlivePeriod, "keepAlivePeriod", opts.Server.KeepAlivePeriod, "The keepalive period of tcp connections, such as 15s. 0 means default and negative means disabled.")
+    flags.IntVar(&opts.Server.MaxArgSize, "maxArgSize", opts.Server.MaxArgSize, "The max size of one argument in a tcp request. The unit is Byte and 0 means no limit.")
+    flags.IntVar(&opts.Server.MaxFrameSize, "maxFrameSize", opts.Server.MaxFrameSize, "The max size of a tcp request frame. The unit is Byte and 0 means no limit.")
+    flags.IntVar(&opts.Server.MaxChunkedSize, "maxChunkedSize", opts.Server.MaxChunkedSize, "The max size of a value uploaded in chunks over tcp. The unit is Byte and 0 means no limit.")
+    flags.IntVar(&opts.Server.MaxChunkedInFlight, "maxChunkedInFlight", opts.Server.MaxChunkedInFlight, "The max total size of chunks received by all uploads in progress. The unit is Byte and 0 means no limit.")
+    flags.IntVar(&opts.Server.WriteBufferSize, "writeBufferSize", opts.Server.WriteBufferSize, "The size of the write buffer of a tcp connection. Responses of pipelined requests are flushed together. The unit is Byte and 0 means flushing every response.")
+    flags.StringVar(&opts.Server.BackingStore, "backingStore", opts.Server.BackingStore, "The backing store used to load missing keys. A http or https url means a callback with the same api as the http server, otherwise it's a command run as \"command get|set|delete key [ttl]\".")
+    flags.DurationVar(&opts.Server.BackingStoreTTL, "backingStoreTTL", opts.Server.BackingStoreTTL, "The ttl of values loaded from the backing store, such as 10m. 0 means never expire.")
+    flags.BoolVar(&opts.Server.WriteThrough, "writeThrough", opts.Server.WriteThrough, "Write sets and deletes to the backing store before the cache.")
+    flags.StringVar(&opts.Server.HTTPAuthFile, "httpAuthFile", opts.Server.HTTPAuthFile, "The json file of credentials (api keys or basic auth users with scopes) used by http server.")
+    flags.DurationVar(&opts.Server.SlowLogThreshold, "slowLogThreshold", opts.Server.SlowLogThreshold, "The threshold of slow log, such as 100ms. 0 means disabled.")
+    flags.IntVar(&opts.Server.ReplicationFactor, "replicationFactor", opts.Server.ReplicationFactor, "The number of copies of each key including the owner's. Copies are replicated asynchronously and only tcp server supports it. It needs -enable=replication.")
+    flags.StringVar(&opts.enable, "enable", opts.enable, "The experimental features enabled, separated by \",\" ("+strings.Join(servers.ExperimentalFeatures(), ", ")+").")
+    flags.IntVar(&opts.Server.GossipPort, "gossipPort", opts.Server.GossipPort, "The port used to gossip with other nodes in cluster. Nodes on the same machine should use different ports.")
+    flags.StringVar(&opts.cluster, "cluster", opts.cluster, "The gossip addresses of servers in cluster separated by \",\", such as 127.0.0.1:7946. One node in cluster will be ok.")
+    flags.StringVar(&opts.Server.AuditFile, "auditFile", opts.Server.AuditFile, "The file which successful write operations are appended to as json lines with the client and user. Empty means disabled.")
+    flags.IntVar(&opts.Server.AuditMaxSize, "auditMaxSize", opts.Server.AuditMaxSize, "The max size of audit file before rotating. The unit is MB and 0 means never rotating.")
+    flags.DurationVar(&opts.Server.AuditMaxAge, "auditMaxAge", opts.Server.AuditMaxAge, "The max age of rotated audit files, such as 720h. 0 means keeping forever.")
+    flags.StringVar(&opts.Server.AlertWebhook, "alertWebhook", opts.Server.AlertWebhook, "The url which alerts are posted to as json when resource usage crosses thresholds. Empty means disabled.")
+    flags.DurationVar(&opts.Server.AlertInterval, "alertInterval", opts.Server.AlertInterval, "The duration between two alert checks, such as 30s.")
+    flags.IntVar(&opts.Server.AlertMemoryPercent, "alertMemoryPercent", opts.Server.AlertMemoryPercent, "The threshold of data size in percent of maxEntrySize. 0 means disabled.")
+    flags.IntVar(&opts.Server.AlertEvictionRate, "alertEvictionRate", opts.Server.AlertEvictionRate, "The threshold of evicted entries per second. 0 means disabled.")
+    flags.IntVar(&opts.Server.AlertDumpFailures, "alertDumpFailures", opts.Server.AlertDumpFailures, "The threshold of failed dumps in one alert check. 0 means disabled.")
+    flags.DurationVar(&opts.Server.FaultLatency, "faultLatency", opts.Server.FaultLatency, "The latency injected into every tcp request for testing only, such as 50ms. 0 means disabled.")
+    flags.IntVar(&opts.Server.FaultDropPercent, "faultDropPercent", opts.Server.FaultDropPercent, "The percent of tcp responses dropped by closing the connection for testing only. 0 means disabled.")
+    flags.IntVar(&opts.Server.FaultRedirectPercent, "faultRedirectPercent", opts.Server.FaultRedirectPercent, "The percent of keyed tcp commands redirected to a random other node for testing only. 0 means disabled.")
+
+    // 日志的选项配置
+    flags.StringVar(&opts.Log.Level, "logLevel", opts.Log.Level, "The level of logs (debug, info, warn, error).")
+    flags.StringVar(&opts.Log.Output, "logOutput", opts.Log.Output, "The output of logs, which can be stdout, stderr or a file path.")
+    flags.IntVar(&opts.Log.MaxSize, "logMaxSize", opts.Log.MaxSize, "The max size of log file before rotating. The unit is MB and 0 means never rotating.")
+    flags.DurationVar(&opts.Log.MaxAge, "logMaxAge", opts.Log.MaxAge, "The max age of rotated log files, such as 168h. 0 means keeping forever.")
+
+    // 指标上报的选项配置
+    flags.StringVar(&opts.Metrics.Reporter, "metricsReporter", opts.Metrics.Reporter, "The reporter of metrics (none, prometheus, statsd).")
+    flags.StringVar(&opts.Metrics.Address, "metricsAddress", opts.Metrics.Address, "The address of metrics reporter. It's the listening address like :9100 for prometheus and the statsd server like 127.0.0.1:8125 for statsd.")
+    flags.DurationVar(&opts.Metrics.Interval, "metricsInterval", opts.Metrics.Interval, "The duration between two pushes of statsd reporter, such as 10s.")
+
+    // 缓存的选项配置
+    flags.IntVar(&opts.Cache.MaxEntrySize, "maxEntrySize", opts.Cache.MaxEntrySize, "The max memory size that entries can use. The unit is MB.")
+    flags.IntVar(&opts.Cache.MaxGcCount, "maxGcCount", opts.Cache.MaxGcCount, "The max count of entries that gc will clean.")
+    flags.DurationVar(&opts.Cache.GcDuration, "gcDuration", opts.Cache.GcDuration, "The duration between two gc tasks, such as 90s or 2h.")
+    flags.DurationVar(&opts.Cache.GcLockTime, "gcLockTime", opts.Cache.GcLockTime, "The max time gc holds a segment lock per batch before yielding to other operations, such as 1ms. 0 means unlimited.")
+    flags.StringVar(&opts.Cache.DumpFile, "dumpFile", opts.Cache.DumpFile, "The file used to dump the cache. {node} and {port} will be replaced with the address and port of this node, such as cache-{node}.dump.")
+    flags.DurationVar(&opts.Cache.DumpDuration, "dumpDuration", opts.Cache.DumpDuration, "The duration between two dump tasks, such as 30m.")
+    flags.IntVar(&opts.Cache.MapSizeOfSegment, "mapSizeOfSegment", opts.Cache.MapSizeOfSegment, "The map size of segment.")
+    flags.IntVar(&opts.Cache.SegmentSize, "segmentSize", opts.Cache.SegmentSize, "The number of segment in a cache. This value should be the pow of 2 for precision.")
+    flags.DurationVar(&opts.Cache.CasSleepTime, "casSleepTime", opts.Cache.CasSleepTime, "The time of sleep in one cas step, such as 1ms.")
+    flags.IntVar(&opts.Cache.MaxEntriesPerSegment, "maxEntriesPerSegment", opts.Cache.MaxEntriesPerSegment, "The max count of entries in one segment. 0 means no limit.")
+    flags.IntVar(&opts.Cache.MaxCount, "maxCount", opts.Cache.MaxCount, "The max count of entries in the whole cache, split evenly across segments. 0 means no limit.")
+    flags.StringVar(&opts.Cache.EvictionPolicy, "evictionPolicy", opts.Cache.EvictionPolicy, "The policy of evicting entries when the cache is full (none, lru, lfu, random).")
+    flags.DurationVar(&opts.Cache.NegativeTTL, "negativeTTL", opts.Cache.NegativeTTL, "The duration of caching not found results from the backing store, such as 30s. 0 means disabled.")
+    flags.DurationVar(&opts.Cache.StaleTTL, "staleTTL", opts.Cache.StaleTTL, "The duration of serving expired entries stale while refreshing them from the backing store, such as 1m. 0 means disabled.")
+    flags.StringVar(&opts.Cache.SpillDir, "spillDir", opts.Cache.SpillDir, "The directory that evicted entries are spilled to and promoted back from on access. It needs an eviction policy other than none.")
+    flags.IntVar(&opts.Cache.SpillThreshold, "spillThreshold", opts.Cache.SpillThreshold, "The size of values stored on disk directly instead of memory. The unit is Byte and 0 means only evicted entries are spilled.")
+    flags.BoolVar(&opts.Cache.ValueArena, "valueArena", opts.Cache.ValueArena, "Store values in an arena outside the go heap to reduce gc work. Reads copy values and the arena never returns memory to the os.")
+    flags.StringVar(&opts.Cache.Engine, "engine", opts.Cache.Engine, "The storage engine of segments, one of memory and bolt. Bolt stores entries in the file of -enginePath and ignores -dumpFile.")
+    flags.StringVar(&opts.Cache.EnginePath, "enginePath", opts.Cache.EnginePath, "The database file of the bolt engine.")
+    return flags
+}
+
 // nodesInCluster 使用 "," 分割 cluster 并解析出集群信息。
 func nodesInCluster(cluster string) []string {
     if cluster == "" {
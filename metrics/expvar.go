@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"expvar"
+)
+
+// Expvar 返回一个输出 registry 中所有指标的 expvar.Func，可以使用 expvar.Publish 发布到标准的 /debug/vars 接口上。
+// 输出的 key 是指标的名字加上标签，计数器和仪表的值是一个数字，直方图的值是包含 count 和 sum 的对象。
+func Expvar(registry *Registry) expvar.Func {
+	return func() interface{} {
+		result := map[string]interface{}{}
+		for _, sample := range registry.Snapshot() {
+			key := sample.Name + formatLabels(sample.Labels)
+			if sample.Kind != KindHistogram {
+				result[key] = sample.Value
+				continue
+			}
+
+			result[key] = map[string]interface{}{
+				"count": sample.Count,
+				"sum":   sample.Sum,
+			}
+		}
+		return result
+	}
+}
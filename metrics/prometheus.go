@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// prometheusReporter 是 Prometheus 的拉取式上报器，会开启一个 HTTP 服务，由 Prometheus 定时来拉取指标。
+type prometheusReporter struct {
+	server *http.Server
+}
+
+// newPrometheusReporter 在 address 上开启一个提供 /metrics 接口的 HTTP 服务。
+func newPrometheusReporter(registry *Registry, address string) (*prometheusReporter, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(registry))
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	return &prometheusReporter{server: server}, nil
+}
+
+// Close 关闭 HTTP 服务。
+func (pr *prometheusReporter) Close() error {
+	return pr.server.Close()
+}
+
+// Handler 返回一个以 Prometheus 文本格式输出 registry 中所有指标的 http.Handler，也可以挂载到已有的 HTTP 服务上。
+func Handler(registry *Registry) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writer.WriteHeader(http.StatusOK)
+
+		buffer := bufio.NewWriter(writer)
+		defer buffer.Flush()
+
+		// 同一个名字的指标只需要输出一次 HELP 和 TYPE
+		lastName := ""
+		for _, sample := range registry.Snapshot() {
+			if sample.Name != lastName {
+				fmt.Fprintf(buffer, "# HELP %s %s\n", sample.Name, sample.Help)
+				fmt.Fprintf(buffer, "# TYPE %s %s\n", sample.Name, sample.Kind)
+				lastName = sample.Name
+			}
+			writePrometheusSample(buffer, sample)
+		}
+	})
+}
+
+// writePrometheusSample 以 Prometheus 文本格式输出一个指标的值，直方图会输出每个桶以及总和和个数。
+func writePrometheusSample(writer *bufio.Writer, sample Sample) {
+	if sample.Kind != KindHistogram {
+		fmt.Fprintf(writer, "%s%s %s\n", sample.Name, formatLabels(sample.Labels), formatFloat(sample.Value))
+		return
+	}
+
+	for i, bucket := range sample.Buckets {
+		labels := append(append([]string(nil), sample.Labels...), "le", formatFloat(bucket))
+		fmt.Fprintf(writer, "%s_bucket%s %d\n", sample.Name, formatLabels(labels), sample.Counts[i])
+	}
+
+	labels := append(append([]string(nil), sample.Labels...), "le", "+Inf")
+	fmt.Fprintf(writer, "%s_bucket%s %d\n", sample.Name, formatLabels(labels), sample.Count)
+	fmt.Fprintf(writer, "%s_sum%s %s\n", sample.Name, formatLabels(sample.Labels), formatFloat(sample.Sum))
+	fmt.Fprintf(writer, "%s_count%s %d\n", sample.Name, formatLabels(sample.Labels), sample.Count)
+}
+
+// formatFloat 使用最短的形式格式化浮点数。
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
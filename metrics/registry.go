@@ -0,0 +1,290 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// DefaultBuckets 是直方图默认使用的桶，单位是秒，适合统计请求和后台任务的耗时。
+	DefaultBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+	// Default 是默认的指标注册表，缓存和服务器的指标都会注册到这里。
+	Default = NewRegistry()
+)
+
+// Registry 是指标的注册表，记录着所有的计数器、仪表和直方图，是并发安全的。
+// 同样名字和标签的指标只会注册一次，重复注册会返回已经注册过的指标，所以可以在需要的地方直接获取指标使用。
+type Registry struct {
+	// metrics 记录着所有的指标，key 是指标的名字加上标签。
+	metrics map[string]*metric
+
+	// lock 用于保证注册指标的并发安全。
+	lock *sync.RWMutex
+}
+
+// NewRegistry 返回一个新的指标注册表。
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: map[string]*metric{},
+		lock:    &sync.RWMutex{},
+	}
+}
+
+// Counter 返回名字是 name 的计数器，labels 是成对的标签名和标签值，比如 "command", "get"。
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	return r.register(name, help, KindCounter, labels, func(m *metric) {
+		m.counter = &Counter{}
+	}).counter
+}
+
+// Gauge 返回名字是 name 的仪表，labels 是成对的标签名和标签值。
+func (r *Registry) Gauge(name string, help string, labels ...string) *Gauge {
+	return r.register(name, help, KindGauge, labels, func(m *metric) {
+		m.gauge = &Gauge{}
+	}).gauge
+}
+
+// GaugeFunc 注册一个在上报的时候才调用 fn 获取值的仪表，适合数据个数这种本身已经记录在别的地方的值。
+// 同样名字和标签的仪表再次注册的时候会使用新的 fn 替换旧的。
+func (r *Registry) GaugeFunc(name string, help string, fn func() float64, labels ...string) {
+	m := r.register(name, help, KindGauge, labels, func(m *metric) {
+		m.gauge = &Gauge{}
+	})
+
+	r.lock.Lock()
+	m.gaugeFunc = fn
+	r.lock.Unlock()
+}
+
+// Histogram 返回名字是 name 的直方图，buckets 为 nil 的时候使用 DefaultBuckets，labels 是成对的标签名和标签值。
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return r.register(name, help, KindHistogram, labels, func(m *metric) {
+		m.histogram = newHistogram(buckets)
+	}).histogram
+}
+
+// register 注册一个指标，已经注册过的话就直接返回，否则使用 init 初始化一个新的指标。
+func (r *Registry) register(name string, help string, kind string, labels []string, init func(m *metric)) *metric {
+	key := name + formatLabels(labels)
+	r.lock.RLock()
+	m, ok := r.metrics[key]
+	r.lock.RUnlock()
+	if ok {
+		return m
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if m, ok = r.metrics[key]; ok {
+		return m
+	}
+
+	m = &metric{name: name, help: help, kind: kind, labels: labels}
+	init(m)
+	r.metrics[key] = m
+	return m
+}
+
+// Snapshot 返回所有指标当前的值，已经按照名字和标签排好序了。
+func (r *Registry) Snapshot() []Sample {
+	r.lock.RLock()
+	samples := make([]Sample, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		samples = append(samples, m.sample())
+	}
+	r.lock.RUnlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Name != samples[j].Name {
+			return samples[i].Name < samples[j].Name
+		}
+		return formatLabels(samples[i].Labels) < formatLabels(samples[j].Labels)
+	})
+	return samples
+}
+
+const (
+	// KindCounter 是计数器，只会增加的值，比如命令的执行次数。
+	KindCounter = "counter"
+
+	// KindGauge 是仪表，可以增加也可以减少的值，比如当前的连接数。
+	KindGauge = "gauge"
+
+	// KindHistogram 是直方图，用于统计耗时之类的值的分布。
+	KindHistogram = "histogram"
+)
+
+// Sample 是某个指标在某个时刻的值。
+type Sample struct {
+	// Name 是指标的名字。
+	Name string
+
+	// Help 是指标的说明。
+	Help string
+
+	// Kind 是指标的类型，可以是 KindCounter、KindGauge 和 KindHistogram。
+	Kind string
+
+	// Labels 是成对的标签名和标签值。
+	Labels []string
+
+	// Value 是计数器和仪表的值。
+	Value float64
+
+	// Buckets 是直方图的桶的上界，Counts 是每个桶中累计的个数，也就是小于等于上界的值的个数。
+	Buckets []float64
+
+	Counts []uint64
+
+	// Count 和 Sum 是直方图中所有值的个数和总和。
+	Count uint64
+
+	Sum float64
+}
+
+// metric 是注册表中的一个指标。
+type metric struct {
+	name      string
+	help      string
+	kind      string
+	labels    []string
+	counter   *Counter
+	gauge     *Gauge
+	gaugeFunc func() float64
+	histogram *Histogram
+}
+
+// sample 返回这个指标当前的值，调用者需要持有注册表的读锁。
+func (m *metric) sample() Sample {
+	sample := Sample{Name: m.name, Help: m.help, Kind: m.kind, Labels: m.labels}
+	switch m.kind {
+	case KindCounter:
+		sample.Value = float64(m.counter.Value())
+	case KindGauge:
+		sample.Value = m.gauge.Value()
+		if m.gaugeFunc != nil {
+			sample.Value = m.gaugeFunc()
+		}
+	case KindHistogram:
+		sample.Buckets = m.histogram.buckets
+		sample.Counts, sample.Count, sample.Sum = m.histogram.snapshot()
+	}
+	return sample
+}
+
+// formatLabels 把成对的标签格式化成 {name="value",...} 的形式，没有标签的话返回空字符串。
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+strings.ReplaceAll(labels[i+1], `"`, `\"`)+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter 是只会增加的计数器。
+type Counter struct {
+	value int64
+}
+
+// Inc 将计数器加 1。
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.value, 1)
+}
+
+// Add 将计数器加上 delta，delta 需要大于等于 0。
+func (c *Counter) Add(delta int64) {
+	atomic.AddInt64(&c.value, delta)
+}
+
+// Value 返回计数器当前的值。
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+// Gauge 是可以增加也可以减少的仪表。
+type Gauge struct {
+	// bits 是 float64 的二进制表示，这样就可以使用 atomic 包进行并发安全的更新了。
+	bits uint64
+}
+
+// Set 将仪表设置为 value。
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
+}
+
+// Add 将仪表加上 delta，delta 可以是负数。
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		if atomic.CompareAndSwapUint64(&g.bits, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// Value 返回仪表当前的值。
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Histogram 是统计值分布情况的直方图。
+type Histogram struct {
+	// buckets 是每个桶的上界，是从小到大排好序的。
+	buckets []float64
+
+	// counts 是每个桶中的个数，最后一个是超过了所有上界的个数。
+	counts []uint64
+
+	count uint64
+
+	sum float64
+
+	lock *sync.Mutex
+}
+
+// newHistogram 返回一个使用 buckets 作为桶的直方图。
+func newHistogram(buckets []float64) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &Histogram{
+		buckets: sorted,
+		counts:  make([]uint64, len(sorted)+1),
+		lock:    &sync.Mutex{},
+	}
+}
+
+// Observe 记录一个值。
+func (h *Histogram) Observe(value float64) {
+	index := sort.SearchFloat64s(h.buckets, value)
+	h.lock.Lock()
+	h.counts[index]++
+	h.count++
+	h.sum += value
+	h.lock.Unlock()
+}
+
+// snapshot 返回每个桶中累计的个数，以及所有值的个数和总和。
+func (h *Histogram) snapshot() ([]uint64, uint64, float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	cumulative := make([]uint64, len(h.buckets))
+	total := uint64(0)
+	for i := range h.buckets {
+		total += h.counts[i]
+		cumulative[i] = total
+	}
+	return cumulative, h.count, h.sum
+}
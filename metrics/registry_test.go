@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// go test -v -run=^TestRegistry$
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("commands_total", "The number of commands.", "command", "get").Add(2)
+	registry.Counter("commands_total", "The number of commands.", "command", "get").Inc()
+	registry.Gauge("connections", "The number of connections.").Set(5)
+	registry.GaugeFunc("entries", "The number of entries.", func() float64 { return 7 })
+	histogram := registry.Histogram("duration_seconds", "The duration.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+
+	samples := registry.Snapshot()
+	if len(samples) != 4 {
+		t.Fatalf("samples length %d should be 4", len(samples))
+	}
+
+	// 同样名字和标签的计数器只会注册一次
+	if samples[0].Name != "commands_total" || samples[0].Value != 3 {
+		t.Fatalf("sample %+v is wrong", samples[0])
+	}
+
+	if samples[1].Name != "connections" || samples[1].Value != 5 || samples[3].Name != "entries" || samples[3].Value != 7 {
+		t.Fatalf("samples %+v are wrong", samples)
+	}
+
+	if counts := samples[2].Counts; samples[2].Count != 3 || counts[0] != 1 || counts[1] != 2 {
+		t.Fatalf("histogram sample %+v is wrong", samples[2])
+	}
+}
+
+// go test -v -run=^TestHandler$
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("commands_total", "The number of commands.", "command", "get").Inc()
+	registry.Histogram("duration_seconds", "The duration.", []float64{1}).Observe(0.5)
+
+	recorder := httptest.NewRecorder()
+	Handler(registry).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE commands_total counter",
+		`commands_total{command="get"} 1`,
+		`duration_seconds_bucket{le="1"} 1`,
+		`duration_seconds_bucket{le="+Inf"} 1`,
+		"duration_seconds_count 1",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("body %q should contain %q", body, line)
+		}
+	}
+}
+
+// go test -v -run=^TestExpvar$
+func TestExpvar(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("commands_total", "The number of commands.", "command", "get").Add(2)
+	registry.Histogram("duration_seconds", "The duration.", nil).Observe(0.5)
+
+	values := Expvar(registry)().(map[string]interface{})
+	if values[`commands_total{command="get"}`] != float64(2) {
+		t.Fatalf("values %+v are wrong", values)
+	}
+
+	if histogram, ok := values["duration_seconds"].(map[string]interface{}); !ok || histogram["count"] != uint64(1) {
+		t.Fatalf("values %+v are wrong", values)
+	}
+}
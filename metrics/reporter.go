@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+const (
+	// ReporterNone 表示不上报指标，指标还是会被记录，可以通过 Handler 挂载到别的 HTTP 服务上。
+	ReporterNone = "none"
+
+	// ReporterPrometheus 表示开启一个 HTTP 服务，由 Prometheus 来拉取指标。
+	ReporterPrometheus = "prometheus"
+
+	// ReporterStatsd 表示定时把指标推送到 StatsD 服务。
+	ReporterStatsd = "statsd"
+)
+
+// Options 是指标上报的选项配置。
+type Options struct {
+	// Reporter 是上报器的类型，可选值有 none、prometheus 和 statsd。
+	Reporter string
+
+	// Address 是上报器使用的地址，prometheus 是监听的地址，比如 :9100，statsd 是 StatsD 服务的地址，比如 127.0.0.1:8125。
+	Address string
+
+	// Interval 是 statsd 推送指标的时间间隔。
+	Interval time.Duration
+}
+
+// DefaultOptions 返回默认的指标上报配置，默认是不上报的。
+func DefaultOptions() Options {
+	return Options{
+		Reporter: ReporterNone,
+		Address:  "",
+		Interval: 10 * time.Second,
+	}
+}
+
+// Validate 校验指标上报的选项配置，不合法的配置会返回说明原因的错误。
+func (o *Options) Validate() error {
+	switch o.Reporter {
+	case ReporterNone:
+		return nil
+	case ReporterPrometheus, ReporterStatsd:
+	default:
+		return fmt.Errorf("invalid metrics Reporter %q: must be one of none, prometheus and statsd", o.Reporter)
+	}
+
+	if o.Address == "" {
+		return fmt.Errorf("invalid metrics Address: must be set when Reporter is %s", o.Reporter)
+	}
+
+	if o.Reporter == ReporterStatsd && o.Interval <= 0 {
+		return fmt.Errorf("invalid metrics Interval %v: must be greater than 0", o.Interval)
+	}
+	return nil
+}
+
+// StartReporter 按照 options 开启上报 registry 中指标的上报器，关闭返回的 io.Closer 就会停止上报。
+func StartReporter(registry *Registry, options Options) (io.Closer, error) {
+	switch options.Reporter {
+	case ReporterPrometheus:
+		return newPrometheusReporter(registry, options.Address)
+	case ReporterStatsd:
+		return newStatsdReporter(registry, options.Address, options.Interval)
+	default:
+		return noopReporter{}, nil
+	}
+}
+
+// noopReporter 是不上报指标的上报器。
+type noopReporter struct{}
+
+func (noopReporter) Close() error { return nil }
+
+// optionsJSON 是 Options 在 JSON 中的格式，时间间隔使用 "10s" 这样的字符串表示。
+type optionsJSON struct {
+	*jsonOptions
+	Interval helpers.Duration
+}
+
+// jsonOptions 和 Options 的字段一样，但是没有 Options 的方法，避免序列化的时候无限递归。
+type jsonOptions Options
+
+// MarshalJSON 把 Options 序列化成 JSON，时间间隔会序列化成字符串。
+func (o Options) MarshalJSON() ([]byte, error) {
+	return json.Marshal(optionsJSON{
+		jsonOptions: (*jsonOptions)(&o),
+		Interval:    helpers.Duration(o.Interval),
+	})
+}
+
+// UnmarshalJSON 从 JSON 中解析出 Options，时间间隔可以是字符串也可以是以纳秒为单位的数字，JSON 中没有出现的字段会保留原来的值。
+func (o *Options) UnmarshalJSON(data []byte) error {
+	aux := optionsJSON{
+		jsonOptions: (*jsonOptions)(o),
+		Interval:    helpers.Duration(o.Interval),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	o.Interval = time.Duration(aux.Interval)
+	return nil
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// statsdMaxPacketSize 是一个 StatsD 数据包的最大字节数，超过这个大小的数据会分成多个包发送，避免 UDP 包被分片。
+	statsdMaxPacketSize = 1432
+)
+
+// statsdReporter 是 StatsD 的推送式上报器，每隔一段时间就把所有指标通过 UDP 推送到 StatsD 服务。
+type statsdReporter struct {
+	registry *Registry
+
+	conn net.Conn
+
+	// lastCounters 记录着上一次推送时计数器的值，StatsD 的计数器是增量，所以每次只推送两次之间增加的部分。
+	lastCounters map[string]float64
+
+	// lastHistograms 记录着上一次推送时直方图的个数和总和，直方图会以平均值的形式推送。
+	lastHistograms map[string][2]float64
+
+	closed chan struct{}
+}
+
+// newStatsdReporter 返回一个每隔 interval 就推送一次指标到 address 上的 StatsD 上报器。
+func newStatsdReporter(registry *Registry, address string, interval time.Duration) (*statsdReporter, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &statsdReporter{
+		registry:       registry,
+		conn:           conn,
+		lastCounters:   map[string]float64{},
+		lastHistograms: map[string][2]float64{},
+		closed:         make(chan struct{}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sr.push()
+			case <-sr.closed:
+				return
+			}
+		}
+	}()
+	return sr, nil
+}
+
+// push 把所有指标推送到 StatsD 服务，推送失败的话就等下一次推送，因为 UDP 本来就不保证送达。
+func (sr *statsdReporter) push() {
+	buffer := &bytes.Buffer{}
+	for _, sample := range sr.registry.Snapshot() {
+		name := statsdName(sample)
+		line := ""
+		switch sample.Kind {
+		case KindCounter:
+			delta := sample.Value - sr.lastCounters[name]
+			sr.lastCounters[name] = sample.Value
+			if delta <= 0 {
+				continue
+			}
+			line = fmt.Sprintf("%s:%s|c\n", name, formatFloat(delta))
+		case KindGauge:
+			line = fmt.Sprintf("%s:%s|g\n", name, formatFloat(sample.Value))
+		case KindHistogram:
+			// 直方图推送的是两次推送之间的平均值，单位从秒换成 StatsD 习惯使用的毫秒
+			last := sr.lastHistograms[name]
+			sr.lastHistograms[name] = [2]float64{float64(sample.Count), sample.Sum}
+			count := float64(sample.Count) - last[0]
+			if count <= 0 {
+				continue
+			}
+			line = fmt.Sprintf("%s:%s|ms\n", name, formatFloat((sample.Sum-last[1])/count*1000))
+		}
+
+		if buffer.Len()+len(line) > statsdMaxPacketSize {
+			sr.conn.Write(buffer.Bytes())
+			buffer.Reset()
+		}
+		buffer.WriteString(line)
+	}
+
+	if buffer.Len() > 0 {
+		sr.conn.Write(buffer.Bytes())
+	}
+}
+
+// statsdName 把指标的名字和标签拼接成 StatsD 的名字，比如 kafo_server_commands_total.command.get。
+func statsdName(sample Sample) string {
+	parts := []string{sample.Name}
+	for i := 1; i < len(sample.Labels); i += 2 {
+		parts = append(parts, strings.NewReplacer(".", "_", ":", "_", "|", "_").Replace(sample.Labels[i]))
+	}
+	return strings.Join(parts, ".")
+}
+
+// Close 停止推送并关闭连接。
+func (sr *statsdReporter) Close() error {
+	close(sr.closed)
+	return sr.conn.Close()
+}
@@ -0,0 +1,52 @@
+package servers
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+)
+
+const (
+	// listenFdsStart 是 systemd 传递的第一个文件描述符，前面的 0、1、2 是标准输入、输出和错误。
+	listenFdsStart = 3
+)
+
+var (
+	errNoActivatedListener = errors.New("no listener is passed by socket activation")
+)
+
+// activatedListeners 返回 systemd socket activation 传递过来的监听器。
+// systemd 会通过 LISTEN_PID 和 LISTEN_FDS 两个环境变量告知传递了几个文件描述符，这些文件描述符从 3 开始依次递增。
+// 这样服务重启的时候监听的套接字是由 systemd 持有的，不会因为重启而拒绝新的连接。
+func activatedListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errNoActivatedListener
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, errNoActivatedListener
+	}
+
+	// 清理掉这些环境变量，避免被子进程继承之后误用
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, fds)
+	for fd := listenFdsStart; fd < listenFdsStart+fds; fd++ {
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+
+		// FileListener 会复制一份文件描述符，所以原来的文件需要关闭
+		file.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,212 @@
+package servers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+const (
+	// alertMemory 是数据占用空间过多的告警。
+	alertMemory = "memory"
+
+	// alertEvictionRate 是淘汰数据过快的告警。
+	alertEvictionRate = "eviction-rate"
+
+	// alertDumpFailures 是持久化失败的告警。
+	alertDumpFailures = "dump-failures"
+
+	// AlertFiring 表示告警被触发了。
+	AlertFiring = "firing"
+
+	// AlertResolved 表示告警已经恢复正常了。
+	AlertResolved = "resolved"
+
+	// alertTimeout 是发送一次告警的超时时间。
+	alertTimeout = 5 * time.Second
+)
+
+// Alert 是发送给告警地址的通知，同一个告警只会在触发和恢复的时候各发送一次，不会在每次检查的时候重复发送。
+type Alert struct {
+	// Node 是发出告警的节点地址。
+	Node string `json:"node"`
+
+	// Name 是告警的名字，可以是 memory、eviction-rate 和 dump-failures。
+	Name string `json:"name"`
+
+	// Status 是告警的状态，可以是 AlertFiring 和 AlertResolved。
+	Status string `json:"status"`
+
+	// Value 是检查时的实际值，单位和阈值一样。
+	Value float64 `json:"value"`
+
+	// Threshold 是告警的阈值。
+	Threshold float64 `json:"threshold"`
+
+	// Message 是告警的描述。
+	Message string `json:"message"`
+
+	// Time 是检查的时间。
+	Time time.Time `json:"time"`
+}
+
+// alertSample 是一次检查时采集到的资源使用情况。
+type alertSample struct {
+	// time 是采集的时间。
+	time time.Time
+
+	// entrySize 是数据占用的空间大小，单位是字节。
+	entrySize int64
+
+	// maxEntrySize 是触发写满保护的空间大小，单位是字节，MaxEntrySize 的单位是 MB。
+	maxEntrySize int64
+
+	// evictions 是累计淘汰的数据个数。
+	evictions int64
+
+	// dumpErrors 是累计持久化失败的次数。
+	dumpErrors int64
+
+	// lastDumpFailed 表示最近一次持久化是否失败了。
+	lastDumpFailed bool
+}
+
+// newAlertSample 采集 cache 当前的资源使用情况。
+func newAlertSample(cache *caches.Cache) alertSample {
+	status := cache.Status()
+	return alertSample{
+		time:           time.Now(),
+		entrySize:      status.KeySize + status.ValueSize,
+		maxEntrySize:   int64(cache.Options().MaxEntrySize) << 20,
+		evictions:      caches.EvictionCount(),
+		dumpErrors:     caches.DumpErrorCount(),
+		lastDumpFailed: cache.DumpStatus().LastError != "",
+	}
+}
+
+// alerter 定期检查资源的使用情况，超过阈值或者恢复正常的时候发送告警。
+type alerter struct {
+	// node 是发出告警的节点地址。
+	node string
+
+	// client 是发送告警使用的 HTTP 客户端。
+	client *http.Client
+
+	// last 是上一次检查时的采集结果，用于计算两次检查之间的变化。
+	last alertSample
+
+	// firing 记录着正在触发中的告警。
+	firing map[string]bool
+}
+
+// newAlerter 返回一个告警器，first 是第一次采集的结果。
+func newAlerter(node string, first alertSample) *alerter {
+	return &alerter{
+		node:   node,
+		client: &http.Client{Timeout: alertTimeout},
+		last:   first,
+		firing: map[string]bool{},
+	}
+}
+
+// check 使用 options 中的阈值检查 sample，返回状态发生变化的告警，阈值为 0 的告警如果正在触发中，会被当作已经恢复。
+func (a *alerter) check(options *Options, sample alertSample) []Alert {
+	last := a.last
+	a.last = sample
+
+	var alerts []Alert
+	update := func(name string, breached bool, value float64, threshold int, message string) {
+		if breached == a.firing[name] {
+			return
+		}
+
+		a.firing[name] = breached
+		status := AlertFiring
+		if !breached {
+			status = AlertResolved
+		}
+		alerts = append(alerts, Alert{
+			Node:      a.node,
+			Name:      name,
+			Status:    status,
+			Value:     value,
+			Threshold: float64(threshold),
+			Message:   message,
+			Time:      sample.time,
+		})
+	}
+
+	memoryPercent := 0.0
+	if sample.maxEntrySize > 0 {
+		memoryPercent = 100 * float64(sample.entrySize) / float64(sample.maxEntrySize)
+	}
+	update(alertMemory, options.AlertMemoryPercent > 0 && memoryPercent >= float64(options.AlertMemoryPercent), memoryPercent, options.AlertMemoryPercent,
+		fmt.Sprintf("data size is %.1f%% of max entry size", memoryPercent))
+
+	evictionRate := 0.0
+	if seconds := sample.time.Sub(last.time).Seconds(); seconds > 0 {
+		evictionRate = float64(sample.evictions-last.evictions) / seconds
+	}
+	update(alertEvictionRate, options.AlertEvictionRate > 0 && evictionRate >= float64(options.AlertEvictionRate), evictionRate, options.AlertEvictionRate,
+		fmt.Sprintf("%.1f entries evicted per second", evictionRate))
+
+	// 持久化的间隔一般比检查的间隔长很多，所以触发之后要等到有一次持久化成功了才算恢复
+	dumpFailures := sample.dumpErrors - last.dumpErrors
+	dumpBreached := dumpFailures >= int64(options.AlertDumpFailures) || (a.firing[alertDumpFailures] && sample.lastDumpFailed)
+	update(alertDumpFailures, options.AlertDumpFailures > 0 && dumpBreached, float64(dumpFailures), options.AlertDumpFailures,
+		fmt.Sprintf("%d dumps failed since last check", dumpFailures))
+	return alerts
+}
+
+// send 把告警 POST 到 webhook，发送失败的话只会输出错误日志，不会重试。
+func (a *alerter) send(webhook string, alert Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+
+	response, err := a.client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		helpers.Error("Failed to send alert", "name", alert.Name, "status", alert.Status, "err", err)
+		return
+	}
+
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		helpers.Error("Failed to send alert", "name", alert.Name, "status", alert.Status, "code", response.StatusCode)
+	}
+}
+
+// watchAlerts 开启一个协程定期检查告警，没有配置告警地址的时候也会检查，这样热加载配置之后告警的状态才是准确的。
+func (n *node) watchAlerts() {
+	a := newAlerter(n.address, newAlertSample(n.cache))
+	go func() {
+		ticker := time.NewTicker(n.options.AlertInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-n.closed:
+				return
+			}
+
+			options := n.live.load()
+			for _, alert := range a.check(options, newAlertSample(n.cache)) {
+				if alert.Status == AlertFiring {
+					helpers.Warn("Alert firing", "name", alert.Name, "value", alert.Value, "threshold", alert.Threshold)
+				} else {
+					helpers.Info("Alert resolved", "name", alert.Name, "value", alert.Value, "threshold", alert.Threshold)
+				}
+
+				if options.AlertWebhook != "" {
+					a.send(options.AlertWebhook, alert)
+				}
+			}
+		}
+	}()
+}
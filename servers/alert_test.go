@@ -0,0 +1,71 @@
+package servers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestAlerterCheck$
+func TestAlerterCheck(t *testing.T) {
+	options := DefaultOptions()
+	now := time.Now()
+	a := newAlerter("127.0.0.1:5837", alertSample{time: now, maxEntrySize: 100})
+
+	names := func(alerts []Alert) []string {
+		result := make([]string, 0, len(alerts))
+		for _, alert := range alerts {
+			result = append(result, alert.Name+" "+alert.Status)
+		}
+		return result
+	}
+
+	check := func(sample alertSample, want ...string) {
+		t.Helper()
+		now = now.Add(time.Second)
+		sample.time = now
+		sample.maxEntrySize = 100
+		got := names(a.check(&options, sample))
+		if len(got) != len(want) {
+			t.Fatalf("alerts %v should be %v", got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("alerts %v should be %v", got, want)
+			}
+		}
+	}
+
+	check(alertSample{entrySize: 50})
+	check(alertSample{entrySize: 95, evictions: 2000, dumpErrors: 1, lastDumpFailed: true},
+		"memory firing", "eviction-rate firing", "dump-failures firing")
+
+	// 已经触发的告警不会重复发送，持久化一直失败的话告警也不会恢复
+	check(alertSample{entrySize: 96, evictions: 3500, dumpErrors: 1, lastDumpFailed: true})
+	check(alertSample{entrySize: 10, evictions: 3500, dumpErrors: 1}, "memory resolved", "eviction-rate resolved", "dump-failures resolved")
+
+	// 阈值为 0 表示不告警
+	options.AlertMemoryPercent = 0
+	check(alertSample{entrySize: 100, evictions: 3500, dumpErrors: 1})
+}
+
+// go test -v -run=^TestAlerterSend$
+func TestAlerterSend(t *testing.T) {
+	alerts := make(chan Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		alert := Alert{}
+		if err := json.NewDecoder(request.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		alerts <- alert
+	}))
+	defer server.Close()
+
+	a := newAlerter("127.0.0.1:5837", alertSample{})
+	a.send(server.URL, Alert{Node: "127.0.0.1:5837", Name: alertMemory, Status: AlertFiring, Value: 95, Threshold: 90})
+	if alert := <-alerts; alert.Name != alertMemory || alert.Status != AlertFiring || alert.Value != 95 {
+		t.Fatalf("alert %+v is wrong", alert)
+	}
+}
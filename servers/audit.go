@@ -0,0 +1,158 @@
+package servers
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+// auditRecord 是审计日志中的一条记录，会被序列化成一行 JSON。
+type auditRecord struct {
+	// Time 是写操作完成的时间。
+	Time time.Time `json:"time"`
+
+	// Client 是客户端的地址。
+	Client string `json:"client"`
+
+	// User 是 HTTP 接口的凭证对应的身份，没有开启认证或者是 TCP 服务器的话为空。
+	User string `json:"user,omitempty"`
+
+	// Operation 是写操作的类型，比如 set、delete、incr 和 expire。
+	Operation string `json:"operation"`
+
+	// Key 是被修改的 key。
+	Key string `json:"key"`
+}
+
+// auditor 负责把写操作追加到审计日志中，没有配置审计日志的话是 nil，nil 的 auditor 什么也不做。
+type auditor struct {
+	// file 是审计日志文件，超过大小之后会自动切分。
+	file io.WriteCloser
+
+	// lock 保证一条记录是完整地写入的。
+	lock *sync.Mutex
+}
+
+// newAuditor 使用选项配置创建审计日志，没有配置 AuditFile 的话返回 nil。
+func newAuditor(options *Options) (*auditor, error) {
+	if options.AuditFile == "" {
+		return nil, nil
+	}
+
+	file, err := helpers.NewRotatingFile(options.AuditFile, options.AuditMaxSize, options.AuditMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	return &auditor{file: file, lock: &sync.Mutex{}}, nil
+}
+
+// record 记录一次写操作，写入失败的话只会输出错误日志，不能因为审计日志写不进去就让写操作失败。
+func (a *auditor) record(client string, user string, operation string, key string) {
+	if a == nil {
+		return
+	}
+
+	data, err := json.Marshal(auditRecord{Time: time.Now(), Client: client, User: user, Operation: operation, Key: key})
+	if err != nil {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, err = a.file.Write(append(data, '\n')); err != nil {
+		helpers.Error("Failed to write audit log", "operation", operation, "key", key, "err", err)
+	}
+}
+
+// Close 关闭审计日志文件。
+func (a *auditor) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// auditedWrite 是命令中的一个写操作。
+type auditedWrite struct {
+	operation string
+	key       string
+}
+
+// auditedCommands 是需要记录审计日志的命令，以及从成功执行的命令中找出写操作的方法，body 是命令的响应。
+// 复制命令是节点之间同步数据使用的，所属节点上已经记录过了，所以不需要记录。
+var auditedCommands = map[byte]func(args [][]byte, body []byte) []auditedWrite{
+	setCommand: func(args [][]byte, body []byte) []auditedWrite {
+		return []auditedWrite{{operation: "set", key: string(args[1])}}
+	},
+	deleteCommand: func(args [][]byte, body []byte) []auditedWrite {
+		return []auditedWrite{{operation: "delete", key: string(args[0])}}
+	},
+	incrCommand: func(args [][]byte, body []byte) []auditedWrite {
+		return []auditedWrite{{operation: "incr", key: string(args[0])}}
+	},
+	expireCommand: func(args [][]byte, body []byte) []auditedWrite {
+		return []auditedWrite{{operation: "expire", key: string(args[0])}}
+	},
+	msetCommand: msetWrites,
+	execCommand: execWrites,
+	// 分块上传的数据是在最后一块收到之后才添加的，由 chunk 命令自己记录，只有空的数据是在 chunkset 命令中直接添加的
+	chunkSetCommand: func(args [][]byte, body []byte) []auditedWrite {
+		transfer := chunkedTransfer{}
+		if err := json.Unmarshal(body, &transfer); err != nil || transfer.ID != 0 {
+			return nil
+		}
+		return []auditedWrite{{operation: "set", key: string(args[1])}}
+	},
+}
+
+// msetWrites 返回批量添加命令中成功添加的 key，不属于当前节点或者添加失败的 key 不会被记录。
+func msetWrites(args [][]byte, body []byte) []auditedWrite {
+	var results []batchResult
+	if err := json.Unmarshal(body, &results); err != nil {
+		return nil
+	}
+
+	writes := make([]auditedWrite, 0, len(results))
+	for i, result := range results {
+		index := 1 + 2*i
+		if result.Node == "" && result.Error == "" && index < len(args) {
+			writes = append(writes, auditedWrite{operation: "set", key: string(args[index])})
+		}
+	}
+	return writes
+}
+
+// execWrites 返回事务中的添加和删除操作，命令成功执行说明事务中所有的操作都生效了。
+func execWrites(args [][]byte, body []byte) []auditedWrite {
+	ops, err := decodeOperations(args)
+	if err != nil {
+		return nil
+	}
+
+	writes := make([]auditedWrite, 0, len(ops))
+	for _, op := range ops {
+		switch op.Type {
+		case caches.OpSet:
+			writes = append(writes, auditedWrite{operation: "set", key: op.Key})
+		case caches.OpDelete:
+			writes = append(writes, auditedWrite{operation: "delete", key: op.Key})
+		}
+	}
+	return writes
+}
+
+// auditCommand 记录成功执行的写命令，client 是发起命令的客户端地址。
+func (a *auditor) auditCommand(client string, command byte, args [][]byte, body []byte) {
+	writes, ok := auditedCommands[command]
+	if a == nil || !ok {
+		return
+	}
+
+	for _, write := range writes(args, body) {
+		a.record(client, "", write.operation, write.key)
+	}
+}
@@ -0,0 +1,77 @@
+package servers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+)
+
+// go test -v -run=^TestAuditor$
+func TestAuditor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafo-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.AuditFile = filepath.Join(dir, "audit.log")
+	a, err := newAuditor(&options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.auditCommand("127.0.0.1:50000", setCommand, [][]byte{make([]byte, 8), []byte("key1"), []byte("value1")}, nil)
+	a.auditCommand("127.0.0.1:50000", getCommand, [][]byte{[]byte("key1")}, []byte("value1"))
+
+	// 批量添加的时候只会记录成功添加到当前节点的 key
+	args := encodeEntries([]string{"key2", "key3"}, map[string][]byte{"key2": []byte("value2"), "key3": []byte("value3")}, 0)
+	body, _ := json.Marshal([]batchResult{{}, {Node: "127.0.0.1:5838"}})
+	a.auditCommand("127.0.0.1:50000", msetCommand, args, body)
+
+	ops := []caches.Operation{{Type: caches.OpGet, Key: "key4"}, {Type: caches.OpDelete, Key: "key4"}}
+	a.auditCommand("127.0.0.1:50000", execCommand, encodeOperations(ops), nil)
+	a.record("127.0.0.1:50001", "app", "delete", "key5")
+	a.Close()
+
+	file, err := os.Open(options.AuditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := auditRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	want := []auditRecord{
+		{Client: "127.0.0.1:50000", Operation: "set", Key: "key1"},
+		{Client: "127.0.0.1:50000", Operation: "set", Key: "key2"},
+		{Client: "127.0.0.1:50000", Operation: "delete", Key: "key4"},
+		{Client: "127.0.0.1:50001", User: "app", Operation: "delete", Key: "key5"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("records %+v should be %+v", records, want)
+	}
+	for i, record := range records {
+		record.Time = want[i].Time
+		if record != want[i] {
+			t.Fatalf("record %+v should be %+v", record, want[i])
+		}
+	}
+
+	// 没有配置审计日志的话什么也不做
+	var disabled *auditor
+	disabled.record("127.0.0.1:50000", "", "set", "key1")
+}
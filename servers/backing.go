@@ -0,0 +1,180 @@
+package servers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+)
+
+const (
+	// commandNotFoundStatus 是外部命令表示数据不存在的退出码，和 grep 找不到内容时一样。
+	commandNotFoundStatus = 1
+)
+
+// newBackingStore 按照 BackingStore 创建后端存储并设置到缓存中，http 或者 https 的地址使用 HTTP 回调，否则使用外部命令。
+// 没有配置 BackingStore 的话不会修改缓存，开启了 WriteThrough 才会把写操作同步到后端存储中。
+func newBackingStore(cache *caches.Cache, options *Options) error {
+	if options.BackingStore == "" {
+		return nil
+	}
+
+	var store interface {
+		caches.Loader
+		caches.Writer
+	}
+
+	if strings.HasPrefix(options.BackingStore, "http://") || strings.HasPrefix(options.BackingStore, "https://") {
+		store = &httpBackingStore{url: strings.TrimSuffix(options.BackingStore, "/"), ttl: int64(options.BackingStoreTTL / time.Second), client: &http.Client{}}
+	} else {
+		path, err := exec.LookPath(options.BackingStore)
+		if err != nil {
+			return err
+		}
+		store = &commandBackingStore{path: path, ttl: int64(options.BackingStoreTTL / time.Second)}
+	}
+
+	cache.SetLoader(store)
+	if options.WriteThrough {
+		cache.SetWriter(store)
+	}
+	return nil
+}
+
+// httpBackingStore 是通过 HTTP 回调访问的后端存储，接口和缓存的 HTTP 接口一样：
+// GET、PUT 和 DELETE {url}/{key} 分别是加载、写入和删除数据，数据的 ttl 放在 Ttl 头部中，加载的时候返回 404 表示数据不存在。
+type httpBackingStore struct {
+	// url 是后端存储的地址，末尾没有 "/"。
+	url string
+
+	// ttl 是加载的数据没有 Ttl 头部时使用的 ttl。
+	ttl int64
+
+	client *http.Client
+}
+
+// Load 加载 key 对应的数据。
+func (hbs *httpBackingStore) Load(ctx context.Context, key string) (value []byte, ttl int64, ok bool, err error) {
+	response, err := hbs.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, 0, false, nil
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, 0, false, fmt.Errorf("backing store responded %s", response.Status)
+	}
+
+	ttl = hbs.ttl
+	if header := response.Header.Get("Ttl"); header != "" {
+		if ttl, err = strconv.ParseInt(header, 10, 64); err != nil {
+			return nil, 0, false, fmt.Errorf("invalid ttl %q from backing store", header)
+		}
+	}
+
+	value, err = ioutil.ReadAll(response.Body)
+	return value, ttl, err == nil, err
+}
+
+// Write 把 key 对应的数据写入后端存储。
+func (hbs *httpBackingStore) Write(ctx context.Context, key string, value []byte, ttl int64) error {
+	response, err := hbs.do(ctx, http.MethodPut, key, value, http.Header{"Ttl": {strconv.FormatInt(ttl, 10)}})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("backing store responded %s", response.Status)
+	}
+	return nil
+}
+
+// Delete 从后端存储中删除 key 对应的数据。
+func (hbs *httpBackingStore) Delete(ctx context.Context, key string) error {
+	response, err := hbs.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 && response.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("backing store responded %s", response.Status)
+	}
+	return nil
+}
+
+// do 向后端存储发送 key 对应的请求。
+func (hbs *httpBackingStore) do(ctx context.Context, method string, key string, body []byte, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequest(method, hbs.url+"/"+url.PathEscape(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	return hbs.client.Do(request.WithContext(ctx))
+}
+
+// commandBackingStore 是通过外部命令访问的后端存储，每个操作都会执行一次命令：
+// "command get {key}" 从标准输出中返回数据，退出码为 1 表示数据不存在；
+// "command set {key} {ttl}" 从标准输入中读取数据；"command delete {key}" 删除数据，数据不存在的时候也应该正常退出。
+type commandBackingStore struct {
+	// path 是命令的路径。
+	path string
+
+	// ttl 是加载的数据使用的 ttl。
+	ttl int64
+}
+
+// Load 加载 key 对应的数据。
+func (cbs *commandBackingStore) Load(ctx context.Context, key string) (value []byte, ttl int64, ok bool, err error) {
+	value, err = cbs.run(ctx, nil, "get", key)
+	if exitErr, isExitErr := err.(*exec.ExitError); isExitErr && exitErr.ExitCode() == commandNotFoundStatus {
+		return nil, 0, false, nil
+	}
+
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return value, cbs.ttl, true, nil
+}
+
+// Write 把 key 对应的数据写入后端存储。
+func (cbs *commandBackingStore) Write(ctx context.Context, key string, value []byte, ttl int64) error {
+	_, err := cbs.run(ctx, value, "set", key, strconv.FormatInt(ttl, 10))
+	return err
+}
+
+// Delete 从后端存储中删除 key 对应的数据。
+func (cbs *commandBackingStore) Delete(ctx context.Context, key string) error {
+	_, err := cbs.run(ctx, nil, "delete", key)
+	return err
+}
+
+// run 使用 args 执行命令，stdin 会作为命令的标准输入，返回命令的标准输出。
+// 命令失败并且在标准错误中输出了内容的话，返回的错误就是标准错误中的内容，方便在日志中排查。
+func (cbs *commandBackingStore) run(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, cbs.path, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 && exitErr.ExitCode() != commandNotFoundStatus {
+		return nil, errors.New(strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return output, err
+}
@@ -0,0 +1,67 @@
+package servers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// go test -v -run=^TestHTTPBackingStore$
+func TestHTTPBackingStore(t *testing.T) {
+	data := map[string][]byte{"a key": []byte("value")}
+	lock := &sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		key, _ := url.PathUnescape(request.URL.EscapedPath()[len("/cache/"):])
+		switch request.Method {
+		case http.MethodGet:
+			value, ok := data[key]
+			if !ok {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writer.Header().Set("Ttl", "60")
+			writer.Write(value)
+		case http.MethodPut:
+			if request.Header.Get("Ttl") != "10" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data[key], _ = ioutil.ReadAll(request.Body)
+			writer.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			delete(data, key)
+			writer.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	store := &httpBackingStore{url: server.URL + "/cache", client: server.Client()}
+	ctx := context.Background()
+	value, ttl, ok, err := store.Load(ctx, "a key")
+	if err != nil || !ok || string(value) != "value" || ttl != 60 {
+		t.Fatalf("value %s, ttl %d, ok %+v or err %+v is wrong", value, ttl, ok, err)
+	}
+
+	if _, _, ok, err = store.Load(ctx, "missing"); ok || err != nil {
+		t.Fatalf("ok %+v or err %+v is wrong", ok, err)
+	}
+
+	if err = store.Write(ctx, "new/key", []byte("new"), 10); err != nil || string(data["new/key"]) != "new" {
+		t.Fatalf("data %s or err %+v is wrong", data["new/key"], err)
+	}
+
+	if err = store.Write(ctx, "new/key", []byte("new"), 20); err == nil {
+		t.Fatal("err should not be nil")
+	}
+
+	if err = store.Delete(ctx, "new/key"); err != nil || data["new/key"] != nil {
+		t.Fatalf("data %s or err %+v is wrong", data["new/key"], err)
+	}
+}
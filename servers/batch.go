@@ -0,0 +1,78 @@
+package servers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	errInvalidBatch = errors.New("invalid batch arguments")
+)
+
+// batchResult 是批量命令中每个 key 的执行结果。
+type batchResult struct {
+	// Value 是 key 对应的数据，只有 mget 命令才会返回。
+	Value []byte `json:"value,omitempty"`
+
+	// Found 表示 key 对应的数据是否存在，只有 mget 命令才会返回。
+	Found bool `json:"found,omitempty"`
+
+	// Node 不为空说明这个 key 不属于当前节点，需要到 Node 节点上执行。
+	Node string `json:"node,omitempty"`
+
+	// Error 是执行这个 key 时发生的错误。
+	Error string `json:"error,omitempty"`
+}
+
+// BatchError 是批量操作中部分 key 执行失败的错误，Errors 记录着每个失败的 key 对应的错误。
+type BatchError struct {
+	// Errors 记录着每个失败的 key 对应的错误。
+	Errors map[string]error
+}
+
+// Error 返回错误信息，会列出所有失败的 key。
+func (be *BatchError) Error() string {
+	keys := make([]string, 0, len(be.Errors))
+	for key := range be.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	messages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, fmt.Sprintf("%s: %v", key, be.Errors[key]))
+	}
+	return fmt.Sprintf("%d keys failed in batch (%s)", len(keys), strings.Join(messages, "; "))
+}
+
+// encodeEntries 将批量添加的数据编码成 mset 命令的参数，第一个参数是 ttl，后面是 key 和 value 交替排列。
+func encodeEntries(keys []string, entries map[string][]byte, ttl int64) [][]byte {
+	ttlBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
+
+	args := make([][]byte, 0, len(keys)*2+1)
+	args = append(args, ttlBytes)
+	for _, key := range keys {
+		args = append(args, []byte(key), entries[key])
+	}
+	return args
+}
+
+// decodeEntries 从 mset 命令的参数中解析出 ttl 以及 key 和 value。
+func decodeEntries(args [][]byte) (ttl int64, keys []string, values [][]byte, err error) {
+	if len(args) < 1 || len(args[0]) != 8 || len(args)%2 != 1 {
+		return 0, nil, nil, errInvalidBatch
+	}
+
+	ttl = int64(binary.BigEndian.Uint64(args[0]))
+	keys = make([]string, 0, len(args)/2)
+	values = make([][]byte, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		keys = append(keys, string(args[i]))
+		values = append(values, args[i+1])
+	}
+	return ttl, keys, values, nil
+}
@@ -0,0 +1,76 @@
+package servers
+
+import (
+	"sync"
+
+	"github.com/herrhu97/go-distributed-cache/metrics"
+)
+
+const (
+	// initialBufferSize 是新建的缓冲区的初始大小，大部分请求帧和响应帧都比这个小。
+	initialBufferSize = 4 << 10 // 4 KB
+
+	// maxPooledBufferSize 是可以放回池中的缓冲区的最大大小，
+	// 偶尔出现的大请求使用的缓冲区不会放回池中，否则池中的缓冲区会一直占用大量的内存。
+	maxPooledBufferSize = 64 << 10 // 64 KB
+)
+
+var (
+	// bufferPool 是读取请求和写入响应使用的缓冲区池。
+	bufferPool = &sync.Pool{}
+
+	// bufferPoolHits 和 bufferPoolMisses 是从池中获取缓冲区的时候，复用了缓冲区和新建了缓冲区的次数。
+	bufferPoolHits = metrics.Default.Counter("kafo_server_buffer_pool_gets_total", "The number of buffers got from the pool.", "result", "hit")
+
+	bufferPoolMisses = metrics.Default.Counter("kafo_server_buffer_pool_gets_total", "The number of buffers got from the pool.", "result", "miss")
+)
+
+// buffer 是可以放回池中的缓冲区，一个请求中的所有参数都是从同一个缓冲区中分配的。
+// 放回池中之后缓冲区会被别的请求复用，所以分配出去的字节切片在 release 之后就不能再访问了。
+// nil 的 buffer 每次分配都会新建字节切片，不会被复用。
+type buffer struct {
+	// data 是缓冲区的内存，长度是已经分配出去的字节数。
+	data []byte
+}
+
+// getBuffer 从池中获取一个空的缓冲区，池中没有的话就新建一个。
+func getBuffer() *buffer {
+	if b, ok := bufferPool.Get().(*buffer); ok {
+		bufferPoolHits.Inc()
+		return b
+	}
+
+	bufferPoolMisses.Inc()
+	return &buffer{data: make([]byte, 0, initialBufferSize)}
+}
+
+// alloc 从缓冲区中分配 size 个字节。
+// 剩余的空间不够的话会换成一块更大的内存，已经分配出去的字节切片还是引用着原来的内存，所以不会受到影响。
+func (b *buffer) alloc(size int) []byte {
+	if b == nil || size > maxPooledBufferSize {
+		return make([]byte, size)
+	}
+
+	used := len(b.data)
+	if used+size > cap(b.data) {
+		capacity := 2 * cap(b.data)
+		if capacity < size {
+			capacity = size
+		}
+		b.data = make([]byte, 0, capacity)
+		used = 0
+	}
+
+	b.data = b.data[:used+size]
+	return b.data[used : used+size : used+size]
+}
+
+// release 把缓冲区放回池中，太大的缓冲区会被直接丢弃。
+func (b *buffer) release() {
+	if b == nil || cap(b.data) > maxPooledBufferSize {
+		return
+	}
+
+	b.data = b.data[:0]
+	bufferPool.Put(b)
+}
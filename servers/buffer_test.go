@@ -0,0 +1,61 @@
+package servers
+
+import (
+	"bytes"
+	"testing"
+)
+
+// go test -v -run=^TestBuffer$
+func TestBuffer(t *testing.T) {
+	buf := &buffer{data: make([]byte, 0, 8)}
+	first := buf.alloc(6)
+	copy(first, "kafo-1")
+
+	// 剩余的空间不够的时候会换成更大的内存，已经分配出去的字节切片不受影响
+	second := buf.alloc(6)
+	copy(second, "kafo-2")
+	if string(first) != "kafo-1" || string(second) != "kafo-2" || cap(buf.data) != 16 {
+		t.Fatalf("first %s, second %s or cap %d is wrong", first, second, cap(buf.data))
+	}
+
+	// 分配出去的字节切片不能通过 append 覆盖后面的内存
+	third := buf.alloc(2)
+	_ = append(second, "!!"...)
+	if len(third) != 2 || third[0] != 0 || third[1] != 0 {
+		t.Fatalf("third %v is wrong", third)
+	}
+
+	// 太大的字节切片不会从缓冲区中分配
+	if large := buf.alloc(maxPooledBufferSize + 1); len(large) != maxPooledBufferSize+1 || len(buf.data) != 8 {
+		t.Fatalf("len(buf.data) %d is wrong", len(buf.data))
+	}
+
+	var nilBuffer *buffer
+	if arg := nilBuffer.alloc(3); len(arg) != 3 {
+		t.Fatalf("len(arg) %d should be 3", len(arg))
+	}
+	nilBuffer.release()
+}
+
+// go test -v -run=^TestReadRequestFromWithBuffer$
+func TestReadRequestFromWithBuffer(t *testing.T) {
+	request := bytes.NewBuffer(nil)
+	writeRequestTo(request, setCommand, [][]byte{[]byte("key"), []byte("value")}, false)
+
+	buf := getBuffer()
+	defer buf.release()
+
+	_, args, err := readRequestFrom(request, frameLimits{}, false, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(args[0]) != "key" || string(args[1]) != "value" {
+		t.Fatalf("args %q are wrong", args)
+	}
+
+	// 请求帧中的头部、参数长度和参数都是从缓冲区中分配的
+	if len(buf.data) != headerLength+argLengthSize+len("key")+len("value") {
+		t.Fatalf("len(buf.data) %d is wrong", len(buf.data))
+	}
+}
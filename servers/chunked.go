@@ -0,0 +1,336 @@
+package servers
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// transferIdleTimeout 是分块传输的最长空闲时间，超过这个时间没有收到下一块的传输会被丢弃，
+	// 否则中途断开的客户端留下的传输会一直占用内存。
+	transferIdleTimeout = time.Minute
+
+	// maxTransfers 是一个节点上同时进行的分块传输的最大个数。
+	maxTransfers = 128
+
+	// defaultChunkSize 是分块传输时每一块的默认大小。
+	defaultChunkSize = 1 << 20 // 1 MB
+)
+
+var (
+	errInvalidChunkArgs = errors.New("invalid chunk arguments")
+
+	errTransferNotFound = errors.New("chunked transfer not found or expired")
+
+	errTooManyTransfers = errors.New("too many chunked transfers")
+
+	errChunkedValueTooLarge = errors.New("chunked value size exceeds the limit")
+
+	errTooManyChunkedBytes = errors.New("chunked bytes in flight exceed the limit")
+)
+
+// chunkedTransfer 是 chunkset 和 chunkget 命令的响应，后续的 chunk 命令需要使用其中的 ID 发送到 Node 节点上。
+type chunkedTransfer struct {
+	// ID 是传输的编号，为 0 表示数据是空的，不需要再传输了。
+	ID uint64 `json:"id"`
+
+	// Size 是整个数据的大小，单位是字节。
+	Size int64 `json:"size"`
+
+	// Node 是进行传输的节点地址，也就是 key 所属的节点。
+	Node string `json:"node"`
+}
+
+// transfer 是一个正在进行的分块传输。
+type transfer struct {
+	// key 和 ttl 是上传的数据的 key 和 ttl，下载的时候只用于日志。
+	key string
+
+	ttl int64
+
+	// upload 表示这是上传还是下载。
+	upload bool
+
+	// data 是上传已经收到的数据，会随着收到的块增长，容量不会超过 size，下载的时候是读取到的整个数据。
+	// 缓存中的数据写入之后就不会被修改了，所以下载的过程中数据被覆盖了也不会影响正在进行的传输。
+	data []byte
+
+	// size 是整个数据的大小。
+	size int
+
+	// chunkSize 是下载时每一块的大小。
+	chunkSize int
+
+	// lastActive 是最后一次传输的时间。
+	lastActive time.Time
+}
+
+// transferRegistry 记录着节点上所有正在进行的分块传输。
+// 传输不属于某个连接，因为客户端的每一块可能是通过连接池中不同的连接发送的。
+type transferRegistry struct {
+	// nextID 是下一个传输的编号。
+	nextID uint64
+
+	// transfers 存储着所有正在进行的传输，key 是传输的编号。
+	transfers map[uint64]*transfer
+
+	// inFlight 是所有正在进行的上传已经分配的字节数，也就是 data 的容量，下载的数据本来就在缓存中，所以不算在里面。
+	inFlight int
+
+	// lock 保护 nextID、transfers 和 inFlight。
+	lock *sync.Mutex
+}
+
+// newTransferRegistry 返回一个空的传输记录。
+func newTransferRegistry() *transferRegistry {
+	return &transferRegistry{
+		transfers: map[uint64]*transfer{},
+		lock:      &sync.Mutex{},
+	}
+}
+
+// start 记录一个新的传输并返回它的编号，会先清理掉已经过期的传输。
+func (tr *transferRegistry) start(t *transfer) (uint64, error) {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+
+	now := time.Now()
+	tr.expire(now)
+	if len(tr.transfers) >= maxTransfers {
+		return 0, errTooManyTransfers
+	}
+
+	tr.nextID++
+	t.lastActive = now
+	tr.transfers[tr.nextID] = t
+	return tr.nextID, nil
+}
+
+// expire 删除已经过期的传输，调用者需要持有锁。
+func (tr *transferRegistry) expire(now time.Time) {
+	for id, t := range tr.transfers {
+		if now.Sub(t.lastActive) > transferIdleTimeout {
+			tr.remove(id, t)
+		}
+	}
+}
+
+// remove 删除一个传输，并释放它占用的字节数，调用者需要持有锁。
+func (tr *transferRegistry) remove(id uint64, t *transfer) {
+	if t.upload {
+		tr.inFlight -= cap(t.data)
+	}
+	delete(tr.transfers, id)
+}
+
+// upload 把 offset 开始的 chunk 追加到上传的数据中，全部收到之后会删除这个传输并返回它，否则返回 nil。
+// 客户端重试的时候可能会重复发送已经收到的块，这种块会被直接忽略，所以 chunk 命令是幂等的。
+// 上传的空间是随着收到的块成倍增长的，但是不会超过整个数据的大小，这样最后收到的数据刚好占满空间，可以直接交给缓存。
+// 所有上传已经分配的字节数加起来不能超过 maxInFlight，为 0 表示不限制。
+func (tr *transferRegistry) upload(id uint64, offset int, chunk []byte, maxInFlight int) (*transfer, error) {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+
+	tr.expire(time.Now())
+	t, ok := tr.transfers[id]
+	if !ok || !t.upload {
+		return nil, errTransferNotFound
+	}
+
+	received := len(t.data)
+	if offset+len(chunk) <= received {
+		return nil, nil
+	}
+
+	if offset != received || received+len(chunk) > t.size {
+		return nil, errInvalidChunkArgs
+	}
+
+	if needed := received + len(chunk); needed > cap(t.data) {
+		capacity := 2 * cap(t.data)
+		if capacity < needed {
+			capacity = needed
+		}
+
+		if capacity > t.size {
+			capacity = t.size
+		}
+
+		grown := capacity - cap(t.data)
+		if maxInFlight > 0 && tr.inFlight+grown > maxInFlight {
+			return nil, errTooManyChunkedBytes
+		}
+
+		data := make([]byte, received, capacity)
+		copy(data, t.data)
+		t.data = data
+		tr.inFlight += grown
+	}
+
+	t.data = append(t.data, chunk...)
+	t.lastActive = time.Now()
+	if len(t.data) < t.size {
+		return nil, nil
+	}
+
+	tr.remove(id, t)
+	return t, nil
+}
+
+// download 返回下载的数据中 offset 开始的一块，读到末尾之后会删除这个传输。
+// 下载的数据是不会被修改的，所以返回的块在删除传输之后也可以安全地使用。
+func (tr *transferRegistry) download(id uint64, offset int) ([]byte, error) {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+
+	t, ok := tr.transfers[id]
+	if !ok || t.upload || time.Since(t.lastActive) > transferIdleTimeout {
+		return nil, errTransferNotFound
+	}
+
+	if offset < 0 || offset >= t.size {
+		return nil, errInvalidChunkArgs
+	}
+
+	end := offset + t.chunkSize
+	if end >= t.size {
+		end = t.size
+		tr.remove(id, t)
+	}
+
+	t.lastActive = time.Now()
+	return t.data[offset:end], nil
+}
+
+// chunkSetHandler 是开始分块上传的处理器，参数依次是 ttl、key 和整个数据的大小，数据的大小不能超过 MaxChunkedSize。
+// 上传的空间不会按照客户端声明的大小提前分配，而是随着收到的块增长的，这样客户端声明一个很大的数据也不会占用内存。
+func (ts *TCPServer) chunkSetHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 3 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	if len(args[0]) != 8 || len(args[2]) != 8 {
+		return nil, errInvalidChunkArgs
+	}
+
+	key := string(args[1])
+	if err = ts.checkKeyNode(key); err != nil {
+		return nil, err
+	}
+
+	ttl := int64(binary.BigEndian.Uint64(args[0]))
+	size := int64(binary.BigEndian.Uint64(args[2]))
+	if maxSize := ts.live.load().MaxChunkedSize; size < 0 || (maxSize > 0 && size > int64(maxSize)) {
+		return nil, errChunkedValueTooLarge
+	}
+
+	// 空的数据不需要再传输了，直接添加就可以了
+	if size == 0 {
+		if err = ts.commitUpload(&transfer{key: key, ttl: ttl}); err != nil {
+			return nil, err
+		}
+		return json.Marshal(chunkedTransfer{Node: ts.address})
+	}
+
+	id, err := ts.transfers.start(&transfer{key: key, ttl: ttl, upload: true, size: int(size)})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(chunkedTransfer{ID: id, Size: size, Node: ts.address})
+}
+
+// chunkGetHandler 是开始分块下载的处理器，参数依次是 key 和每一块的大小。
+func (ts *TCPServer) chunkGetHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	if len(args[1]) != 8 {
+		return nil, errInvalidChunkArgs
+	}
+
+	key := string(args[0])
+	if err = ts.checkKeyNode(key); err != nil {
+		return nil, err
+	}
+
+	chunkSize := int(binary.BigEndian.Uint64(args[1]))
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
+
+	value, ok, err := ts.cache.GetContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errNotFound
+	}
+
+	if len(value) == 0 {
+		return json.Marshal(chunkedTransfer{Node: ts.address})
+	}
+
+	if chunkSize > len(value) {
+		chunkSize = len(value)
+	}
+
+	id, err := ts.transfers.start(&transfer{key: key, data: value, size: len(value), chunkSize: chunkSize})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(chunkedTransfer{ID: id, Size: int64(len(value)), Node: ts.address})
+}
+
+// chunkHandler 是传输一块数据的处理器，参数依次是传输的编号、这一块的偏移量和上传的数据，下载的时候没有数据，响应体就是下载的一块数据。
+// 上传的最后一块收到之后才会真正添加数据，需要记录客户端的地址用于审计日志，所以这是一个会话处理器。
+func (ts *TCPServer) chunkHandler(s *session, args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	if len(args[0]) != 8 || len(args[1]) != 8 {
+		return nil, errInvalidChunkArgs
+	}
+
+	id := binary.BigEndian.Uint64(args[0])
+	offset := int(binary.BigEndian.Uint64(args[1]))
+	if offset < 0 {
+		return nil, errInvalidChunkArgs
+	}
+
+	if len(args) < 3 {
+		return ts.transfers.download(id, offset)
+	}
+
+	t, err := ts.transfers.upload(id, offset, args[2], ts.live.load().MaxChunkedInFlight)
+	if err != nil || t == nil {
+		return nil, err
+	}
+
+	if err = ts.commitUpload(t); err != nil {
+		return nil, err
+	}
+	ts.auditor.record(s.conn.RemoteAddr().String(), "", "set", t.key)
+	return nil, nil
+}
+
+// commitUpload 把上传完的数据添加到缓存中，并复制到副本节点上。
+// 上传的数据是专门为这个 key 分配的，传输删除之后就不会再修改了，所以直接交给缓存，不需要再复制一份。
+func (ts *TCPServer) commitUpload(t *transfer) error {
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
+
+	if err := ts.cache.SetOwnedWithTTLContext(ctx, t.key, t.data, t.ttl); err != nil {
+		return err
+	}
+	ts.replicate(setCommand, t.key, t.data, t.ttl)
+	return nil
+}
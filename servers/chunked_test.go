@@ -0,0 +1,110 @@
+package servers
+
+import (
+	"testing"
+)
+
+// go test -v -run=^TestTransferRegistry$
+func TestTransferRegistry(t *testing.T) {
+	registry := newTransferRegistry()
+	id, err := registry.start(&transfer{key: "key", upload: true, size: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if done, err := registry.upload(id, 0, []byte("kaf"), 0); err != nil || done != nil {
+		t.Fatalf("done %+v or err %+v is wrong", done, err)
+	}
+
+	// 重复发送的块会被忽略，跳过的块会返回错误
+	if done, err := registry.upload(id, 0, []byte("kaf"), 0); err != nil || done != nil {
+		t.Fatalf("done %+v or err %+v is wrong", done, err)
+	}
+
+	if _, err := registry.upload(id, 4, []byte("o"), 0); err != errInvalidChunkArgs {
+		t.Fatalf("err %+v should be errInvalidChunkArgs", err)
+	}
+
+	done, err := registry.upload(id, 3, []byte("o!!"), 0)
+	if err != nil || done == nil || string(done.data) != "kafo!!" {
+		t.Fatalf("done %+v or err %+v is wrong", done, err)
+	}
+
+	if _, err := registry.upload(id, 3, []byte("o!!"), 0); err != errTransferNotFound {
+		t.Fatalf("err %+v should be errTransferNotFound", err)
+	}
+
+	id, err = registry.start(&transfer{key: "key", data: []byte("kafo!!"), size: 6, chunkSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := registry.upload(id, 0, []byte("kafo"), 0); err != errTransferNotFound {
+		t.Fatalf("err %+v should be errTransferNotFound", err)
+	}
+
+	var data []byte
+	for offset := 0; offset < 6; {
+		chunk, err := registry.download(id, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, chunk...)
+		offset += len(chunk)
+	}
+
+	if string(data) != "kafo!!" {
+		t.Fatalf("data %s should be kafo!!", data)
+	}
+
+	if _, err := registry.download(id, 0); err != errTransferNotFound {
+		t.Fatalf("err %+v should be errTransferNotFound", err)
+	}
+}
+
+// go test -v -run=^TestTransferRegistryInFlight$
+func TestTransferRegistryInFlight(t *testing.T) {
+	registry := newTransferRegistry()
+
+	// 声明的大小不会提前分配内存，只有收到的块才会计入正在传输的字节数
+	first, err := registry.start(&transfer{key: "key1", upload: true, size: 1 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := registry.start(&transfer{key: "key2", upload: true, size: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = registry.upload(first, 0, []byte("kafo"), 6); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = registry.upload(second, 0, []byte("kafo"), 6); err != errTooManyChunkedBytes {
+		t.Fatalf("err %+v should be errTooManyChunkedBytes", err)
+	}
+
+	if registry.inFlight != 4 {
+		t.Fatalf("inFlight %d should be 4", registry.inFlight)
+	}
+
+	// 完成的上传会释放占用的字节数
+	if _, err = registry.upload(second, 0, []byte("ka"), 6); err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := registry.upload(second, 2, []byte("fo"), 8)
+	if err != nil || done == nil || string(done.data) != "kafo" {
+		t.Fatalf("done %+v or err %+v is wrong", done, err)
+	}
+
+	// 空间不会超过声明的大小，完成的数据可以直接交给缓存，不会浪费内存
+	if cap(done.data) != 4 {
+		t.Fatalf("cap(done.data) %d should be 4", cap(done.data))
+	}
+
+	if registry.inFlight != 4 {
+		t.Fatalf("inFlight %d should be 4", registry.inFlight)
+	}
+}
@@ -0,0 +1,60 @@
+package servers
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// BalancePolicy 是不针对某个 key 的操作选择节点的策略，比如获取集群的节点信息和遍历 key。
+// 这些操作可以在任意节点上执行，所以没必要总是从第一个节点开始尝试，否则压力都会集中在这个节点上。
+type BalancePolicy int
+
+const (
+	// BalanceRoundRobin 表示依次轮流从每个节点开始尝试，这是默认的策略。
+	BalanceRoundRobin BalancePolicy = iota
+
+	// BalanceLeastBusy 表示优先尝试客户端正在使用的连接最少的节点，连接数一样的节点会轮流尝试。
+	BalanceLeastBusy
+)
+
+// String 返回策略的名字。
+func (bp BalancePolicy) String() string {
+	switch bp {
+	case BalanceRoundRobin:
+		return "round-robin"
+	case BalanceLeastBusy:
+		return "least-busy"
+	default:
+		return "unknown"
+	}
+}
+
+// balancedNodes 按照选择节点的策略返回集群中的所有节点，调用方需要按照顺序尝试，直到有一个节点执行成功。
+func (tc *TCPClient) balancedNodes() []string {
+	nodes := tc.circle.Members()
+	if len(nodes) <= 1 {
+		return nodes
+	}
+
+	// 一致性哈希环返回的节点是无序的，需要先排好序才能轮转，这样两种策略下压力都不会总是集中在同一个节点上
+	sort.Strings(nodes)
+	start := int(atomic.AddUint64(&tc.balanceCursor, 1) % uint64(len(nodes)))
+	nodes = append(append(make([]string, 0, len(nodes)), nodes[start:]...), nodes[:start]...)
+
+	if tc.options.BalancePolicy == BalanceLeastBusy {
+		busy := make(map[string]int, len(nodes))
+		tc.lock.RLock()
+		for _, node := range nodes {
+			if pool, ok := tc.pools[node]; ok {
+				stats := pool.stats()
+				busy[node] = stats.Open - stats.Idle
+			}
+		}
+		tc.lock.RUnlock()
+
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return busy[nodes[i]] < busy[nodes[j]]
+		})
+	}
+	return nodes
+}
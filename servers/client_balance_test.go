@@ -0,0 +1,64 @@
+package servers
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"stathat.com/c/consistent"
+)
+
+// newBalanceClient 返回一个只有一致性哈希环和连接池的客户端，用于测试选择节点的策略。
+func newBalanceClient(policy BalancePolicy, nodes ...string) *TCPClient {
+	options := DefaultClientOptions()
+	options.BalancePolicy = policy
+	tc := &TCPClient{
+		pools:   map[string]*clientPool{},
+		options: &options,
+		lock:    &sync.RWMutex{},
+		circle:  consistent.New(),
+	}
+	tc.circle.Set(nodes)
+	return tc
+}
+
+// go test -v -run=^TestBalancedNodesRoundRobin$
+func TestBalancedNodesRoundRobin(t *testing.T) {
+	tc := newBalanceClient(BalanceRoundRobin, "a", "b", "c")
+
+	first := map[string]int{}
+	for i := 0; i < 30; i++ {
+		nodes := tc.balancedNodes()
+		if len(nodes) != 3 {
+			t.Fatalf("nodes %v should have 3 nodes", nodes)
+		}
+		first[nodes[0]]++
+	}
+
+	for _, node := range []string{"a", "b", "c"} {
+		if first[node] != 10 {
+			t.Fatalf("node %s should be tried first 10 times but got %d", node, first[node])
+		}
+	}
+}
+
+// go test -v -run=^TestBalancedNodesLeastBusy$
+func TestBalancedNodesLeastBusy(t *testing.T) {
+	tc := newBalanceClient(BalanceLeastBusy, "a", "b")
+
+	dialed := 0
+	tc.pools["a"] = newClientPool("a", 2, 0, pipeDial(&dialed))
+	defer tc.pools["a"].Close()
+
+	client, err := tc.pools["a"].get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.pools["a"].put(client)
+
+	for i := 0; i < 10; i++ {
+		if nodes := tc.balancedNodes(); nodes[0] != "b" {
+			t.Fatalf("nodes %v should start with the least busy node b", nodes)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package servers
+
+import (
+	"bufio"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+)
+
+// SetFrom 从 reader 中读取 size 个字节作为 key 的 value 添加到缓存中。
+// 数据会被分成多个 ChunkSize 大小的块依次发送，客户端不需要把整个 value 放在一块连续的内存中，也不会受到服务端 MaxArgSize 的限制。
+// 分块上传的数据不会被压缩，服务端不支持分块传输的话会读取整个 value 再使用 Set 添加。
+func (tc *TCPClient) SetFrom(key string, reader io.Reader, size int64, ttl int64) error {
+	return tc.SetFromContext(context.Background(), key, reader, size, ttl)
+}
+
+// SetFromContext 和 SetFrom 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+// 中途失败的话已经上传的块会在服务端超时之后被丢弃，缓存中的数据不会被修改。
+func (tc *TCPClient) SetFromContext(ctx context.Context, key string, reader io.Reader, size int64, ttl int64) error {
+	node, err := tc.nodeOf(key)
+	if err != nil {
+		return err
+	}
+
+	chunked, err := tc.supportsChunked(node)
+	if err != nil {
+		return err
+	}
+
+	if !chunked {
+		value := make([]byte, size)
+		if _, err = io.ReadFull(reader, value); err != nil {
+			return err
+		}
+		return tc.SetContext(ctx, key, value, ttl)
+	}
+
+	body, err := tc.doCommand(ctx, node, chunkSetCommand, [][]byte{uint64Bytes(uint64(ttl)), []byte(key), uint64Bytes(uint64(size))})
+	if err != nil {
+		return err
+	}
+
+	transfer := chunkedTransfer{}
+	if err = json.Unmarshal(body, &transfer); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, tc.chunkSize())
+	for offset := int64(0); offset < transfer.Size; {
+		n := int64(len(chunk))
+		if remaining := transfer.Size - offset; remaining < n {
+			n = remaining
+		}
+
+		if _, err = io.ReadFull(reader, chunk[:n]); err != nil {
+			return err
+		}
+
+		// 每一块都带着自己的偏移量，所以连接断开之后重试发送同一块不会导致数据重复
+		_, err = tc.doCommand(ctx, transfer.Node, chunkCommand, [][]byte{uint64Bytes(transfer.ID), uint64Bytes(uint64(offset)), chunk[:n]})
+		if err != nil {
+			return err
+		}
+		offset += n
+	}
+	return nil
+}
+
+// GetTo 把 key 对应的 value 写入 writer，返回写入的字节数，key 不存在的话返回 ErrNotFound。
+// 数据会被分成多个 ChunkSize 大小的块依次下载，客户端不需要把整个 value 放在一块连续的内存中。
+// 服务端不支持分块传输的话会使用 Get 读取整个 value 再写入。
+func (tc *TCPClient) GetTo(key string, writer io.Writer) (int64, error) {
+	return tc.GetToContext(context.Background(), key, writer)
+}
+
+// GetToContext 和 GetTo 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+// 中途失败的话已经写入 writer 的数据不会被撤回，调用方需要自己丢弃不完整的数据。
+func (tc *TCPClient) GetToContext(ctx context.Context, key string, writer io.Writer) (int64, error) {
+	node, err := tc.nodeOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	chunked, err := tc.supportsChunked(node)
+	if err != nil {
+		return 0, err
+	}
+
+	if !chunked {
+		value, err := tc.GetContext(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := writer.Write(value)
+		return int64(n), err
+	}
+
+	body, err := tc.doCommand(ctx, node, chunkGetCommand, [][]byte{[]byte(key), uint64Bytes(uint64(tc.chunkSize()))})
+	if err != nil {
+		return 0, err
+	}
+
+	transfer := chunkedTransfer{}
+	if err = json.Unmarshal(body, &transfer); err != nil {
+		return 0, err
+	}
+
+	reader, err := tc.decompressReader(&chunkReader{ctx: ctx, tc: tc, transfer: transfer})
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(writer, reader)
+}
+
+// supportsChunked 返回 node 是否支持分块传输。
+func (tc *TCPClient) supportsChunked(node string) (bool, error) {
+	handshake, err := tc.Handshake(node)
+	if err != nil {
+		return false, err
+	}
+	return handshake.Supports(featureChunked), nil
+}
+
+// chunkSize 返回分块传输时每一块的大小。
+func (tc *TCPClient) chunkSize() int {
+	if tc.options.ChunkSize <= 0 {
+		return defaultChunkSize
+	}
+	return tc.options.ChunkSize
+}
+
+// decompressReader 在开启了压缩并且数据是压缩过的时候，返回一边读取一边解压的 reader，否则返回原来的数据。
+func (tc *TCPClient) decompressReader(reader io.Reader) (io.Reader, error) {
+	if tc.options.CompressionThreshold <= 0 {
+		return reader, nil
+	}
+
+	buffered := bufio.NewReader(reader)
+	header, err := buffered.Peek(compressionHeaderLength)
+	if err != nil || string(header[:len(compressionMagic)]) != compressionMagic {
+		// 比压缩头部还短的数据不可能是压缩过的
+		return buffered, nil
+	}
+
+	if header[len(compressionMagic)] != compressionFlate {
+		return nil, errCorruptedCompressedValue
+	}
+
+	buffered.Discard(compressionHeaderLength)
+	return flate.NewReader(buffered), nil
+}
+
+// chunkReader 是一块一块下载数据的 reader，读完当前的块之后才会下载下一块。
+type chunkReader struct {
+	ctx context.Context
+
+	tc *TCPClient
+
+	// transfer 是正在进行的下载。
+	transfer chunkedTransfer
+
+	// offset 是下一块在整个数据中的偏移量。
+	offset int64
+
+	// chunk 是当前的块中还没有被读取的数据。
+	chunk []byte
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if len(cr.chunk) == 0 {
+		if cr.offset >= cr.transfer.Size {
+			return 0, io.EOF
+		}
+
+		chunk, err := cr.tc.doCommand(cr.ctx, cr.transfer.Node, chunkCommand, [][]byte{uint64Bytes(cr.transfer.ID), uint64Bytes(uint64(cr.offset))})
+		if err != nil {
+			return 0, err
+		}
+
+		if len(chunk) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		cr.chunk = chunk
+		cr.offset += int64(len(chunk))
+	}
+
+	n := copy(p, cr.chunk)
+	cr.chunk = cr.chunk[n:]
+	return n, nil
+}
+
+// uint64Bytes 返回 n 的大端编码。
+func uint64Bytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
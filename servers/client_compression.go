@@ -0,0 +1,69 @@
+package servers
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io/ioutil"
+)
+
+const (
+	// compressionMagic 是压缩过的 value 的前缀，用于和没有压缩的 value 区分开。
+	compressionMagic = "\xffKZ"
+
+	// compressionFlate 表示 value 是使用 flate 算法压缩的，写在前缀后面的一个字节中，方便以后支持其他算法。
+	compressionFlate = byte(1)
+
+	// compressionHeaderLength 是压缩过的 value 的头部长度，包括前缀和算法。
+	compressionHeaderLength = len(compressionMagic) + 1
+)
+
+var (
+	errCorruptedCompressedValue = errors.New("compressed value is corrupted")
+)
+
+// compressValue 在开启了压缩并且 value 的大小达到了阈值的时候压缩 value，压缩之后没有变小的话就保持原样。
+func (tc *TCPClient) compressValue(value []byte) []byte {
+	threshold := tc.options.CompressionThreshold
+	if threshold <= 0 || len(value) < threshold {
+		return value
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, len(value)/2+compressionHeaderLength))
+	buffer.WriteString(compressionMagic)
+	buffer.WriteByte(compressionFlate)
+
+	writer, err := flate.NewWriter(buffer, flate.DefaultCompression)
+	if err != nil {
+		return value
+	}
+
+	if _, err = writer.Write(value); err != nil {
+		return value
+	}
+
+	if err = writer.Close(); err != nil || buffer.Len() >= len(value) {
+		return value
+	}
+	return buffer.Bytes()
+}
+
+// decompressValue 在开启了压缩的时候解压 value，没有压缩过的 value 会原样返回，所以压缩过和没压缩过的 value 可以共存。
+func (tc *TCPClient) decompressValue(value []byte) ([]byte, error) {
+	if tc.options.CompressionThreshold <= 0 || len(value) < compressionHeaderLength || string(value[:len(compressionMagic)]) != compressionMagic {
+		return value, nil
+	}
+
+	if value[len(compressionMagic)] != compressionFlate {
+		return nil, errCorruptedCompressedValue
+	}
+
+	reader := flate.NewReader(bytes.NewReader(value[compressionHeaderLength:]))
+	defer reader.Close()
+
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errCorruptedCompressedValue
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,38 @@
+package servers
+
+import (
+	"bytes"
+	"testing"
+)
+
+// go test -v -run=^TestCompressValue$
+func TestCompressValue(t *testing.T) {
+	tc := &TCPClient{options: &ClientOptions{CompressionThreshold: 64}}
+
+	// 小于阈值的 value 和压缩之后没有变小的 value 都保持原样
+	small := []byte("small")
+	if compressed := tc.compressValue(small); !bytes.Equal(compressed, small) {
+		t.Fatalf("small value %q should not be compressed", compressed)
+	}
+
+	large := bytes.Repeat([]byte("kafo"), 1024)
+	compressed := tc.compressValue(large)
+	if len(compressed) >= len(large) || string(compressed[:len(compressionMagic)]) != compressionMagic {
+		t.Fatalf("large value should be compressed with magic but got %d bytes", len(compressed))
+	}
+
+	for _, value := range [][]byte{small, compressed} {
+		decompressed, err := tc.decompressValue(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decompressed, small) && !bytes.Equal(decompressed, large) {
+			t.Fatalf("decompressed value %q is wrong", decompressed)
+		}
+	}
+
+	if _, err := tc.decompressValue(compressed[:compressionHeaderLength+2]); err != errCorruptedCompressedValue {
+		t.Fatalf("err %v should be %v", err, errCorruptedCompressedValue)
+	}
+}
@@ -0,0 +1,61 @@
+package servers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+)
+
+var (
+	// ErrNotFound 表示 key 对应的数据不存在。
+	ErrNotFound = errNotFound
+
+	// ErrServerBusy 表示服务端正在持久化，暂时拒绝了这个操作，可以稍后重试。
+	ErrServerBusy = errors.New("server is busy")
+
+	// ErrEntryTooLarge 表示数据太大了，超过了服务端的限制。
+	ErrEntryTooLarge = errors.New("entry is too large")
+
+	// ErrHashMismatch 表示客户端和服务端的一致性哈希配置不一致。
+	ErrHashMismatch = errors.New("consistent hash config doesn't match the server")
+
+	// ErrNotInteger 表示自增的数据不是整数，或者自增之后溢出了。
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+)
+
+// ErrRedirect 表示 key 不属于执行命令的节点，需要到 Node 节点上执行。
+type ErrRedirect struct {
+	// Node 是 key 所属的节点。
+	Node string
+}
+
+// Error 返回错误信息，和服务端返回的重定向信息是一样的。
+func (er *ErrRedirect) Error() string {
+	return redirectPrefix + " " + er.Node
+}
+
+// replyErrors 是服务端返回的错误信息对应的类型化错误。
+var replyErrors = map[string]error{
+	errNotFound.Error():              ErrNotFound,
+	caches.ErrBusyDumping.Error():    ErrServerBusy,
+	caches.ErrEntryTooLarge.Error():  ErrEntryTooLarge,
+	caches.ErrTooManyEntries.Error(): ErrEntryTooLarge,
+	errArgTooLarge.Error():           ErrEntryTooLarge,
+	errFrameTooLarge.Error():         ErrEntryTooLarge,
+	caches.ErrNotInteger.Error():     ErrNotInteger,
+}
+
+// newReplyError 使用服务端返回的错误信息创建一个错误，能识别的错误信息会包装成对应的类型化错误，
+// 调用方可以使用 errors.Is 和 errors.As 判断错误的类型，而不需要解析错误信息。
+func newReplyError(message string) *replyError {
+	if err, ok := replyErrors[message]; ok {
+		return &replyError{message: message, err: err}
+	}
+
+	if strings.HasPrefix(message, redirectPrefix) {
+		node := strings.TrimSpace(strings.TrimPrefix(message, redirectPrefix))
+		return &replyError{message: message, err: &ErrRedirect{Node: node}}
+	}
+	return &replyError{message: message}
+}
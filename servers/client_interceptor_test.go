@@ -0,0 +1,35 @@
+package servers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// go test -v -run=^TestChainInterceptors$
+func TestChainInterceptors(t *testing.T) {
+	var steps []string
+	interceptor := func(name string) Interceptor {
+		return func(ctx context.Context, call *Call, next Invoker) ([]byte, error) {
+			steps = append(steps, name+" before")
+			body, err := next(ctx, call)
+			steps = append(steps, name+" after")
+			return body, err
+		}
+	}
+
+	invoker := chainInterceptors([]Interceptor{interceptor("first"), interceptor("second")}, func(ctx context.Context, call *Call) ([]byte, error) {
+		steps = append(steps, "invoke "+call.Operation)
+		return []byte("body"), nil
+	})
+
+	body, err := invoker(context.Background(), &Call{Operation: "get"})
+	if err != nil || string(body) != "body" {
+		t.Fatalf("body %s and err %v should be body and nil", body, err)
+	}
+
+	want := []string{"first before", "second before", "invoke get", "second after", "first after"}
+	if !reflect.DeepEqual(steps, want) {
+		t.Fatalf("steps %v should be %v", steps, want)
+	}
+}
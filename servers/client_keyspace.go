@@ -0,0 +1,73 @@
+package servers
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+)
+
+// Incr 将 key 对应的数据当作十进制的整数加上 delta，返回加上之后的值，delta 为负数就是自减。
+// 数据不存在的话会从 0 开始加，数据不是整数的话返回 ErrNotInteger。
+func (tc *TCPClient) Incr(key string, delta int64) (int64, error) {
+	return tc.IncrContext(context.Background(), key, delta)
+}
+
+// IncrContext 和 Incr 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+func (tc *TCPClient) IncrContext(ctx context.Context, key string, delta int64) (int64, error) {
+	node, err := tc.nodeOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	deltaBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(deltaBytes, uint64(delta))
+	body, err := tc.doCommand(ctx, node, incrCommand, [][]byte{[]byte(key), deltaBytes})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) != 8 {
+		return 0, errInvalidNumberArg
+	}
+	return int64(binary.BigEndian.Uint64(body)), nil
+}
+
+// Expire 重新设置 key 对应的数据的寿命，单位是秒，0 表示永不过期，返回数据是否存在。
+func (tc *TCPClient) Expire(key string, ttl int64) (bool, error) {
+	return tc.ExpireContext(context.Background(), key, ttl)
+}
+
+// ExpireContext 和 Expire 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+func (tc *TCPClient) ExpireContext(ctx context.Context, key string, ttl int64) (bool, error) {
+	node, err := tc.nodeOf(key)
+	if err != nil {
+		return false, err
+	}
+
+	ttlBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
+	_, err = tc.doCommand(ctx, node, expireCommand, [][]byte{[]byte(key), ttlBytes})
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Exists 返回 key 对应的数据是否存在，不会延长数据的寿命。
+func (tc *TCPClient) Exists(key string) (bool, error) {
+	return tc.ExistsContext(context.Background(), key)
+}
+
+// ExistsContext 和 Exists 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+func (tc *TCPClient) ExistsContext(ctx context.Context, key string) (bool, error) {
+	node, err := tc.nodeOf(key)
+	if err != nil {
+		return false, err
+	}
+
+	body, err := tc.doCommand(ctx, node, existsCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return false, err
+	}
+	return len(body) == 1 && body[0] == 1, nil
+}
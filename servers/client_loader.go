@@ -0,0 +1,50 @@
+package servers
+
+import (
+	"context"
+	"errors"
+)
+
+// LoaderFunc 是缓存未命中时加载数据的函数，比如从数据库中查询，返回的数据会被存储到集群中。
+type LoaderFunc func(key string) ([]byte, error)
+
+// GetOrLoad 获取指定 key 的 value，如果 key 不存在，就调用 loader 加载数据，并以 ttl 存储到集群中，然后返回加载的数据。
+// 同一个进程中同一时间同一个 key 只会有一个 loader 在执行，其他调用会等待并共享它的结果，避免缓存未命中的时候大量的请求打到后端。
+func (tc *TCPClient) GetOrLoad(key string, loader LoaderFunc, ttl int64) ([]byte, error) {
+	return tc.GetOrLoadContext(context.Background(), key, loader, ttl)
+}
+
+// GetOrLoadContext 和 GetOrLoad 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+// 存储加载的数据失败并不会影响返回的结果，下一次获取的时候会重新加载。
+func (tc *TCPClient) GetOrLoadContext(ctx context.Context, key string, loader LoaderFunc, ttl int64) ([]byte, error) {
+	value, err := tc.GetContext(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		return value, err
+	}
+
+	loaded, err, shared := tc.loads.DoContext(ctx, key, func() (interface{}, error) {
+		// 等待执行的过程中其他进程可能已经加载好了，所以再获取一次
+		value, err := tc.GetContext(ctx, key)
+		if !errors.Is(err, ErrNotFound) {
+			return value, err
+		}
+
+		if value, err = loader(key); err != nil {
+			return nil, err
+		}
+
+		tc.SetContext(ctx, key, value, ttl)
+		return value, nil
+	})
+
+	// 共享的是其他协程的结果，如果是因为它的 ctx 被取消或者超时而失败的，当前的调用需要重新加载
+	if shared && ctx.Err() == nil && (err == context.Canceled || err == context.DeadlineExceeded) {
+		return tc.GetOrLoadContext(ctx, key, loader, ttl)
+	}
+
+	value, _ = loaded.([]byte)
+	if shared {
+		value = append([]byte(nil), value...)
+	}
+	return value, err
+}
@@ -0,0 +1,79 @@
+package servers
+
+import (
+	"time"
+)
+
+// ClientMetrics 是客户端的指标钩子，用户可以实现这个接口，把客户端的指标上报到自己的监控系统中。
+// 这些方法会在执行命令的协程中被调用，所以实现需要是并发安全的，并且不能有太大的开销。
+type ClientMetrics interface {
+	// ObserveOperation 上报一次操作的耗时和结果，耗时包括了重试的时间，err 为 nil 表示操作成功了。
+	ObserveOperation(operation string, duration time.Duration, err error)
+
+	// ObserveRedirect 上报一次重定向，from 是收到重定向的节点，to 是正确的节点。
+	ObserveRedirect(from string, to string)
+
+	// ObservePool 上报某个节点的连接池的使用情况，每次从连接池中取出连接之后都会上报。
+	ObservePool(node string, stats PoolStats)
+}
+
+// PoolStats 是连接池的使用情况。
+type PoolStats struct {
+	// Size 是连接池的最大连接数。
+	Size int `json:"size"`
+
+	// Open 是已经建立的连接数。
+	Open int `json:"open"`
+
+	// Idle 是空闲的连接数。
+	Idle int `json:"idle"`
+}
+
+// noopMetrics 是什么都不做的指标钩子，没有设置指标钩子的时候使用。
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveOperation(operation string, duration time.Duration, err error) {}
+
+func (noopMetrics) ObserveRedirect(from string, to string) {}
+
+func (noopMetrics) ObservePool(node string, stats PoolStats) {}
+
+// commandNames 是每个命令的名字，用于上报指标和打印日志。
+var commandNames = map[byte]string{
+	getCommand:            "get",
+	setCommand:            "set",
+	deleteCommand:         "delete",
+	statusCommand:         "status",
+	nodesCommand:          "nodes",
+	infoCommand:           "info",
+	pingCommand:           "ping",
+	handshakeCommand:      "handshake",
+	execCommand:           "exec",
+	mgetCommand:           "mget",
+	msetCommand:           "mset",
+	watchCommand:          "watch",
+	replicateCommand:      "replicate",
+	replicaGetCommand:     "replica_get",
+	keysCommand:           "keys",
+	publishCommand:        "publish",
+	subscribeCommand:      "subscribe",
+	incrCommand:           "incr",
+	expireCommand:         "expire",
+	existsCommand:         "exists",
+	reloadCommand:         "reload",
+	evictionPolicyCommand: "eviction-policy",
+	configCommand:         "config",
+	logLevelCommand:       "log-level",
+	clientsCommand:        "clients",
+	chunkSetCommand:       "chunkset",
+	chunkGetCommand:       "chunkget",
+	chunkCommand:          "chunk",
+}
+
+// commandName 返回命令的名字，未知的命令会返回 unknown。
+func commandName(command byte) string {
+	if name, ok := commandNames[command]; ok {
+		return name
+	}
+	return "unknown"
+}
@@ -0,0 +1,113 @@
+package servers
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// ClientOptions 是 TCPClient 的选项配置。
+type ClientOptions struct {
+	// DialTimeout 是建立连接的超时时间，小于等于 0 表示不限制。
+	DialTimeout time.Duration
+
+	// RequestTimeout 是每个操作的超时时间，包括了重试的时间，小于等于 0 表示不限制。
+	// 如果调用带 ctx 的方法时 ctx 已经有了更早的期限，就以 ctx 的期限为准。
+	RequestTimeout time.Duration
+
+	// PoolSize 是每个节点的连接池的最大连接数。
+	PoolSize int
+
+	// VirtualNodeCount 是一致性哈希的虚拟节点数，需要和服务端保持一致，否则节点的判断会发生误差，导致大量的重定向。
+	// 握手的时候会和服务端的配置进行校验，不一致的话连接会失败，返回 ErrHashMismatch。
+	// 小于等于 0 表示使用服务端的配置。
+	VirtualNodeCount int
+
+	// HashFunction 是一致性哈希使用的哈希函数，目前只支持 HashCRC32，和 VirtualNodeCount 一样会在握手的时候进行校验。
+	// 为空表示使用服务端的配置。
+	HashFunction string
+
+	// ConnectionTTL 是连接的最大存活时间，超过这个时间的连接会被关闭，然后重新建立，小于等于 0 表示不限制。
+	ConnectionTTL time.Duration
+
+	// WarmUpConnections 是创建客户端的时候预先和每个节点建立的连接数，这样启动之后的第一批请求就不需要等待建立连接了。
+	// 不会超过 PoolSize，小于等于 0 表示不预先建立连接，等到使用的时候再建立。
+	WarmUpConnections int
+
+	// HealthCheckInterval 是后台检查空闲连接的时间间隔，坏掉的空闲连接会被提前关闭，而不是等到使用的时候才发现，小于等于 0 表示不检查。
+	HealthCheckInterval time.Duration
+
+	// UpdateCircleDuration 是定时更新集群节点信息的时间间隔。
+	UpdateCircleDuration time.Duration
+
+	// Checksum 表示是否开启 CRC32 校验，开启之后请求帧和响应帧都会带上校验码，用于在不稳定的网络中发现数据损坏。
+	// 只有服务端也支持校验的时候才会生效。
+	Checksum bool
+
+	// TLSConfig 不为 nil 的话会使用 TLS 连接节点，每次建立新的连接都会重新进行 TLS 握手。
+	// 服务端本身还不支持 TLS，所以需要在每个节点前面部署 TLS 代理，比如 stunnel。
+	// 如果没有设置 ServerName，就会使用节点地址中的主机名校验证书。
+	TLSConfig *tls.Config
+
+	// ReplicationFactor 是服务端每个 key 保存的份数，需要和服务端保持一致，小于等于 1 表示服务端没有开启复制。
+	// 大于 1 的时候，所属节点不可用会到一致性哈希环上的后续节点读取副本，读到的数据可能是旧的。
+	ReplicationFactor int
+
+	// CompressionThreshold 是压缩 value 的阈值，开启之后达到这个大小的 value 会在写入之前使用 flate 压缩，读取之后自动解压。
+	// 压缩过的 value 带有格式标记，所以和没压缩过的 value 可以共存，但是读取同一批 key 的客户端都需要开启压缩，
+	// 否则读到的就是压缩过的数据。小于等于 0 表示不压缩。
+	CompressionThreshold int
+
+	// ChunkSize 是 SetFrom 和 GetTo 分块传输时每一块的大小，不能超过服务端的 MaxArgSize，小于等于 0 表示使用默认的 1 MB。
+	ChunkSize int
+
+	// SingleFlight 表示是否合并并发获取同一个 key 的请求，开启之后同一时间同一个 key 只会发送一个请求，其他请求会共享它的结果。
+	// 热点 key 被大量协程同时获取的时候，可以避免这些请求同时打到节点上。
+	SingleFlight bool
+
+	// BalancePolicy 是不针对某个 key 的操作选择节点的策略，比如获取集群的节点信息、订阅拓扑变化和遍历 key。
+	BalancePolicy BalancePolicy
+
+	// RetryPolicy 是操作失败时的重试策略，为 nil 的话就使用默认的重试策略。
+	RetryPolicy RetryPolicy
+
+	// Metrics 是客户端的指标钩子，为 nil 的话就不上报指标。
+	Metrics ClientMetrics
+
+	// Interceptors 是客户端的拦截器，按照顺序包裹每个命令的执行，第一个拦截器在最外层。
+	// 流水线和订阅使用的是专门的连接，不会经过拦截器，只有流水线中需要单独重试的命令才会经过。
+	Interceptors []Interceptor
+}
+
+// DefaultClientOptions 返回默认的客户端选项配置。
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		DialTimeout:          3 * time.Second,
+		RequestTimeout:       0,
+		PoolSize:             defaultPoolSize,
+		VirtualNodeCount:     1024,
+		HashFunction:         HashCRC32,
+		ConnectionTTL:        15 * time.Minute,
+		WarmUpConnections:    0,
+		HealthCheckInterval:  healthCheckIdleTime,
+		UpdateCircleDuration: 5 * time.Minute,
+		Checksum:             false,
+		ReplicationFactor:    1,
+		CompressionThreshold: 0,
+		ChunkSize:            defaultChunkSize,
+		SingleFlight:         false,
+		BalancePolicy:        BalanceRoundRobin,
+		RetryPolicy:          DefaultRetryPolicy(),
+		Metrics:              noopMetrics{},
+	}
+}
+
+// features 返回客户端在握手时提出的特性。
+func (co *ClientOptions) features() []string {
+	if !co.Checksum {
+		return clientFeatures
+	}
+
+	features := make([]string, 0, len(clientFeatures)+1)
+	features = append(features, clientFeatures...)
+	return append(features, featureCRC)
+}
@@ -0,0 +1,168 @@
+package servers
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+// PipelineResult 是流水线中一个命令的结果。
+type PipelineResult struct {
+	// Value 是 Get 获取到的数据，其他命令是 nil。
+	Value []byte
+
+	// Err 是命令执行的错误，Get 的 key 不存在时是 ErrNotFound。
+	Err error
+}
+
+// pipelineCommand 是流水线中等待执行的一个命令。
+type pipelineCommand struct {
+	key string
+
+	request pipelineRequest
+}
+
+// Pipeline 是客户端的流水线，Get、Set 和 Delete 只会把命令记录下来，调用 Exec 的时候才会真正执行：
+//
+//	pipeline := client.Pipeline()
+//	pipeline.Set("k1", []byte("v1"), 0)
+//	pipeline.Get("k2")
+//	results, err := pipeline.Exec()
+//
+// 命令会按照 key 所属的节点分组，每个节点的命令都在同一个连接上一次性发送出去，所以每个节点只需要一次网络往返。
+// 和事务不同，流水线中的命令并不是原子执行的，每个命令都有自己的结果。
+// Pipeline 不是并发安全的。
+type Pipeline struct {
+	client *TCPClient
+
+	commands []*pipelineCommand
+}
+
+// Pipeline 返回一个新的流水线。
+func (tc *TCPClient) Pipeline() *Pipeline {
+	return &Pipeline{client: tc}
+}
+
+// Get 将获取 key 的命令加入到流水线中。
+func (p *Pipeline) Get(key string) *Pipeline {
+	return p.add(key, getCommand, [][]byte{[]byte(key)})
+}
+
+// Set 将添加键值对的命令加入到流水线中。
+func (p *Pipeline) Set(key string, value []byte, ttl int64) *Pipeline {
+	ttlBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
+	return p.add(key, setCommand, [][]byte{ttlBytes, []byte(key), p.client.compressValue(value)})
+}
+
+// Delete 将删除 key 的命令加入到流水线中。
+func (p *Pipeline) Delete(key string) *Pipeline {
+	return p.add(key, deleteCommand, [][]byte{[]byte(key)})
+}
+
+// Len 返回流水线中还没执行的命令个数。
+func (p *Pipeline) Len() int {
+	return len(p.commands)
+}
+
+// add 将命令加入到流水线中。
+func (p *Pipeline) add(key string, command byte, args [][]byte) *Pipeline {
+	p.commands = append(p.commands, &pipelineCommand{
+		key:     key,
+		request: pipelineRequest{command: command, args: args},
+	})
+	return p
+}
+
+// Exec 执行流水线中的所有命令，并按照加入的顺序返回每个命令的结果，执行之后流水线会被清空，可以继续使用。
+func (p *Pipeline) Exec() ([]PipelineResult, error) {
+	return p.ExecContext(context.Background())
+}
+
+// ExecContext 和 Exec 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+// 返回的错误只表示 ctx 被取消或者超时了，每个命令的错误都在各自的结果中。
+// 被重定向或者因为连接断开而失败的命令，会按照重试策略单独重新执行。
+func (p *Pipeline) ExecContext(ctx context.Context) ([]PipelineResult, error) {
+	commands := p.commands
+	p.commands = nil
+
+	results := make([]PipelineResult, len(commands))
+	groups := map[string][]int{}
+	for i, command := range commands {
+		node, err := p.client.nodeOf(command.key)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		groups[node] = append(groups[node], i)
+	}
+
+	wg := &sync.WaitGroup{}
+	for node, indexes := range groups {
+		wg.Add(1)
+		go func(node string, indexes []int) {
+			defer wg.Done()
+			p.execOn(ctx, node, commands, indexes, results)
+		}(node, indexes)
+	}
+	wg.Wait()
+	return results, ctx.Err()
+}
+
+// execOn 在 node 上执行 indexes 对应的命令，并把结果写入到 results 中。
+func (p *Pipeline) execOn(ctx context.Context, node string, commands []*pipelineCommand, indexes []int, results []PipelineResult) {
+	tc := p.client
+	pool := tc.poolOf(node)
+	client, err := pool.get(ctx)
+	if err != nil {
+		for _, i := range indexes {
+			results[i].Err = err
+		}
+		return
+	}
+
+	requests := make([]pipelineRequest, len(indexes))
+	for j, i := range indexes {
+		requests[j] = pipelineRequest{
+			command: commands[i].request.command,
+			args:    client.withTraceID(helpers.NewTraceID(), commands[i].request.args),
+		}
+	}
+
+	tc.options.Metrics.ObservePool(node, pool.stats())
+	callResults, err := client.DoPipelineContext(ctx, requests)
+	pool.put(client)
+
+	for j, i := range indexes {
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		result := callResults[j]
+		_, redirected := redirectNodeOf(result.err)
+		if result.err == nil || (!redirected && !isConnectionError(result.err)) {
+			results[i] = PipelineResult{Value: result.body, Err: result.err}
+			continue
+		}
+
+		// 拓扑变化或者连接断开导致失败的命令，交给 doCommand 按照重试策略重新执行
+		command := commands[i]
+		body, err := tc.doCommand(ctx, node, command.request.command, command.request.args)
+		results[i] = PipelineResult{Value: body, Err: err}
+	}
+
+	// 只有 Get 才有返回值，其他命令的响应体没有意义
+	for _, i := range indexes {
+		if commands[i].request.command != getCommand {
+			results[i].Value = nil
+			continue
+		}
+
+		if results[i].Err == nil {
+			results[i].Value, results[i].Err = tc.decompressValue(results[i].Value)
+		}
+	}
+}
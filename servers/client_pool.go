@@ -0,0 +1,269 @@
+package servers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+const (
+	// defaultPoolSize 是每个节点的连接池默认的最大连接数。
+	defaultPoolSize = 8
+
+	// healthCheckIdleTime 是连接空闲多久之后，取出来使用之前需要先 ping 一下检查是否还可用。
+	healthCheckIdleTime = 30 * time.Second
+)
+
+var (
+	errPoolClosed = errors.New("client pool is closed")
+)
+
+// pooledClient 是连接池中的连接，记录着连接的创建时间和最后一次使用的时间。
+type pooledClient struct {
+	*protocolClient
+
+	// createdTime 是连接的创建时间。
+	createdTime time.Time
+
+	// lastUsedTime 是连接最后一次放回连接池的时间，新建立的连接是零值。
+	lastUsedTime time.Time
+
+	// checkedTime 是后台最后一次检查连接可用的时间。
+	checkedTime time.Time
+}
+
+// reused 返回连接是不是之前用过的，用过的连接在空闲的时候可能已经被服务端关闭了。
+func (pc *pooledClient) reused() bool {
+	return !pc.lastUsedTime.IsZero()
+}
+
+// clientPool 是某个节点的连接池。
+// 连接池中的连接数不会超过 size 个，所有连接都在使用中的时候，取连接的操作会等待其他协程归还连接。
+type clientPool struct {
+	// address 是节点的地址。
+	address string
+
+	// dial 用于建立新的连接，包括握手之类的初始化工作。
+	dial func(address string) (*protocolClient, error)
+
+	// idleClients 存储着空闲的连接。
+	idleClients chan *pooledClient
+
+	// tokens 用于限制连接数，每建立一个连接就放入一个令牌，关闭一个连接就取出一个令牌。
+	tokens chan struct{}
+
+	// maxLifetime 是连接的最大存活时间，超过这个时间的连接不会再被使用，小于等于 0 表示不限制。
+	maxLifetime time.Duration
+
+	// closed 表示连接池是否已经关闭了。
+	closed bool
+
+	// lock 用于保护 closed。
+	lock *sync.RWMutex
+}
+
+// newClientPool 返回一个节点的连接池，size 是最大连接数。
+func newClientPool(address string, size int, maxLifetime time.Duration, dial func(address string) (*protocolClient, error)) *clientPool {
+	if size <= 0 {
+		size = defaultPoolSize
+	}
+
+	return &clientPool{
+		address:     address,
+		dial:        dial,
+		idleClients: make(chan *pooledClient, size),
+		tokens:      make(chan struct{}, size),
+		maxLifetime: maxLifetime,
+		lock:        &sync.RWMutex{},
+	}
+}
+
+// get 从连接池中取出一个可用的连接，如果没有空闲的连接并且连接数还没达到上限，就建立一个新的连接。
+// 如果连接数已经达到了上限，就等待其他协程归还连接，直到 ctx 被取消或者超时。
+// 取出的连接使用完之后必须调用 put 归还。
+func (cp *clientPool) get(ctx context.Context) (*pooledClient, error) {
+	for {
+		if cp.isClosed() {
+			return nil, errPoolClosed
+		}
+
+		// 优先使用空闲的连接
+		select {
+		case client := <-cp.idleClients:
+			if cp.healthy(client) {
+				return client, nil
+			}
+			cp.discard(client)
+			continue
+		default:
+		}
+
+		select {
+		case client := <-cp.idleClients:
+			if cp.healthy(client) {
+				return client, nil
+			}
+			cp.discard(client)
+		case cp.tokens <- struct{}{}:
+			client, err := cp.dial(cp.address)
+			if err != nil {
+				<-cp.tokens
+				return nil, err
+			}
+			return &pooledClient{protocolClient: client, createdTime: time.Now()}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// healthy 检查连接是否还可以使用。
+// 已经断开或者超过最大存活时间的连接都是不可用的，空闲了比较久的连接还需要 ping 一下，防止拿到一个已经被服务端关闭的半开连接。
+func (cp *clientPool) healthy(client *pooledClient) bool {
+	if client.broken() {
+		return false
+	}
+
+	now := time.Now()
+	if cp.maxLifetime > 0 && now.Sub(client.createdTime) > cp.maxLifetime {
+		return false
+	}
+
+	if now.Sub(client.lastUsedTime) > healthCheckIdleTime && now.Sub(client.checkedTime) > healthCheckIdleTime {
+		return cp.ping(client)
+	}
+	return true
+}
+
+// ping 使用 ping 命令检查连接是否还可用。
+func (cp *clientPool) ping(client *pooledClient) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.DoContext(ctx, pingCommand, client.withTraceID(helpers.NewTraceID(), nil))
+	return err == nil
+}
+
+// checkIdle 检查所有空闲的连接，已经断开、超过最大存活时间或者 ping 不通的连接都会被关闭，这样使用的时候就不会拿到坏掉的连接了。
+// 检查的过程中连接是从空闲队列中取出来的，所以不会和正在使用的协程冲突。
+func (cp *clientPool) checkIdle() {
+	for i := len(cp.idleClients); i > 0; i-- {
+		var client *pooledClient
+		select {
+		case client = <-cp.idleClients:
+		default:
+			return
+		}
+
+		if client.broken() || (cp.maxLifetime > 0 && time.Since(client.createdTime) > cp.maxLifetime) || !cp.ping(client) {
+			cp.discard(client)
+			continue
+		}
+
+		// 检查的时候并没有真正使用连接，所以不更新最后一次使用的时间，只记录检查的时间
+		client.checkedTime = time.Now()
+		if cp.isClosed() {
+			cp.discard(client)
+			continue
+		}
+
+		select {
+		case cp.idleClients <- client:
+		default:
+			cp.discard(client)
+		}
+	}
+}
+
+// put 将连接归还给连接池，已经断开的连接会被直接关闭。
+func (cp *clientPool) put(client *pooledClient) {
+	if client.broken() || cp.isClosed() {
+		cp.discard(client)
+		return
+	}
+
+	client.lastUsedTime = time.Now()
+	select {
+	case cp.idleClients <- client:
+	default:
+		// 连接数是受令牌限制的，所以空闲连接的队列是不会满的，这里只是以防万一
+		cp.discard(client)
+	}
+}
+
+// warmUp 预先建立连接放入空闲队列中，直到连接数达到 count 个，已经建立的连接也算在内，count 不会超过连接池的最大连接数。
+// 建立连接失败的话会马上返回错误，剩下的连接等到使用的时候再建立。
+func (cp *clientPool) warmUp(count int) error {
+	if count > cap(cp.tokens) {
+		count = cap(cp.tokens)
+	}
+
+	for len(cp.tokens) < count {
+		if cp.isClosed() {
+			return errPoolClosed
+		}
+
+		select {
+		case cp.tokens <- struct{}{}:
+		default:
+			return nil
+		}
+
+		client, err := cp.dial(cp.address)
+		if err != nil {
+			<-cp.tokens
+			return err
+		}
+
+		// 刚刚握手过的连接是可用的，记录下检查的时间，避免第一次使用的时候还要 ping 一下
+		now := time.Now()
+		select {
+		case cp.idleClients <- &pooledClient{protocolClient: client, createdTime: now, checkedTime: now}:
+		default:
+			client.Close()
+			<-cp.tokens
+			return nil
+		}
+	}
+	return nil
+}
+
+// stats 返回连接池的使用情况。
+func (cp *clientPool) stats() PoolStats {
+	return PoolStats{
+		Size: cap(cp.tokens),
+		Open: len(cp.tokens),
+		Idle: len(cp.idleClients),
+	}
+}
+
+// discard 关闭连接并归还它占用的令牌。
+func (cp *clientPool) discard(client *pooledClient) {
+	client.Close()
+	<-cp.tokens
+}
+
+// isClosed 返回连接池是否已经关闭了。
+func (cp *clientPool) isClosed() bool {
+	cp.lock.RLock()
+	defer cp.lock.RUnlock()
+	return cp.closed
+}
+
+// Close 关闭连接池和所有空闲的连接，正在使用的连接会在归还的时候被关闭。
+func (cp *clientPool) Close() error {
+	cp.lock.Lock()
+	cp.closed = true
+	cp.lock.Unlock()
+
+	for {
+		select {
+		case client := <-cp.idleClients:
+			cp.discard(client)
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package servers
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipeDial 返回一个使用内存管道建立连接的 dial 函数，服务端会对每个请求返回空的响应。
+func pipeDial(dialed *int) func(address string) (*protocolClient, error) {
+	return func(address string) (*protocolClient, error) {
+		server, client := net.Pipe()
+		go func() {
+			defer server.Close()
+			for {
+				_, _, err := readRequestFrom(server, frameLimits{}, false, nil)
+				if err != nil {
+					return
+				}
+				writeResponseTo(server, successReply, nil, false)
+			}
+		}()
+
+		*dialed++
+		return newProtocolClientOn(client), nil
+	}
+}
+
+// go test -v -run=^TestClientPool$
+func TestClientPool(t *testing.T) {
+	dialed := 0
+	pool := newClientPool("pipe", 2, 0, pipeDial(&dialed))
+	defer pool.Close()
+
+	client1, err := pool.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client2, err := pool.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 连接数已经达到上限了，取连接的操作会一直等待，直到超时
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = pool.get(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err %v should be %v", err, context.DeadlineExceeded)
+	}
+
+	// 归还的连接会被复用，断开的连接会被丢弃
+	pool.put(client1)
+	client2.Close()
+	pool.put(client2)
+
+	client, err := pool.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client != client1 || dialed != 2 {
+		t.Fatalf("client %p should be %p and dialed %d should be 2", client, client1, dialed)
+	}
+}
+
+// go test -v -run=^TestClientPoolCheckIdle$
+func TestClientPoolCheckIdle(t *testing.T) {
+	dialed := 0
+	pool := newClientPool("pipe", 2, 0, pipeDial(&dialed))
+	defer pool.Close()
+
+	client1, err := pool.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client2, err := pool.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 连接在空闲的时候被服务端关闭了，后台检查的时候会被发现并关闭
+	pool.put(client1)
+	pool.put(client2)
+	client2.conn.Close()
+	pool.checkIdle()
+
+	if stats := pool.stats(); stats.Open != 1 || stats.Idle != 1 {
+		t.Fatalf("stats %+v should have 1 open and 1 idle client", stats)
+	}
+
+	client, err := pool.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client != client1 || !client.reused() {
+		t.Fatalf("client %p should be %p and reused", client, client1)
+	}
+}
+
+// go test -v -run=^TestClientPoolWarmUp$
+func TestClientPoolWarmUp(t *testing.T) {
+	dialed := 0
+	pool := newClientPool("pipe", 2, 0, pipeDial(&dialed))
+	defer pool.Close()
+
+	// 预先建立的连接数不会超过连接池的最大连接数
+	if err := pool.warmUp(3); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := pool.stats(); stats.Open != 2 || stats.Idle != 2 || dialed != 2 {
+		t.Fatalf("stats %+v should have 2 open and 2 idle clients, dialed %d should be 2", stats, dialed)
+	}
+
+	client, err := pool.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.reused() || dialed != 2 {
+		t.Fatalf("warmed up client should not be reused and dialed %d should be 2", dialed)
+	}
+}
@@ -0,0 +1,127 @@
+package servers
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy 是客户端的重试策略，决定失败的操作是否需要重试，以及重试之前需要等待多久。
+type RetryPolicy interface {
+	// Retry 返回第 attempt 次执行失败之后是否需要重试，以及重试之前需要等待的时间，attempt 从 1 开始。
+	Retry(attempt int, err error) (delay time.Duration, ok bool)
+}
+
+// BackoffRetryPolicy 是使用指数退避的重试策略，每次重试的等待时间都会翻倍，并且加上一定的随机抖动，
+// 避免大量的客户端在同一时间一起重试，把刚恢复的节点又打垮了。
+type BackoffRetryPolicy struct {
+	// MaxAttempts 是最多执行的次数，包括第一次执行。
+	MaxAttempts int
+
+	// BaseDelay 是第一次重试之前等待的时间。
+	BaseDelay time.Duration
+
+	// MaxDelay 是重试之前最多等待的时间。
+	MaxDelay time.Duration
+
+	// Jitter 是随机抖动的比例，取值范围是 0 到 1，0 表示不抖动。
+	Jitter float64
+
+	// Retryable 判断某个错误是否可以重试，为 nil 的话就使用 IsRetryable。
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy 返回默认的重试策略。
+func DefaultRetryPolicy() *BackoffRetryPolicy {
+	return &BackoffRetryPolicy{
+		MaxAttempts: maxRedirectTimes,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// Retry 返回第 attempt 次执行失败之后是否需要重试，以及重试之前需要等待的时间。
+// 重定向错误是不需要等待的，因为正确的节点已经告诉我们了，马上到正确的节点上重试就可以了。
+func (brp *BackoffRetryPolicy) Retry(attempt int, err error) (time.Duration, bool) {
+	if attempt >= brp.MaxAttempts {
+		return 0, false
+	}
+
+	if _, ok := redirectNodeOf(err); ok {
+		return 0, true
+	}
+
+	retryable := brp.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	if !retryable(err) {
+		return 0, false
+	}
+	return brp.delayOf(attempt), true
+}
+
+// delayOf 返回第 attempt 次执行失败之后需要等待的时间。
+func (brp *BackoffRetryPolicy) delayOf(attempt int) time.Duration {
+	delay := brp.BaseDelay
+	for i := 1; i < attempt && delay < brp.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if brp.MaxDelay > 0 && delay > brp.MaxDelay {
+		delay = brp.MaxDelay
+	}
+
+	if brp.Jitter > 0 {
+		jitter := float64(delay) * brp.Jitter
+		delay = time.Duration(float64(delay) - jitter + rand.Float64()*jitter*2)
+	}
+	return delay
+}
+
+// IsRetryable 返回 err 是否是可以重试的错误，包括重定向、连接断开、服务端处理超时以及服务端正在持久化。
+// 调用方自己取消或者超时导致的错误是不可以重试的，因为调用方已经不需要这个结果了。
+func IsRetryable(err error) bool {
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+
+	if _, ok := redirectNodeOf(err); ok {
+		return true
+	}
+
+	if isConnectionError(err) {
+		return true
+	}
+
+	return errors.Is(err, ErrServerBusy) || err.Error() == context.DeadlineExceeded.Error()
+}
+
+// redirectNodeOf 从重定向错误中取出正确的节点地址，如果 err 不是重定向错误就返回 false。
+func redirectNodeOf(err error) (string, bool) {
+	var redirect *ErrRedirect
+	if !errors.As(err, &redirect) {
+		return "", false
+	}
+	return redirect.Node, true
+}
+
+// sleepContext 等待 delay 时间，如果等待的过程中 ctx 被取消或者超时了，就返回 ctx 的错误。
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
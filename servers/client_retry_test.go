@@ -0,0 +1,62 @@
+package servers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+)
+
+// go test -v -run=^TestBackoffRetryPolicy$
+func TestBackoffRetryPolicy(t *testing.T) {
+	policy := &BackoffRetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    15 * time.Millisecond,
+	}
+
+	// 重定向不需要等待
+	delay, ok := policy.Retry(1, newReplyError("redirect to node 127.0.0.1:5837"))
+	if !ok || delay != 0 {
+		t.Fatalf("redirect should be retried immediately but got %v, %v", delay, ok)
+	}
+
+	delay, ok = policy.Retry(2, errors.New("connection reset by peer"))
+	if !ok || delay != 15*time.Millisecond {
+		t.Fatalf("connection error should be retried after %v but got %v, %v", 15*time.Millisecond, delay, ok)
+	}
+
+	if _, ok = policy.Retry(3, errors.New("connection reset by peer")); ok {
+		t.Fatal("retry should stop after max attempts")
+	}
+
+	if _, ok = policy.Retry(1, newReplyError(errNotFound.Error())); ok {
+		t.Fatal("not found should not be retried")
+	}
+
+	if _, ok = policy.Retry(1, context.Canceled); ok {
+		t.Fatal("canceled should not be retried")
+	}
+}
+
+// go test -v -run=^TestNewReplyError$
+func TestNewReplyError(t *testing.T) {
+	if err := newReplyError(errNotFound.Error()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err %v should be %v", err, ErrNotFound)
+	}
+
+	if err := newReplyError(caches.ErrBusyDumping.Error()); !errors.Is(err, ErrServerBusy) {
+		t.Fatalf("err %v should be %v", err, ErrServerBusy)
+	}
+
+	var redirect *ErrRedirect
+	if err := newReplyError("redirect to node 127.0.0.1:5837"); !errors.As(err, &redirect) || redirect.Node != "127.0.0.1:5837" {
+		t.Fatalf("err %v should be a redirect to 127.0.0.1:5837", err)
+	}
+
+	if err := newReplyError("unknown"); err.Error() != "unknown" || errors.Unwrap(err) != nil {
+		t.Fatalf("err %v should be unknown and not wrap any error", err)
+	}
+}
@@ -0,0 +1,189 @@
+package servers
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+)
+
+// ScanOptions 是遍历集群中的 key 时使用的选项。
+type ScanOptions struct {
+	// Prefix 是 key 的前缀，为空表示不过滤。
+	Prefix string
+
+	// Match 是 path.Match 格式的通配符，比如 user:*:name，在服务端进行过滤，为空表示不过滤。
+	Match string
+
+	// Count 是每次从节点上获取的 key 个数的参考值，小于等于 0 就使用服务端默认的个数。
+	Count int
+}
+
+// KeyScanner 是遍历集群中所有 key 的迭代器，使用方式和 bufio.Scanner 类似：
+//
+//	scanner := client.Scan(ctx, servers.ScanOptions{Prefix: "user:"})
+//	for scanner.Next() {
+//		fmt.Println(scanner.Key())
+//	}
+//	if err := scanner.Err(); err != nil {
+//		...
+//	}
+//
+// 节点是一个接一个遍历的，每个节点都使用服务端的游标分页获取 key。
+// 遍历的过程中有节点离开集群的话，这个节点剩下的 key 会被跳过，因为这些 key 已经访问不到了；
+// 有节点加入集群的话，会在遍历完已知的节点之后再遍历新的节点。
+// 集群拓扑变化时同一个 key 可能出现在两个节点上，所以遍历过的 key 会被记录下来去重，注意这会占用和 key 的个数成正比的内存。
+// KeyScanner 不是并发安全的。
+type KeyScanner struct {
+	client *TCPClient
+
+	ctx context.Context
+
+	options ScanOptions
+
+	// nodes 是还没遍历完的节点。
+	nodes []string
+
+	// cursor 是当前节点下一次遍历的游标。
+	cursor int
+
+	// scanned 记录着已经遍历过的节点，包括正在遍历的节点。
+	scanned map[string]bool
+
+	// keys 是已经获取到但是还没返回给调用方的 key。
+	keys []string
+
+	// seen 记录着已经返回给调用方的 key，用于去重。
+	seen map[string]struct{}
+
+	// key 是当前的 key。
+	key string
+
+	// err 是遍历过程中发生的错误，发生错误之后遍历就结束了。
+	err error
+}
+
+// Scan 返回一个遍历集群中所有 key 的迭代器。
+func (tc *TCPClient) Scan(ctx context.Context, options ScanOptions) *KeyScanner {
+	nodes := tc.balancedNodes()
+	scanned := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		scanned[node] = true
+	}
+
+	return &KeyScanner{
+		client:  tc,
+		ctx:     ctx,
+		options: options,
+		nodes:   nodes,
+		scanned: scanned,
+		seen:    map[string]struct{}{},
+	}
+}
+
+// Next 遍历下一个 key，返回 false 说明遍历结束了，需要通过 Err 判断是不是发生了错误。
+func (ks *KeyScanner) Next() bool {
+	for ks.err == nil {
+		for len(ks.keys) > 0 {
+			key := ks.keys[0]
+			ks.keys = ks.keys[1:]
+			if _, ok := ks.seen[key]; ok {
+				continue
+			}
+
+			ks.seen[key] = struct{}{}
+			ks.key = key
+			return true
+		}
+
+		if len(ks.nodes) == 0 && !ks.addJoinedNodes() {
+			return false
+		}
+		ks.err = ks.fetch()
+	}
+	return false
+}
+
+// Key 返回当前的 key。
+func (ks *KeyScanner) Key() string {
+	return ks.key
+}
+
+// Err 返回遍历过程中发生的错误。
+func (ks *KeyScanner) Err() error {
+	return ks.err
+}
+
+// fetch 从当前的节点上获取下一页的 key，节点遍历完或者已经离开集群了，就切换到下一个节点。
+func (ks *KeyScanner) fetch() error {
+	node := ks.nodes[0]
+	body, err := ks.client.doCommand(ks.ctx, node, keysCommand, ks.args())
+	if err != nil {
+		if !isConnectionError(err) || ks.inCluster(node) {
+			return err
+		}
+
+		// 节点已经离开集群了，剩下的 key 也访问不到了，直接跳过这个节点
+		ks.nextNode()
+		return nil
+	}
+
+	result := &keysResult{}
+	if err = json.Unmarshal(body, result); err != nil {
+		return err
+	}
+
+	ks.keys = result.Keys
+	ks.cursor = result.Cursor
+	if ks.cursor == 0 {
+		ks.nextNode()
+	}
+	return nil
+}
+
+// args 返回 keys 命令的参数。
+func (ks *KeyScanner) args() [][]byte {
+	cursor := make([]byte, 8)
+	binary.BigEndian.PutUint64(cursor, uint64(ks.cursor))
+
+	count := make([]byte, 8)
+	if ks.options.Count > 0 {
+		binary.BigEndian.PutUint64(count, uint64(ks.options.Count))
+	}
+
+	args := [][]byte{[]byte(ks.options.Prefix), cursor, count}
+	if ks.options.Match != "" {
+		args = append(args, []byte(ks.options.Match))
+	}
+	return args
+}
+
+// nextNode 切换到下一个节点。
+func (ks *KeyScanner) nextNode() {
+	ks.nodes = ks.nodes[1:]
+	ks.cursor = 0
+}
+
+// inCluster 返回节点是否还在集群中，获取不到集群的节点信息就认为还在。
+func (ks *KeyScanner) inCluster(node string) bool {
+	nodes, err := ks.client.nodes()
+	if err != nil {
+		return true
+	}
+	return containsNode(nodes, node)
+}
+
+// addJoinedNodes 将遍历过程中新加入集群的节点添加到待遍历的节点中，返回是否有新的节点。
+func (ks *KeyScanner) addJoinedNodes() bool {
+	nodes, err := ks.client.nodes()
+	if err != nil {
+		return false
+	}
+
+	for _, node := range nodes {
+		if !ks.scanned[node] {
+			ks.scanned[node] = true
+			ks.nodes = append(ks.nodes, node)
+		}
+	}
+	return len(ks.nodes) > 0
+}
@@ -0,0 +1,22 @@
+package servers
+
+import (
+	"errors"
+	"testing"
+)
+
+// go test -v -run=^TestStatusPartial$
+func TestStatusPartial(t *testing.T) {
+	// 没有服务监听的端口，获取状态一定会失败
+	tc := newBalanceClient(BalanceRoundRobin, "127.0.0.1:1")
+
+	status, err := tc.Status()
+	var nodesErr *NodesError
+	if !errors.As(err, &nodesErr) {
+		t.Fatalf("err %v should be *NodesError", err)
+	}
+
+	if !status.Partial || status.Errors["127.0.0.1:1"] == nil || status.Count != 0 {
+		t.Fatalf("status %+v is wrong", status)
+	}
+}
@@ -0,0 +1,87 @@
+package servers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+const (
+	// watchRetryDuration 是控制连接断开之后，重新建立控制连接之前等待的时间。
+	watchRetryDuration = time.Second
+
+	// unknownTopologyVersion 表示客户端还不知道节点的拓扑版本，节点收到这个版本号会马上返回当前的拓扑。
+	unknownTopologyVersion = ^uint64(0)
+)
+
+var (
+	errWatchNotSupported = errors.New("node doesn't support watching topology")
+)
+
+// watchTopology 开启一个协程，使用单独的控制连接订阅集群拓扑的变化，一旦集群成员发生变化，就马上更新一致性哈希环。
+// 这样就不需要等到下一次定时更新，避免了在这段时间内大量的请求被重定向。
+// 因为 watch 命令会一直阻塞到拓扑变化为止，而服务端是按照顺序处理一个连接上的请求的，所以不能使用连接池中的连接。
+// 如果节点不支持 watch 命令，就只能依靠定时更新了。
+func (tc *TCPClient) watchTopology() {
+	go func() {
+		for {
+			client, err := tc.dialControl()
+			if err == errWatchNotSupported {
+				return
+			}
+
+			if err == nil {
+				tc.watchOn(client)
+				client.Close()
+			}
+
+			// 客户端已经关闭了就退出，否则等待一段时间之后重新建立控制连接
+			if sleepContext(tc.ctx, watchRetryDuration) != nil {
+				return
+			}
+		}
+	}()
+}
+
+// dialControl 和集群中的某个节点建立控制连接，如果节点不支持 watch 命令就返回 errWatchNotSupported。
+func (tc *TCPClient) dialControl() (*protocolClient, error) {
+	for _, node := range tc.balancedNodes() {
+		client, err := tc.dial(node)
+		if err != nil {
+			continue
+		}
+
+		if !client.handshake.Supports(featureWatch) {
+			client.Close()
+			return nil, errWatchNotSupported
+		}
+		return client, nil
+	}
+	return nil, errNoClientIsAvailble
+}
+
+// watchOn 使用控制连接不断地执行 watch 命令，直到连接出错或者客户端被关闭。
+func (tc *TCPClient) watchOn(client *protocolClient) {
+	version := unknownTopologyVersion
+	versionBytes := make([]byte, 8)
+	for {
+		binary.BigEndian.PutUint64(versionBytes, version)
+		body, err := client.DoContext(tc.ctx, watchCommand, client.withTraceID(helpers.NewTraceID(), [][]byte{versionBytes}))
+		if err != nil {
+			return
+		}
+
+		topology := &topology{}
+		if err = json.Unmarshal(body, topology); err != nil {
+			return
+		}
+
+		if topology.Version != version && len(topology.Nodes) > 0 {
+			tc.circle.Set(topology.Nodes)
+		}
+		version = topology.Version
+	}
+}
@@ -0,0 +1,170 @@
+package servers
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientInfo 是一个客户端连接的统计信息，用于找出是哪个应用实例在大量地访问缓存，只有 TCP 服务器才会统计。
+type ClientInfo struct {
+	// ID 是连接的编号，从 1 开始递增。
+	ID uint64 `json:"id"`
+
+	// Address 是客户端的地址。
+	Address string `json:"address"`
+
+	// Age 是连接建立了多久，单位是秒。
+	Age int64 `json:"age"`
+
+	// Idle 是连接有多久没有执行命令了，单位是秒。
+	Idle int64 `json:"idle"`
+
+	// LastCommand 是最后一次执行的命令，还没有执行过命令的话为空。
+	LastCommand string `json:"lastCommand"`
+
+	// Commands 是这个连接上执行过的命令个数。
+	Commands int64 `json:"commands"`
+
+	// BytesIn 和 BytesOut 是这个连接上读取和写入的字节数。
+	BytesIn int64 `json:"bytesIn"`
+
+	BytesOut int64 `json:"bytesOut"`
+}
+
+// clientStats 记录着一个连接上的统计数据，读取的时候不需要加锁。
+type clientStats struct {
+	// id 是连接的编号。
+	id uint64
+
+	// address 是客户端的地址。
+	address string
+
+	// connectedAt 是连接建立的时间。
+	connectedAt time.Time
+
+	// lastActive 是最后一次执行命令的时间，单位是纳秒。
+	lastActive int64
+
+	// lastCommand 是最后一次执行的命令，为 0 表示还没有执行过命令。
+	lastCommand int32
+
+	// commands 是执行过的命令个数。
+	commands int64
+
+	// bytesIn 和 bytesOut 是读取和写入的字节数。
+	bytesIn int64
+
+	bytesOut int64
+}
+
+// observe 记录一次命令的执行。
+func (cs *clientStats) observe(command byte) {
+	atomic.StoreInt64(&cs.lastActive, time.Now().UnixNano())
+	atomic.StoreInt32(&cs.lastCommand, int32(command))
+	atomic.AddInt64(&cs.commands, 1)
+}
+
+// info 返回连接当前的统计信息。
+func (cs *clientStats) info(now time.Time) ClientInfo {
+	info := ClientInfo{
+		ID:       cs.id,
+		Address:  cs.address,
+		Age:      int64(now.Sub(cs.connectedAt).Seconds()),
+		Idle:     int64(now.Sub(time.Unix(0, atomic.LoadInt64(&cs.lastActive))).Seconds()),
+		Commands: atomic.LoadInt64(&cs.commands),
+		BytesIn:  atomic.LoadInt64(&cs.bytesIn),
+		BytesOut: atomic.LoadInt64(&cs.bytesOut),
+	}
+
+	if command := atomic.LoadInt32(&cs.lastCommand); command != 0 {
+		info.LastCommand = commandName(byte(command))
+	}
+	return info
+}
+
+// countingConn 是会统计读取和写入的字节数的连接。
+type countingConn struct {
+	net.Conn
+
+	// stats 是这个连接的统计数据。
+	stats *clientStats
+}
+
+func (cc *countingConn) Read(p []byte) (int, error) {
+	n, err := cc.Conn.Read(p)
+	atomic.AddInt64(&cc.stats.bytesIn, int64(n))
+	return n, err
+}
+
+func (cc *countingConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	atomic.AddInt64(&cc.stats.bytesOut, int64(n))
+	return n, err
+}
+
+// clientRegistry 记录着服务器上所有打开的连接。
+type clientRegistry struct {
+	// nextID 是下一个连接的编号。
+	nextID uint64
+
+	// clients 存储着所有打开的连接的统计数据，key 是连接的编号。
+	clients map[uint64]*clientStats
+
+	// lock 保护 clients。
+	lock *sync.Mutex
+}
+
+// newClientRegistry 返回一个空的连接记录。
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{
+		clients: map[uint64]*clientStats{},
+		lock:    &sync.Mutex{},
+	}
+}
+
+// register 记录一个新的连接，返回会统计读写字节数的连接，连接关闭的时候需要调用 unregister。
+func (cr *clientRegistry) register(conn net.Conn) (*countingConn, *clientStats) {
+	now := time.Now()
+	stats := &clientStats{
+		id:          atomic.AddUint64(&cr.nextID, 1),
+		address:     conn.RemoteAddr().String(),
+		connectedAt: now,
+		lastActive:  now.UnixNano(),
+	}
+
+	cr.lock.Lock()
+	cr.clients[stats.id] = stats
+	cr.lock.Unlock()
+	return &countingConn{Conn: conn, stats: stats}, stats
+}
+
+// unregister 删除一个已经关闭的连接。
+func (cr *clientRegistry) unregister(stats *clientStats) {
+	cr.lock.Lock()
+	delete(cr.clients, stats.id)
+	cr.lock.Unlock()
+}
+
+// list 返回所有打开的连接的统计信息，按照连接的编号排序。
+func (cr *clientRegistry) list() []ClientInfo {
+	cr.lock.Lock()
+	all := make([]*clientStats, 0, len(cr.clients))
+	for _, stats := range cr.clients {
+		all = append(all, stats)
+	}
+	cr.lock.Unlock()
+
+	now := time.Now()
+	infos := make([]ClientInfo, 0, len(all))
+	for _, stats := range all {
+		infos = append(infos, stats.info(now))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
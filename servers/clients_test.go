@@ -0,0 +1,44 @@
+package servers
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// go test -v -run=^TestClientRegistry$
+func TestClientRegistry(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	registry := newClientRegistry()
+	conn, stats := registry.register(server)
+
+	go func() {
+		client.Write([]byte("hello"))
+		io.ReadFull(client, make([]byte, 3))
+	}()
+
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	stats.observe(getCommand)
+
+	infos := registry.list()
+	if len(infos) != 1 {
+		t.Fatalf("len(infos) %d should be 1", len(infos))
+	}
+
+	info := infos[0]
+	if info.ID != 1 || info.LastCommand != "get" || info.Commands != 1 || info.BytesIn != 5 || info.BytesOut != 3 {
+		t.Fatalf("info %+v is wrong", info)
+	}
+
+	registry.unregister(stats)
+	if infos = registry.list(); len(infos) != 0 {
+		t.Fatalf("len(infos) %d should be 0", len(infos))
+	}
+}
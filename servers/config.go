@@ -0,0 +1,23 @@
+package servers
+
+import (
+	"github.com/herrhu97/go-distributed-cache/caches"
+)
+
+// EffectiveConfig 是节点当前实际使用的选项配置，也就是默认值、配置文件、命令行参数和热加载合并之后的结果。
+// 它的格式和配置文件是一样的，所以可以直接保存成配置文件使用，敏感的凭证信息不会被输出。
+type EffectiveConfig struct {
+	// Server 是服务器的选项配置。
+	Server Options `json:"server"`
+
+	// Cache 是缓存的选项配置。
+	Cache caches.Options `json:"cache"`
+}
+
+// newEffectiveConfig 返回节点当前实际使用的选项配置。
+func newEffectiveConfig(cache *caches.Cache, n *node) *EffectiveConfig {
+	return &EffectiveConfig{
+		Server: *n.live.load(),
+		Cache:  cache.Options(),
+	}
+}
@@ -0,0 +1,152 @@
+package servers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// dashboardPath 是内置的网页控制台的访问路径，和 expvarPath 一样没有加上版本前缀，方便在浏览器中直接打开。
+	dashboardPath = "/dashboard"
+
+	// defaultHotKeysCount 是获取热点 key 时默认的 key 个数。
+	defaultHotKeysCount = 10
+
+	// maxHotKeysCount 是获取热点 key 时最多的 key 个数，避免一次返回太多数据。
+	maxHotKeysCount = 1000
+)
+
+// dashboardPage 是网页控制台的页面，只依赖现有的 HTTP 接口，每隔几秒刷新一次。
+// 集群中的节点信息来自节点广播的元数据，热点 key 和运行时信息只有当前节点的。
+var dashboardPage = strings.Replace(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kafo dashboard</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 24px; color: #222; }
+h1 { font-size: 20px; }
+h2 { font-size: 16px; margin-top: 28px; }
+table { border-collapse: collapse; min-width: 480px; }
+th, td { border: 1px solid #ddd; padding: 4px 10px; text-align: left; font-size: 13px; }
+th { background: #f5f5f5; }
+#error { color: #c00; }
+.muted { color: #888; font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>kafo dashboard</h1>
+<div class="muted">Refreshed every 5 seconds. <span id="updated"></span></div>
+<div id="error"></div>
+
+<h2>This node</h2>
+<table id="node"></table>
+
+<h2>Cluster members</h2>
+<table id="members"></table>
+
+<h2>Hot keys on this node</h2>
+<table id="hotkeys"></table>
+
+<script>
+var api = "/{{apiVersion}}";
+
+function escape(s) {
+  return String(s).replace(/[&<>"']/g, function (c) {
+    return {"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;"}[c];
+  });
+}
+
+function bytes(n) {
+  var units = ["B", "KB", "MB", "GB", "TB"];
+  var i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return n.toFixed(i === 0 ? 0 : 1) + " " + units[i];
+}
+
+function hitRate(hits, misses) {
+  var total = (hits || 0) + (misses || 0);
+  return total === 0 ? "-" : (100 * hits / total).toFixed(1) + "%";
+}
+
+function render(id, header, rows) {
+  var html = "<tr>" + header.map(function (h) { return "<th>" + escape(h) + "</th>"; }).join("") + "</tr>";
+  rows.forEach(function (row) {
+    html += "<tr>" + row.map(function (v) { return "<td>" + escape(v) + "</td>"; }).join("") + "</tr>";
+  });
+  document.getElementById(id).innerHTML = html;
+}
+
+function get(uri) {
+  return fetch(api + uri, {credentials: "same-origin"}).then(function (response) {
+    if (!response.ok) {
+      throw new Error(uri + ": " + response.status + " " + response.statusText);
+    }
+    return response.json();
+  });
+}
+
+function refresh() {
+  Promise.all([get("/info"), get("/nodes?verbose=true"), get("/hotkeys?count=20")]).then(function (results) {
+    var info = results[0], members = results[1] || [], hotKeys = results[2] || [];
+    render("node", ["Field", "Value"], [
+      ["Address", info.address],
+      ["Version", info.version],
+      ["Role", info.role],
+      ["Uptime", info.uptime + "s"],
+      ["Entries", info.status.count],
+      ["Data size", bytes(info.status.keySize + info.status.valueSize)],
+      ["Hit rate", hitRate(info.hits, info.misses) + " (" + info.hits + " hits, " + info.misses + " misses)"],
+      ["Eviction policy", info.evictionPolicy],
+      ["Connections", info.connections],
+      ["Heap in use", bytes(info.runtime.heapInuse)],
+      ["Goroutines", info.runtime.goroutines]
+    ]);
+    render("members", ["Address", "State", "Version", "Uptime", "Entries", "Data size", "Hit rate"], members.map(function (m) {
+      return [m.address, m.state, m.version || "-", m.uptime ? m.uptime + "s" : "-", m.count, bytes(m.memory), hitRate(m.hits, m.misses)];
+    }));
+    render("hotkeys", ["Key", "Hits"], hotKeys.map(function (k) {
+      return [k.key, k.hits];
+    }));
+    document.getElementById("error").textContent = "";
+    document.getElementById("updated").textContent = "Last update: " + new Date().toLocaleTimeString();
+  }).catch(function (err) {
+    document.getElementById("error").textContent = err.message;
+  });
+}
+
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`, "{{apiVersion}}", APIVersion, -1)
+
+// dashboardHandler 用于输出网页控制台的页面。
+func (hs *HTTPServer) dashboardHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Write([]byte(dashboardPage))
+}
+
+// hotKeysHandler 用于获取当前节点上访问次数最多的 key，支持 count 查询参数。
+func (hs *HTTPServer) hotKeysHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	count, err := intQueryOf(request.URL.Query(), "count", defaultHotKeysCount)
+	if err != nil || count < 1 || count > maxHotKeysCount {
+		writeError(writer, http.StatusBadRequest, errorCodeBadRequest, "count must be an integer between 1 and "+strconv.Itoa(maxHotKeysCount))
+		return
+	}
+
+	body, err := json.Marshal(hs.cache.HotKeys(count))
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
+		return
+	}
+	writer.Write(body)
+}
@@ -0,0 +1,26 @@
+package servers
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// etagOf 返回 value 的 ETag，使用的是 value 的 FNV-1a 哈希值，并按照 HTTP 的规范使用双引号包起来。
+func etagOf(value []byte) string {
+	hash := fnv.New64a()
+	hash.Write(value)
+	return strconv.Quote(strconv.FormatUint(hash.Sum64(), 16))
+}
+
+// matchETag 判断 If-Match 或者 If-None-Match 头部中的 ETag 列表是否和 etag 匹配。
+// 头部的值可以是 "*"，表示匹配任意 ETag，也可以是使用逗号分隔的多个 ETag，弱 ETag 的 W/ 前缀会被忽略。
+func matchETag(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package servers
+
+import (
+	"expvar"
+	"net/http"
+	"sync"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+	"github.com/herrhu97/go-distributed-cache/metrics"
+)
+
+const (
+	// expvarPath 是 expvar 标准的访问路径，没有加上版本前缀是为了兼容现有的调试工具。
+	expvarPath = "/debug/vars"
+)
+
+var (
+	// publishExpvarOnce 保证内部的计数器只会发布一次，因为 expvar 重复发布同一个名字会 panic。
+	publishExpvarOnce = &sync.Once{}
+)
+
+// publishExpvar 把内部的计数器发布到 expvar 上，包括所有的指标、每种命令的执行次数、每个 segment 的数据情况和锁竞争情况。
+// 一个程序中一般只有一个 HTTP 服务器，如果创建了多个，segment 的数据情况是第一个服务器的缓存的。
+func publishExpvar(cache *caches.Cache) {
+	publishExpvarOnce.Do(func() {
+		expvar.Publish("kafo.metrics", metrics.Expvar(metrics.Default))
+		expvar.Publish("kafo.commands", expvar.Func(commandTotals))
+		expvar.Publish("kafo.segments", expvar.Func(func() interface{} {
+			return cache.SegmentStatus()
+		}))
+		expvar.Publish("kafo.contention", expvar.Func(func() interface{} {
+			return cache.SegmentContention()
+		}))
+	})
+}
+
+// commandTotals 返回每种 TCP 命令的执行次数，key 是命令的名字。
+func commandTotals() interface{} {
+	totals := map[string]int64{}
+	for _, sample := range metrics.Default.Snapshot() {
+		if sample.Name != "kafo_server_commands_total" || len(sample.Labels) < 2 {
+			continue
+		}
+		totals[sample.Labels[1]] = int64(sample.Value)
+	}
+	return totals
+}
+
+// expvarHandler 用于输出 expvar 中发布的所有变量，包括 Go 运行时自带的 memstats 和 cmdline。
+func (hs *HTTPServer) expvarHandler() http.Handler {
+	publishExpvar(hs.cache)
+	return expvar.Handler()
+}
@@ -0,0 +1,87 @@
+package servers
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+var (
+	// faultRedirectCommands 是可以被强制重定向的命令，这些命令在所属节点不是当前节点的时候本来就会返回重定向。
+	faultRedirectCommands = map[byte]bool{
+		getCommand:    true,
+		setCommand:    true,
+		deleteCommand: true,
+		incrCommand:   true,
+		expireCommand: true,
+		existsCommand: true,
+	}
+)
+
+// faultInjector 按照选项配置向 TCP 服务器中注入延迟、丢弃响应和强制重定向这些故障，用于自动化地测试客户端的重试和重新均衡逻辑。
+// 配置都是可以热加载的，所以测试中可以随时开启和关闭故障。为 nil 的时候不会注入任何故障。
+type faultInjector struct {
+	// options 存储着服务器最新的选项配置。
+	options *liveOptions
+
+	// redirectTarget 返回强制重定向的目标节点，没有可以重定向的节点时返回 false。
+	redirectTarget func() (string, bool)
+}
+
+// newFaultInjector 返回一个使用 options 配置的故障注入器，开启了故障注入的话会打印一条警告日志，避免在生产环境中误开启。
+func newFaultInjector(options *liveOptions, redirectTarget func() (string, bool)) *faultInjector {
+	if o := options.load(); o.FaultLatency > 0 || o.FaultDropPercent > 0 || o.FaultRedirectPercent > 0 {
+		helpers.Warn("Fault injection is enabled, it should only be used for testing",
+			"latency", o.FaultLatency, "dropPercent", o.FaultDropPercent, "redirectPercent", o.FaultRedirectPercent)
+	}
+	return &faultInjector{options: options, redirectTarget: redirectTarget}
+}
+
+// before 在处理请求之前注入延迟，需要强制重定向的话返回重定向的错误，这个时候命令就不会执行了。
+func (fi *faultInjector) before(command byte) error {
+	if fi == nil {
+		return nil
+	}
+
+	options := fi.options.load()
+	if options.FaultLatency > 0 {
+		time.Sleep(options.FaultLatency)
+	}
+
+	if !faultRedirectCommands[command] || !hit(options.FaultRedirectPercent) {
+		return nil
+	}
+
+	node, ok := fi.redirectTarget()
+	if !ok {
+		return nil
+	}
+	return fmt.Errorf("redirect to node %s", node)
+}
+
+// drop 返回是否需要丢弃这个请求的响应。
+func (fi *faultInjector) drop() bool {
+	return fi != nil && hit(fi.options.load().FaultDropPercent)
+}
+
+// hit 以 percent 的概率返回 true。
+func hit(percent int) bool {
+	return percent > 0 && rand.Intn(100) < percent
+}
+
+// redirectTarget 随机返回集群中除了当前节点以外的一个节点。
+func (n *node) redirectTarget() (string, bool) {
+	var others []string
+	for _, node := range n.nodes() {
+		if !n.isCurrentNode(node) {
+			others = append(others, node)
+		}
+	}
+
+	if len(others) == 0 {
+		return "", false
+	}
+	return others[rand.Intn(len(others))], true
+}
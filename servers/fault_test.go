@@ -0,0 +1,66 @@
+package servers
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// go test -v -run=^TestFaultInjector$
+func TestFaultInjector(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	options := DefaultOptions()
+	options.FaultRedirectPercent = 100
+	live := newLiveOptions(&options)
+	ps := newProtocolServer(live)
+	ps.faults = newFaultInjector(live, func() (string, bool) {
+		return "127.0.0.1:5838", true
+	})
+
+	handled := int32(0)
+	ps.RegisterHandler(getCommand, func(args [][]byte) ([]byte, error) {
+		atomic.AddInt32(&handled, 1)
+		return args[0], nil
+	})
+	go ps.handleConn(server)
+
+	request := bytes.NewBuffer(nil)
+	writeRequestTo(request, getCommand, [][]byte{[]byte("key")}, false)
+	if _, err := client.Write(request.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 强制重定向的命令不会执行
+	reply, body, err := readResponseFrom(client, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if node, ok := redirectNodeOf(newReplyError(string(body))); reply != errorReply || !ok || node != "127.0.0.1:5838" {
+		t.Fatalf("reply %d with body %s should be a redirect", reply, body)
+	}
+
+	if n := atomic.LoadInt32(&handled); n != 0 {
+		t.Fatalf("handled %d should be 0", n)
+	}
+
+	// 丢弃响应的命令会执行，但是连接会被关闭
+	options.FaultRedirectPercent = 0
+	options.FaultDropPercent = 100
+	live.reload(options)
+	if _, err = client.Write(request.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = readResponseFrom(client, false); err != io.EOF {
+		t.Fatalf("err %v should be io.EOF", err)
+	}
+
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Fatalf("handled %d should be 1", n)
+	}
+}
@@ -0,0 +1,53 @@
+package servers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// FeatureReplication 是异步复制的功能，开启之后 ReplicationFactor 大于 1 的时候才会把数据复制到后续的节点上。
+	FeatureReplication = "replication"
+)
+
+// experimentalFeatures 记录着所有的实验性功能以及它们的说明，实验性功能默认都是关闭的，需要在 Options.Features 中开启。
+// 新的子系统可以先作为实验性功能发布，稳定之后再从这里移除，变成默认开启的功能。
+var experimentalFeatures = map[string]string{
+	FeatureReplication: "asynchronous replication of keys to the following nodes in the circle",
+}
+
+// ExperimentalFeatures 返回所有实验性功能的名字，已经按照字母顺序排好序了。
+func ExperimentalFeatures() []string {
+	features := make([]string, 0, len(experimentalFeatures))
+	for feature := range experimentalFeatures {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return features
+}
+
+// FeatureEnabled 返回实验性功能 feature 是否开启了。
+func (o *Options) FeatureEnabled(feature string) bool {
+	for _, enabled := range o.Features {
+		if enabled == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFeatures 校验开启的实验性功能是否都存在，以及使用到的实验性功能是否都开启了。
+func (o *Options) validateFeatures() error {
+	for _, feature := range o.Features {
+		if _, ok := experimentalFeatures[feature]; !ok {
+			return fmt.Errorf("unknown feature %q in Features: must be one of %s", feature, strings.Join(ExperimentalFeatures(), ", "))
+		}
+	}
+
+	// 没有开启复制功能却设置了 ReplicationFactor 的话，直接报错比悄悄不复制更好
+	if o.ReplicationFactor > 1 && !o.FeatureEnabled(FeatureReplication) {
+		return fmt.Errorf("invalid ReplicationFactor %d: feature %s is experimental and should be enabled first", o.ReplicationFactor, FeatureReplication)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package servers
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// ProtocolVersion 是当前服务支持的最高命令协议版本。
+	// 注意这个版本和底层传输帧的版本不是一回事，它描述的是命令集合和命令参数的格式。
+	ProtocolVersion = byte(1)
+
+	// minProtocolVersion 是当前服务还能兼容的最低命令协议版本。
+	minProtocolVersion = byte(1)
+)
+
+const (
+	// featureInfo 表示支持 info 命令。
+	featureInfo = "info"
+
+	// featurePing 表示支持 ping 命令。
+	featurePing = "ping"
+
+	// featureCRC 表示握手之后的每个请求帧和响应帧都会带上 CRC32 校验码，用于在不稳定的网络中发现数据损坏。
+	featureCRC = "crc"
+
+	// featureTrace 表示握手之后的每个请求帧的第一个参数都是追踪 ID。
+	featureTrace = "trace"
+
+	// featureBatch 表示支持 mget 和 mset 批量命令。
+	featureBatch = "batch"
+
+	// featureWatch 表示支持 watch 命令，客户端可以通过这个命令订阅集群拓扑的变化。
+	featureWatch = "watch"
+
+	// featurePubSub 表示支持 publish 和 subscribe 命令。
+	featurePubSub = "pubsub"
+
+	// featureChunked 表示支持 chunkset、chunkget 和 chunk 命令，很大的数据可以分成多个帧传输。
+	featureChunked = "chunked"
+)
+
+var (
+	// supportedFeatures 是当前服务支持的所有特性。
+	// 新增命令或者新的协议能力时，需要在这里添加对应的特性，这样客户端就可以通过握手知道服务端是否支持。
+	supportedFeatures = []string{featureInfo, featurePing, featureCRC, featureTrace, featureBatch, featureWatch, featurePubSub, featureChunked}
+
+	// clientFeatures 是 TCPClient 默认提出的特性，握手时客户端只会提出自己支持的特性。
+	// 校验特性会增加开销，所以只有在选项配置中开启了才会提出。
+	clientFeatures = []string{featureInfo, featurePing, featureTrace, featureBatch, featureWatch, featurePubSub, featureChunked}
+
+	errHandshakeNeedsVersion = errors.New("handshake needs protocol version")
+)
+
+const (
+	// HashCRC32 是使用 CRC32 作为哈希函数的一致性哈希，也是目前唯一支持的哈希函数。
+	HashCRC32 = "crc32"
+)
+
+// HashConfig 是一致性哈希的配置，客户端必须和服务端使用一样的配置，否则客户端计算出来的节点就是错的，会导致大量的重定向。
+type HashConfig struct {
+	// Function 是一致性哈希使用的哈希函数。
+	Function string `json:"function"`
+
+	// VirtualNodes 是每个节点的虚拟节点数。
+	VirtualNodes int `json:"virtualNodes"`
+}
+
+// Handshake 是握手的结果，包含了协商后的协议版本和双方都支持的特性。
+type Handshake struct {
+	// Version 是协商后的协议版本。
+	Version byte `json:"version"`
+
+	// Features 是双方都支持的特性。
+	Features []string `json:"features"`
+
+	// Hash 是服务端使用的一致性哈希配置，旧版本的服务端不会返回这个配置。
+	Hash *HashConfig `json:"hash,omitempty"`
+}
+
+// Supports 返回协商结果中是否包含某个特性。
+func (h *Handshake) Supports(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate 使用客户端的协议版本和特性进行协商，返回协商的结果。
+// 协商后的版本是双方版本中较低的那个，如果低于服务端能兼容的最低版本就返回错误。
+func negotiate(clientVersion byte, clientFeatures []string) (*Handshake, error) {
+	if clientVersion < minProtocolVersion {
+		return nil, fmt.Errorf("protocol version %d is too old, the minimum is %d", clientVersion, minProtocolVersion)
+	}
+
+	version := clientVersion
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+
+	server := &Handshake{Features: supportedFeatures}
+	features := make([]string, 0, len(clientFeatures))
+	for _, feature := range clientFeatures {
+		if server.Supports(feature) {
+			features = append(features, feature)
+		}
+	}
+
+	return &Handshake{
+		Version:  version,
+		Features: features,
+	}, nil
+}
+
+// handshakeArgs 将协议版本和特性编码成握手命令的参数，第一个参数是协议版本，后面的参数都是特性。
+func handshakeArgs(version byte, features []string) [][]byte {
+	args := make([][]byte, 0, len(features)+1)
+	args = append(args, []byte{version})
+	for _, feature := range features {
+		args = append(args, []byte(feature))
+	}
+	return args
+}
+
+// parseHandshakeArgs 从握手命令的参数中解析出协议版本和特性。
+func parseHandshakeArgs(args [][]byte) (byte, []string, error) {
+	if len(args) < 1 || len(args[0]) != 1 {
+		return 0, nil, errHandshakeNeedsVersion
+	}
+
+	features := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		features = append(features, string(arg))
+	}
+	return args[0][0], features, nil
+}
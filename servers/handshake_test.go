@@ -0,0 +1,23 @@
+package servers
+
+import "testing"
+
+// go test -v -run=^TestNegotiate$
+func TestNegotiate(t *testing.T) {
+	handshake, err := negotiate(ProtocolVersion+1, []string{featurePing, "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if handshake.Version != ProtocolVersion {
+		t.Fatalf("version %d is wrong", handshake.Version)
+	}
+
+	if !handshake.Supports(featurePing) || handshake.Supports("unknown") {
+		t.Fatalf("features %v are wrong", handshake.Features)
+	}
+
+	if _, err = negotiate(minProtocolVersion-1, nil); err == nil {
+		t.Fatal("negotiate with an old version should fail")
+	}
+}
@@ -1,17 +1,34 @@
 package servers
 
 import (
-	"cache-server/caches"
-	"cache-server/helpers"
+	"context"
 	"encoding/json"
-	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
+	"sync"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+const (
+	// defaultKeysCount 是分页获取 key 时每一页默认的 key 个数。
+	defaultKeysCount = 100
+)
+
+// keysResult 是分页获取 key 的结果。
+type keysResult struct {
+	// Keys 是这一页的 key。
+	Keys []string `json:"keys"`
+
+	// Cursor 是下一页的游标，为 0 说明已经没有下一页了。
+	Cursor int `json:"cursor"`
+}
+
 // HTTPServer 是http服务器结构
 type HTTPServer struct {
 	// node 是用于记录集群信息的实例
@@ -22,28 +39,98 @@ type HTTPServer struct {
 
 	// options 存储着这个服务器的选项配置
 	options *Options
+
+	// authenticator 是 HTTP 接口的认证器
+	authenticator *authenticator
+
+	// auditor 记录成功执行的写操作，没有配置审计日志的话是 nil。
+	auditor *auditor
+
+	// listeners 是服务器运行之后创建的监听器，关闭服务器的时候需要关闭它们。
+	listeners []net.Listener
+
+	// closed 表示服务器是否已经关闭了，用于区分监听器是被关闭了还是出错了。
+	closed bool
+
+	// lock 用于保护 listeners 和 closed。
+	lock sync.Mutex
 }
 
 // NewHTTPServer 返回一个关于cache的新HTTP服务器
 func NewHTTPServer(cache *caches.Cache, options *Options) (*HTTPServer, error) {
-	n, err := newNode(options)
+	authenticator, err := newAuthenticator(options)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = newBackingStore(cache, options); err != nil {
+		return nil, err
+	}
+
+	auditor, err := newAuditor(options)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := newNode(options, cache)
+	if err != nil {
+		auditor.Close()
+		return nil, err
+	}
+
 	return &HTTPServer{
-		node:    n,
-		cache:   cache,
-		options: options,
+		node:          n,
+		cache:         cache,
+		options:       options,
+		authenticator: authenticator,
+		auditor:       auditor,
 	}, nil
 }
 
-// Run 启动服务器
+// Run 启动服务器，直到服务器被关闭。
 func (hs *HTTPServer) Run() error {
-	return http.ListenAndServe(
-		helpers.JoinAddressAndPort(
-			hs.options.Address, hs.options.Port),
-		hs.routerHandler())
+	defer hs.auditor.Close()
+	listeners, err := listen(hs.options)
+	if err != nil {
+		return err
+	}
+
+	hs.lock.Lock()
+	hs.listeners = listeners
+	hs.lock.Unlock()
+
+	// 每个监听器都使用单独的协程去 accept 连接，任何一个监听器出错都会返回
+	handler := hs.routerHandler()
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(l net.Listener) {
+			server := &http.Server{Handler: handler, ConnState: trackConnState}
+			errs <- server.Serve(l)
+		}(listener)
+	}
+
+	err = <-errs
+	closeListeners(listeners)
+
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	if hs.closed {
+		return nil
+	}
+	return err
+}
+
+// Close 关闭服务器，会先离开集群，让其他节点尽快把 key 分配给别的节点。
+func (hs *HTTPServer) Close() error {
+	nodeErr := hs.node.close()
+
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.closed = true
+	if err := closeListeners(hs.listeners); err != nil {
+		return err
+	}
+	return nodeErr
 }
 
 // wrapUriWithVersion 会用 API 版本去包装 uri，比如 "v1" 版本的 API 包装 "/cache" 就会变成 "/v1/cache"。
@@ -57,9 +144,18 @@ func (hs *HTTPServer) routerHandler() http.Handler {
 	router.GET(wrapUriWithVersion("/cache/:key"), hs.getHandler)
 	router.PUT(wrapUriWithVersion("/cache/:key"), hs.setHandler)
 	router.DELETE(wrapUriWithVersion("/cache/:key"), hs.deleteHandler)
+	router.GET(wrapUriWithVersion("/keys"), hs.keysHandler)
 	router.GET(wrapUriWithVersion("/status"), hs.statusHandler)
 	router.GET(wrapUriWithVersion("/nodes"), hs.nodesHandler)
-	return router
+	router.GET(wrapUriWithVersion("/info"), hs.infoHandler)
+	router.GET(wrapUriWithVersion("/hotkeys"), hs.hotKeysHandler)
+	router.POST(wrapUriWithVersion("/admin/reload"), hs.reloadHandler)
+	router.GET(wrapUriWithVersion("/admin/config"), hs.configHandler)
+	router.PUT(wrapUriWithVersion("/admin/eviction-policy/:policy"), hs.evictionPolicyHandler)
+	router.PUT(wrapUriWithVersion("/admin/log-level/:level"), hs.logLevelHandler)
+	router.Handler(http.MethodGet, expvarPath, hs.expvarHandler())
+	router.GET(dashboardPath, hs.dashboardHandler)
+	return traceHandler(hs.live, hs.authenticator.wrap(router))
 }
 
 // getHandler 用于获取缓存数据
@@ -67,24 +163,48 @@ func (hs *HTTPServer) getHandler(writer http.ResponseWriter, request *http.Reque
 	key := params.ByName("key")
 	node, err := hs.selectNode(key)
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
 		return
 	}
 
 	// 非当前节点告知正确节点，直接返回
 	if !hs.isCurrentNode(node) {
-		writer.Header().Set("Location", node+request.RequestURI)
-		writer.WriteHeader(http.StatusTemporaryRedirect)
+		redirectTo(writer, node, request)
 		return
 	}
 
-	value, ok := hs.cache.Get(key)
+	ctx, cancel := requestContext(request.Context(), hs.live.load())
+	defer cancel()
+
+	entry, ok, err := hs.cache.GetEntryContext(ctx, key)
+	if err != nil {
+		// 请求超时、被取消或者缓存正在持久化，返回 503 错误码
+		writeUnavailable(writer, err)
+		return
+	}
 	if !ok {
 		// 返回 404 错误码
-		writer.WriteHeader(http.StatusNotFound)
+		writeError(writer, http.StatusNotFound, errorCodeNotFound, "key not found")
 		return
 	}
-	writer.Write(value)
+
+	// 通过响应头返回数据剩余的寿命和创建时间，Ttl 头部和设置数据时使用的是同一个，0 表示永不过期
+	value := entry.Value
+	writer.Header().Set("Ttl", strconv.FormatInt(entry.Ttl, 10))
+	writer.Header().Set("Ctime", strconv.FormatInt(entry.Ctime, 10))
+	if entry.Stale {
+		// 过期的旧数据使用 HTTP 缓存中约定的 110 警告标记出来，后台正在重新加载
+		writer.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+
+	// 如果客户端缓存的 ETag 和当前数据的一致，就返回 304 错误码，不需要再传输一次数据，节省带宽
+	etag := etagOf(value)
+	writer.Header().Set("ETag", etag)
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" && matchETag(ifNoneMatch, etag) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeValue(writer, request, value)
 }
 
 // setHandler 用于保存缓存数据
@@ -93,44 +213,79 @@ func (hs *HTTPServer) setHandler(writer http.ResponseWriter, request *http.Reque
 
 	node, err := hs.selectNode(key)
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
 		return
 	}
 
 	if !hs.isCurrentNode(node) {
-		writer.Header().Set("Location", node+request.RequestURI)
-		writer.WriteHeader(http.StatusTemporaryRedirect)
+		redirectTo(writer, node, request)
 		return
 	}
 
-	value, err := ioutil.ReadAll(request.Body)
+	// 缓存中保存的是数据的副本，所以请求体使用的缓冲区在处理完之后就可以放回池中了
+	buf := getBuffer()
+	defer buf.release()
+	value, err := readValue(request, hs.live.load().MaxArgSize, buf)
+	if err == errValueTooLarge {
+		writeError(writer, http.StatusRequestEntityTooLarge, errorCodeEntryTooLarge, err.Error())
+		return
+	}
 	if err != nil {
-		// 返回 500 错误码
-		writer.WriteHeader(http.StatusInternalServerError)
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
 		return
 	}
 
 	// 从请求中获取ttl
 	ttl, err := ttlOf(request)
 	if err != nil {
-		// 返回500错误码
-		writer.WriteHeader(http.StatusInternalServerError)
+		// ttl 不合法，返回 400 错误码
+		writeError(writer, http.StatusBadRequest, errorCodeBadRequest, "invalid ttl: "+err.Error())
 		return
 	}
 
+	ctx, cancel := requestContext(request.Context(), hs.live.load())
+	defer cancel()
+
 	// 添加数据，并设置为指定的ttl
-	err = hs.cache.SetWithTTL(key, value, ttl)
+	// 如果请求带有 If-Match 头部，就只有当前数据的 ETag 匹配时才会添加，用于避免并发更新时丢失数据
+	applied := true
+	if ifMatch := request.Header.Get("If-Match"); ifMatch != "" {
+		applied, err = hs.cache.SetIf(ctx, key, value, ttl, func(oldValue []byte, ok bool) bool {
+			return ok && matchETag(ifMatch, etagOf(oldValue))
+		})
+	} else {
+		err = hs.cache.SetWithTTLContext(ctx, key, value, ttl)
+	}
+
+	if err == context.DeadlineExceeded || err == context.Canceled || err == caches.ErrBusyDumping {
+		writeUnavailable(writer, err)
+		return
+	}
 	if err != nil {
 		// 如果返回了错误，说明触发了写满保护机制，返回 413 错误码，这个错误码表示请求体中的数据太大了
-		// 同时返回错误信息，加上一个 "Error: " 的前缀，方便识别为错误码
-		writer.WriteHeader(http.StatusRequestEntityTooLarge)
-		writer.Write([]byte("Error: " + err.Error()))
+		writeError(writer, http.StatusRequestEntityTooLarge, errorCodeEntryTooLarge, err.Error())
 		return
 	}
+
+	// If-Match 的条件不满足，返回 412 错误码
+	if !applied {
+		writeError(writer, http.StatusPreconditionFailed, errorCodePreconditionFailed, "etag does not match")
+		return
+	}
+
 	// 成功添加就返回 201 的状态码，其实 200 的状态码也可以，不过 201 的语义更符合，所以就选了这个状态码
+	hs.auditor.record(request.RemoteAddr, userOf(request), "set", key)
+	writer.Header().Set("ETag", etagOf(value))
 	writer.WriteHeader(http.StatusCreated)
 }
 
+// redirectTo 将请求重定向到正确的节点上。
+// 节点地址中可能带有 IPv6 的方括号，所以需要拼接出完整的 URL，而不是直接把节点地址当作路径。
+func redirectTo(writer http.ResponseWriter, node string, request *http.Request) {
+	writer.Header().Set("Location", "http://"+node+request.RequestURI)
+	writer.WriteHeader(http.StatusTemporaryRedirect)
+}
+
 // ttlOf从请求中解析ttl并返回，如果error不为空，说明ttl解析出错
 func ttlOf(request *http.Request) (int64, error) {
 	// 从请求头中获取 ttl 头部，如果没有设置或者 ttl 为空均按不设置 ttl 处理，也就是不会过期
@@ -146,21 +301,68 @@ func (hs *HTTPServer) deleteHandler(writer http.ResponseWriter, r *http.Request,
 	key := params.ByName("key")
 	node, err := hs.selectNode(key)
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
 		return
 	}
 
 	if !hs.isCurrentNode(node) {
-		writer.Header().Set("Location", node+r.RequestURI)
-		writer.WriteHeader(http.StatusTemporaryRedirect)
+		redirectTo(writer, node, r)
 		return
 	}
 
-	err = hs.cache.Delete(key)
+	ctx, cancel := requestContext(r.Context(), hs.live.load())
+	defer cancel()
+
+	existed, err := hs.cache.DeleteContext(ctx, key)
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+		writeUnavailable(writer, err)
 		return
 	}
+
+	// 数据不存在就返回 404 错误码，成功删除就返回 204 状态码
+	if !existed {
+		writeError(writer, http.StatusNotFound, errorCodeNotFound, "key not found")
+		return
+	}
+	hs.auditor.record(r.RemoteAddr, userOf(r), "delete", key)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// keysHandler 用于分页获取当前节点上的 key，支持 prefix、cursor 和 count 三个查询参数
+// 注意数据是分布在集群的各个节点上的，所以这里只会返回当前节点上的 key
+func (hs *HTTPServer) keysHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	query := request.URL.Query()
+	cursor, err := intQueryOf(query, "cursor", 0)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, errorCodeBadRequest, "invalid cursor: "+err.Error())
+		return
+	}
+
+	count, err := intQueryOf(query, "count", defaultKeysCount)
+	if err != nil || count < 1 {
+		writeError(writer, http.StatusBadRequest, errorCodeBadRequest, "count must be a positive integer")
+		return
+	}
+
+	keys, nextCursor := hs.cache.Keys(query.Get("prefix"), cursor, count)
+	body, err := json.Marshal(&keysResult{
+		Keys:   keys,
+		Cursor: nextCursor,
+	})
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
+		return
+	}
+	writer.Write(body)
+}
+
+// intQueryOf 从查询参数中解析出整数，如果没有这个参数就返回 defaultValue
+func intQueryOf(query url.Values, name string, defaultValue int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
 }
 
 // statusHandler 用于获取缓存键值对的个数
@@ -168,18 +370,73 @@ func (hs *HTTPServer) statusHandler(writer http.ResponseWriter, request *http.Re
 	status, err := json.Marshal(hs.cache.Status())
 
 	if err != nil {
-		// 返回 500 错误码
-		writer.WriteHeader(http.StatusInternalServerError)
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
 		return
 	}
 	writer.Write(status)
 }
 
+// nodesHandler 用于获取集群所有节点的名称，带上 verbose=true 参数的话会返回每个节点的详细信息
 func (hs *HTTPServer) nodesHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-	nodes, err := json.Marshal(hs.nodes())
+	var nodes []byte
+	var err error
+	if request.URL.Query().Get(verboseArg) == "true" {
+		nodes, err = json.Marshal(hs.nodeInfos())
+	} else {
+		nodes, err = json.Marshal(hs.nodes())
+	}
+
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
 		return
 	}
 	writer.Write(nodes)
 }
+
+// infoHandler 用于获取服务器的详细信息
+func (hs *HTTPServer) infoHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	info, err := json.Marshal(newInfo(hs.cache, hs.node))
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
+		return
+	}
+	writer.Write(info)
+}
+
+// configHandler 用于获取节点当前实际使用的选项配置，返回的格式和配置文件一样。
+func (hs *HTTPServer) configHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	config, err := json.MarshalIndent(newEffectiveConfig(hs.cache, hs.node), "", "    ")
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(config)
+}
+
+// reloadHandler 用于重新读取配置并热加载，只对当前节点生效，成功的话返回 204 状态码。
+func (hs *HTTPServer) reloadHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if err := hs.reload(); err != nil {
+		writeError(writer, http.StatusInternalServerError, errorCodeInternal, err.Error())
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// logLevelHandler 用于修改日志级别，只对当前节点生效，成功的话返回 204 状态码。
+func (hs *HTTPServer) logLevelHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if err := setLogLevel(params.ByName("level")); err != nil {
+		writeError(writer, http.StatusBadRequest, errorCodeBadRequest, err.Error())
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// evictionPolicyHandler 用于修改缓存的淘汰策略，只对当前节点生效，成功的话返回 204 状态码。
+func (hs *HTTPServer) evictionPolicyHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if err := hs.cache.SetEvictionPolicy(params.ByName("policy")); err != nil {
+		writeError(writer, http.StatusBadRequest, errorCodeBadRequest, err.Error())
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,184 @@
+package servers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ScopeRead 是读取数据的权限，对应 GET 和 HEAD 请求。
+	ScopeRead = "read"
+
+	// ScopeWrite 是修改数据的权限，对应 PUT 和 DELETE 等请求。
+	ScopeWrite = "write"
+
+	// ScopeAdmin 是管理的权限，对应 /admin 下的所有接口，比如热加载配置。
+	ScopeAdmin = "admin"
+
+	// apiKeyHeader 是传递 API key 使用的请求头，也可以使用 "Authorization: Bearer <key>" 的方式传递。
+	apiKeyHeader = "X-Api-Key"
+
+	// errorCodeUnauthorized 表示请求没有携带合法的凭证。
+	errorCodeUnauthorized = "unauthorized"
+
+	// errorCodeForbidden 表示凭证没有访问这个接口的权限。
+	errorCodeForbidden = "forbidden"
+)
+
+// Credential 是访问 HTTP 接口使用的凭证，可以是静态的 API key，也可以是 HTTP basic auth 的用户名和密码。
+// 这个凭证只作用于 HTTP 接口，和 TCP 服务器的认证是相互独立的。
+type Credential struct {
+	// Name 是凭证对应的身份，比如使用这个凭证的应用或者租户，会记录到审计日志中，为空的话使用 Username。
+	Name string `json:"name"`
+
+	// Key 是静态的 API key，为空说明这个凭证使用的是用户名和密码。
+	Key string `json:"key"`
+
+	// Username 是 basic auth 的用户名。
+	Username string `json:"username"`
+
+	// Password 是 basic auth 的密码。
+	Password string `json:"password"`
+
+	// Scopes 是这个凭证拥有的权限，可以是 ScopeRead、ScopeWrite 和 ScopeAdmin。
+	Scopes []string `json:"scopes"`
+}
+
+// String 返回凭证的描述，注意这里不会输出 key 和密码，避免打印选项配置的时候泄露出去。
+func (c Credential) String() string {
+	if c.Key != "" {
+		return "apiKey(" + strings.Join(c.Scopes, ",") + ")"
+	}
+	return "basic:" + c.Username + "(" + strings.Join(c.Scopes, ",") + ")"
+}
+
+// identity 返回凭证在审计日志中使用的身份，不会包含 key 和密码。
+func (c *Credential) identity() string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	if c.Key == "" {
+		return c.Username
+	}
+	return "apiKey"
+}
+
+// credentialKey 是请求使用的凭证在 context 中使用的 key。
+type credentialKey struct{}
+
+// userOf 返回请求使用的凭证对应的身份，没有开启认证的话返回空字符串。
+func userOf(request *http.Request) string {
+	credential, ok := request.Context().Value(credentialKey{}).(*Credential)
+	if !ok {
+		return ""
+	}
+	return credential.identity()
+}
+
+// hasScope 返回凭证是否拥有某个权限。
+func (c *Credential) hasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCredentials 从 JSON 格式的文件中读取凭证列表。
+func loadCredentials(file string) ([]Credential, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials []Credential
+	err = json.Unmarshal(data, &credentials)
+	return credentials, err
+}
+
+// secureEqual 使用固定时间的方式比较两个字符串，避免通过比较耗时猜出凭证。
+func secureEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// authenticator 是 HTTP 接口的认证器。
+type authenticator struct {
+	// credentials 是所有合法的凭证。
+	credentials []Credential
+}
+
+// newAuthenticator 使用选项配置中的凭证和凭证文件创建认证器。
+func newAuthenticator(options *Options) (*authenticator, error) {
+	credentials := append([]Credential{}, options.HTTPCredentials...)
+	if options.HTTPAuthFile != "" {
+		fileCredentials, err := loadCredentials(options.HTTPAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, fileCredentials...)
+	}
+	return &authenticator{credentials: credentials}, nil
+}
+
+// credentialOf 从请求中找出匹配的凭证，找不到就返回 false。
+func (a *authenticator) credentialOf(request *http.Request) (*Credential, bool) {
+	key := request.Header.Get(apiKeyHeader)
+	if key == "" {
+		if authorization := request.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+			key = strings.TrimPrefix(authorization, "Bearer ")
+		}
+	}
+
+	username, password, basic := request.BasicAuth()
+	for i := range a.credentials {
+		credential := &a.credentials[i]
+		if credential.Key != "" && key != "" && secureEqual(credential.Key, key) {
+			return credential, true
+		}
+
+		if credential.Key == "" && basic && secureEqual(credential.Username, username) && secureEqual(credential.Password, password) {
+			return credential, true
+		}
+	}
+	return nil, false
+}
+
+// scopeOf 返回请求需要的权限，管理类和调试类的请求需要 ScopeAdmin，读取类的请求需要 ScopeRead，其他请求需要 ScopeWrite。
+func scopeOf(request *http.Request) string {
+	if strings.HasPrefix(request.URL.Path, wrapUriWithVersion("/admin")+"/") || request.URL.Path == expvarPath {
+		return ScopeAdmin
+	}
+
+	if request.Method == http.MethodGet || request.Method == http.MethodHead {
+		return ScopeRead
+	}
+	return ScopeWrite
+}
+
+// wrap 使用认证器包装 handler，如果没有配置任何凭证，就直接返回 handler，也就是不开启认证。
+func (a *authenticator) wrap(handler http.Handler) http.Handler {
+	if len(a.credentials) == 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		credential, ok := a.credentialOf(request)
+		if !ok {
+			writer.Header().Set("WWW-Authenticate", `Basic realm="kafo"`)
+			writeError(writer, http.StatusUnauthorized, errorCodeUnauthorized, "missing or invalid credential")
+			return
+		}
+
+		if !credential.hasScope(scopeOf(request)) {
+			writeError(writer, http.StatusForbidden, errorCodeForbidden, "credential doesn't have "+scopeOf(request)+" scope")
+			return
+		}
+		handler.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), credentialKey{}, credential)))
+	})
+}
@@ -0,0 +1,117 @@
+package servers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// go test -v -run=^TestAuthenticatorWrap$
+func TestAuthenticatorWrap(t *testing.T) {
+	a := &authenticator{credentials: []Credential{
+		{Name: "reader", Key: "read-key", Scopes: []string{ScopeRead}},
+		{Name: "writer", Key: "write-key", Scopes: []string{ScopeRead, ScopeWrite}},
+		{Name: "admin", Key: "admin-key", Scopes: []string{ScopeAdmin}},
+		{Username: "alice", Password: "secret", Scopes: []string{ScopeRead, ScopeWrite}},
+	}}
+
+	var user string
+	handler := a.wrap(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		user = userOf(request)
+		writer.WriteHeader(http.StatusOK)
+	}))
+
+	apiKey := func(key string) func(request *http.Request) {
+		return func(request *http.Request) {
+			request.Header.Set(apiKeyHeader, key)
+		}
+	}
+
+	bearer := func(key string) func(request *http.Request) {
+		return func(request *http.Request) {
+			request.Header.Set("Authorization", "Bearer "+key)
+		}
+	}
+
+	basic := func(username string, password string) func(request *http.Request) {
+		return func(request *http.Request) {
+			request.SetBasicAuth(username, password)
+		}
+	}
+
+	anonymous := func(request *http.Request) {}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		auth   func(request *http.Request)
+		status int
+		user   string
+	}{
+		{"missing credential", http.MethodGet, "/v1/cache/key", anonymous, http.StatusUnauthorized, ""},
+		{"invalid api key", http.MethodGet, "/v1/cache/key", apiKey("wrong-key"), http.StatusUnauthorized, ""},
+		{"invalid bearer token", http.MethodGet, "/v1/cache/key", bearer("wrong-key"), http.StatusUnauthorized, ""},
+		{"read with read scope", http.MethodGet, "/v1/cache/key", apiKey("read-key"), http.StatusOK, "reader"},
+		{"head with read scope", http.MethodHead, "/v1/cache/key", apiKey("read-key"), http.StatusOK, "reader"},
+		{"put with read scope", http.MethodPut, "/v1/cache/key", apiKey("read-key"), http.StatusForbidden, ""},
+		{"delete with read scope", http.MethodDelete, "/v1/cache/key", apiKey("read-key"), http.StatusForbidden, ""},
+		{"put with write scope", http.MethodPut, "/v1/cache/key", bearer("write-key"), http.StatusOK, "writer"},
+		{"admin with write scope", http.MethodPost, "/v1/admin/reload", apiKey("write-key"), http.StatusForbidden, ""},
+		{"admin get with read scope", http.MethodGet, "/v1/admin/config", apiKey("read-key"), http.StatusForbidden, ""},
+		{"admin with admin scope", http.MethodPost, "/v1/admin/reload", apiKey("admin-key"), http.StatusOK, "admin"},
+		{"expvar with read scope", http.MethodGet, expvarPath, apiKey("read-key"), http.StatusForbidden, ""},
+		{"expvar with admin scope", http.MethodGet, expvarPath, apiKey("admin-key"), http.StatusOK, "admin"},
+		{"basic auth", http.MethodPut, "/v1/cache/key", basic("alice", "secret"), http.StatusOK, "alice"},
+		{"basic auth with wrong password", http.MethodGet, "/v1/cache/key", basic("alice", "wrong"), http.StatusUnauthorized, ""},
+		{"basic auth with unknown user", http.MethodGet, "/v1/cache/key", basic("bob", "secret"), http.StatusUnauthorized, ""},
+		{"basic auth on admin", http.MethodGet, "/v1/admin/config", basic("alice", "secret"), http.StatusForbidden, ""},
+
+		// 状态、信息和控制台这些接口没有豁免，同样需要凭证
+		{"status without credential", http.MethodGet, "/v1/status", anonymous, http.StatusUnauthorized, ""},
+		{"info without credential", http.MethodGet, "/v1/info", anonymous, http.StatusUnauthorized, ""},
+		{"dashboard without credential", http.MethodGet, dashboardPath, anonymous, http.StatusUnauthorized, ""},
+		{"status with read scope", http.MethodGet, "/v1/status", apiKey("read-key"), http.StatusOK, "reader"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			user = ""
+			request := httptest.NewRequest(c.method, c.path, nil)
+			c.auth(request)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != c.status {
+				t.Fatalf("status %d should be %d", recorder.Code, c.status)
+			}
+
+			if user != c.user {
+				t.Fatalf("user %q should be %q", user, c.user)
+			}
+
+			challenge := recorder.Header().Get("WWW-Authenticate")
+			if c.status == http.StatusUnauthorized && challenge == "" {
+				t.Fatal("unauthorized response should have WWW-Authenticate header")
+			}
+			if c.status != http.StatusUnauthorized && challenge != "" {
+				t.Fatalf("WWW-Authenticate header %q should be empty", challenge)
+			}
+		})
+	}
+}
+
+// go test -v -run=^TestAuthenticatorDisabled$
+func TestAuthenticatorDisabled(t *testing.T) {
+	a := &authenticator{}
+	handler := a.wrap(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+
+	// 没有配置任何凭证的话不开启认证，管理接口也可以直接访问
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v1/admin/reload", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d should be %d", recorder.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,65 @@
+package servers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+)
+
+const (
+	// errorCodeInternal 表示服务器内部发生了错误。
+	errorCodeInternal = "internal_error"
+
+	// errorCodeBadRequest 表示请求的参数不合法。
+	errorCodeBadRequest = "bad_request"
+
+	// errorCodeNotFound 表示数据不存在。
+	errorCodeNotFound = "not_found"
+
+	// errorCodeTimeout 表示请求处理超时或者被取消了。
+	errorCodeTimeout = "timeout"
+
+	// errorCodeEntryTooLarge 表示触发了写满保护机制，拒绝写入数据。
+	errorCodeEntryTooLarge = "entry_too_large"
+
+	// errorCodePreconditionFailed 表示 If-Match 之类的前置条件不满足。
+	errorCodePreconditionFailed = "precondition_failed"
+
+	// errorCodeBusy 表示缓存正在持久化，按照持久化的背压策略拒绝了这次请求，客户端可以稍后重试。
+	errorCodeBusy = "busy"
+)
+
+// httpError 是 HTTP 接口返回的错误信息，Code 是给程序判断用的错误码，Message 是给人看的错误描述。
+type httpError struct {
+	// Code 是错误码。
+	Code string `json:"code"`
+
+	// Message 是错误描述。
+	Message string `json:"message"`
+}
+
+// writeError 将状态码和 JSON 格式的错误信息写入响应中。
+func writeError(writer http.ResponseWriter, status int, code string, message string) {
+	body, err := json.Marshal(&httpError{
+		Code:    code,
+		Message: message,
+	})
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(body)
+}
+
+// writeUnavailable 将请求无法处理的原因写入响应中，可能是请求超时了，也可能是缓存正在持久化。
+func writeUnavailable(writer http.ResponseWriter, err error) {
+	if err == caches.ErrBusyDumping {
+		writeError(writer, http.StatusServiceUnavailable, errorCodeBusy, err.Error())
+		return
+	}
+	writeError(writer, http.StatusServiceUnavailable, errorCodeTimeout, err.Error())
+}
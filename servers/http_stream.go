@@ -0,0 +1,64 @@
+package servers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// streamChunkSize 是分块读取请求体时每一块的大小。
+	streamChunkSize = 64 * 1024
+)
+
+var (
+	errValueTooLarge = errors.New("value size exceeds the limit")
+)
+
+// readValue 从请求体中读取数据，maxSize 是数据的最大字节数，小于等于 0 表示不限制。
+// 如果请求带有 Content-Length，就直接分配刚好大小的内存一次性读取，避免 ioutil.ReadAll 扩容时反复复制和浪费内存。
+// 如果是分块传输的请求，就一块一块地读取，并且在超过 maxSize 的时候马上停止读取，不会把整个请求体都读到内存中。
+// 带有 Content-Length 的请求体是从 buf 中分配的，buf 放回池中之后就不能再访问了。
+func readValue(request *http.Request, maxSize int, buf *buffer) ([]byte, error) {
+	if maxSize > 0 && request.ContentLength > int64(maxSize) {
+		return nil, errValueTooLarge
+	}
+
+	if request.ContentLength >= 0 {
+		value := buf.alloc(int(request.ContentLength))
+		_, err := io.ReadFull(request.Body, value)
+		return value, err
+	}
+
+	body := io.Reader(request.Body)
+	if maxSize > 0 {
+		// 多读一个字节，用于判断请求体是否超过了限制
+		body = io.LimitReader(request.Body, int64(maxSize)+1)
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, streamChunkSize))
+	chunk := make([]byte, streamChunkSize)
+	for {
+		n, err := body.Read(chunk)
+		buffer.Write(chunk[:n])
+		if maxSize > 0 && buffer.Len() > maxSize {
+			return nil, errValueTooLarge
+		}
+
+		if err == io.EOF {
+			return buffer.Bytes(), nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// writeValue 将数据流式地写入响应中。
+// 这里使用的是 http.ServeContent，它会一块一块地写入数据，并且支持 Range 请求，大数据可以分段下载或者断点续传。
+func writeValue(writer http.ResponseWriter, request *http.Request, value []byte) {
+	http.ServeContent(writer, request, "", time.Time{}, bytes.NewReader(value))
+}
@@ -0,0 +1,382 @@
+package servers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+)
+
+// newTestHTTPServer 返回一个单节点的 HTTP 服务器的路由处理器，不会监听服务端口，测试结束的时候会自动关闭。
+// modify 可以在创建服务器之前修改选项配置，为 nil 的话使用默认配置。
+func newTestHTTPServer(t *testing.T, modify func(options *Options)) (*HTTPServer, http.Handler) {
+	t.Helper()
+
+	// memberlist 需要真正监听 gossip 端口，所以先找一个空闲的端口
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gossipPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	options := DefaultOptions()
+	options.Address = "127.0.0.1"
+	options.GossipPort = gossipPort
+	if modify != nil {
+		modify(&options)
+	}
+
+	cacheOptions := caches.DefaultOptions()
+	cacheOptions.DumpFile = ""
+	cache := caches.NewCacheWith(cacheOptions)
+	hs, err := NewHTTPServer(cache, &options)
+	if err != nil {
+		cache.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		hs.Close()
+		cache.Close()
+	})
+	return hs, hs.routerHandler()
+}
+
+// serveHTTP 使用 handler 处理一个请求，setup 可以在处理之前修改请求，比如设置请求头。
+func serveHTTP(handler http.Handler, method string, path string, body string, setup func(request *http.Request)) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if setup != nil {
+		setup(request)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// go test -v -run=^TestHTTPServerETag$
+func TestHTTPServerETag(t *testing.T) {
+	_, handler := newTestHTTPServer(t, nil)
+
+	recorder := serveHTTP(handler, http.MethodPut, "/v1/cache/key", "value", nil)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("status %d should be %d", recorder.Code, http.StatusCreated)
+	}
+
+	etag := recorder.Header().Get("ETag")
+	if etag != etagOf([]byte("value")) {
+		t.Fatalf("etag %s should be %s", etag, etagOf([]byte("value")))
+	}
+
+	recorder = serveHTTP(handler, http.MethodGet, "/v1/cache/key", "", nil)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "value" {
+		t.Fatalf("response %d %q should be %d %q", recorder.Code, recorder.Body.String(), http.StatusOK, "value")
+	}
+
+	if got := recorder.Header().Get("ETag"); got != etag {
+		t.Fatalf("etag %s should be %s", got, etag)
+	}
+
+	// ETag 匹配的话返回 304，并且不会返回数据，弱 ETag 和 ETag 列表也能匹配
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		recorder = serveHTTP(handler, http.MethodGet, "/v1/cache/key", "", func(request *http.Request) {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		})
+		if recorder.Code != http.StatusNotModified {
+			t.Fatalf("status %d with If-None-Match %s should be %d", recorder.Code, ifNoneMatch, http.StatusNotModified)
+		}
+
+		if recorder.Body.Len() != 0 {
+			t.Fatalf("body %q of 304 response should be empty", recorder.Body.String())
+		}
+
+		if got := recorder.Header().Get("ETag"); got != etag {
+			t.Fatalf("etag %s should be %s", got, etag)
+		}
+	}
+
+	recorder = serveHTTP(handler, http.MethodGet, "/v1/cache/key", "", func(request *http.Request) {
+		request.Header.Set("If-None-Match", `"other"`)
+	})
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "value" {
+		t.Fatalf("response %d %q should be %d %q", recorder.Code, recorder.Body.String(), http.StatusOK, "value")
+	}
+
+	// ETag 不匹配的话返回 412，并且不会修改数据
+	recorder = serveHTTP(handler, http.MethodPut, "/v1/cache/key", "new value", func(request *http.Request) {
+		request.Header.Set("If-Match", `"other"`)
+	})
+	if recorder.Code != http.StatusPreconditionFailed {
+		t.Fatalf("status %d should be %d", recorder.Code, http.StatusPreconditionFailed)
+	}
+
+	if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("content type %s should be application/json", got)
+	}
+
+	if !strings.Contains(recorder.Body.String(), errorCodePreconditionFailed) {
+		t.Fatalf("body %q should contain %s", recorder.Body.String(), errorCodePreconditionFailed)
+	}
+
+	recorder = serveHTTP(handler, http.MethodGet, "/v1/cache/key", "", nil)
+	if recorder.Body.String() != "value" {
+		t.Fatalf("value %q should not be changed", recorder.Body.String())
+	}
+
+	// ETag 匹配的话修改数据，并返回新的 ETag
+	recorder = serveHTTP(handler, http.MethodPut, "/v1/cache/key", "new value", func(request *http.Request) {
+		request.Header.Set("If-Match", etag)
+	})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("status %d should be %d", recorder.Code, http.StatusCreated)
+	}
+
+	if got := recorder.Header().Get("ETag"); got != etagOf([]byte("new value")) {
+		t.Fatalf("etag %s should be %s", got, etagOf([]byte("new value")))
+	}
+
+	// 数据不存在的时候 If-Match 也不会满足，即使是 "*"
+	recorder = serveHTTP(handler, http.MethodPut, "/v1/cache/missing", "value", func(request *http.Request) {
+		request.Header.Set("If-Match", "*")
+	})
+	if recorder.Code != http.StatusPreconditionFailed {
+		t.Fatalf("status %d should be %d", recorder.Code, http.StatusPreconditionFailed)
+	}
+}
+
+// go test -v -run=^TestHTTPServerKeys$
+func TestHTTPServerKeys(t *testing.T) {
+	hs, handler := newTestHTTPServer(t, nil)
+	for i := 0; i < 20; i++ {
+		hs.cache.Set(fmt.Sprintf("user:%d", i), []byte("value"))
+		hs.cache.Set(fmt.Sprintf("order:%d", i), []byte("value"))
+	}
+
+	// 使用 cursor 一页一页地遍历，每个 key 都只会出现一次，并且遍历完之后 cursor 会回到 0
+	seen := make(map[string]bool)
+	cursor, pages := 0, 0
+	for {
+		recorder := serveHTTP(handler, http.MethodGet, "/v1/keys?prefix=user:&count=1&cursor="+strconv.Itoa(cursor), "", nil)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status %d should be %d", recorder.Code, http.StatusOK)
+		}
+
+		var result keysResult
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range result.Keys {
+			if !strings.HasPrefix(key, "user:") {
+				t.Fatalf("key %s should have prefix user:", key)
+			}
+
+			if seen[key] {
+				t.Fatalf("key %s is returned twice", key)
+			}
+			seen[key] = true
+		}
+
+		pages++
+		cursor = result.Cursor
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if len(seen) != 20 {
+		t.Fatalf("len(seen) %d should be 20", len(seen))
+	}
+
+	if pages < 2 {
+		t.Fatalf("pages %d should be more than 1 when count is 1", pages)
+	}
+
+	// 不传参数的话从头开始遍历所有的 key
+	recorder := serveHTTP(handler, http.MethodGet, "/v1/keys", "", nil)
+	var result keysResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Keys) != 40 || result.Cursor != 0 {
+		t.Fatalf("keys %d and cursor %d should be 40 and 0", len(result.Keys), result.Cursor)
+	}
+
+	for _, query := range []string{"count=0", "count=-1", "count=abc", "cursor=abc"} {
+		recorder := serveHTTP(handler, http.MethodGet, "/v1/keys?"+query, "", nil)
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("status %d with %s should be %d", recorder.Code, query, http.StatusBadRequest)
+		}
+
+		if !strings.Contains(recorder.Body.String(), errorCodeBadRequest) {
+			t.Fatalf("body %q should contain %s", recorder.Body.String(), errorCodeBadRequest)
+		}
+	}
+}
+
+// go test -v -run=^TestHTTPServerTTLHeaders$
+func TestHTTPServerTTLHeaders(t *testing.T) {
+	_, handler := newTestHTTPServer(t, nil)
+
+	recorder := serveHTTP(handler, http.MethodPut, "/v1/cache/key", "value", func(request *http.Request) {
+		request.Header.Set("Ttl", "100")
+	})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("status %d should be %d", recorder.Code, http.StatusCreated)
+	}
+
+	recorder = serveHTTP(handler, http.MethodGet, "/v1/cache/key", "", nil)
+	ttl, err := strconv.ParseInt(recorder.Header().Get("Ttl"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl < 99 || ttl > 100 {
+		t.Fatalf("ttl %d should be about 100", ttl)
+	}
+
+	ctime, err := strconv.ParseInt(recorder.Header().Get("Ctime"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ctime <= 0 {
+		t.Fatalf("ctime %d should be positive", ctime)
+	}
+
+	// 没有设置 ttl 的数据永不过期，Ttl 头部是 0
+	serveHTTP(handler, http.MethodPut, "/v1/cache/forever", "value", nil)
+	recorder = serveHTTP(handler, http.MethodGet, "/v1/cache/forever", "", nil)
+	if got := recorder.Header().Get("Ttl"); got != "0" {
+		t.Fatalf("ttl %s should be 0", got)
+	}
+
+	recorder = serveHTTP(handler, http.MethodPut, "/v1/cache/key", "value", func(request *http.Request) {
+		request.Header.Set("Ttl", "abc")
+	})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status %d should be %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+// go test -v -run=^TestHTTPServerDelete$
+func TestHTTPServerDelete(t *testing.T) {
+	_, handler := newTestHTTPServer(t, nil)
+	serveHTTP(handler, http.MethodPut, "/v1/cache/key", "value", nil)
+
+	recorder := serveHTTP(handler, http.MethodDelete, "/v1/cache/key", "", nil)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("status %d should be %d", recorder.Code, http.StatusNoContent)
+	}
+
+	if recorder.Body.Len() != 0 {
+		t.Fatalf("body %q of 204 response should be empty", recorder.Body.String())
+	}
+
+	// 数据已经被删除了，删除和获取都返回 404 和 JSON 格式的错误信息
+	for _, method := range []string{http.MethodDelete, http.MethodGet} {
+		recorder = serveHTTP(handler, method, "/v1/cache/key", "", nil)
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("status %d of %s should be %d", recorder.Code, method, http.StatusNotFound)
+		}
+
+		if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+			t.Fatalf("content type %s should be application/json", got)
+		}
+
+		var body httpError
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		if body.Code != errorCodeNotFound || body.Message == "" {
+			t.Fatalf("error %+v should have code %s and a message", body, errorCodeNotFound)
+		}
+	}
+}
+
+// go test -v -run=^TestHTTPServerLargeValue$
+func TestHTTPServerLargeValue(t *testing.T) {
+	_, handler := newTestHTTPServer(t, func(options *Options) {
+		options.MaxArgSize = 200 * 1024
+	})
+
+	// 分块传输的请求没有 Content-Length，会一块一块地读取，超过 MaxArgSize 的时候返回 413
+	chunked := func(request *http.Request) {
+		request.ContentLength = -1
+	}
+
+	value := strings.Repeat("v", 200*1024)
+	for _, setup := range []func(request *http.Request){nil, chunked} {
+		recorder := serveHTTP(handler, http.MethodPut, "/v1/cache/key", value+"v", setup)
+		if recorder.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("status %d should be %d", recorder.Code, http.StatusRequestEntityTooLarge)
+		}
+
+		if !strings.Contains(recorder.Body.String(), errorCodeEntryTooLarge) {
+			t.Fatalf("body %q should contain %s", recorder.Body.String(), errorCodeEntryTooLarge)
+		}
+
+		recorder = serveHTTP(handler, http.MethodGet, "/v1/cache/key", "", nil)
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("status %d should be %d", recorder.Code, http.StatusNotFound)
+		}
+	}
+
+	recorder := serveHTTP(handler, http.MethodPut, "/v1/cache/key", value, chunked)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("status %d should be %d", recorder.Code, http.StatusCreated)
+	}
+
+	recorder = serveHTTP(handler, http.MethodGet, "/v1/cache/key", "", nil)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != value {
+		t.Fatalf("status %d and body length %d should be %d and %d", recorder.Code, recorder.Body.Len(), http.StatusOK, len(value))
+	}
+
+	if got := recorder.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Fatalf("accept ranges %s should be bytes", got)
+	}
+}
+
+// go test -v -run=^TestHTTPServerRange$
+func TestHTTPServerRange(t *testing.T) {
+	_, handler := newTestHTTPServer(t, nil)
+	serveHTTP(handler, http.MethodPut, "/v1/cache/key", "0123456789", nil)
+
+	ranges := []struct {
+		header       string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=0-3", http.StatusPartialContent, "0123", "bytes 0-3/10"},
+		{"bytes=5-", http.StatusPartialContent, "56789", "bytes 5-9/10"},
+		{"bytes=-2", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"bytes=20-30", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+
+	for _, r := range ranges {
+		recorder := serveHTTP(handler, http.MethodGet, "/v1/cache/key", "", func(request *http.Request) {
+			request.Header.Set("Range", r.header)
+		})
+		if recorder.Code != r.status {
+			t.Fatalf("status %d of range %s should be %d", recorder.Code, r.header, r.status)
+		}
+
+		if got := recorder.Header().Get("Content-Range"); got != r.contentRange {
+			t.Fatalf("content range %s of range %s should be %s", got, r.header, r.contentRange)
+		}
+
+		if r.status == http.StatusPartialContent && recorder.Body.String() != r.body {
+			t.Fatalf("body %q of range %s should be %q", recorder.Body.String(), r.header, r.body)
+		}
+	}
+}
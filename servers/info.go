@@ -0,0 +1,163 @@
+package servers
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+const (
+	// standaloneRole 表示当前节点是单机运行的，集群中只有它一个节点。
+	standaloneRole = "standalone"
+
+	// memberRole 表示当前节点是集群中的一个成员。
+	memberRole = "member"
+)
+
+// Info 是服务器的详细信息，比 Status 丰富得多，包含了版本、运行时间、配置、集群和后台任务等信息。
+type Info struct {
+	// Version 是服务的版本号。
+	Version string `json:"version"`
+
+	// Commit 是编译时代码的 git 提交号，没有在编译时注入的话为空。
+	Commit string `json:"commit"`
+
+	// BuildTime 是编译的时间，没有在编译时注入的话为空。
+	BuildTime string `json:"buildTime"`
+
+	// APIVersion 是服务的 API 版本。
+	APIVersion string `json:"apiVersion"`
+
+	// Address 是当前节点的访问地址。
+	Address string `json:"address"`
+
+	// Uptime 是服务已经运行的时间，单位是秒。
+	Uptime int64 `json:"uptime"`
+
+	// Role 是当前节点在集群中的角色。
+	Role string `json:"role"`
+
+	// Nodes 是集群中的节点个数。
+	Nodes int `json:"nodes"`
+
+	// ServerOptions 是服务器的选项配置。
+	ServerOptions Options `json:"serverOptions"`
+
+	// CacheOptions 是缓存的选项配置。
+	CacheOptions caches.Options `json:"cacheOptions"`
+
+	// Status 是缓存的数据情况。
+	Status caches.Status `json:"status"`
+
+	// Hits 和 Misses 是服务启动以来读取数据时命中和没有命中的次数。
+	Hits int64 `json:"hits"`
+
+	Misses int64 `json:"misses"`
+
+	// EvictionPolicy 是缓存当前使用的淘汰策略。
+	EvictionPolicy string `json:"evictionPolicy"`
+
+	// LogLevel 是当前的日志级别。
+	LogLevel string `json:"logLevel"`
+
+	// Gc 是 GC 任务的执行情况。
+	Gc caches.TaskStatus `json:"gc"`
+
+	// Dump 是持久化任务的执行情况。
+	Dump caches.TaskStatus `json:"dump"`
+
+	// Connections 是当前打开的客户端连接数，TCP 和 HTTP 服务器都会统计。
+	Connections int64 `json:"connections"`
+
+	// Runtime 是 Go 运行时的信息。
+	Runtime RuntimeInfo `json:"runtime"`
+}
+
+// RuntimeInfo 是 Go 运行时的信息。
+type RuntimeInfo struct {
+	// GoVersion 是编译使用的 Go 版本。
+	GoVersion string `json:"goVersion"`
+
+	// NumCPU 是机器的 CPU 个数。
+	NumCPU int `json:"numCPU"`
+
+	// Goroutines 是当前的协程个数。
+	Goroutines int `json:"goroutines"`
+
+	// HeapAlloc 是堆上已分配的字节数。
+	HeapAlloc uint64 `json:"heapAlloc"`
+
+	// HeapInuse 是堆上正在使用的 span 占用的字节数，包括了还没有被 GC 回收的对象，更接近堆实际占用的内存。
+	HeapInuse uint64 `json:"heapInuse"`
+
+	// NumGC 是 Go 运行时 GC 的次数。
+	NumGC uint32 `json:"numGC"`
+
+	// GCPauseTotal 是 Go 运行时 GC 累计暂停的时间，单位是纳秒。
+	GCPauseTotal uint64 `json:"gcPauseTotal"`
+
+	// LastGCPause 是最近一次 GC 暂停的时间，单位是纳秒，还没有 GC 过的话是 0。
+	LastGCPause uint64 `json:"lastGCPause"`
+}
+
+// newRuntimeInfo 返回当前的 Go 运行时信息。
+func newRuntimeInfo() RuntimeInfo {
+	memStats := &runtime.MemStats{}
+	runtime.ReadMemStats(memStats)
+	lastGCPause := uint64(0)
+	if memStats.NumGC > 0 {
+		// PauseNs 是一个环形缓冲区，最近一次 GC 的暂停时间在 (NumGC+255)%256 的位置
+		lastGCPause = memStats.PauseNs[(memStats.NumGC+255)%256]
+	}
+
+	return RuntimeInfo{
+		GoVersion:    runtime.Version(),
+		NumCPU:       runtime.NumCPU(),
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    memStats.HeapAlloc,
+		HeapInuse:    memStats.HeapInuse,
+		NumGC:        memStats.NumGC,
+		GCPauseTotal: memStats.PauseTotalNs,
+		LastGCPause:  lastGCPause,
+	}
+}
+
+// newInfo 使用缓存和节点的信息生成服务器的详细信息。
+func newInfo(cache *caches.Cache, n *node) *Info {
+	nodes := n.nodes()
+	role := memberRole
+	if len(nodes) <= 1 {
+		role = standaloneRole
+	}
+
+	cacheOptions := cache.Options()
+	evictionPolicy := cacheOptions.EvictionPolicy
+	if evictionPolicy == "" {
+		evictionPolicy = caches.EvictionPolicyNone
+	}
+
+	hits, misses := caches.HitStats()
+	return &Info{
+		Version:        Version,
+		Commit:         Commit,
+		BuildTime:      BuildTime,
+		APIVersion:     APIVersion,
+		Address:        n.address,
+		Uptime:         int64(time.Since(n.startTime).Seconds()),
+		Role:           role,
+		Nodes:          len(nodes),
+		ServerOptions:  *n.live.load(),
+		CacheOptions:   cacheOptions,
+		Status:         cache.Status(),
+		Hits:           hits,
+		Misses:         misses,
+		EvictionPolicy: evictionPolicy,
+		LogLevel:       helpers.LogLevel(),
+		Gc:             cache.GcStatus(),
+		Dump:           cache.DumpStatus(),
+		Connections:    int64(connections.Value()),
+		Runtime:        newRuntimeInfo(),
+	}
+}
@@ -0,0 +1,64 @@
+package servers
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+// listen 根据选项配置创建服务器的监听器。
+// 如果 Acceptors 大于 1，就会开启 SO_REUSEPORT 并创建多个监听同一个地址的监听器，每个监听器都会有自己的 accept 循环，
+// 由内核负责把新连接分散到这些监听器上，在多核机器上可以提高处理连接的吞吐量。
+// 如果开启了 SocketActivation，就会优先使用 systemd 传递过来的监听器。
+func listen(options *Options) ([]net.Listener, error) {
+	if options.SocketActivation {
+		return activatedListeners()
+	}
+
+	acceptors := options.Acceptors
+	if acceptors < 1 {
+		acceptors = 1
+	}
+
+	// 注意 keepalive 为 0 的时候会使用默认的时间间隔，小于 0 的时候会关闭 keepalive
+	config := &net.ListenConfig{
+		KeepAlive: options.KeepAlivePeriod,
+	}
+
+	if acceptors > 1 {
+		config.Control = func(network string, address string, conn syscall.RawConn) error {
+			var err error
+			controlErr := conn.Control(func(fd uintptr) {
+				err = setReusePort(fd)
+			})
+			if controlErr != nil {
+				return controlErr
+			}
+			return err
+		}
+	}
+
+	address := helpers.JoinAddressAndPort(options.Address, options.Port)
+	listeners := make([]net.Listener, 0, acceptors)
+	for i := 0; i < acceptors; i++ {
+		listener, err := config.Listen(context.Background(), options.Network, address)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// closeListeners 关闭所有的监听器，并返回最后一个发生的错误。
+func closeListeners(listeners []net.Listener) (err error) {
+	for _, listener := range listeners {
+		if closeErr := listener.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package servers
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/metrics"
+)
+
+var (
+	// connections 是服务器当前打开的客户端连接数。
+	connections = metrics.Default.Gauge("kafo_server_connections", "The number of open client connections.")
+
+	// flushes 是 TCP 服务器把缓冲的响应发送出去的次数，和命令的执行次数比较就可以知道平均每次发送了多少个响应。
+	flushes = metrics.Default.Counter("kafo_server_flushes_total", "The number of times buffered tcp responses are flushed.")
+)
+
+// trackConnState 根据 HTTP 连接的状态变化统计打开的连接数，被劫持的连接已经不归 HTTP 服务器管理了，所以也算作关闭。
+func trackConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		connections.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		connections.Add(-1)
+	}
+}
+
+// observeCommand 记录一次 TCP 命令的执行次数、耗时以及是否失败。
+func observeCommand(command byte, beginTime time.Time, err error) {
+	name := commandName(command)
+	metrics.Default.Counter("kafo_server_commands_total", "The number of tcp commands.", "command", name).Inc()
+	metrics.Default.Histogram("kafo_server_command_duration_seconds", "The duration of tcp commands.", nil, "command", name).Observe(time.Since(beginTime).Seconds())
+	if err != nil {
+		metrics.Default.Counter("kafo_server_command_errors_total", "The number of failed tcp commands.", "command", name).Inc()
+	}
+}
+
+// observeRequest 记录一次 HTTP 请求的执行次数和耗时。
+func observeRequest(method string, beginTime time.Time) {
+	metrics.Default.Counter("kafo_http_requests_total", "The number of http requests.", "method", method).Inc()
+	metrics.Default.Histogram("kafo_http_request_duration_seconds", "The duration of http requests.", nil, "method", method).Observe(time.Since(beginTime).Seconds())
+}
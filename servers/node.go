@@ -1,10 +1,14 @@
 package servers
 
 import (
-	"cache-server/helpers"
-	"io/ioutil"
+	"context"
+	"log"
+	"sync"
 	"time"
 
+	"github.com/herrhu97/go-distributed-cache/caches"
+	"github.com/herrhu97/go-distributed-cache/helpers"
+
 	"github.com/hashicorp/memberlist"
 	"stathat.com/c/consistent"
 )
@@ -14,6 +18,12 @@ type node struct {
 	// options 存储着一些服务器相关的选项。
 	options *Options
 
+	// live 存储着最新的选项配置，只有可以热加载的配置会和 options 不一样。
+	live *liveOptions
+
+	// reloadFunc 是收到热加载请求时调用的函数。
+	reloadFunc ReloadFunc
+
 	// address 记录的是当前节点的访问地址，包含 ip 或者主机、端口等信息。
 	address string
 
@@ -22,36 +32,113 @@ type node struct {
 
 	// nodeManager 是节点管理器，用于管理节点。
 	nodeManager *memberlist.Memberlist
+
+	// cache 是当前节点的缓存，用于在节点的元数据中广播数据的情况。
+	cache *caches.Cache
+
+	// startTime 是节点启动的时间。
+	startTime time.Time
+
+	// topologyEvents 用于接收集群成员变化的事件，事件只是一个信号，具体的成员需要重新获取。
+	topologyEvents chan struct{}
+
+	// topologyVersion 是集群拓扑的版本号，每次集群成员变化都会加一。
+	topologyVersion uint64
+
+	// topologyChanged 会在集群拓扑变化的时候被关闭，用于唤醒所有等待拓扑变化的协程，关闭之后会换成一个新的通道。
+	topologyChanged chan struct{}
+
+	// topologyLock 用于保护 topologyVersion 和 topologyChanged。
+	topologyLock *sync.RWMutex
+
+	// closed 会在节点关闭的时候被关闭，用于通知后台的协程退出。
+	closed chan struct{}
+
+	// closeOnce 保证节点只会被关闭一次。
+	closeOnce *sync.Once
 }
 
-// newNode 创建一个节点实例，并使用 options 去初始化。
-func newNode(options *Options) (*node, error) {
-	if options.Cluster == nil || len(options.Cluster) == 0 {
-		options.Cluster = []string{options.Address}
+// topology 是集群的拓扑信息。
+type topology struct {
+	// Version 是拓扑的版本号，每次集群成员变化都会加一。
+	Version uint64 `json:"version"`
+
+	// Nodes 是集群中的所有节点。
+	Nodes []string `json:"nodes"`
+}
+
+// topologyDelegate 用于接收 memberlist 的成员变化事件。
+type topologyDelegate struct {
+	events chan struct{}
+}
+
+// notify 发送一个成员变化的信号，如果已经有信号还没处理，就不需要重复发送了。
+func (td *topologyDelegate) notify() {
+	select {
+	case td.events <- struct{}{}:
+	default:
 	}
+}
 
-	nodeManager, err := createNodeManager(options)
-	if err != nil {
-		return nil, err
+func (td *topologyDelegate) NotifyJoin(node *memberlist.Node) {
+	helpers.Info("Node joined the cluster", "node", node.Name)
+	td.notify()
+}
+
+func (td *topologyDelegate) NotifyLeave(node *memberlist.Node) {
+	helpers.Info("Node left the cluster", "node", node.Name)
+	td.notify()
+}
+
+// NotifyUpdate 只是节点的元数据更新了，集群的成员并没有变化，所以不需要发送信号。
+func (td *topologyDelegate) NotifyUpdate(*memberlist.Node) {}
+
+// newNode 创建一个节点实例，并使用 options 去初始化，cache 是当前节点的缓存。
+func newNode(options *Options, cache *caches.Cache) (*node, error) {
+	if options.Cluster == nil || len(options.Cluster) == 0 {
+		options.Cluster = []string{helpers.JoinAddressAndPort(options.Address, options.GossipPort)}
 	}
 
+	topologyEvents := make(chan struct{}, 1)
 	node := &node{
-		options:     options,
-		address:     helpers.JoinAddressAndPort(options.Address, options.Port),
-		circle:      consistent.New(),
-		nodeManager: nodeManager,
+		options:         options,
+		live:            newLiveOptions(options),
+		address:         helpers.JoinAddressAndPort(options.Address, options.Port),
+		circle:          consistent.New(),
+		cache:           cache,
+		startTime:       time.Now(),
+		topologyEvents:  topologyEvents,
+		topologyChanged: make(chan struct{}),
+		topologyLock:    &sync.RWMutex{},
+		closed:          make(chan struct{}),
+		closeOnce:       &sync.Once{},
+	}
+
+	nodeManager, err := createNodeManager(options, &topologyDelegate{events: topologyEvents}, &nodeDelegate{node: node})
+	if err != nil {
+		return nil, err
 	}
 
+	node.nodeManager = nodeManager
 	node.circle.NumberOfReplicas = options.VirtualNodeCount
 	node.autoUpdateCircle()
+	node.watchTopologyEvents()
+	if cache != nil && options.AlertInterval > 0 {
+		node.watchAlerts()
+	}
 	return node, nil
 }
 
-func createNodeManager(options *Options) (*memberlist.Memberlist, error) {
+func createNodeManager(options *Options, events memberlist.EventDelegate, delegate memberlist.Delegate) (*memberlist.Memberlist, error) {
 	config := memberlist.DefaultLANConfig()
+	config.Events = events
+	config.Delegate = delegate
 	config.Name = helpers.JoinAddressAndPort(options.Address, options.Port)
-	config.BindAddr = options.Address
-	config.LogOutput = ioutil.Discard
+	config.BindAddr = helpers.TrimBrackets(options.Address)
+	config.BindPort = options.GossipPort
+	config.AdvertisePort = options.GossipPort
+	// memberlist 的日志也使用统一的日志输出，它的 debug 日志非常多，只有日志级别是 debug 的时候才会看到
+	config.Logger = log.New(helpers.LogWriter("component", "memberlist"), "", 0)
 
 	nodeManager, err := memberlist.Create(config)
 	if err != nil {
@@ -62,6 +149,11 @@ func createNodeManager(options *Options) (*memberlist.Memberlist, error) {
 	return nodeManager, err
 }
 
+// Nodes 返回集群中所有节点的地址。
+func (n *node) Nodes() []string {
+	return n.nodes()
+}
+
 func (n *node) nodes() []string {
 	members := n.nodeManager.Members()
 	nodes := make([]string, len(members))
@@ -75,6 +167,24 @@ func (n *node) selectNode(name string) (string, error) {
 	return n.circle.Get(name)
 }
 
+// replicasOf 返回保存 name 的所有节点，第一个是所属节点，后面是一致性哈希环上依次保存副本的节点。
+// 集群的节点数比副本数少的时候，每个节点都会保存一份。
+func (n *node) replicasOf(name string) ([]string, error) {
+	count := n.options.ReplicationFactor
+	if members := len(n.circle.Members()); count > members {
+		count = members
+	}
+
+	if count <= 1 {
+		node, err := n.circle.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		return []string{node}, nil
+	}
+	return n.circle.GetN(name, count)
+}
+
 func (n *node) isCurrentNode(address string) bool {
 	return n.address == address
 }
@@ -86,12 +196,74 @@ func (n *node) updateCircle() {
 func (n *node) autoUpdateCircle() {
 	n.updateCircle()
 	go func() {
-		ticker := time.NewTicker(time.Duration(n.options.UpdateCircleDuration) * time.Second)
+		ticker := time.NewTicker(n.options.UpdateCircleDuration)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				n.updateCircle()
+
+				// 顺便广播一下当前节点最新的元数据，让其他节点知道当前节点的数据情况
+				n.nodeManager.UpdateNode(time.Second)
+			case <-n.closed:
+				return
 			}
 		}
 	}()
 }
+
+// close 通知集群当前节点要离开了，然后停止 gossip 和后台的协程，离开集群失败的话其他节点会在探测失败之后把当前节点移除。
+func (n *node) close() (err error) {
+	n.closeOnce.Do(func() {
+		close(n.closed)
+		if leaveErr := n.nodeManager.Leave(time.Second); leaveErr != nil {
+			helpers.Warn("Failed to leave the cluster", "err", leaveErr)
+		}
+		err = n.nodeManager.Shutdown()
+	})
+	return err
+}
+
+// watchTopologyEvents 开启一个协程处理集群成员变化的事件，马上更新一致性哈希，并唤醒所有等待拓扑变化的协程。
+func (n *node) watchTopologyEvents() {
+	go func() {
+		for range n.topologyEvents {
+			n.updateCircle()
+
+			n.topologyLock.Lock()
+			n.topologyVersion++
+			close(n.topologyChanged)
+			n.topologyChanged = make(chan struct{})
+			n.topologyLock.Unlock()
+		}
+	}()
+}
+
+// topology 返回当前的集群拓扑。
+func (n *node) topology() *topology {
+	n.topologyLock.RLock()
+	version := n.topologyVersion
+	n.topologyLock.RUnlock()
+
+	return &topology{
+		Version: version,
+		Nodes:   n.nodes(),
+	}
+}
+
+// waitForTopology 等待集群拓扑的版本号不等于 version，然后返回最新的集群拓扑。
+// 如果等待的过程中 ctx 被取消或者超时了，就直接返回当前的集群拓扑，由调用方决定是否继续等待。
+func (n *node) waitForTopology(ctx context.Context, version uint64) *topology {
+	n.topologyLock.RLock()
+	changed := n.topologyChanged
+	current := n.topologyVersion
+	n.topologyLock.RUnlock()
+
+	if current == version {
+		select {
+		case <-changed:
+		case <-ctx.Done():
+		}
+	}
+	return n.topology()
+}
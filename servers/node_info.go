@@ -0,0 +1,153 @@
+package servers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const (
+	// verboseArg 是 nodes 命令的参数，带上这个参数会返回每个节点的详细信息，而不只是节点的地址。
+	verboseArg = "verbose"
+)
+
+// nodeStates 是 memberlist 中节点状态对应的名字。
+var nodeStates = map[memberlist.NodeStateType]string{
+	memberlist.StateAlive:   "alive",
+	memberlist.StateSuspect: "suspect",
+	memberlist.StateDead:    "dead",
+	memberlist.StateLeft:    "left",
+}
+
+// NodeInfo 是集群中某个节点的信息。
+// 除了地址和状态，其他的信息都来自节点广播的元数据，由于元数据是定期广播的，所以会有一定的延迟。
+// 不支持广播元数据的旧版本节点只会有地址、角色和状态。
+type NodeInfo struct {
+	// Address 是节点的访问地址。
+	Address string `json:"address"`
+
+	// Role 是节点在集群中的角色。
+	Role string `json:"role"`
+
+	// State 是节点的状态，可以是 alive 或者 suspect，suspect 表示节点可能已经不可用了。
+	State string `json:"state"`
+
+	// Version 是节点的服务版本号。
+	Version string `json:"version,omitempty"`
+
+	// Commit 和 BuildTime 是节点编译时代码的 git 提交号和编译的时间，用于找出集群中版本不一致的节点。
+	Commit string `json:"commit,omitempty"`
+
+	BuildTime string `json:"buildTime,omitempty"`
+
+	// Uptime 是节点已经运行的时间，单位是秒。
+	Uptime int64 `json:"uptime,omitempty"`
+
+	// Count 是节点上的数据个数。
+	Count int `json:"count"`
+
+	// Memory 是节点上的数据占用的空间大小，包括 key 和 value。
+	Memory int64 `json:"memory"`
+
+	// Hits 和 Misses 是节点启动以来读取数据时命中和没有命中的次数。
+	Hits int64 `json:"hits"`
+
+	Misses int64 `json:"misses"`
+}
+
+// nodeMeta 是节点通过 memberlist 广播给其他节点的元数据，大小不能超过 memberlist.MetaMaxSize。
+type nodeMeta struct {
+	// Version 是节点的服务版本号。
+	Version string `json:"v"`
+
+	// Commit 和 BuildTime 是节点编译时代码的 git 提交号和编译的时间。
+	Commit string `json:"g,omitempty"`
+
+	BuildTime string `json:"b,omitempty"`
+
+	// StartTime 是节点启动的时间，使用 Unix 时间戳表示。
+	StartTime int64 `json:"s"`
+
+	// Count 是节点上的数据个数。
+	Count int `json:"c"`
+
+	// Memory 是节点上的数据占用的空间大小。
+	Memory int64 `json:"m"`
+
+	// Hits 和 Misses 是节点读取数据时命中和没有命中的次数。
+	Hits int64 `json:"h,omitempty"`
+
+	Misses int64 `json:"x,omitempty"`
+}
+
+// nodeDelegate 用于向 memberlist 提供当前节点的元数据，其他的功能都没有用到。
+type nodeDelegate struct {
+	node *node
+}
+
+// NodeMeta 返回当前节点的元数据，超过大小限制的话就不广播元数据了。
+func (nd *nodeDelegate) NodeMeta(limit int) []byte {
+	meta := nodeMeta{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		StartTime: nd.node.startTime.Unix(),
+	}
+
+	if nd.node.cache != nil {
+		status := nd.node.cache.Status()
+		meta.Count = status.Count
+		meta.Memory = status.KeySize + status.ValueSize
+	}
+	meta.Hits, meta.Misses = caches.HitStats()
+
+	data, err := json.Marshal(meta)
+	if err != nil || len(data) > limit {
+		return nil
+	}
+	return data
+}
+
+func (nd *nodeDelegate) NotifyMsg([]byte) {}
+
+func (nd *nodeDelegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+
+func (nd *nodeDelegate) LocalState(join bool) []byte { return nil }
+
+func (nd *nodeDelegate) MergeRemoteState(buf []byte, join bool) {}
+
+// nodeInfos 返回集群中所有节点的信息。
+func (n *node) nodeInfos() []NodeInfo {
+	members := n.nodeManager.Members()
+	role := memberRole
+	if len(members) <= 1 {
+		role = standaloneRole
+	}
+
+	infos := make([]NodeInfo, len(members))
+	for i, member := range members {
+		infos[i] = NodeInfo{
+			Address: member.Name,
+			Role:    role,
+			State:   nodeStates[member.State],
+		}
+
+		meta := nodeMeta{}
+		if err := json.Unmarshal(member.Meta, &meta); err != nil {
+			continue
+		}
+
+		infos[i].Version = meta.Version
+		infos[i].Commit = meta.Commit
+		infos[i].BuildTime = meta.BuildTime
+		infos[i].Uptime = int64(time.Since(time.Unix(meta.StartTime, 0)).Seconds())
+		infos[i].Count = meta.Count
+		infos[i].Memory = meta.Memory
+		infos[i].Hits = meta.Hits
+		infos[i].Misses = meta.Misses
+	}
+	return infos
+}
@@ -1,13 +1,36 @@
 package servers
 
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
 // Options 是服务器的选项配置。
 type Options struct {
 	// Address 是服务器监听使用的地址。
 	Address string
 
+	// Network 是服务器监听使用的网络类型，可以是 tcp、tcp4 或者 tcp6。
+	// 使用 tcp 并且 Address 是 "::" 的时候会同时监听 IPv4 和 IPv6，也就是双栈监听。
+	Network string
+
 	// Port 是服务器监听使用的端口。
 	Port int
 
+	// Acceptors 是监听器的个数，大于 1 的时候会开启 SO_REUSEPORT，使用多个监听器并行地接收连接。
+	// 在多核机器上适当调大这个值可以提高处理连接的吞吐量。
+	Acceptors int
+
+	// SocketActivation 表示是否使用 systemd socket activation 传递过来的监听器，开启之后会忽略 Address 和 Port 等监听配置。
+	SocketActivation bool
+
 	// ServerType 是服务器的类型。
 	ServerType string
 
@@ -15,19 +38,363 @@ type Options struct {
 	VirtualNodeCount int
 
 	// UpdateCircleDuration 是指更新一致性哈希信息的时间间隔。
-    // 单位是秒。
-	UpdateCircleDuration int
+	UpdateCircleDuration time.Duration
+
+	// GossipPort 是节点之间使用 gossip 协议同步集群信息的端口，同一台机器上运行多个节点的时候需要设置成不同的端口。
+	GossipPort int
 
-	// cluster 是指需要加入的集群，只需要集群中一个节点的地址即可。
+	// cluster 是指需要加入的集群，只需要集群中一个节点的 gossip 地址即可，比如 127.0.0.1:7946，不带端口的话就使用默认的 7946 端口。
 	Cluster []string
+
+	// RequestTimeout 是每个请求的处理期限，超过这个时间还没处理完的请求会返回超时错误。
+	// 如果设置为 0 就表示不限制处理时间。
+	RequestTimeout time.Duration
+
+	// IdleTimeout 是 TCP 连接的空闲超时时间，连接空闲超过这个时间就会被关闭，这样崩溃的客户端留下的半开连接就不会一直堆积。
+	// 如果设置为 0 就表示不关闭空闲连接。
+	IdleTimeout time.Duration
+
+	// KeepAlivePeriod 是 TCP 连接的 keepalive 探测间隔。
+	// 如果设置为 0 就使用系统默认的间隔，小于 0 就表示关闭 keepalive。
+	KeepAlivePeriod time.Duration
+
+	// MaxArgSize 是 TCP 请求中单个参数的最大字节数，会在分配内存之前进行校验，HTTP 请求中数据的最大字节数也使用这个值。
+	// 单位是字节，如果设置为 0 就表示不限制。
+	MaxArgSize int
+
+	// MaxFrameSize 是 TCP 请求帧的最大字节数，会在分配内存之前进行校验，每个参数在参数列表中占用的内存也会计算在内。
+	// 单位是字节，如果设置为 0 就表示不限制，这时候只要设置了 MaxArgSize，一个请求帧最多也只能有 1048576 个参数。
+	MaxFrameSize int
+
+	// MaxChunkedSize 是分块上传的数据的最大字节数，会在开始上传的时候进行校验。
+	// 单位是字节，如果设置为 0 就表示不限制。
+	MaxChunkedSize int
+
+	// MaxChunkedInFlight 是所有正在进行的分块上传已经分配的空间加起来的最大字节数，超过之后新的块会被拒绝，
+	// 完成的数据会直接交给缓存，不会再复制一份，这样很多客户端同时上传也不会占用太多的内存。单位是字节，如果设置为 0 就表示不限制。
+	MaxChunkedInFlight int
+
+	// WriteBufferSize 是 TCP 连接的写缓冲区大小，响应会先写入缓冲区，等到已经读取的请求都处理完了再一起发送出去，
+	// 这样 pipeline 中的多个响应只需要一次系统调用。单位是字节，如果设置为 0 就表示每个响应都马上发送。
+	WriteBufferSize int
+
+	// BackingStore 是缓存未命中时加载数据的后端存储，http 或者 https 的地址表示使用 HTTP 回调，否则就是外部命令的路径，为空表示不加载。
+	// HTTP 回调的接口和缓存的 HTTP 接口一样，外部命令的用法见 commandBackingStore。修改之后需要重启才会生效。
+	BackingStore string
+
+	// BackingStoreTTL 是从后端存储加载的数据的 ttl，HTTP 回调可以在 Ttl 头部中返回每个数据自己的 ttl。
+	// 数据的 ttl 是以秒为单位的，不足一秒的部分会被舍去，如果设置为 0 就表示不会过期。
+	BackingStoreTTL time.Duration
+
+	// WriteThrough 表示是否把写操作同步到 BackingStore 中，开启之后写入后端存储成功了才会写入缓存。
+	WriteThrough bool
+
+	// HTTPCredentials 是访问 HTTP 接口使用的凭证，如果没有配置任何凭证，HTTP 接口就不需要认证。
+	// 注意凭证是敏感信息，所以不会被序列化输出。
+	HTTPCredentials []Credential `json:"-"`
+
+	// HTTPAuthFile 是存储 HTTP 接口凭证的 JSON 文件，文件中的凭证会和 HTTPCredentials 合并使用。
+	HTTPAuthFile string
+
+	// SlowLogThreshold 是慢日志的阈值，处理时间超过这个值的请求会带着追踪 ID 记录到日志中。
+	// 如果设置为 0 就表示不记录慢日志。
+	SlowLogThreshold time.Duration
+
+	// ReplicationFactor 是每个 key 保存的份数，包括所属节点自己的那一份，小于等于 1 表示不复制。
+	// 大于 1 的时候，所属节点写入成功之后会异步地把数据复制到一致性哈希环上的后续节点，复制是尽力而为的，所以副本上的数据可能是旧的。
+	// 目前只有 tcp 类型的服务器支持复制，并且复制还是实验性功能，需要在 Features 中开启 replication 才能使用。
+	ReplicationFactor int
+
+	// Features 是开启的实验性功能，实验性功能默认都是关闭的，可选值可以通过 ExperimentalFeatures 获取。
+	Features []string
+
+	// AuditFile 是审计日志的文件路径，每一次成功的写操作都会以一行 JSON 的形式追加到文件中，为空表示不记录审计日志。
+	AuditFile string
+
+	// AuditMaxSize 是审计日志文件的最大大小，超过之后就会切分出一个新的文件，单位是 MB，如果设置为 0 就表示不按照大小切分。
+	AuditMaxSize int
+
+	// AuditMaxAge 是切分出来的旧审计日志文件保留的时间，如果设置为 0 就表示一直保留。
+	AuditMaxAge time.Duration
+
+	// AlertWebhook 是告警通知的地址，资源的使用情况超过阈值或者恢复正常的时候，会以 JSON 的形式 POST 告警到这个地址，为空表示不发送告警。
+	AlertWebhook string
+
+	// AlertInterval 是检查告警阈值的时间间隔。
+	AlertInterval time.Duration
+
+	// AlertMemoryPercent 是数据占用空间的告警阈值，是占 MaxEntrySize 的百分比，达到这个值之后很快就会触发写满保护，如果设置为 0 就表示不告警。
+	AlertMemoryPercent int
+
+	// AlertEvictionRate 是淘汰数据的速度的告警阈值，单位是每秒淘汰的数据个数，如果设置为 0 就表示不告警。
+	AlertEvictionRate int
+
+	// AlertDumpFailures 是持久化失败的告警阈值，一个检查间隔内持久化失败的次数达到这个值就会告警，如果设置为 0 就表示不告警。
+	AlertDumpFailures int
+
+	// FaultLatency 是注入到每个 TCP 请求中的延迟，和下面的故障注入配置一样只用于测试客户端的超时、重试和重新均衡逻辑，不要在生产环境中开启。
+	// 如果设置为 0 就表示不注入延迟。
+	FaultLatency time.Duration
+
+	// FaultDropPercent 是丢弃 TCP 响应的概率，命令会正常执行，但是不会返回响应，而是直接关闭连接，模拟响应在网络中丢失的情况。
+	// 是一个百分比，如果设置为 0 就表示不丢弃。
+	FaultDropPercent int
+
+	// FaultRedirectPercent 是带有 key 的 TCP 命令被强制重定向到集群中另一个随机节点的概率，命令不会执行，集群中只有一个节点的时候不会重定向。
+	// 是一个百分比，如果设置为 0 就表示不重定向。
+	FaultRedirectPercent int
 }
 
 func DefaultOptions() Options {
 	return Options{
 		Address:              "127.0.0.1",
+		Network:              "tcp",
 		Port:                 5837,
+		Acceptors:            1,
 		ServerType:           "tcp",
 		VirtualNodeCount:     1024,
-		UpdateCircleDuration: 3,
+		UpdateCircleDuration: 3 * time.Second,
+		GossipPort:           7946,
+		RequestTimeout:       5 * time.Second,
+		IdleTimeout:          30 * time.Minute,
+		KeepAlivePeriod:      15 * time.Second,
+		MaxArgSize:           64 << 20,  // 64 MB
+		MaxFrameSize:         128 << 20, // 128 MB
+		MaxChunkedSize:       1 << 30,   // 1 GB
+		MaxChunkedInFlight:   1 << 30,   // 1 GB
+		WriteBufferSize:      16 << 10,  // 16 KB
+		SlowLogThreshold:     100 * time.Millisecond,
+		ReplicationFactor:    1,
+		AuditMaxSize:         100,                 // 100 MB
+		AuditMaxAge:          30 * 24 * time.Hour, // 30 days
+		AlertInterval:        30 * time.Second,
+		AlertMemoryPercent:   90,
+		AlertEvictionRate:    1000,
+		AlertDumpFailures:    1,
+	}
+}
+
+// Validate 校验选项配置，不合法的配置会返回说明原因的错误。
+func (o *Options) Validate() error {
+	// 使用 socket activation 的时候会忽略监听相关的配置，但是 Address 和 Port 仍然会作为节点的访问地址
+	if err := validateAddress(o.Address); err != nil {
+		return fmt.Errorf("invalid Address %q: %v", o.Address, err)
+	}
+
+	if o.Port <= 0 || o.Port > 65535 {
+		return fmt.Errorf("invalid Port %d: must be between 1 and 65535", o.Port)
+	}
+
+	if o.Network != "tcp" && o.Network != "tcp4" && o.Network != "tcp6" {
+		return fmt.Errorf("invalid Network %q: must be one of tcp, tcp4 and tcp6", o.Network)
+	}
+
+	if o.Acceptors <= 0 {
+		return fmt.Errorf("invalid Acceptors %d: must be greater than 0", o.Acceptors)
+	}
+
+	if o.ServerType != "tcp" && o.ServerType != "http" {
+		return fmt.Errorf("invalid ServerType %q: must be one of tcp and http", o.ServerType)
+	}
+
+	if o.VirtualNodeCount <= 0 {
+		return fmt.Errorf("invalid VirtualNodeCount %d: must be greater than 0", o.VirtualNodeCount)
+	}
+
+	if o.UpdateCircleDuration <= 0 {
+		return fmt.Errorf("invalid UpdateCircleDuration %v: must be greater than 0", o.UpdateCircleDuration)
+	}
+
+	if o.GossipPort <= 0 || o.GossipPort > 65535 {
+		return fmt.Errorf("invalid GossipPort %d: must be between 1 and 65535", o.GossipPort)
+	}
+
+	if o.GossipPort == o.Port {
+		return fmt.Errorf("invalid GossipPort %d: must be different from Port", o.GossipPort)
+	}
+
+	for _, node := range o.Cluster {
+		// 集群中的节点可以不带端口，这时候使用的是默认的 gossip 端口
+		host, port, err := net.SplitHostPort(node)
+		if err != nil {
+			host, err = node, nil
+		} else if n, convErr := strconv.Atoi(port); convErr != nil || n <= 0 || n > 65535 {
+			err = fmt.Errorf("port %q is not between 1 and 65535", port)
+		}
+
+		if err == nil {
+			err = validateAddress(host)
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid node %q in Cluster: %v", node, err)
+		}
+	}
+
+	if o.RequestTimeout < 0 {
+		return fmt.Errorf("invalid RequestTimeout %v: must not be negative", o.RequestTimeout)
+	}
+
+	if o.IdleTimeout < 0 {
+		return fmt.Errorf("invalid IdleTimeout %v: must not be negative", o.IdleTimeout)
 	}
+
+	if o.MaxArgSize < 0 {
+		return fmt.Errorf("invalid MaxArgSize %d: must not be negative", o.MaxArgSize)
+	}
+
+	if o.MaxFrameSize < 0 {
+		return fmt.Errorf("invalid MaxFrameSize %d: must not be negative", o.MaxFrameSize)
+	}
+
+	if o.MaxChunkedSize < 0 {
+		return fmt.Errorf("invalid MaxChunkedSize %d: must not be negative", o.MaxChunkedSize)
+	}
+
+	if o.MaxChunkedInFlight < 0 {
+		return fmt.Errorf("invalid MaxChunkedInFlight %d: must not be negative", o.MaxChunkedInFlight)
+	}
+
+	if o.WriteBufferSize < 0 {
+		return fmt.Errorf("invalid WriteBufferSize %d: must not be negative", o.WriteBufferSize)
+	}
+
+	if o.BackingStoreTTL < 0 {
+		return fmt.Errorf("invalid BackingStoreTTL %v: must not be negative", o.BackingStoreTTL)
+	}
+
+	if o.WriteThrough && o.BackingStore == "" {
+		return fmt.Errorf("invalid WriteThrough %v: needs a BackingStore", o.WriteThrough)
+	}
+
+	if o.SlowLogThreshold < 0 {
+		return fmt.Errorf("invalid SlowLogThreshold %v: must not be negative", o.SlowLogThreshold)
+	}
+
+	if o.ReplicationFactor < 0 {
+		return fmt.Errorf("invalid ReplicationFactor %d: must not be negative", o.ReplicationFactor)
+	}
+
+	if o.AuditMaxSize < 0 {
+		return fmt.Errorf("invalid AuditMaxSize %d: must not be negative", o.AuditMaxSize)
+	}
+
+	if o.AuditMaxAge < 0 {
+		return fmt.Errorf("invalid AuditMaxAge %v: must not be negative", o.AuditMaxAge)
+	}
+
+	if o.AlertWebhook != "" {
+		if webhook, err := url.Parse(o.AlertWebhook); err != nil || (webhook.Scheme != "http" && webhook.Scheme != "https") || webhook.Host == "" {
+			return fmt.Errorf("invalid AlertWebhook %q: must be a http or https url", o.AlertWebhook)
+		}
+	}
+
+	if o.AlertInterval <= 0 {
+		return fmt.Errorf("invalid AlertInterval %v: must be greater than 0", o.AlertInterval)
+	}
+
+	if o.AlertMemoryPercent < 0 || o.AlertMemoryPercent > 100 {
+		return fmt.Errorf("invalid AlertMemoryPercent %d: must be between 0 and 100", o.AlertMemoryPercent)
+	}
+
+	if o.AlertEvictionRate < 0 {
+		return fmt.Errorf("invalid AlertEvictionRate %d: must not be negative", o.AlertEvictionRate)
+	}
+
+	if o.AlertDumpFailures < 0 {
+		return fmt.Errorf("invalid AlertDumpFailures %d: must not be negative", o.AlertDumpFailures)
+	}
+
+	if o.FaultLatency < 0 {
+		return fmt.Errorf("invalid FaultLatency %v: must not be negative", o.FaultLatency)
+	}
+
+	if o.FaultDropPercent < 0 || o.FaultDropPercent > 100 {
+		return fmt.Errorf("invalid FaultDropPercent %d: must be between 0 and 100", o.FaultDropPercent)
+	}
+
+	if o.FaultRedirectPercent < 0 || o.FaultRedirectPercent > 100 {
+		return fmt.Errorf("invalid FaultRedirectPercent %d: must be between 0 and 100", o.FaultRedirectPercent)
+	}
+	return o.validateFeatures()
+}
+
+// validateAddress 校验 address 是不是合法的 IP 或者主机名，IPv6 的地址带不带方括号都可以，但是不能带上端口。
+func validateAddress(address string) error {
+	address = helpers.TrimBrackets(address)
+	if address == "" {
+		return fmt.Errorf("address is empty")
+	}
+
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+
+	if strings.Contains(address, ":") {
+		return fmt.Errorf("address should not contain a port")
+	}
+
+	for _, c := range address {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return fmt.Errorf("%q is not allowed in a host name", c)
+		}
+	}
+	return nil
+}
+
+// optionsJSON 是 Options 在 JSON 中的格式，时间间隔使用 "3s" 这样的字符串表示。
+type optionsJSON struct {
+	*jsonOptions
+	UpdateCircleDuration helpers.Duration
+	RequestTimeout       helpers.Duration
+	IdleTimeout          helpers.Duration
+	KeepAlivePeriod      helpers.Duration
+	BackingStoreTTL      helpers.Duration
+	SlowLogThreshold     helpers.Duration
+	AuditMaxAge          helpers.Duration
+	AlertInterval        helpers.Duration
+	FaultLatency         helpers.Duration
+}
+
+// newOptionsJSON 返回 o 在 JSON 中的格式，修改返回值中的 jsonOptions 会直接修改 o。
+func newOptionsJSON(o *Options) optionsJSON {
+	return optionsJSON{
+		jsonOptions:          (*jsonOptions)(o),
+		UpdateCircleDuration: helpers.Duration(o.UpdateCircleDuration),
+		RequestTimeout:       helpers.Duration(o.RequestTimeout),
+		IdleTimeout:          helpers.Duration(o.IdleTimeout),
+		KeepAlivePeriod:      helpers.Duration(o.KeepAlivePeriod),
+		BackingStoreTTL:      helpers.Duration(o.BackingStoreTTL),
+		SlowLogThreshold:     helpers.Duration(o.SlowLogThreshold),
+		AuditMaxAge:          helpers.Duration(o.AuditMaxAge),
+		AlertInterval:        helpers.Duration(o.AlertInterval),
+		FaultLatency:         helpers.Duration(o.FaultLatency),
+	}
+}
+
+// jsonOptions 和 Options 的字段一样，但是没有 Options 的方法，避免序列化的时候无限递归。
+type jsonOptions Options
+
+// MarshalJSON 把 Options 序列化成 JSON，时间间隔会序列化成字符串。
+func (o Options) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newOptionsJSON(&o))
+}
+
+// UnmarshalJSON 从 JSON 中解析出 Options，时间间隔可以是字符串也可以是以纳秒为单位的数字，JSON 中没有出现的字段会保留原来的值。
+func (o *Options) UnmarshalJSON(data []byte) error {
+	aux := newOptionsJSON(o)
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	o.UpdateCircleDuration = time.Duration(aux.UpdateCircleDuration)
+	o.RequestTimeout = time.Duration(aux.RequestTimeout)
+	o.IdleTimeout = time.Duration(aux.IdleTimeout)
+	o.KeepAlivePeriod = time.Duration(aux.KeepAlivePeriod)
+	o.BackingStoreTTL = time.Duration(aux.BackingStoreTTL)
+	o.SlowLogThreshold = time.Duration(aux.SlowLogThreshold)
+	o.AuditMaxAge = time.Duration(aux.AuditMaxAge)
+	o.AlertInterval = time.Duration(aux.AlertInterval)
+	o.FaultLatency = time.Duration(aux.FaultLatency)
+	return nil
 }
@@ -0,0 +1,63 @@
+package servers
+
+import "testing"
+
+// go test -v -run=^TestOptionsValidate$
+func TestOptionsValidate(t *testing.T) {
+	options := DefaultOptions()
+	options.Cluster = []string{"127.0.0.1:5837", "[::1]:5837", "cache-1.local:5837"}
+	if err := options.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, address := range []string{"", "127.0.0.1:5837", "cache server"} {
+		options := DefaultOptions()
+		options.Address = address
+		if err := options.Validate(); err == nil {
+			t.Fatalf("validate with address %q should fail", address)
+		}
+	}
+
+	options = DefaultOptions()
+	options.Cluster = []string{"127.0.0.1", "127.0.0.1:port"}
+	if err := options.Validate(); err == nil {
+		t.Fatal("validate with a node with wrong port should fail")
+	}
+
+	options = DefaultOptions()
+	options.GossipPort = options.Port
+	if err := options.Validate(); err == nil {
+		t.Fatal("validate with the same port and gossip port should fail")
+	}
+
+	options = DefaultOptions()
+	options.UpdateCircleDuration = 0
+	if err := options.Validate(); err == nil {
+		t.Fatal("validate with zero UpdateCircleDuration should fail")
+	}
+
+	options = DefaultOptions()
+	options.AlertWebhook = "127.0.0.1:9000/alerts"
+	if err := options.Validate(); err == nil {
+		t.Fatal("validate with an AlertWebhook without scheme should fail")
+	}
+}
+
+// go test -v -run=^TestOptionsFeatures$
+func TestOptionsFeatures(t *testing.T) {
+	options := DefaultOptions()
+	options.ReplicationFactor = 2
+	if err := options.Validate(); err == nil {
+		t.Fatal("validate with replication disabled should fail")
+	}
+
+	options.Features = []string{FeatureReplication}
+	if err := options.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	options.Features = append(options.Features, "unknown")
+	if err := options.Validate(); err == nil {
+		t.Fatal("validate with unknown feature should fail")
+	}
+}
@@ -0,0 +1,261 @@
+package servers
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// 这里的传输协议和 vex 的协议是完全兼容的，所以使用 vex 的客户端也可以正常访问服务器。
+// 请求帧的格式：版本号（1 字节）+ 命令（1 字节）+ 参数个数（4 字节）+ { 参数长度（4 字节）+ 参数内容 }...
+// 响应帧的格式：版本号（1 字节）+ 答复码（1 字节）+ 响应体长度（4 字节）+ 响应体内容
+const (
+	// frameVersion 是传输帧的版本号。
+	frameVersion = byte(1)
+
+	// headerLength 是帧头部占用的字节数。
+	headerLength = 6
+
+	// argLengthSize 是参数长度占用的字节数。
+	argLengthSize = 4
+
+	// successReply 是成功的答复码。
+	successReply = byte(0)
+
+	// errorReply 是发生错误的答复码。
+	errorReply = byte(1)
+
+	// checksumLength 是 CRC32 校验码占用的字节数，只有握手时协商开启了校验，帧的末尾才会带上校验码。
+	checksumLength = 4
+
+	// argSliceSize 是参数列表中每个参数的切片头占用的内存，64 位平台上是 24 个字节。
+	// 参数个数是客户端声称的，一个只有几个字节的头部就可以声称有几千万个参数，所以估算帧的大小时也要算上这部分内存。
+	argSliceSize = 24
+
+	// maxArgsLength 是只限制了参数大小、没有限制帧大小的时候，一个请求帧最多可以有的参数个数。
+	maxArgsLength = 1 << 20
+
+	// initialArgsCap 是参数列表预先分配的最大容量，更多的参数会在真正读取到的时候再扩容。
+	initialArgsCap = 16
+)
+
+var (
+	errFrameVersionMismatch = errors.New("protocol version between client and server doesn't match")
+
+	errArgTooLarge = errors.New("argument size exceeds the limit")
+
+	errFrameTooLarge = errors.New("frame size exceeds the limit")
+
+	errTooManyArgs = errors.New("argument count exceeds the limit")
+
+	errChecksumMismatch = errors.New("checksum of frame doesn't match")
+)
+
+// frameLimits 是请求帧的大小限制，所有的长度都会在分配内存之前进行校验，
+// 防止一个恶意的请求帧声称自己有好几个 GB 的长度，导致服务器直接分配大量内存。
+type frameLimits struct {
+	// maxArgSize 是单个参数的最大字节数，小于等于 0 表示不限制。
+	maxArgSize int
+
+	// maxFrameSize 是整个请求帧的最大字节数，小于等于 0 表示不限制。
+	maxFrameSize int
+}
+
+// checkFrameSize 检查请求帧的大小是否超过了限制。
+func (fl frameLimits) checkFrameSize(size uint64) error {
+	if fl.maxFrameSize > 0 && size > uint64(fl.maxFrameSize) {
+		return errFrameTooLarge
+	}
+	return nil
+}
+
+// checkArgsLength 检查请求帧声称的参数个数是否超过了限制，返回按照参数个数估算出来的帧的最小大小。
+// 限制了帧大小的话，每个参数都按照参数长度和切片头占用的内存计算；只限制了参数大小的话，参数个数不能超过 maxArgsLength。
+func (fl frameLimits) checkArgsLength(argsLength uint32) (uint64, error) {
+	frameSize := uint64(headerLength) + uint64(argsLength)*(argLengthSize+argSliceSize)
+	if err := fl.checkFrameSize(frameSize); err != nil {
+		return 0, err
+	}
+
+	if fl.maxFrameSize <= 0 && fl.maxArgSize > 0 && argsLength > maxArgsLength {
+		return 0, errTooManyArgs
+	}
+	return frameSize, nil
+}
+
+// checkArgSize 检查单个参数的大小是否超过了限制。
+func (fl frameLimits) checkArgSize(size uint32) error {
+	if fl.maxArgSize > 0 && uint64(size) > uint64(fl.maxArgSize) {
+		return errArgTooLarge
+	}
+	return nil
+}
+
+// readRequestFrom 从 reader 中读取一个请求帧，并解析出命令和参数，读取的过程中会使用 limits 校验帧的大小。
+// 如果 checksum 为 true，说明请求帧的末尾带有 4 个字节的 CRC32 校验码，读取完之后会进行校验。
+// 参数都是从 buf 中分配的，buf 放回池中之后参数就不能再访问了，buf 为 nil 的话每个参数都会单独分配内存。
+func readRequestFrom(reader io.Reader, limits frameLimits, checksum bool, buf *buffer) (command byte, args [][]byte, err error) {
+	// 开启校验的时候，读取到的所有数据都会同时写入哈希中，用于计算校验码
+	raw := reader
+	hash := crc32.NewIEEE()
+	if checksum {
+		reader = io.TeeReader(reader, hash)
+	}
+
+	header := buf.alloc(headerLength)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if header[0] != frameVersion {
+		return 0, nil, errFrameVersionMismatch
+	}
+
+	command = header[1]
+	argsLength := binary.BigEndian.Uint32(header[2:])
+
+	// 先用参数个数估算一下帧的大小，并且参数列表不会按照声称的参数个数一次性分配，而是读取到参数之后再扩容，
+	// 这样只有头部没有参数的请求帧也不会导致服务器分配大量内存
+	frameSize, err := limits.checkArgsLength(argsLength)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	argsCap := argsLength
+	if argsCap > initialArgsCap {
+		argsCap = initialArgsCap
+	}
+	args = make([][]byte, 0, argsCap)
+
+	argLength := buf.alloc(argLengthSize)
+	for i := uint32(0); i < argsLength; i++ {
+		_, err = io.ReadFull(reader, argLength)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		size := binary.BigEndian.Uint32(argLength)
+		if err = limits.checkArgSize(size); err != nil {
+			return 0, nil, err
+		}
+
+		frameSize += uint64(size)
+		if err = limits.checkFrameSize(frameSize); err != nil {
+			return 0, nil, err
+		}
+
+		arg := buf.alloc(int(size))
+		_, err = io.ReadFull(reader, arg)
+		if err != nil {
+			return 0, nil, err
+		}
+		args = append(args, arg)
+	}
+
+	if checksum {
+		if err = verifyChecksum(raw, hash.Sum32()); err != nil {
+			return 0, nil, err
+		}
+	}
+	return command, args, nil
+}
+
+// verifyChecksum 从 reader 中读取 4 个字节的 CRC32 校验码，并和 expected 进行比较。
+func verifyChecksum(reader io.Reader, expected uint32) error {
+	trailer := make([]byte, checksumLength)
+	if _, err := io.ReadFull(reader, trailer); err != nil {
+		return err
+	}
+
+	if binary.BigEndian.Uint32(trailer) != expected {
+		return errChecksumMismatch
+	}
+	return nil
+}
+
+// appendChecksum 计算 frame 的 CRC32 校验码并追加到 frame 的末尾。
+func appendChecksum(frame []byte) []byte {
+	var trailer [checksumLength]byte
+	binary.BigEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(frame))
+	return append(frame, trailer[:]...)
+}
+
+// writeResponseTo 将答复码和响应体编码成一个响应帧写入 writer，如果 checksum 为 true，响应帧的末尾会带上 CRC32 校验码。
+// 响应帧是在池中的缓冲区里编码的，写入之后就会放回池中，所以 writer 不能保留写入的数据。
+func writeResponseTo(writer io.Writer, reply byte, body []byte, checksum bool) error {
+	buf := getBuffer()
+	defer buf.release()
+
+	response := buf.alloc(headerLength + len(body) + checksumLength)[:headerLength]
+	response[0] = frameVersion
+	response[1] = reply
+	binary.BigEndian.PutUint32(response[2:], uint32(len(body)))
+	response = append(response, body...)
+	if checksum {
+		response = appendChecksum(response)
+	}
+	_, err := writer.Write(response)
+	return err
+}
+
+// writeRequestTo 将命令和参数编码成一个请求帧写入 writer，如果 checksum 为 true，请求帧的末尾会带上 CRC32 校验码。
+func writeRequestTo(writer io.Writer, command byte, args [][]byte, checksum bool) error {
+	size := headerLength + checksumLength
+	for _, arg := range args {
+		size += argLengthSize + len(arg)
+	}
+
+	request := make([]byte, headerLength, size)
+	request[0] = frameVersion
+	request[1] = command
+	binary.BigEndian.PutUint32(request[2:], uint32(len(args)))
+
+	argLength := make([]byte, argLengthSize)
+	for _, arg := range args {
+		binary.BigEndian.PutUint32(argLength, uint32(len(arg)))
+		request = append(request, argLength...)
+		request = append(request, arg...)
+	}
+
+	if checksum {
+		request = appendChecksum(request)
+	}
+	_, err := writer.Write(request)
+	return err
+}
+
+// readResponseFrom 从 reader 中读取一个响应帧，并解析出答复码和响应体。
+// 如果 checksum 为 true，说明响应帧的末尾带有 4 个字节的 CRC32 校验码，读取完之后会进行校验。
+func readResponseFrom(reader io.Reader, checksum bool) (reply byte, body []byte, err error) {
+	raw := reader
+	hash := crc32.NewIEEE()
+	if checksum {
+		reader = io.TeeReader(reader, hash)
+	}
+
+	header := make([]byte, headerLength)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return errorReply, nil, err
+	}
+
+	if header[0] != frameVersion {
+		return errorReply, nil, errFrameVersionMismatch
+	}
+
+	reply = header[1]
+	body = make([]byte, binary.BigEndian.Uint32(header[2:]))
+	_, err = io.ReadFull(reader, body)
+	if err != nil {
+		return errorReply, nil, err
+	}
+
+	if checksum {
+		if err = verifyChecksum(raw, hash.Sum32()); err != nil {
+			return errorReply, nil, err
+		}
+	}
+	return reply, body, nil
+}
@@ -0,0 +1,306 @@
+package servers
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errClientClosed = errors.New("client is closed")
+)
+
+// callResult 是一个请求的执行结果。
+type callResult struct {
+	// body 是响应体。
+	body []byte
+
+	// err 是执行请求发生的错误。
+	err error
+}
+
+// replyError 是服务端通过响应帧返回的错误，这种错误并不会影响连接的使用。
+type replyError struct {
+	message string
+
+	// err 是错误信息对应的类型化错误，比如 ErrNotFound，不能识别的错误信息是 nil。
+	err error
+}
+
+// Error 返回错误信息。
+func (re *replyError) Error() string {
+	return re.message
+}
+
+// Unwrap 返回错误信息对应的类型化错误。
+func (re *replyError) Unwrap() error {
+	return re.err
+}
+
+// pendingCall 是一个已经发送出去，正在等待响应的请求。
+type pendingCall struct {
+	// checksum 表示这个请求的响应帧是否带有校验码。
+	checksum bool
+
+	// done 用于接收请求的执行结果。
+	done chan *callResult
+}
+
+// protocolClient 是使用自定义传输协议的客户端连接，和 vex 的客户端是兼容的。
+// vex 的客户端一个连接同时只能执行一个请求，而这个客户端支持流水线，多个协程可以并发地在同一个连接上发送请求，不需要等待上一个请求的响应。
+// 因为服务端是按照请求的顺序返回响应的，所以只需要按照发送的顺序记录下等待响应的请求，然后依次把响应交给它们就可以了。
+type protocolClient struct {
+	// conn 是底层的连接。
+	conn net.Conn
+
+	// writer 是带缓冲的写入器，写入请求之后会马上刷新。
+	writer *bufio.Writer
+
+	// writeLock 保证请求的入队和写入是原子的，这样请求发送的顺序和等待响应的顺序才是一致的。
+	writeLock *sync.Mutex
+
+	// pendingLock 保护 pending 和 err，读取协程只会使用这个锁，所以写入阻塞的时候也不会影响响应的读取。
+	pendingLock *sync.Mutex
+
+	// pendingCond 用于在有新的请求入队或者连接出错时唤醒读取协程。
+	pendingCond *sync.Cond
+
+	// pending 是按照发送顺序排列的正在等待响应的请求。
+	pending []*pendingCall
+
+	// err 是导致连接不可用的错误，不为 nil 说明连接已经关闭了。
+	err error
+
+	// checksum 表示请求帧和响应帧是否带有校验码，由握手协商决定。
+	checksum bool
+
+	// handshake 是这个连接握手协商的结果，还没有握手的时候是 nil。
+	handshake *Handshake
+}
+
+// newProtocolClient 连接到 address 并返回一个客户端连接，dialTimeout 是建立连接的超时时间，小于等于 0 表示不限制。
+// tlsConfig 不为 nil 的话会使用 TLS 建立连接，TLS 握手的时间也包括在 dialTimeout 中。
+func newProtocolClient(address string, dialTimeout time.Duration, tlsConfig *tls.Config) (*protocolClient, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if tlsConfig != nil {
+		conn, err := tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return newProtocolClientOn(conn), nil
+	}
+
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return newProtocolClientOn(conn), nil
+}
+
+// newProtocolClientOn 使用已经建立好的连接 conn 返回一个客户端连接。
+func newProtocolClientOn(conn net.Conn) *protocolClient {
+	pendingLock := &sync.Mutex{}
+	pc := &protocolClient{
+		conn:        conn,
+		writer:      bufio.NewWriter(conn),
+		writeLock:   &sync.Mutex{},
+		pendingLock: pendingLock,
+		pendingCond: sync.NewCond(pendingLock),
+	}
+	go pc.readLoop()
+	return pc
+}
+
+// readLoop 不断地读取响应，并按照顺序交给等待响应的请求，直到连接出错或者被关闭。
+func (pc *protocolClient) readLoop() {
+	reader := bufio.NewReader(pc.conn)
+	for {
+		pc.pendingLock.Lock()
+		for len(pc.pending) == 0 && pc.err == nil {
+			pc.pendingCond.Wait()
+		}
+
+		if pc.err != nil {
+			pc.pendingLock.Unlock()
+			return
+		}
+		call := pc.pending[0]
+		pc.pendingLock.Unlock()
+
+		reply, body, err := readResponseFrom(reader, call.checksum)
+		if err != nil {
+			pc.fail(err)
+			return
+		}
+
+		// 读取响应的时候没有持有锁，连接可能已经被关闭了，这时候 fail 已经通知了所有等待响应的请求，包括 call
+		pc.pendingLock.Lock()
+		if pc.err != nil || len(pc.pending) == 0 || pc.pending[0] != call {
+			pc.pendingLock.Unlock()
+			return
+		}
+		pc.pending = pc.pending[1:]
+		pc.pendingLock.Unlock()
+
+		if reply == errorReply {
+			call.done <- &callResult{body: body, err: newReplyError(string(body))}
+			continue
+		}
+		call.done <- &callResult{body: body}
+	}
+}
+
+// fail 将连接标记为不可用并关闭，然后通知所有还在等待响应的请求。
+func (pc *protocolClient) fail(err error) {
+	pc.pendingLock.Lock()
+	defer pc.pendingLock.Unlock()
+
+	if pc.err != nil {
+		return
+	}
+
+	pc.err = err
+	pc.conn.Close()
+	for _, call := range pc.pending {
+		call.done <- &callResult{err: err}
+	}
+	pc.pending = nil
+	pc.pendingCond.Broadcast()
+}
+
+// enqueue 将请求加入到等待响应的队列中，如果连接已经不可用了就返回错误。
+func (pc *protocolClient) enqueue(call *pendingCall) error {
+	pc.pendingLock.Lock()
+	defer pc.pendingLock.Unlock()
+
+	if pc.err != nil {
+		return pc.err
+	}
+
+	pc.pending = append(pc.pending, call)
+	pc.pendingCond.Signal()
+	return nil
+}
+
+// Do 执行命令并返回响应体。
+func (pc *protocolClient) Do(command byte, args [][]byte) ([]byte, error) {
+	return pc.DoContext(context.Background(), command, args)
+}
+
+// DoContext 执行命令并返回响应体，如果在响应返回之前 ctx 被取消或者超时了，就直接返回 ctx 的错误。
+// 注意这时候请求可能已经发送出去了，它的响应到达之后会被丢弃，不会影响后面的请求。
+func (pc *protocolClient) DoContext(ctx context.Context, command byte, args [][]byte) ([]byte, error) {
+	call := &pendingCall{done: make(chan *callResult, 1)}
+
+	// 请求要先入队再写入，否则响应可能会在入队之前就被读取协程读到
+	pc.writeLock.Lock()
+	call.checksum = pc.checksum
+	if err := pc.enqueue(call); err != nil {
+		pc.writeLock.Unlock()
+		return nil, err
+	}
+
+	err := writeRequestTo(pc.writer, command, args, call.checksum)
+	if err == nil {
+		err = pc.writer.Flush()
+	}
+	pc.writeLock.Unlock()
+
+	// 写入失败的时候连接上的数据流已经不完整了，只能关闭连接，等待响应的请求都会收到这个错误
+	if err != nil {
+		pc.fail(err)
+	}
+
+	select {
+	case result := <-call.done:
+		return result.body, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// pipelineRequest 是流水线中的一个请求。
+type pipelineRequest struct {
+	command byte
+
+	args [][]byte
+}
+
+// DoPipelineContext 一次性写入所有的请求，只刷新一次缓冲区，然后按照顺序等待所有的响应，所以只需要一次网络往返。
+// 返回的结果和请求一一对应，如果在所有响应返回之前 ctx 被取消或者超时了，就直接返回 ctx 的错误。
+func (pc *protocolClient) DoPipelineContext(ctx context.Context, requests []pipelineRequest) ([]*callResult, error) {
+	calls := make([]*pendingCall, len(requests))
+
+	pc.writeLock.Lock()
+	var err error
+	for i, request := range requests {
+		call := &pendingCall{done: make(chan *callResult, 1), checksum: pc.checksum}
+		calls[i] = call
+
+		// 入队失败说明连接已经不可用了，前面入队的请求都已经收到了错误，后面的请求就不需要再写入了
+		if err = pc.enqueue(call); err != nil {
+			call.done <- &callResult{err: err}
+			break
+		}
+
+		if err = writeRequestTo(pc.writer, request.command, request.args, call.checksum); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = pc.writer.Flush()
+	}
+	pc.writeLock.Unlock()
+
+	if err != nil {
+		pc.fail(err)
+	}
+
+	results := make([]*callResult, len(calls))
+	for i, call := range calls {
+		if call == nil {
+			results[i] = &callResult{err: err}
+			continue
+		}
+
+		select {
+		case results[i] = <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+// withTraceID 如果这个连接协商了追踪特性，就把追踪 ID 作为第一个参数加到 args 前面。
+func (pc *protocolClient) withTraceID(traceID string, args [][]byte) [][]byte {
+	if pc.handshake != nil && pc.handshake.Supports(featureTrace) {
+		return append([][]byte{[]byte(traceID)}, args...)
+	}
+	return args
+}
+
+// enableChecksum 开启请求帧和响应帧的校验码，从下一个请求开始生效，一般在握手协商之后调用。
+func (pc *protocolClient) enableChecksum() {
+	pc.writeLock.Lock()
+	defer pc.writeLock.Unlock()
+	pc.checksum = true
+}
+
+// broken 返回这个连接是否已经不可用了，比如连接被服务端关闭了。
+func (pc *protocolClient) broken() bool {
+	pc.pendingLock.Lock()
+	defer pc.pendingLock.Unlock()
+	return pc.err != nil
+}
+
+// Close 关闭这个客户端连接。
+func (pc *protocolClient) Close() error {
+	pc.fail(errClientClosed)
+	return nil
+}
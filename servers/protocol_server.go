@@ -0,0 +1,313 @@
+package servers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+	"github.com/herrhu97/go-distributed-cache/tracing"
+)
+
+var (
+	errCommandHandlerNotFound = errors.New("failed to find a handler of command")
+)
+
+// commandHandler 是命令处理器，args 是命令的参数，返回的 body 是响应体。
+// args 使用的内存在响应写入之后会被别的请求复用，所以处理器返回之后还需要使用的参数必须复制一份。
+type commandHandler func(args [][]byte) (body []byte, err error)
+
+// sessionHandler 是需要访问连接状态的命令处理器，比如握手命令需要修改连接上协商的结果。
+type sessionHandler func(s *session, args [][]byte) (body []byte, err error)
+
+// session 记录着一个连接上的状态。
+type session struct {
+	// conn 是这个会话的连接。
+	conn net.Conn
+
+	// checksum 表示这个连接上的帧是否带有 CRC32 校验码，由握手命令协商决定。
+	checksum bool
+
+	// trace 表示这个连接上的请求帧的第一个参数是否是追踪 ID，由握手命令协商决定。
+	trace bool
+}
+
+// protocolServer 是使用自定义传输协议的服务器，负责连接的管理以及请求和响应的编解码。
+// 之前使用的是 vex 的服务器，但是 vex 没有暴露底层的连接，所以没办法做空闲超时和 keepalive 之类的连接控制，
+// 于是在这里实现了一个和 vex 协议兼容的服务器。
+type protocolServer struct {
+	// options 存储着服务器最新的选项配置。
+	options *liveOptions
+
+	// listeners 是服务器的监听器，开启了 SO_REUSEPORT 的时候会有多个监听器。
+	listeners []net.Listener
+
+	// handlers 存储着所有的命令处理器。
+	handlers map[byte]sessionHandler
+
+	// auditor 记录成功执行的写命令，没有配置审计日志的话是 nil。
+	auditor *auditor
+
+	// clients 记录着所有打开的连接的统计数据。
+	clients *clientRegistry
+
+	// faults 向请求中注入故障，只有 TCP 服务器会设置，否则是 nil。
+	faults *faultInjector
+
+	// closed 在服务器关闭之后会被设置为 1，accept 循环通过它区分监听器是被关闭了还是出错了。
+	closed int32
+}
+
+// newProtocolServer 返回一个使用 options 初始化的服务器。
+func newProtocolServer(options *liveOptions) *protocolServer {
+	return &protocolServer{
+		options:  options,
+		handlers: map[byte]sessionHandler{},
+		clients:  newClientRegistry(),
+	}
+}
+
+// RegisterHandler 注册命令处理器。
+func (ps *protocolServer) RegisterHandler(command byte, handler commandHandler) {
+	ps.handlers[command] = func(s *session, args [][]byte) (body []byte, err error) {
+		return handler(args)
+	}
+}
+
+// RegisterSessionHandler 注册需要访问连接状态的命令处理器。
+func (ps *protocolServer) RegisterSessionHandler(command byte, handler sessionHandler) {
+	ps.handlers[command] = handler
+}
+
+// ListenAndServe 根据选项配置监听并提供服务，每个监听器都会有自己的 accept 循环。
+func (ps *protocolServer) ListenAndServe() (err error) {
+	ps.listeners, err = listen(ps.options.load())
+	if err != nil {
+		return err
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, listener := range ps.listeners {
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			ps.serve(l)
+		}(listener)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// serve 使用 listener 接收连接并处理，直到 listener 被关闭。
+// 文件描述符用完之类的临时错误会按照指数退避等待一段时间再重试，和 net/http 的处理方式一样，避免空转占满 CPU 和刷屏日志。
+func (ps *protocolServer) serve(listener net.Listener) error {
+	// 使用 WaitGroup 记录连接数，并等待所有连接处理完毕
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// 监听器被关闭了，就退出循环
+			if atomic.LoadInt32(&ps.closed) == 1 {
+				return nil
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				delay = acceptBackoff(delay)
+				helpers.Warn("Failed to accept connection", "err", err, "retryIn", delay)
+				time.Sleep(delay)
+				continue
+			}
+
+			helpers.Error("Stop accepting connections", "address", listener.Addr(), "err", err)
+			return err
+		}
+		delay = 0
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ps.handleConn(conn)
+		}()
+	}
+}
+
+// acceptBackoff 返回 accept 出错之后下一次重试前的等待时间，从 5ms 开始每次翻倍，最多等待 1s。
+func acceptBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return 5 * time.Millisecond
+	}
+
+	if delay *= 2; delay > time.Second {
+		return time.Second
+	}
+	return delay
+}
+
+// handleConn 处理一个连接上的所有请求。
+// 处理器中的 panic 只会关闭这一个连接，不会导致整个进程退出。
+func (ps *protocolServer) handleConn(rawConn net.Conn) {
+	defer rawConn.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			helpers.Error("Close connection after panic", "remote", rawConn.RemoteAddr(), "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	// 包装之后的连接会统计读写的字节数
+	conn, stats := ps.clients.register(rawConn)
+	defer ps.clients.unregister(stats)
+
+	// 将连接包装成缓冲读取器，提高读取的性能
+	reader := bufio.NewReader(conn)
+
+	connections.Add(1)
+	defer connections.Add(-1)
+
+	// 热加载之后这些配置只会对新的连接生效
+	options := ps.options.load()
+	idleTimeout := options.IdleTimeout
+	limits := frameLimits{
+		maxArgSize:   options.MaxArgSize,
+		maxFrameSize: options.MaxFrameSize,
+	}
+
+	// 响应会先写入缓冲区，只有在已经读取的请求都处理完了，也就是下一次读取可能会阻塞的时候才发送出去
+	// 客户端使用 pipeline 一次发送多个请求的话，这些请求的响应只需要一次系统调用就可以发送出去
+	writer := newResponseWriter(conn, options.WriteBufferSize)
+
+	s := &session{conn: conn}
+	for {
+		// 每次读取请求前都刷新一下读取期限，如果连接空闲的时间超过了 IdleTimeout，读取就会失败，然后关闭连接
+		// 这样崩溃的客户端留下的半开连接就不会一直堆积
+		if idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+
+		// 注意协商的结果要从下一个帧开始生效，所以握手命令的响应帧还是使用握手之前的状态
+		// 参数使用的缓冲区在响应写入之后就会放回池中，所以处理器需要保留参数的话必须复制一份
+		checksum := s.checksum
+		buf := getBuffer()
+		command, args, err := readRequestFrom(reader, limits, checksum, buf)
+		if err == errArgTooLarge || err == errFrameTooLarge || err == errTooManyArgs || err == errChecksumMismatch {
+			// 超过大小限制的请求帧剩下的数据是不会读取的，校验失败的连接也已经不可信了，所以告知客户端原因之后就关闭连接
+			helpers.Warn("Close connection with invalid request frame", "remote", conn.RemoteAddr(), "err", err)
+			buf.release()
+			writeResponseTo(writer, errorReply, []byte(err.Error()), checksum)
+			writer.flush()
+			return
+		}
+
+		if err != nil {
+			buf.release()
+			// 不管是超时、协议不匹配还是连接关闭，这个连接上的数据流都已经不可信了，直接关闭连接
+			return
+		}
+
+		// 协商了追踪的连接，请求帧的第一个参数是追踪 ID
+		traceID := ""
+		if s.trace && len(args) > 0 {
+			traceID = string(args[0])
+			args = args[1:]
+		}
+
+		// 处理器是不接收 context 的，所以命令的 span 只能通过追踪 ID 和客户端的追踪关联起来
+		_, span := tracing.Start(tracing.ContextWithRemoteTraceID(context.Background(), traceID), "kafo.command "+commandName(command),
+			tracing.Attr("kafo.command", commandName(command)), tracing.Attr("kafo.trace_id", traceID))
+		beginTime := time.Now()
+		stats.observe(command)
+		reply, body, err := ps.handleRequest(s, command, args)
+		span.End(err)
+		observeCommand(command, beginTime, err)
+		if err != nil {
+			body = []byte(err.Error())
+		} else {
+			ps.auditor.auditCommand(conn.RemoteAddr().String(), command, args, body)
+		}
+		slowLog(ps.options.load(), traceID, "command "+strconv.Itoa(int(command)), beginTime)
+
+		// 丢弃响应的时候需要关闭连接，否则后面的响应就和请求对不上了
+		if ps.faults.drop() {
+			buf.release()
+			writer.flush()
+			return
+		}
+
+		err = writeResponseTo(writer, reply, body, checksum)
+		buf.release()
+		if err != nil {
+			return
+		}
+
+		if err = writer.done(reader.Buffered() > 0); err != nil {
+			return
+		}
+	}
+}
+
+// responseWriter 是带缓冲的响应写入器，缓冲区满了的时候也会自动发送。
+type responseWriter struct {
+	*bufio.Writer
+
+	// batching 表示是否会等到已经读取的请求都处理完了再发送响应。
+	batching bool
+}
+
+// newResponseWriter 返回一个写入 conn 的响应写入器，size 为 0 的话每个响应都会马上发送。
+func newResponseWriter(conn net.Conn, size int) *responseWriter {
+	if size <= 0 {
+		return &responseWriter{Writer: bufio.NewWriter(conn)}
+	}
+	return &responseWriter{Writer: bufio.NewWriterSize(conn, size), batching: true}
+}
+
+// done 在写入一个响应之后调用，pending 表示是否还有已经读取但是没有处理的请求，有的话就先不发送。
+func (rw *responseWriter) done(pending bool) error {
+	if rw.batching && pending {
+		return nil
+	}
+	return rw.flush()
+}
+
+// flush 发送缓冲区中的所有响应。
+func (rw *responseWriter) flush() error {
+	if rw.Buffered() == 0 {
+		return nil
+	}
+
+	flushes.Inc()
+	return rw.Flush()
+}
+
+// handleRequest 找到命令对应的处理器并处理请求。
+func (ps *protocolServer) handleRequest(s *session, command byte, args [][]byte) (reply byte, body []byte, err error) {
+	handle, ok := ps.handlers[command]
+	if !ok {
+		return errorReply, nil, errCommandHandlerNotFound
+	}
+
+	if err = ps.faults.before(command); err != nil {
+		return errorReply, nil, err
+	}
+
+	body, err = handle(s, args)
+	if err != nil {
+		return errorReply, body, err
+	}
+	return successReply, body, nil
+}
+
+// Close 关闭服务器的所有监听器。
+func (ps *protocolServer) Close() error {
+	atomic.StoreInt32(&ps.closed, 1)
+	return closeListeners(ps.listeners)
+}
@@ -0,0 +1,391 @@
+package servers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestReadRequestFromWithLimits$
+func TestReadRequestFromWithLimits(t *testing.T) {
+	request := []byte{frameVersion, getCommand, 0, 0, 0, 1, 0, 0, 0, 3, 'k', 'e', 'y'}
+	command, args, err := readRequestFrom(bytes.NewReader(request), frameLimits{maxArgSize: 3, maxFrameSize: 64}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if command != getCommand || len(args) != 1 || string(args[0]) != "key" {
+		t.Fatalf("command %d or args %v is wrong", command, args)
+	}
+
+	_, _, err = readRequestFrom(bytes.NewReader(request), frameLimits{maxArgSize: 2}, false, nil)
+	if err != errArgTooLarge {
+		t.Fatalf("err %v should be %v", err, errArgTooLarge)
+	}
+
+	// 声称有 0xFFFFFFFF 个参数的请求帧应该在分配参数列表之前就被拒绝
+	request = []byte{frameVersion, getCommand, 0xFF, 0xFF, 0xFF, 0xFF}
+	_, _, err = readRequestFrom(bytes.NewReader(request), frameLimits{maxFrameSize: 1024}, false, nil)
+	if err != errFrameTooLarge {
+		t.Fatalf("err %v should be %v", err, errFrameTooLarge)
+	}
+}
+
+// go test -v -run=^TestReadRequestFromWithHugeArgsLength$
+func TestReadRequestFromWithHugeArgsLength(t *testing.T) {
+	// 只有头部的请求帧声称有 0x02000000 个参数，按照参数个数分配参数列表的话需要 800 MB 内存
+	request := []byte{frameVersion, getCommand, 0x02, 0, 0, 0}
+	options := DefaultOptions()
+	_, _, err := readRequestFrom(bytes.NewReader(request), frameLimits{maxArgSize: options.MaxArgSize, maxFrameSize: options.MaxFrameSize}, false, nil)
+	if err != errFrameTooLarge {
+		t.Fatalf("err %v should be %v", err, errFrameTooLarge)
+	}
+
+	// 没有限制帧大小的时候，参数个数也是有限制的
+	_, _, err = readRequestFrom(bytes.NewReader(request), frameLimits{maxArgSize: options.MaxArgSize}, false, nil)
+	if err != errTooManyArgs {
+		t.Fatalf("err %v should be %v", err, errTooManyArgs)
+	}
+
+	// 参数个数在限制之内或者完全不限制的话，没有参数内容的请求帧也不会分配参数个数那么多的内存
+	for _, limits := range []frameLimits{{maxArgSize: options.MaxArgSize, maxFrameSize: options.MaxFrameSize}, {}} {
+		request = []byte{frameVersion, getCommand, 0, 0x10, 0, 0}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, _, err = readRequestFrom(bytes.NewReader(request), limits, false, nil)
+		runtime.ReadMemStats(&after)
+		if err != io.EOF {
+			t.Fatalf("err %v should be %v", err, io.EOF)
+		}
+
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64*1024 {
+			t.Fatalf("allocated %d bytes for a frame without arguments", allocated)
+		}
+	}
+}
+
+// go test -v -run=^TestReadRequestFromWithChecksum$
+func TestReadRequestFromWithChecksum(t *testing.T) {
+	request := appendChecksum([]byte{frameVersion, getCommand, 0, 0, 0, 1, 0, 0, 0, 3, 'k', 'e', 'y'})
+	_, args, err := readRequestFrom(bytes.NewReader(request), frameLimits{}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(args[0]) != "key" {
+		t.Fatalf("args %v is wrong", args)
+	}
+
+	// 模拟传输过程中数据损坏
+	request[10] = 'c'
+	_, _, err = readRequestFrom(bytes.NewReader(request), frameLimits{}, true, nil)
+	if err != errChecksumMismatch {
+		t.Fatalf("err %v should be %v", err, errChecksumMismatch)
+	}
+}
+
+// go test -v -run=^TestWriteRequestTo$
+func TestWriteRequestTo(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	err := writeRequestTo(buffer, setCommand, [][]byte{[]byte("key"), []byte("value")}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	command, args, err := readRequestFrom(buffer, frameLimits{}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if command != setCommand || len(args) != 2 || string(args[0]) != "key" || string(args[1]) != "value" {
+		t.Fatalf("command %d or args %v is wrong", command, args)
+	}
+}
+
+// go test -v -run=^TestProtocolClientPipelining$
+func TestProtocolClientPipelining(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	// 服务端按照请求的顺序返回参数本身作为响应
+	go func() {
+		for {
+			_, args, err := readRequestFrom(server, frameLimits{}, false, nil)
+			if err != nil {
+				return
+			}
+			writeResponseTo(server, successReply, args[0], false)
+		}
+	}()
+
+	pc := newProtocolClientOn(client)
+	defer pc.Close()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(no int) {
+			defer wg.Done()
+			arg := strconv.Itoa(no)
+			body, err := pc.Do(getCommand, [][]byte{[]byte(arg)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if string(body) != arg {
+				t.Errorf("body %s should be %s", body, arg)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// go test -v -run=^TestProtocolClientDoContext$
+func TestProtocolClientDoContext(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	// 服务端只读取请求，永远不返回响应，模拟一个很慢的节点
+	go io.Copy(ioutil.Discard, server)
+
+	pc := newProtocolClientOn(client)
+	defer pc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pc.DoContext(ctx, getCommand, [][]byte{[]byte("key")}); err != context.DeadlineExceeded {
+		t.Fatalf("err %v should be %v", err, context.DeadlineExceeded)
+	}
+}
+
+// closeOnReadConn 是第一次读取到数据之后、返回之前会调用 onRead 的连接，用于模拟读取响应的时候连接被关闭了。
+type closeOnReadConn struct {
+	net.Conn
+	onRead func()
+	once   sync.Once
+}
+
+func (c *closeOnReadConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.once.Do(c.onRead)
+	}
+	return n, err
+}
+
+// go test -v -run=^TestProtocolClientCloseWhileReading$
+func TestProtocolClientCloseWhileReading(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	// 整个响应帧一次性写入，这样客户端一次就能读到完整的响应
+	go func() {
+		if _, _, err := readRequestFrom(server, frameLimits{}, false, nil); err != nil {
+			return
+		}
+
+		response := &bytes.Buffer{}
+		writeResponseTo(response, successReply, []byte("value"), false)
+		server.Write(response.Bytes())
+	}()
+
+	conn := &closeOnReadConn{Conn: client}
+	pc := newProtocolClientOn(conn)
+	conn.onRead = func() {
+		pc.Close()
+	}
+
+	// 读到响应之后连接已经被关闭了，请求已经收到了关闭的错误，读取协程不能再把响应交给它
+	if _, err := pc.Do(getCommand, [][]byte{[]byte("key")}); err != errClientClosed {
+		t.Fatalf("err %v should be %v", err, errClientClosed)
+	}
+
+	if !pc.broken() {
+		t.Fatal("closed client should be broken")
+	}
+}
+
+// go test -v -run=^TestProtocolClientDoPipelineContext$
+func TestProtocolClientDoPipelineContext(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	// 服务端按照请求的顺序返回参数本身作为响应，参数是 error 的时候返回错误
+	go func() {
+		for {
+			_, args, err := readRequestFrom(server, frameLimits{}, false, nil)
+			if err != nil {
+				return
+			}
+
+			if string(args[0]) == "error" {
+				writeResponseTo(server, errorReply, []byte(errNotFound.Error()), false)
+				continue
+			}
+			writeResponseTo(server, successReply, args[0], false)
+		}
+	}()
+
+	pc := newProtocolClientOn(client)
+	defer pc.Close()
+
+	requests := make([]pipelineRequest, 10)
+	for i := range requests {
+		requests[i] = pipelineRequest{command: getCommand, args: [][]byte{[]byte(strconv.Itoa(i))}}
+	}
+	requests[5].args = [][]byte{[]byte("error")}
+
+	results, err := pc.DoPipelineContext(context.Background(), requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, result := range results {
+		if i == 5 {
+			if !errors.Is(result.err, ErrNotFound) {
+				t.Errorf("err %v should be %v", result.err, ErrNotFound)
+			}
+			continue
+		}
+
+		if result.err != nil || string(result.body) != strconv.Itoa(i) {
+			t.Errorf("result %d is %s, %v", i, result.body, result.err)
+		}
+	}
+}
+
+// go test -v -run=^TestProtocolServerBatchesResponses$
+func TestProtocolServerBatchesResponses(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	options := DefaultOptions()
+	ps := newProtocolServer(newLiveOptions(&options))
+	ps.RegisterHandler(getCommand, func(args [][]byte) ([]byte, error) {
+		return args[0], nil
+	})
+	go ps.handleConn(server)
+
+	// 一次写入多个请求，这些请求的响应只会发送一次
+	requests := bytes.NewBuffer(nil)
+	for i := 0; i < 3; i++ {
+		writeRequestTo(requests, getCommand, [][]byte{[]byte(strconv.Itoa(i))}, false)
+	}
+
+	before := flushes.Value()
+	if _, err := client.Write(requests.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, body, err := readResponseFrom(client, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(body) != strconv.Itoa(i) {
+			t.Fatalf("body %s should be %d", body, i)
+		}
+	}
+
+	if n := flushes.Value() - before; n != 1 {
+		t.Fatalf("flushes %v should be 1", n)
+	}
+}
+
+// go test -v -run=^TestProtocolServerRecoversFromPanic$
+func TestProtocolServerRecoversFromPanic(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	options := DefaultOptions()
+	ps := newProtocolServer(newLiveOptions(&options))
+	ps.RegisterHandler(getCommand, func(args [][]byte) ([]byte, error) {
+		return args[1], nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ps.handleConn(server)
+	}()
+
+	// 处理器 panic 之后只会关闭这个连接，客户端读不到响应
+	go writeRequestTo(client, getCommand, [][]byte{[]byte("key")}, false)
+	if _, _, err := readResponseFrom(client, false); err == nil {
+		t.Fatal("reading response from a panicked connection should fail")
+	}
+	<-done
+}
+
+// go test -v -run=^TestTCPServerSetHandlerChecksTTL$
+func TestTCPServerSetHandlerChecksTTL(t *testing.T) {
+	ts := &TCPServer{}
+	if _, err := ts.setHandler([][]byte{{1, 2}, []byte("key"), []byte("value")}); err != errInvalidNumberArg {
+		t.Fatalf("err %v should be errInvalidNumberArg", err)
+	}
+}
+
+// temporaryError 是一个临时的网络错误，比如文件描述符用完了。
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// errorListener 是 accept 的时候依次返回 errs 中的错误的监听器。
+type errorListener struct {
+	net.Listener
+	errs []error
+}
+
+func (el *errorListener) Accept() (net.Conn, error) {
+	err := el.errs[0]
+	if len(el.errs) > 1 {
+		el.errs = el.errs[1:]
+	}
+	return nil, err
+}
+
+func (el *errorListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// go test -v -run=^TestProtocolServerAcceptErrors$
+func TestProtocolServerAcceptErrors(t *testing.T) {
+	delays := []time.Duration{5 * time.Millisecond, 10 * time.Millisecond}
+	delay := time.Duration(0)
+	for _, want := range delays {
+		if delay = acceptBackoff(delay); delay != want {
+			t.Fatalf("delay %v should be %v", delay, want)
+		}
+	}
+
+	if delay = acceptBackoff(800 * time.Millisecond); delay != time.Second {
+		t.Fatalf("delay %v should be capped at 1s", delay)
+	}
+
+	// 临时错误会退避之后重试，其他错误会结束 accept 循环
+	options := DefaultOptions()
+	ps := newProtocolServer(newLiveOptions(&options))
+	permanent := errors.New("permanent")
+	listener := &errorListener{errs: []error{temporaryError{}, temporaryError{}, permanent}}
+	if err := ps.serve(listener); err != permanent {
+		t.Fatalf("err %v should be %v", err, permanent)
+	}
+
+	// 关闭之后监听器返回的错误不算是出错
+	ps.Close()
+	if err := ps.serve(&errorListener{errs: []error{permanent}}); err != nil {
+		t.Fatalf("err %v should be nil after closing", err)
+	}
+}
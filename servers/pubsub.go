@@ -0,0 +1,146 @@
+package servers
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+const (
+	// pubsubHistorySize 是每个节点保留的最近发布的消息个数，订阅者断线重连之后可以从这些消息中补上错过的消息。
+	pubsubHistorySize = 1024
+
+	// pubsubPollTimeout 是 subscribe 命令最多等待新消息的时间，超时之后会返回空的结果，由客户端重新发起 subscribe 命令。
+	// 和 watch 命令一样，这个时间需要比连接的空闲超时短。
+	pubsubPollTimeout = 30 * time.Second
+
+	// unknownCursor 表示订阅者还没有游标，节点收到这个游标会马上返回当前的游标，只有之后发布的消息才会被订阅到。
+	unknownCursor = ^uint64(0)
+)
+
+// Message 是发布到频道中的一条消息。
+type Message struct {
+	// Seq 是消息在发布节点上的序号，同一个节点上的序号是递增的。
+	Seq uint64 `json:"seq"`
+
+	// Channel 是消息所属的频道。
+	Channel string `json:"channel"`
+
+	// Payload 是消息的内容。
+	Payload []byte `json:"payload"`
+}
+
+// pollResult 是 subscribe 命令的结果。
+type pollResult struct {
+	// Cursor 是下一次订阅需要带上的游标。
+	Cursor uint64 `json:"cursor"`
+
+	// Messages 是游标之后发布到订阅的频道中的消息。
+	Messages []*Message `json:"messages"`
+}
+
+// pubsub 是节点上的发布订阅组件，每个频道都和 key 一样属于一致性哈希选出来的节点，消息只会发布到所属节点上。
+// 订阅使用的是长轮询，订阅者带上游标等待新的消息，最近发布的消息会保留下来，所以订阅者断线重连之后不会丢失这段时间的消息，
+// 除非错过的消息已经超过了保留的个数。
+type pubsub struct {
+	// seq 是最后一条消息的序号。
+	seq uint64
+
+	// history 是最近发布的消息，是一个按照序号取模的环形缓冲区。
+	history []*Message
+
+	// published 会在有新消息发布的时候被关闭，用于唤醒所有等待的订阅者，关闭之后会换成一个新的通道。
+	published chan struct{}
+
+	// lock 用于保护上面的字段。
+	lock *sync.RWMutex
+}
+
+// newPubsub 返回一个发布订阅组件。
+func newPubsub() *pubsub {
+	return &pubsub{
+		history:   make([]*Message, pubsubHistorySize),
+		published: make(chan struct{}),
+		lock:      &sync.RWMutex{},
+	}
+}
+
+// publish 发布一条消息到频道中，并唤醒所有等待的订阅者，payload 会被复制一份保存，所以调用者之后可以修改它。
+func (ps *pubsub) publish(channel string, payload []byte) uint64 {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	ps.seq++
+	ps.history[ps.seq%pubsubHistorySize] = &Message{
+		Seq:     ps.seq,
+		Channel: channel,
+		Payload: helpers.Copy(payload),
+	}
+
+	close(ps.published)
+	ps.published = make(chan struct{})
+	return ps.seq
+}
+
+// poll 返回游标之后发布到 channels 中的消息，如果没有新的消息就一直等待，直到有新的消息或者 ctx 被取消了。
+func (ps *pubsub) poll(ctx context.Context, cursor uint64, channels map[string]bool) *pollResult {
+	for {
+		ps.lock.RLock()
+		result := ps.collect(cursor, channels)
+		published := ps.published
+		ps.lock.RUnlock()
+
+		// 没有游标的订阅者只需要拿到当前的游标就可以了
+		if cursor == unknownCursor || len(result.Messages) > 0 {
+			return result
+		}
+
+		select {
+		case <-published:
+			cursor = result.Cursor
+		case <-ctx.Done():
+			return result
+		}
+	}
+}
+
+// collect 返回游标之后发布到 channels 中的消息，调用者需要持有读锁。
+func (ps *pubsub) collect(cursor uint64, channels map[string]bool) *pollResult {
+	result := &pollResult{Cursor: ps.seq, Messages: []*Message{}}
+	if cursor == unknownCursor {
+		return result
+	}
+
+	// 游标比最后一条消息的序号还大，说明节点重启过，序号重新开始了，所以要从头开始
+	if cursor > ps.seq {
+		cursor = 0
+	}
+
+	// 超出保留个数的消息已经被覆盖了，只能从最早保留的消息开始
+	if ps.seq-cursor > pubsubHistorySize {
+		cursor = ps.seq - pubsubHistorySize
+	}
+
+	for seq := cursor + 1; seq <= ps.seq; seq++ {
+		if message := ps.history[seq%pubsubHistorySize]; channels[message.Channel] {
+			result.Messages = append(result.Messages, message)
+		}
+	}
+	return result
+}
+
+// subscribeArgs 返回 subscribe 命令的参数，依次是游标和订阅的频道。
+func subscribeArgs(cursor uint64, channels []string) [][]byte {
+	cursorBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(cursorBytes, cursor)
+
+	args := make([][]byte, 0, len(channels)+1)
+	args = append(args, cursorBytes)
+	for _, channel := range channels {
+		args = append(args, []byte(channel))
+	}
+	return args
+}
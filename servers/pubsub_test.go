@@ -0,0 +1,47 @@
+package servers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestPubsubPoll$
+func TestPubsubPoll(t *testing.T) {
+	ps := newPubsub()
+	channels := map[string]bool{"news": true}
+
+	// 没有游标的订阅者只会收到之后发布的消息
+	ps.publish("news", []byte("old"))
+	result := ps.poll(context.Background(), unknownCursor, channels)
+	if result.Cursor != 1 || len(result.Messages) != 0 {
+		t.Fatalf("result %+v should have cursor 1 and no messages", result)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ps.publish("sports", []byte("skipped"))
+		ps.publish("news", []byte("new"))
+	}()
+
+	result = ps.poll(context.Background(), result.Cursor, channels)
+	if result.Cursor != 3 || len(result.Messages) != 1 || string(result.Messages[0].Payload) != "new" {
+		t.Fatalf("result %+v should have cursor 3 and message new", result)
+	}
+
+	// 超出保留个数的消息会被覆盖，只能收到保留着的消息
+	for i := 0; i < pubsubHistorySize+10; i++ {
+		ps.publish("news", nil)
+	}
+	result = ps.poll(context.Background(), 3, channels)
+	if len(result.Messages) != pubsubHistorySize || result.Messages[0].Seq != result.Cursor-pubsubHistorySize+1 {
+		t.Fatalf("result should have %d messages from seq %d", pubsubHistorySize, result.Cursor-pubsubHistorySize+1)
+	}
+
+	// 没有新的消息的话会一直等待，直到 ctx 被取消
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if result = ps.poll(ctx, result.Cursor, channels); len(result.Messages) != 0 {
+		t.Fatalf("result %+v should have no messages", result)
+	}
+}
@@ -0,0 +1,81 @@
+package servers
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+)
+
+var (
+	errReloadNotSupported = errors.New("reload is not supported")
+)
+
+// ReloadFunc 重新读取配置并热加载，由程序的入口设置，收到热加载的请求时会被调用。
+type ReloadFunc func() error
+
+// liveOptions 保存着服务器最新的选项配置，热加载的时候会整体替换成新的配置，所以读取的时候不需要加锁。
+type liveOptions struct {
+	value atomic.Value
+}
+
+// newLiveOptions 返回一个使用 options 初始化的 liveOptions。
+func newLiveOptions(options *Options) *liveOptions {
+	lo := &liveOptions{}
+	lo.value.Store(options)
+	return lo
+}
+
+// load 返回当前的选项配置，返回的配置是只读的，不能修改。
+func (lo *liveOptions) load() *Options {
+	return lo.value.Load().(*Options)
+}
+
+// reload 使用 options 中可以热加载的配置替换当前的配置，包括请求的处理期限、连接的空闲超时、请求的大小限制、慢日志的阈值、告警的地址和阈值以及故障注入的配置。
+// 监听地址、集群和复制之类的配置需要重启才会生效，连接的空闲超时、请求的大小限制和写缓冲区大小只会对新的连接生效。
+func (lo *liveOptions) reload(options Options) {
+	newOptions := *lo.load()
+	newOptions.RequestTimeout = options.RequestTimeout
+	newOptions.IdleTimeout = options.IdleTimeout
+	newOptions.MaxArgSize = options.MaxArgSize
+	newOptions.MaxFrameSize = options.MaxFrameSize
+	newOptions.MaxChunkedSize = options.MaxChunkedSize
+	newOptions.MaxChunkedInFlight = options.MaxChunkedInFlight
+	newOptions.WriteBufferSize = options.WriteBufferSize
+	newOptions.SlowLogThreshold = options.SlowLogThreshold
+	newOptions.AlertWebhook = options.AlertWebhook
+	newOptions.AlertMemoryPercent = options.AlertMemoryPercent
+	newOptions.AlertEvictionRate = options.AlertEvictionRate
+	newOptions.AlertDumpFailures = options.AlertDumpFailures
+	newOptions.FaultLatency = options.FaultLatency
+	newOptions.FaultDropPercent = options.FaultDropPercent
+	newOptions.FaultRedirectPercent = options.FaultRedirectPercent
+	lo.value.Store(&newOptions)
+}
+
+// Reload 热加载服务器的选项配置，只有运行过程中可以修改的配置才会生效。
+func (n *node) Reload(options Options) {
+	n.live.reload(options)
+}
+
+// OnReload 设置收到热加载请求时调用的函数，需要在服务器运行之前设置。
+func (n *node) OnReload(reload ReloadFunc) {
+	n.reloadFunc = reload
+}
+
+// reload 调用 OnReload 设置的函数进行热加载。
+func (n *node) reload() error {
+	if n.reloadFunc == nil {
+		return errReloadNotSupported
+	}
+	return n.reloadFunc()
+}
+
+// setLogLevel 在运行时修改日志级别，修改之后再热加载配置的话，日志级别会被配置中的值覆盖。
+func setLogLevel(level string) error {
+	if err := helpers.SetLogLevel(level); err != nil {
+		return err
+	}
+	helpers.Info("Log level changed", "level", level)
+	return nil
+}
@@ -0,0 +1,196 @@
+package servers
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+	"github.com/herrhu97/go-distributed-cache/metrics"
+)
+
+const (
+	// replicationQueueSize 是每个副本节点等待复制的数据的最大个数，队列满了之后新的数据会被丢弃。
+	replicationQueueSize = 4096
+
+	// replicationTimeout 是建立复制连接以及复制一条数据的超时时间。
+	replicationTimeout = 3 * time.Second
+)
+
+var (
+	errInvalidReplication = errors.New("invalid replication arguments")
+
+	errReplicatorClosed = errors.New("replicator is closed")
+)
+
+// encodeReplication 将需要复制的操作编码成 replicate 命令的参数，依次是操作的命令、ttl、key 和 value。
+func encodeReplication(command byte, key string, value []byte, ttl int64) [][]byte {
+	ttlBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
+	return [][]byte{{command}, ttlBytes, []byte(key), value}
+}
+
+// decodeReplication 从 replicate 命令的参数中解析出操作的命令、ttl、key 和 value。
+func decodeReplication(args [][]byte) (command byte, key string, value []byte, ttl int64, err error) {
+	if len(args) < 4 || len(args[0]) != 1 || len(args[1]) != 8 {
+		return 0, "", nil, 0, errInvalidReplication
+	}
+	return args[0][0], string(args[2]), args[3], int64(binary.BigEndian.Uint64(args[1])), nil
+}
+
+// replicaLink 是到某个副本节点的复制通道，同一个节点的数据会按照写入的顺序依次复制，保证副本上的操作顺序和所属节点一致。
+type replicaLink struct {
+	// address 是副本节点的地址。
+	address string
+
+	// tasks 存储着等待复制的数据，也就是 replicate 命令的参数。
+	tasks chan [][]byte
+
+	// client 是到副本节点的连接，断开之后会在复制下一条数据的时候重新建立。
+	client *protocolClient
+}
+
+// replicator 是复制器，负责把所属节点写入的数据异步地复制到副本节点上。
+// 复制是尽力而为的，副本节点不可用或者复制的队列满了，数据都会被丢弃，所以副本上的数据只能作为所属节点不可用时的兜底。
+type replicator struct {
+	// node 是当前节点。
+	node *node
+
+	// links 存储着到每个副本节点的复制通道，key 是节点地址。
+	links map[string]*replicaLink
+
+	// closed 表示复制器是否已经关闭了。
+	closed bool
+
+	// lock 用于保护 links 和 closed。
+	lock *sync.Mutex
+
+	// ctx 会在复制器关闭的时候被取消，用于通知复制的协程退出。
+	ctx context.Context
+
+	// cancel 用于取消 ctx。
+	cancel context.CancelFunc
+}
+
+// newReplicator 返回一个复制器。
+func newReplicator(node *node) *replicator {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &replicator{
+		node:   node,
+		links:  map[string]*replicaLink{},
+		lock:   &sync.Mutex{},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	metrics.Default.GaugeFunc("kafo_replication_queue_depth", "The number of entries waiting to be replicated.", r.queueDepth)
+	return r
+}
+
+// queueDepth 返回所有复制通道中等待复制的数据个数。
+func (r *replicator) queueDepth() float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	depth := 0
+	for _, link := range r.links {
+		depth += len(link.tasks)
+	}
+	return float64(depth)
+}
+
+// replicate 将操作复制到 key 的所有副本节点上，只有所属节点才需要调用。
+func (r *replicator) replicate(command byte, key string, value []byte, ttl int64) {
+	replicas, err := r.node.replicasOf(key)
+	if err != nil {
+		return
+	}
+
+	// 复制是异步进行的，而 value 引用着请求的缓冲区，所以需要复制一份
+	args := encodeReplication(command, key, helpers.Copy(value), ttl)
+	for _, replica := range replicas {
+		if r.node.isCurrentNode(replica) {
+			continue
+		}
+
+		link, err := r.linkOf(replica)
+		if err != nil {
+			return
+		}
+
+		select {
+		case link.tasks <- args:
+		default:
+			helpers.Warn("Replication queue is full, drop key", "node", replica, "key", key)
+		}
+	}
+}
+
+// linkOf 返回到副本节点的复制通道，如果还没有就创建一个，并开启一个协程进行复制。
+func (r *replicator) linkOf(address string) (*replicaLink, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return nil, errReplicatorClosed
+	}
+
+	if link, ok := r.links[address]; ok {
+		return link, nil
+	}
+
+	link := &replicaLink{
+		address: address,
+		tasks:   make(chan [][]byte, replicationQueueSize),
+	}
+	r.links[address] = link
+	go r.work(link)
+	return link, nil
+}
+
+// work 依次将通道中的数据复制到副本节点上，直到复制器被关闭。
+func (r *replicator) work(link *replicaLink) {
+	defer func() {
+		if link.client != nil {
+			link.client.Close()
+		}
+	}()
+
+	for {
+		select {
+		case args := <-link.tasks:
+			if err := r.send(link, args); err != nil {
+				helpers.Error("Replicate failed", "node", link.address, "err", err)
+			}
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// send 将一条数据复制到副本节点上，连接断开的话会先重新建立连接。
+func (r *replicator) send(link *replicaLink, args [][]byte) error {
+	if link.client == nil || link.client.broken() {
+		client, err := newProtocolClient(link.address, replicationTimeout, nil)
+		if err != nil {
+			return err
+		}
+		link.client = client
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, replicationTimeout)
+	defer cancel()
+	_, err := link.client.DoContext(ctx, replicateCommand, args)
+	return err
+}
+
+// Close 关闭复制器，还没复制的数据会被丢弃。
+func (r *replicator) Close() error {
+	r.lock.Lock()
+	r.closed = true
+	r.lock.Unlock()
+
+	r.cancel()
+	return nil
+}
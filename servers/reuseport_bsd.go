@@ -0,0 +1,9 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package servers
+
+import "syscall"
+
+// soReusePort 是 SO_REUSEPORT 选项的值。
+const soReusePort = syscall.SO_REUSEPORT
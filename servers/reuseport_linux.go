@@ -0,0 +1,4 @@
+package servers
+
+// soReusePort 是 Linux 上 SO_REUSEPORT 选项的值，syscall 包在 Linux 上没有导出这个常量。
+const soReusePort = 0xf
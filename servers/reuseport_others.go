@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package servers
+
+import "errors"
+
+// setReusePort 在不支持 SO_REUSEPORT 的平台上直接返回错误。
+func setReusePort(fd uintptr) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
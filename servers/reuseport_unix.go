@@ -0,0 +1,11 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package servers
+
+import "syscall"
+
+// setReusePort 给 fd 开启 SO_REUSEPORT 选项，允许多个套接字监听同一个地址和端口。
+func setReusePort(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+}
@@ -1,6 +1,10 @@
 package servers
 
-import "cache-server/caches"
+import (
+	"context"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+)
 
 const (
 	// APIVersion 代表当前服务的版本。
@@ -9,10 +13,32 @@ const (
 	APIVersion = "v1"
 )
 
+// 版本信息可以在编译的时候通过 ldflags 注入，比如：
+// go build -ldflags "-X github.com/herrhu97/go-distributed-cache/servers.Commit=$(git rev-parse --short HEAD) -X github.com/herrhu97/go-distributed-cache/servers.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	// Version 是当前服务的版本号。
+	Version = "v0.1.0"
+
+	// Commit 是编译时代码的 git 提交号，没有注入的话为空。
+	Commit = ""
+
+	// BuildTime 是编译的时间，没有注入的话为空。
+	BuildTime = ""
+)
+
 // Server 是服务器结构的接口
 type Server interface {
 	// Run 会将服务器启动指定的 address 上。
 	Run() error
+
+	// Reload 热加载服务器的选项配置，只有运行过程中可以修改的配置才会生效，其他的配置需要重启才会生效。
+	Reload(options Options)
+
+	// OnReload 设置收到热加载请求时调用的函数，需要在服务器运行之前设置。
+	OnReload(reload ReloadFunc)
+
+	// Close 关闭服务器，会先离开集群，然后停止监听，Run 会在所有的连接处理完之后返回。
+	Close() error
 }
 
 // NewServer 返回一个服务端实例，通过serverType区分
@@ -22,3 +48,12 @@ func NewServer(cache *caches.Cache, options Options) (Server, error) {
 	}
 	return NewHTTPServer(cache, &options)
 }
+
+// requestContext 返回一个带有请求处理期限的上下文，期限由选项配置中的 RequestTimeout 决定。
+// 如果没有设置 RequestTimeout，返回的上下文就没有处理期限。
+func requestContext(parent context.Context, options *Options) (context.Context, context.CancelFunc) {
+	if options.RequestTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, options.RequestTimeout)
+}
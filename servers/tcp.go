@@ -1,14 +1,15 @@
 package servers
 
 import (
-	"cache-server/caches"
-	"cache-server/helpers"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"path"
+	"time"
 
-	"github.com/FishGoddess/vex"
+	"github.com/herrhu97/go-distributed-cache/caches"
 )
 
 const (
@@ -21,16 +22,76 @@ const (
 	statusCommand = byte(4)
 
 	nodesCommand = byte(5)
+
+	infoCommand = byte(6)
+
+	pingCommand = byte(7)
+
+	handshakeCommand = byte(8)
+
+	execCommand = byte(9)
+
+	mgetCommand = byte(10)
+
+	msetCommand = byte(11)
+
+	watchCommand = byte(12)
+
+	replicateCommand = byte(13)
+
+	replicaGetCommand = byte(14)
+
+	keysCommand = byte(15)
+
+	publishCommand = byte(16)
+
+	subscribeCommand = byte(17)
+
+	incrCommand = byte(18)
+
+	expireCommand = byte(19)
+
+	existsCommand = byte(20)
+
+	reloadCommand = byte(21)
+
+	evictionPolicyCommand = byte(22)
+
+	configCommand = byte(23)
+
+	logLevelCommand = byte(24)
+
+	clientsCommand = byte(25)
+
+	chunkSetCommand = byte(26)
+
+	chunkGetCommand = byte(27)
+
+	chunkCommand = byte(28)
+)
+
+const (
+	// watchTimeout 是 watch 命令最多等待拓扑变化的时间，超时之后会返回当前的拓扑，由客户端重新发起 watch 命令。
+	// 这个时间需要比连接的空闲超时短，否则等待的过程中连接就会被关闭。
+	watchTimeout = 30 * time.Second
 )
 
 var (
 	errCommandNeedsMoreArguments = errors.New("command needs more arguments")
 
 	errNotFound = errors.New("not found")
+
+	errInvalidKeysArgs = errors.New("invalid keys arguments")
+
+	errInvalidSubscribeArgs = errors.New("invalid subscribe arguments")
+
+	errInvalidNumberArg = errors.New("invalid number argument")
+
+	// pong 是 ping 命令的响应内容。
+	pong = []byte("pong")
 )
 
 // TCPServer 是TCP类型的服务器
-// TCPClient 理应做并发安全的保证也没做，所以这个客户端在并发下是有问题
 type TCPServer struct {
 	*node
 
@@ -38,24 +99,56 @@ type TCPServer struct {
 	cache *caches.Cache
 
 	// server 是内部真正用于服务的服务器。
-	server *vex.Server
+	server *protocolServer
+
+	// replicator 是复制器，只有开启了复制才会创建，否则是 nil。
+	replicator *replicator
+
+	// pubsub 是发布订阅组件，保存着当前节点所属的频道中最近发布的消息。
+	pubsub *pubsub
+
+	// auditor 记录成功执行的写命令，没有配置审计日志的话是 nil。
+	auditor *auditor
+
+	// transfers 记录着正在进行的分块传输。
+	transfers *transferRegistry
 
 	options *Options
 }
 
 // NewTCPServer 返回新的TCP服务器
 func NewTCPServer(cache *caches.Cache, options *Options) (*TCPServer, error) {
-	n, err := newNode(options)
+	if err := newBackingStore(cache, options); err != nil {
+		return nil, err
+	}
+
+	auditor, err := newAuditor(options)
 	if err != nil {
 		return nil, err
 	}
 
-	return &TCPServer{
-		node:    n,
-		cache:   cache,
-		server:  vex.NewServer(),
-		options: options,
-	}, nil
+	n, err := newNode(options, cache)
+	if err != nil {
+		auditor.Close()
+		return nil, err
+	}
+
+	server := &TCPServer{
+		node:      n,
+		cache:     cache,
+		server:    newProtocolServer(n.live),
+		pubsub:    newPubsub(),
+		auditor:   auditor,
+		transfers: newTransferRegistry(),
+		options:   options,
+	}
+	server.server.auditor = auditor
+	server.server.faults = newFaultInjector(n.live, n.redirectTarget)
+
+	if options.ReplicationFactor > 1 && options.FeatureEnabled(FeatureReplication) {
+		server.replicator = newReplicator(n)
+	}
+	return server, nil
 }
 
 // Run 运行这个TCP服务器
@@ -66,12 +159,51 @@ func (ts *TCPServer) Run() error {
 	ts.server.RegisterHandler(statusCommand, ts.statusHandler)
 
 	ts.server.RegisterHandler(nodesCommand, ts.nodesHandler)
-	return ts.server.ListenAndServe("tcp", helpers.JoinAddressAndPort(ts.options.Address, ts.options.Port))
+	ts.server.RegisterHandler(infoCommand, ts.infoHandler)
+	ts.server.RegisterHandler(pingCommand, ts.pingHandler)
+	ts.server.RegisterSessionHandler(handshakeCommand, ts.handshakeHandler)
+	ts.server.RegisterHandler(execCommand, ts.execHandler)
+	ts.server.RegisterHandler(mgetCommand, ts.mgetHandler)
+	ts.server.RegisterHandler(msetCommand, ts.msetHandler)
+	ts.server.RegisterHandler(watchCommand, ts.watchHandler)
+	ts.server.RegisterHandler(replicateCommand, ts.replicateHandler)
+	ts.server.RegisterHandler(replicaGetCommand, ts.replicaGetHandler)
+	ts.server.RegisterHandler(keysCommand, ts.keysHandler)
+	ts.server.RegisterHandler(publishCommand, ts.publishHandler)
+	ts.server.RegisterHandler(subscribeCommand, ts.subscribeHandler)
+	ts.server.RegisterHandler(incrCommand, ts.incrHandler)
+	ts.server.RegisterHandler(expireCommand, ts.expireHandler)
+	ts.server.RegisterHandler(existsCommand, ts.existsHandler)
+	ts.server.RegisterHandler(reloadCommand, ts.reloadHandler)
+	ts.server.RegisterHandler(evictionPolicyCommand, ts.evictionPolicyHandler)
+	ts.server.RegisterHandler(configCommand, ts.configHandler)
+	ts.server.RegisterHandler(logLevelCommand, ts.logLevelHandler)
+	ts.server.RegisterHandler(clientsCommand, ts.clientsHandler)
+	ts.server.RegisterHandler(chunkSetCommand, ts.chunkSetHandler)
+	ts.server.RegisterHandler(chunkGetCommand, ts.chunkGetHandler)
+	ts.server.RegisterSessionHandler(chunkCommand, ts.chunkHandler)
+	return ts.server.ListenAndServe()
 }
 
-// Close 用于关闭服务器
+// Close 用于关闭服务器，会先离开集群，让其他节点尽快把 key 分配给别的节点。
 func (ts *TCPServer) Close() error {
-	return ts.server.Close()
+	if ts.replicator != nil {
+		ts.replicator.Close()
+	}
+	defer ts.auditor.Close()
+
+	nodeErr := ts.node.close()
+	if err := ts.server.Close(); err != nil {
+		return err
+	}
+	return nodeErr
+}
+
+// replicate 将所属节点上成功执行的操作异步地复制到副本节点上，没有开启复制的话什么也不做。
+func (ts *TCPServer) replicate(command byte, key string, value []byte, ttl int64) {
+	if ts.replicator != nil {
+		ts.replicator.replicate(command, key, value, ttl)
+	}
 }
 
 // =======================================================================
@@ -95,8 +227,14 @@ func (ts *TCPServer) getHandler(args [][]byte) (body []byte, err error) {
 		return nil, fmt.Errorf("redirect to node %s", node)
 	}
 
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
+
 	// 调用缓存的Get方法，如果不存在就返回noFoundErr错误
-	value, ok := ts.cache.Get(string(args[0]))
+	value, ok, err := ts.cache.GetContext(ctx, string(args[0]))
+	if err != nil {
+		return nil, err
+	}
 	if !ok {
 		return value, errNotFound
 	}
@@ -110,24 +248,33 @@ func (ts *TCPServer) setHandler(args [][]byte) (body []byte, err error) {
 		return nil, errCommandNeedsMoreArguments
 	}
 
+	if len(args[0]) != 8 {
+		return nil, errInvalidNumberArg
+	}
+
 	// 使用一致性哈希选择出这个 key 所属的物理节点
-    key := string(args[1])
-    node, err := ts.selectNode(key)
-    if err != nil {
-        return nil, err
-    }
+	key := string(args[1])
+	node, err := ts.selectNode(key)
+	if err != nil {
+		return nil, err
+	}
 
-    // 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
-    if !ts.isCurrentNode(node) {
-        return nil, fmt.Errorf("redirect to node %s", node)
-    }
+	// 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
+	if !ts.isCurrentNode(node) {
+		return nil, fmt.Errorf("redirect to node %s", node)
+	}
 
 	// 读取ttl，注意这里使用大端的方式读取，所以要求客户端也以大端的方式进行存储
 	ttl := int64(binary.BigEndian.Uint64(args[0]))
-	err = ts.cache.SetWithTTL(string(args[1]), args[2], ttl)
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
+
+	err = ts.cache.SetWithTTLContext(ctx, string(args[1]), args[2], ttl)
 	if err != nil {
 		return nil, err
 	}
+
+	ts.replicate(setCommand, key, args[2], ttl)
 	return nil, nil
 }
 
@@ -139,22 +286,27 @@ func (ts *TCPServer) deleteHandler(args [][]byte) (body []byte, err error) {
 	}
 
 	// 使用一致性哈希选择出这个 key 所属的物理节点
-    key := string(args[0])
-    node, err := ts.selectNode(key)
-    if err != nil {
-        return nil, err
-    }
+	key := string(args[0])
+	node, err := ts.selectNode(key)
+	if err != nil {
+		return nil, err
+	}
 
-    // 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
-    if !ts.isCurrentNode(node) {
-        return nil, fmt.Errorf("redirect to node %s", node)
-    }
+	// 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
+	if !ts.isCurrentNode(node) {
+		return nil, fmt.Errorf("redirect to node %s", node)
+	}
+
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
 
 	// 删除指定的数据
-	err = ts.cache.Delete(string(args[0]))
+	_, err = ts.cache.DeleteContext(ctx, string(args[0]))
 	if err != nil {
 		return nil, err
 	}
+
+	ts.replicate(deleteCommand, key, nil, 0)
 	return nil, nil
 }
 
@@ -163,7 +315,422 @@ func (ts *TCPServer) statusHandler(args [][]byte) (body []byte, err error) {
 	return json.Marshal(ts.cache.Status())
 }
 
-// nodesHandler 是返回集群所有节点名称的处理器。
+// nodesHandler 是返回集群所有节点名称的处理器，如果第一个参数是 verbose，就返回每个节点的详细信息。
 func (ts *TCPServer) nodesHandler(args [][]byte) (body []byte, err error) {
+	if len(args) > 0 && string(args[0]) == verboseArg {
+		return json.Marshal(ts.nodeInfos())
+	}
 	return json.Marshal(ts.nodes())
 }
+
+// infoHandler 是返回服务器详细信息的处理器。
+func (ts *TCPServer) infoHandler(args [][]byte) (body []byte, err error) {
+	return json.Marshal(newInfo(ts.cache, ts.node))
+}
+
+// configHandler 是返回节点当前实际使用的选项配置的处理器。
+func (ts *TCPServer) configHandler(args [][]byte) (body []byte, err error) {
+	return json.Marshal(newEffectiveConfig(ts.cache, ts.node))
+}
+
+// pingHandler 是处理 ping 命令的处理器，用于健康检查，不会访问缓存，所以开销非常小。
+func (ts *TCPServer) pingHandler(args [][]byte) (body []byte, err error) {
+	return pong, nil
+}
+
+// handshakeHandler 是处理握手命令的处理器，会和客户端协商协议版本和特性，并将协商的结果记录到连接的会话中。
+func (ts *TCPServer) handshakeHandler(s *session, args [][]byte) (body []byte, err error) {
+	version, features, err := parseHandshakeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	handshake, err := negotiate(version, features)
+	if err != nil {
+		return nil, err
+	}
+
+	s.checksum = handshake.Supports(featureCRC)
+	s.trace = handshake.Supports(featureTrace)
+	handshake.Hash = &HashConfig{Function: HashCRC32, VirtualNodes: ts.options.VirtualNodeCount}
+	return json.Marshal(handshake)
+}
+
+// execHandler 是处理事务命令的处理器，事务中的操作会被原子性地执行。
+// 事务中所有的 key 都必须属于同一个节点的同一个 segment，否则返回错误。
+func (ts *TCPServer) execHandler(args [][]byte) (body []byte, err error) {
+	ops, err := decodeOperations(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ops) < 1 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	node, err := ts.ownerOfOperations(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ts.isCurrentNode(node) {
+		return nil, fmt.Errorf("redirect to node %s", node)
+	}
+
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
+
+	results, err := ts.cache.Exec(ctx, ops)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(results)
+}
+
+// mgetHandler 是处理批量获取命令的处理器，每个参数都是一个 key，返回每个 key 的执行结果。
+// 不属于当前节点的 key 不会导致整个命令失败，而是在结果中告知正确的节点，由客户端到正确的节点上重试。
+func (ts *TCPServer) mgetHandler(args [][]byte) (body []byte, err error) {
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
+
+	results := make([]batchResult, len(args))
+	for i, arg := range args {
+		key := string(arg)
+		node, err := ts.selectNode(key)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		if !ts.isCurrentNode(node) {
+			results[i].Node = node
+			continue
+		}
+
+		value, ok, err := ts.cache.GetContext(ctx, key)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Value = value
+		results[i].Found = ok
+	}
+	return json.Marshal(results)
+}
+
+// msetHandler 是处理批量添加命令的处理器，第一个参数是 ttl，后面是 key 和 value 交替排列，返回每个 key 的执行结果。
+func (ts *TCPServer) msetHandler(args [][]byte) (body []byte, err error) {
+	ttl, keys, values, err := decodeEntries(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
+
+	results := make([]batchResult, len(keys))
+	for i, key := range keys {
+		node, err := ts.selectNode(key)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		if !ts.isCurrentNode(node) {
+			results[i].Node = node
+			continue
+		}
+
+		if err = ts.cache.SetWithTTLContext(ctx, key, values[i], ttl); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		ts.replicate(setCommand, key, values[i], ttl)
+	}
+	return json.Marshal(results)
+}
+
+// watchHandler 是处理 watch 命令的处理器，第一个参数是客户端已知的拓扑版本号。
+// 如果当前的拓扑版本和客户端的一样，就会一直等待，直到拓扑发生变化或者超时了才返回，所以客户端需要使用一个单独的连接执行这个命令。
+func (ts *TCPServer) watchHandler(args [][]byte) (body []byte, err error) {
+	version := uint64(0)
+	if len(args) > 0 && len(args[0]) == 8 {
+		version = binary.BigEndian.Uint64(args[0])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), watchTimeout)
+	defer cancel()
+	return json.Marshal(ts.waitForTopology(ctx, version))
+}
+
+// replicateHandler 是处理复制命令的处理器，由所属节点发送过来，参数依次是操作的命令、ttl、key 和 value。
+// 副本节点不会检查 key 是否属于当前节点，直接写入本地的缓存，并且不会再继续复制。
+func (ts *TCPServer) replicateHandler(args [][]byte) (body []byte, err error) {
+	command, key, value, ttl, err := decodeReplication(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// 后端存储已经由所属节点写过了，副本节点不需要再写一次
+	ctx, cancel := requestContext(caches.WithoutWriter(context.Background()), ts.live.load())
+	defer cancel()
+
+	switch command {
+	case setCommand:
+		err = ts.cache.SetWithTTLContext(ctx, key, value, ttl)
+	case deleteCommand:
+		_, err = ts.cache.DeleteContext(ctx, key)
+	case incrCommand:
+		if len(value) != 8 {
+			return nil, errInvalidReplication
+		}
+		_, err = ts.cache.Incr(ctx, key, int64(binary.BigEndian.Uint64(value)))
+	case expireCommand:
+		_, err = ts.cache.Expire(ctx, key, ttl)
+	default:
+		err = errInvalidReplication
+	}
+	return nil, err
+}
+
+// replicaGetHandler 是读取副本的处理器，用于所属节点不可用的时候，客户端到副本节点上读取数据。
+// 只要当前节点是 key 的所属节点或者副本节点就可以读取，读到的数据可能是旧的。
+func (ts *TCPServer) replicaGetHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	key := string(args[0])
+	replicas, err := ts.replicasOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !containsNode(replicas, ts.address) {
+		return nil, fmt.Errorf("redirect to node %s", replicas[0])
+	}
+
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
+
+	value, ok, err := ts.cache.GetContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return value, errNotFound
+	}
+	return value, nil
+}
+
+// keysHandler 是分页获取当前节点上的 key 的处理器，参数依次是 prefix、cursor、count 以及可选的 match。
+// match 是 path.Match 格式的通配符，为空表示不过滤。开启复制之后节点上还会有其他节点的副本，所以这里只会返回属于当前节点的 key，
+// 这样客户端遍历整个集群的时候就不会拿到重复的 key。
+func (ts *TCPServer) keysHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 3 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	if len(args[1]) != 8 || len(args[2]) != 8 {
+		return nil, errInvalidKeysArgs
+	}
+
+	match := ""
+	if len(args) > 3 {
+		match = string(args[3])
+	}
+
+	if _, err = path.Match(match, ""); err != nil {
+		return nil, err
+	}
+
+	cursor := int(binary.BigEndian.Uint64(args[1]))
+	count := int(binary.BigEndian.Uint64(args[2]))
+	if count < 1 {
+		count = defaultKeysCount
+	}
+
+	keys, nextCursor := ts.cache.Keys(string(args[0]), cursor, count)
+	result := &keysResult{Keys: make([]string, 0, len(keys)), Cursor: nextCursor}
+	for _, key := range keys {
+		if node, err := ts.selectNode(key); err != nil || !ts.isCurrentNode(node) {
+			continue
+		}
+
+		if matched, _ := path.Match(match, key); match != "" && !matched {
+			continue
+		}
+		result.Keys = append(result.Keys, key)
+	}
+	return json.Marshal(result)
+}
+
+// incrHandler 是处理自增命令的处理器，参数依次是 key 和 delta，返回自增之后的值，都是大端的 8 个字节。
+func (ts *TCPServer) incrHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	if len(args[1]) != 8 {
+		return nil, errInvalidNumberArg
+	}
+
+	key := string(args[0])
+	if err = ts.checkKeyNode(key); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
+
+	n, err := ts.cache.Incr(ctx, key, int64(binary.BigEndian.Uint64(args[1])))
+	if err != nil {
+		return nil, err
+	}
+
+	// 副本上执行的也是自增操作，这样就不需要关心数据剩余的寿命了
+	ts.replicate(incrCommand, key, args[1], 0)
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(n))
+	return body, nil
+}
+
+// expireHandler 是处理重新设置寿命的命令的处理器，参数依次是 key 和 ttl，数据不存在的话返回 errNotFound。
+func (ts *TCPServer) expireHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	if len(args[1]) != 8 {
+		return nil, errInvalidNumberArg
+	}
+
+	key := string(args[0])
+	if err = ts.checkKeyNode(key); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(context.Background(), ts.live.load())
+	defer cancel()
+
+	ttl := int64(binary.BigEndian.Uint64(args[1]))
+	ok, err := ts.cache.Expire(ctx, key, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, errNotFound
+	}
+
+	ts.replicate(expireCommand, key, nil, ttl)
+	return nil, nil
+}
+
+// existsHandler 是判断数据是否存在的处理器，参数是 key，返回一个字节，1 表示存在，0 表示不存在。
+func (ts *TCPServer) existsHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	key := string(args[0])
+	if err = ts.checkKeyNode(key); err != nil {
+		return nil, err
+	}
+
+	if ts.cache.Exists(key) {
+		return []byte{1}, nil
+	}
+	return []byte{0}, nil
+}
+
+// publishHandler 是发布消息的处理器，参数依次是频道和消息内容，频道和 key 一样，只能发布到所属节点上。
+func (ts *TCPServer) publishHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	channel := string(args[0])
+	if err = ts.checkKeyNode(channel); err != nil {
+		return nil, err
+	}
+
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, ts.pubsub.publish(channel, args[1]))
+	return seq, nil
+}
+
+// subscribeHandler 是订阅消息的处理器，参数依次是游标和订阅的频道，所有频道都需要属于当前节点。
+// 如果游标之后没有新的消息，就会一直等待，直到有新的消息或者超时了才返回，所以客户端需要使用一个单独的连接执行这个命令。
+func (ts *TCPServer) subscribeHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, errCommandNeedsMoreArguments
+	}
+
+	if len(args[0]) != 8 {
+		return nil, errInvalidSubscribeArgs
+	}
+
+	channels := make(map[string]bool, len(args)-1)
+	for _, arg := range args[1:] {
+		channel := string(arg)
+		if err = ts.checkKeyNode(channel); err != nil {
+			return nil, err
+		}
+		channels[channel] = true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pubsubPollTimeout)
+	defer cancel()
+	return json.Marshal(ts.pubsub.poll(ctx, binary.BigEndian.Uint64(args[0]), channels))
+}
+
+// reloadHandler 是处理热加载命令的处理器，会重新读取配置并热加载，只对当前节点生效。
+func (ts *TCPServer) reloadHandler(args [][]byte) (body []byte, err error) {
+	return nil, ts.reload()
+}
+
+// evictionPolicyHandler 是处理修改淘汰策略命令的处理器，第一个参数是新的淘汰策略，只对当前节点生效。
+func (ts *TCPServer) evictionPolicyHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, errCommandNeedsMoreArguments
+	}
+	return nil, ts.cache.SetEvictionPolicy(string(args[0]))
+}
+
+// logLevelHandler 是处理修改日志级别命令的处理器，第一个参数是新的日志级别，只对当前节点生效。
+func (ts *TCPServer) logLevelHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, errCommandNeedsMoreArguments
+	}
+	return nil, setLogLevel(string(args[0]))
+}
+
+// clientsHandler 是处理获取客户端连接命令的处理器，返回当前节点上所有打开的连接的统计信息。
+func (ts *TCPServer) clientsHandler(args [][]byte) (body []byte, err error) {
+	return json.Marshal(ts.server.clients.list())
+}
+
+// checkKeyNode 检查 key 是否属于当前节点，不属于的话返回重定向的错误，频道也是使用这个方法检查的。
+func (ts *TCPServer) checkKeyNode(key string) error {
+	node, err := ts.selectNode(key)
+	if err != nil {
+		return err
+	}
+
+	if !ts.isCurrentNode(node) {
+		return fmt.Errorf("redirect to node %s", node)
+	}
+	return nil
+}
+
+// containsNode 返回 nodes 中是否包含 node。
+func containsNode(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
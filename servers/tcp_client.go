@@ -1,31 +1,29 @@
 package servers
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"cache-server/caches"
+	"github.com/herrhu97/go-distributed-cache/caches"
+	"github.com/herrhu97/go-distributed-cache/helpers"
+	"github.com/herrhu97/go-distributed-cache/tracing"
 
-	"github.com/FishGoddess/cachego"
-	"github.com/FishGoddess/vex"
 	"stathat.com/c/consistent"
 )
 
 const (
-	// ttlOfClient 是客户端连接的有效期，单位是秒，所以这里是 15 分钟。
-	ttlOfClient = 15 * 60
-
 	// redirectPrefix 是重定向错误的前缀，用于判断某个错误是不是重定向错误。
 	redirectPrefix = "redirect to node"
 
-	// maxRedirectTimes 是最大的重定向次数，如果某次操作重定向了 5 次，说明集群节点的波动太大了，几乎可以认为是不可用的了。
+	// maxRedirectTimes 是默认重试策略的最大执行次数，如果某次操作重定向了 5 次，说明集群节点的波动太大了，几乎可以认为是不可用的了。
 	maxRedirectTimes = 5
-
-	// updateCircleDuration 是更新节点信息的时间间隔，主要是用于更新一致性哈希的节点情况。
-	updateCircleDuration = 5 * time.Minute
 )
 
 var (
@@ -35,55 +33,164 @@ var (
 )
 
 // TCPClient 是 TCP 客户端结构。
+// 每个节点都有一个连接池，每次执行命令都会从连接池中取出一个连接，执行完之后再归还，所以多个协程并发使用也是安全的。
 type TCPClient struct {
-	// clients 存储了所有的客户端连接，这是一个缓存结构。
-	clients *cachego.Cache
+	// balanceCursor 是轮流选择节点的计数器，需要使用原子操作访问，放在第一个字段是为了在 32 位平台上也能 64 位对齐。
+	balanceCursor uint64
+
+	// pools 存储了每个节点的连接池，key 是节点地址。
+	pools map[string]*clientPool
+
+	// options 是客户端的选项配置。
+	options *ClientOptions
+
+	// lock 用于保护 pools。
+	lock *sync.RWMutex
 
 	// circle 存储了当前集群的一致性哈希信息，用于避免重定向。
 	circle *consistent.Consistent
+
+	// gets 用于合并并发获取同一个 key 的请求，只有开启了 ClientOptions.SingleFlight 才会创建，否则是 nil。
+	gets *helpers.SingleFlight
+
+	// loads 用于保证同一时间同一个 key 只有一个 loader 在执行。
+	loads *helpers.SingleFlight
+
+	// invoker 是串上了所有拦截器的命令执行函数。
+	invoker Invoker
+
+	// ctx 会在客户端关闭的时候被取消，用于通知后台的协程退出。
+	ctx context.Context
+
+	// cancel 用于取消 ctx。
+	cancel context.CancelFunc
 }
 
 // NewTCPClient 返回一个新的 TCP 客户端。
 // 由于服务端已经是集群了，这里填的 address 是集群中的一个节点地址。
 func NewTCPClient(address string) (*TCPClient, error) {
+	return NewTCPClientWith(address, DefaultClientOptions())
+}
 
-	// 连接指定的地址
-	client, err := vex.NewClient("tcp", address)
-	if err != nil {
+// NewTCPClientWithPoolSize 返回一个新的 TCP 客户端，poolSize 是每个节点的连接池的最大连接数。
+func NewTCPClientWithPoolSize(address string, poolSize int) (*TCPClient, error) {
+	options := DefaultClientOptions()
+	options.PoolSize = poolSize
+	return NewTCPClientWith(address, options)
+}
+
+// NewTCPClientWith 返回一个使用 options 配置的 TCP 客户端。
+func NewTCPClientWith(address string, options ClientOptions) (*TCPClient, error) {
+	if options.RetryPolicy == nil {
+		options.RetryPolicy = DefaultRetryPolicy()
+	}
+
+	if options.Metrics == nil {
+		options.Metrics = noopMetrics{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tc := &TCPClient{
+		pools:   map[string]*clientPool{},
+		options: &options,
+		lock:    &sync.RWMutex{},
+		loads:   helpers.NewSingleFlight(),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	if options.SingleFlight {
+		tc.gets = helpers.NewSingleFlight()
+	}
+	tc.invoker = chainInterceptors(options.Interceptors, tc.invoke)
+
+	// 先连接指定的地址，确认节点是可用的，握手的时候也会校验一致性哈希的配置
+	if err := tc.Ping(address); err != nil {
+		tc.Close()
+		return nil, err
+	}
+
+	// 没有指定一致性哈希配置的话，就使用服务端的配置
+	if err := tc.adoptHashConfig(address); err != nil {
+		tc.Close()
 		return nil, err
 	}
 
 	// 创建一致性哈希环，并将虚拟节点设置为和服务端一致，否则节点的判断会发生误差
-	circle := consistent.New()
-	circle.NumberOfReplicas = 1024
-	circle.Set([]string{address})
+	tc.circle = consistent.New()
+	tc.circle.NumberOfReplicas = options.VirtualNodeCount
+	tc.circle.Set([]string{address})
 
-	// 创建缓存，设置过期数据清理的时间间隔是 10 分钟，并给所有的客户端连接设置 15 分钟的有效期
-	clients := cachego.NewCache()
-	clients.AutoGc(10 * time.Minute)
-	clients.SetWithTTL(address, client, ttlOfClient)
+	// 开启一个定时任务，定期更新一致性哈希信息，同时订阅集群拓扑的变化，及时更新一致性哈希信息
+	if options.UpdateCircleDuration > 0 {
+		tc.updateCircleAtFixedDuration(options.UpdateCircleDuration)
+	}
+	if options.HealthCheckInterval > 0 {
+		tc.checkHealthAtFixedDuration(options.HealthCheckInterval)
+	}
+	tc.watchTopology()
+	if err := tc.updateCircleAndClients(); err != nil {
+		return tc, err
+	}
 
-	tc := &TCPClient{
-		clients: clients,
-		circle:  circle,
+	if options.WarmUpConnections > 0 {
+		tc.warmUp(options.WarmUpConnections)
 	}
+	return tc, nil
+}
 
-	// 开启一个定时任务，定期更新一致性哈希信息
-	tc.updateCircleAtFixedDuration(updateCircleDuration)
-	return tc, tc.updateCircleAndClients()
+// warmUp 并发地和每个节点预先建立 count 个连接，建立连接失败的节点会在使用的时候再建立连接，所以不会返回错误。
+func (tc *TCPClient) warmUp(count int) {
+	wg := &sync.WaitGroup{}
+	for _, node := range tc.circle.Members() {
+		wg.Add(1)
+		go func(pool *clientPool) {
+			defer wg.Done()
+			pool.warmUp(count)
+		}(tc.poolOf(node))
+	}
+	wg.Wait()
+}
+
+// checkHealthAtFixedDuration 会开启一个定时任务，定期检查每个节点的连接池中的空闲连接。
+func (tc *TCPClient) checkHealthAtFixedDuration(duration time.Duration) {
+	go func() {
+		ticker := time.NewTicker(duration)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				tc.lock.RLock()
+				pools := make([]*clientPool, 0, len(tc.pools))
+				for _, pool := range tc.pools {
+					pools = append(pools, pool)
+				}
+				tc.lock.RUnlock()
+
+				for _, pool := range pools {
+					pool.checkIdle()
+				}
+			case <-tc.ctx.Done():
+				return
+			}
+		}
+	}()
 }
 
 // updateCircleAtFixedDuration 会开启一个定时任务，定期更新一致性哈希信息。
 func (tc *TCPClient) updateCircleAtFixedDuration(duration time.Duration) {
 	go func() {
 		ticker := time.NewTicker(duration)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				nodes, err := tc.nodes()
-				if err != nil {
+				if err == nil {
 					tc.circle.Set(nodes)
 				}
+			case <-tc.ctx.Done():
+				return
 			}
 		}
 	}()
@@ -91,17 +198,16 @@ func (tc *TCPClient) updateCircleAtFixedDuration(duration time.Duration) {
 
 // nodes 返回集群的节点信息。
 func (tc *TCPClient) nodes() ([]string, error) {
-	nodes := tc.circle.Members()
+	nodes := tc.balancedNodes()
 	for _, node := range nodes {
-		client, err := tc.getOrCreateClient(node)
+		body, err := tc.do(context.Background(), node, helpers.NewTraceID(), nodesCommand, nil)
+		if err == errPoolClosed {
+			return nil, err
+		}
 		if err != nil {
 			continue
 		}
 
-		body, err := client.Do(nodesCommand, nil)
-		if err != nil {
-			return nil, err
-		}
 		var nodes []string
 		err = json.Unmarshal(body, &nodes)
 		return nodes, err
@@ -109,20 +215,145 @@ func (tc *TCPClient) nodes() ([]string, error) {
 	return nil, errNoClientIsAvailble
 }
 
-// getOrCreateClient 从缓存中拿到某个节点的客户端连接。
-func (tc *TCPClient) getOrCreateClient(node string) (*vex.Client, error) {
-	// 从cachego中拿连接
-	client, ok := tc.clients.Get(node)
-	if !ok {
-		var err error
-		client, err = vex.NewClient("tcp", node)
+// poolOf 返回某个节点的连接池，如果还没有就创建一个。
+func (tc *TCPClient) poolOf(node string) *clientPool {
+	tc.lock.RLock()
+	pool, ok := tc.pools[node]
+	tc.lock.RUnlock()
+	if ok {
+		return pool
+	}
+
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	if pool, ok = tc.pools[node]; ok {
+		return pool
+	}
+
+	pool = newClientPool(node, tc.options.PoolSize, tc.options.ConnectionTTL, tc.dial)
+	tc.pools[node] = pool
+	return pool
+}
+
+// dial 和节点建立一个新的连接，新的连接需要先进行握手，确认双方的协议版本是兼容的。
+func (tc *TCPClient) dial(node string) (*protocolClient, error) {
+	client, err := newProtocolClient(node, tc.options.DialTimeout, tc.options.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tc.handshake(client); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// handshake 使用 client 和节点进行握手，协商出双方都支持的协议版本和特性，并记录下协商的结果。
+func (tc *TCPClient) handshake(client *protocolClient) error {
+	body, err := client.Do(handshakeCommand, handshakeArgs(ProtocolVersion, tc.options.features()))
+	if err != nil {
+		return err
+	}
+
+	handshake := &Handshake{}
+	if err = json.Unmarshal(body, handshake); err != nil {
+		return err
+	}
+
+	if err = tc.verifyHashConfig(handshake.Hash); err != nil {
+		return err
+	}
+
+	// 协商了校验特性的话，从下一个请求开始，请求帧和响应帧都会带上校验码
+	if handshake.Supports(featureCRC) {
+		client.enableChecksum()
+	}
+	client.handshake = handshake
+	return nil
+}
+
+// verifyHashConfig 校验服务端的一致性哈希配置是否和客户端一致，客户端没有指定的配置不需要校验。
+// 旧版本的服务端不会在握手的时候返回一致性哈希配置，也就没办法校验了。
+func (tc *TCPClient) verifyHashConfig(hash *HashConfig) error {
+	if hash == nil {
+		return nil
+	}
+
+	if (tc.options.HashFunction != "" && tc.options.HashFunction != hash.Function) ||
+		(tc.options.VirtualNodeCount > 0 && tc.options.VirtualNodeCount != hash.VirtualNodes) {
+		return fmt.Errorf("%w: server uses %s with %d virtual nodes but client uses %s with %d virtual nodes",
+			ErrHashMismatch, hash.Function, hash.VirtualNodes, tc.options.HashFunction, tc.options.VirtualNodeCount)
+	}
+	return nil
+}
+
+// adoptHashConfig 使用节点握手时返回的一致性哈希配置补全客户端没有指定的配置，只会在创建客户端的时候调用。
+// 旧版本的服务端不会返回一致性哈希配置，就使用默认的配置。
+func (tc *TCPClient) adoptHashConfig(node string) error {
+	if tc.options.HashFunction != "" && tc.options.VirtualNodeCount > 0 {
+		return nil
+	}
+
+	handshake, err := tc.Handshake(node)
+	if err != nil {
+		return err
+	}
+
+	hash := handshake.Hash
+	if hash == nil {
+		defaults := DefaultClientOptions()
+		hash = &HashConfig{Function: defaults.HashFunction, VirtualNodes: defaults.VirtualNodeCount}
+	}
+
+	if tc.options.HashFunction == "" {
+		tc.options.HashFunction = hash.Function
+	}
+
+	if tc.options.VirtualNodeCount <= 0 {
+		tc.options.VirtualNodeCount = hash.VirtualNodes
+	}
+
+	if tc.options.HashFunction != HashCRC32 {
+		return fmt.Errorf("%w: hash function %s is not supported", ErrHashMismatch, tc.options.HashFunction)
+	}
+	return nil
+}
+
+// Handshake 返回和某个节点握手协商的结果。
+func (tc *TCPClient) Handshake(node string) (*Handshake, error) {
+	pool := tc.poolOf(node)
+	client, err := pool.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.put(client)
+	return client.handshake, nil
+}
+
+// do 从节点的连接池中取出一个连接执行命令，如果这个连接协商了追踪特性，就会把追踪 ID 作为第一个参数传递给服务端。
+// 等待连接和等待响应的过程中 ctx 被取消或者超时了，就返回 ctx 的错误。
+// 用过的连接在空闲的时候可能已经被服务端关闭了，如果在这样的连接上发生了连接错误，会马上换一个新的连接重新执行一次，不算作重试。
+func (tc *TCPClient) do(ctx context.Context, node string, traceID string, command byte, args [][]byte) ([]byte, error) {
+	pool := tc.poolOf(node)
+	for {
+		client, err := pool.get(ctx)
 		if err != nil {
 			return nil, err
 		}
-		// 重新将连接放入cachego
-		tc.clients.SetWithTTL(node, client, ttlOfClient)
+
+		tc.options.Metrics.ObservePool(node, pool.stats())
+		reused := client.reused()
+		body, err := client.DoContext(ctx, command, client.withTraceID(traceID, args))
+		broken := client.broken()
+		pool.put(client)
+
+		// 坏掉的连接在归还的时候就被关闭了，所以最多把空闲的连接都换一遍，不会一直循环下去
+		if err != nil && reused && broken && isConnectionError(err) {
+			continue
+		}
+		return body, err
 	}
-	return client.(*vex.Client), nil
 }
 
 // updateCircleAndClients 更新一致性哈希和客户端连接。
@@ -134,62 +365,240 @@ func (tc *TCPClient) updateCircleAndClients() error {
 
 	tc.circle.Set(nodes)
 	for _, node := range nodes {
-		tc.getOrCreateClient(node)
+		tc.poolOf(node)
 	}
 	return nil
 }
 
-// clientOf 返回某个key的客户端连接
-func (tc *TCPClient) clientOf(key string) (*vex.Client, error) {
-	// 使用一致性哈希环判断这个 key 属于哪一个节点，然后获取这个节点的客户端连接
+// nodeOf 返回某个 key 所属的节点。
+func (tc *TCPClient) nodeOf(key string) (string, error) {
+	// 使用一致性哈希环判断这个 key 属于哪一个节点
 	// 所以一致性哈希环的准确性直接关系到重定向问题的解决
-	node, err := tc.circle.Get(key)
-	if err != nil {
-		return nil, err
-	}
-	return tc.getOrCreateClient(node)
+	return tc.circle.Get(key)
 }
 
-// doCommand 使用 client 执行命令。
-func (tc *TCPClient) doCommand(client *vex.Client, command byte, args [][]byte) (body []byte, err error) {
-	// 因为可能存在重定向，所以使用循环，但是不能一直重定向，所以设置了一个最大的重定向次数
-	for i := 0; i < maxRedirectTimes; i++ {
-		body, err := client.Do(command, args)
-		// 判断发生的错误是不是重定向错误，如果是，就从错误中获取正确的节点地址，并拿到这个节点的客户端连接，再次执行命令
-		if err != nil && strings.HasPrefix(err.Error(), redirectPrefix) {
-			node := strings.TrimPrefix(err.Error(), redirectPrefix)
-			rightClient, err := tc.getOrCreateClient(node)
-			if err != nil {
-				continue
-			}
-			client = rightClient
-			continue
+// doCommand 在 node 上执行命令，命令会先经过所有的拦截器，最后由 invoke 执行。
+func (tc *TCPClient) doCommand(ctx context.Context, node string, command byte, args [][]byte) ([]byte, error) {
+	return tc.invoker(ctx, &Call{
+		Operation: commandName(command),
+		Node:      node,
+		Args:      args,
+		command:   command,
+	})
+}
+
+// invoke 执行命令，失败的时候会按照重试策略进行重试。
+// 重定向会到正确的节点上重试，连接断开会先更新集群的节点信息再重试，服务端处理超时或者正在持久化会退避一段时间再重试。
+func (tc *TCPClient) invoke(ctx context.Context, call *Call) (body []byte, err error) {
+	node, command, args := call.Node, call.command, call.Args
+	// 同一个操作在重试的过程中使用同一个追踪 ID，这样就可以在服务端的日志中追踪到整个操作
+	// 开启了分布式追踪的话就使用 span 所在追踪的 ID，这样服务端的 span 就能关联到调用方的追踪中
+	ctx, span := tracing.Start(ctx, "kafo.client "+commandName(command), tracing.Attr("kafo.command", commandName(command)), tracing.Attr("kafo.node", node))
+	defer func() {
+		span.End(err)
+	}()
+
+	traceID := TraceIDFrom(ctx)
+	if traceID == "" {
+		traceID = span.TraceID()
+	}
+	if traceID == "" {
+		traceID = helpers.NewTraceID()
+	}
+
+	// 操作的超时时间包括了重试的时间，如果 ctx 已经有了更早的期限，就以 ctx 的期限为准
+	if tc.options.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tc.options.RequestTimeout)
+		defer cancel()
+	}
+
+	beginTime := time.Now()
+	defer func() {
+		tc.options.Metrics.ObserveOperation(commandName(command), time.Since(beginTime), err)
+	}()
+
+	for attempt := 1; ; attempt++ {
+		body, err = tc.do(ctx, node, traceID, command, args)
+		if err == nil {
+			return body, nil
+		}
+
+		// 判断发生的错误是不是重定向错误，如果是，就从错误中获取正确的节点地址，下一次到这个节点上执行命令
+		redirectNode, redirected := redirectNodeOf(err)
+		if redirected {
+			tc.options.Metrics.ObserveRedirect(node, redirectNode)
+			node = redirectNode
 		}
 
-		// 如果错误不是重定向错误，而是这个连接关闭的错误，说明这个节点出现问题，很可能是节点信息已经不准了，需要更新集群的节点信息
-		if err != nil && strings.HasSuffix(err.Error(), "closed by the remote host.") {
-			nodes, err := tc.nodes()
-			if err == nil {
+		// 如果是连接断开的错误，说明这个节点出现问题，很可能是节点信息已经不准了，需要更新集群的节点信息
+		if isConnectionError(err) {
+			if nodes, err := tc.nodes(); err == nil {
 				tc.circle.Set(nodes)
 			}
 		}
-		return body, err
+
+		delay, ok := tc.options.RetryPolicy.Retry(attempt, err)
+		if !ok {
+			// 一直在重定向，说明集群节点的波动太大了，几乎可以认为是不可用的了
+			if redirected {
+				return nil, errReachedMaxRetriedTimesErr
+			}
+			return body, err
+		}
+
+		if err = sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
 	}
-	return nil, errReachedMaxRetriedTimesErr
+}
+
+// SetMetrics 设置客户端的指标钩子，需要在使用客户端之前设置。
+func (tc *TCPClient) SetMetrics(metrics ClientMetrics) {
+	tc.options.Metrics = metrics
+}
+
+// PoolStats 返回每个节点的连接池的使用情况，key 是节点地址。
+func (tc *TCPClient) PoolStats() map[string]PoolStats {
+	tc.lock.RLock()
+	defer tc.lock.RUnlock()
+
+	stats := make(map[string]PoolStats, len(tc.pools))
+	for node, pool := range tc.pools {
+		stats[node] = pool.stats()
+	}
+	return stats
+}
+
+// SetRetryPolicy 设置客户端的重试策略，需要在使用客户端之前设置。
+func (tc *TCPClient) SetRetryPolicy(policy RetryPolicy) {
+	tc.options.RetryPolicy = policy
+}
+
+// isConnectionError 返回 err 是否是连接层面的错误，而不是服务端返回的错误。
+// 服务端返回的错误都是通过响应帧传递的，连接还是可以继续使用的，而连接层面的错误会导致连接被关闭。
+// 调用方取消或者超时导致的错误也不是连接层面的错误。
+func isConnectionError(err error) bool {
+	var reply *replyError
+	return !errors.As(err, &reply) && err != errPoolClosed && err != context.Canceled && err != context.DeadlineExceeded
 }
 
 // Get 获取指定 key 的 value。
 func (tc *TCPClient) Get(key string) ([]byte, error) {
-	client, err := tc.clientOf(key)
+	return tc.GetContext(context.Background(), key)
+}
+
+// GetContext 和 Get 一样，只是在等待的过程中会响应 ctx 的取消和超时，避免一个很慢的节点一直阻塞调用方。
+// 如果开启了副本读取，所属节点不可用的时候会透明地到副本节点上读取，需要知道数据是否可能是旧的，可以使用 GetWithFailover。
+func (tc *TCPClient) GetContext(ctx context.Context, key string) ([]byte, error) {
+	result, err := tc.GetWithFailover(ctx, key)
+	return result.Value, err
+}
+
+// ReadResult 是读取操作的结果。
+type ReadResult struct {
+	// Value 是 key 对应的 value。
+	Value []byte
+
+	// Node 是实际读取数据的节点。
+	Node string
+
+	// Stale 表示数据是从副本节点上读取的，由于复制是异步的，数据可能是旧的。
+	Stale bool
+}
+
+// GetWithFailover 获取指定 key 的 value，返回的结果不会是 nil。
+// 如果所属节点在重试之后还是连接不上，并且 ClientOptions.ReplicationFactor 大于 1，就会依次到一致性哈希环上的后续节点读取副本，
+// 这时候结果中的 Stale 是 true。所有副本节点都不可用的话，返回的是所属节点的错误。
+// 开启了 ClientOptions.SingleFlight 的话，并发获取同一个 key 的请求会被合并成一个。
+func (tc *TCPClient) GetWithFailover(ctx context.Context, key string) (*ReadResult, error) {
+	if tc.gets == nil {
+		return tc.getWithFailover(ctx, key)
+	}
+
+	value, err, shared := tc.gets.DoContext(ctx, key, func() (interface{}, error) {
+		return tc.getWithFailover(ctx, key)
+	})
+
+	// 共享的是其他协程的结果，如果是因为它的 ctx 被取消或者超时而失败的，当前的请求还是要自己执行一次
+	if shared && ctx.Err() == nil && (err == context.Canceled || err == context.DeadlineExceeded) {
+		return tc.getWithFailover(ctx, key)
+	}
+
+	result, ok := value.(*ReadResult)
+	if !ok {
+		return &ReadResult{}, err
+	}
+
+	// 共享的结果会返回给多个调用方，所以需要复制一份数据，避免调用方之间互相修改
+	if shared {
+		copied := *result
+		copied.Value = append([]byte(nil), result.Value...)
+		return &copied, err
+	}
+	return result, err
+}
+
+// getWithFailover 是 GetWithFailover 真正获取数据的实现，开启了压缩的话会解压获取到的数据。
+func (tc *TCPClient) getWithFailover(ctx context.Context, key string) (*ReadResult, error) {
+	result, err := tc.readWithFailover(ctx, key)
+	if err == nil {
+		result.Value, err = tc.decompressValue(result.Value)
+	}
+	return result, err
+}
+
+// readWithFailover 从所属节点读取数据，所属节点不可用的话会读取副本。
+func (tc *TCPClient) readWithFailover(ctx context.Context, key string) (*ReadResult, error) {
+	node, err := tc.nodeOf(key)
 	if err != nil {
-		return nil, err
+		return &ReadResult{}, err
+	}
+
+	args := [][]byte{[]byte(key)}
+	value, err := tc.doCommand(ctx, node, getCommand, args)
+	if err == nil || tc.options.ReplicationFactor <= 1 || !isConnectionError(err) {
+		return &ReadResult{Value: value, Node: node}, err
+	}
+
+	replicas, replicasErr := tc.replicasOf(key)
+	if replicasErr != nil {
+		return &ReadResult{}, err
+	}
+
+	// 副本节点只尝试一次，因为所属节点的重试已经花掉了不少时间，副本节点不可用的话直接尝试下一个
+	traceID := helpers.NewTraceID()
+	for _, replica := range replicas {
+		if replica == node {
+			continue
+		}
+
+		value, replicaErr := tc.do(ctx, replica, traceID, replicaGetCommand, args)
+		if replicaErr == nil || !isConnectionError(replicaErr) {
+			tc.options.Metrics.ObserveRedirect(node, replica)
+			return &ReadResult{Value: value, Node: replica, Stale: true}, replicaErr
+		}
+	}
+	return &ReadResult{}, err
+}
+
+// replicasOf 返回保存 key 的所有节点，第一个是所属节点，后面是依次保存副本的节点。
+func (tc *TCPClient) replicasOf(key string) ([]string, error) {
+	count := tc.options.ReplicationFactor
+	if members := len(tc.circle.Members()); count > members {
+		count = members
 	}
-	return tc.doCommand(client, getCommand, [][]byte{[]byte(key)})
+	return tc.circle.GetN(key, count)
 }
 
 // Set 添加一个键值对到缓存中。
 func (tc *TCPClient) Set(key string, value []byte, ttl int64) error {
-	client, err := tc.clientOf(key)
+	return tc.SetContext(context.Background(), key, value, ttl)
+}
+
+// SetContext 和 Set 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+func (tc *TCPClient) SetContext(ctx context.Context, key string, value []byte, ttl int64) error {
+	node, err := tc.nodeOf(key)
 	if err != nil {
 		return err
 	}
@@ -197,66 +606,413 @@ func (tc *TCPClient) Set(key string, value []byte, ttl int64) error {
 	// 注意使用大端的形式存储数字
 	ttlBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
-	_, err = tc.doCommand(client, setCommand, [][]byte{
-		ttlBytes, []byte(key), value,
+	_, err = tc.doCommand(ctx, node, setCommand, [][]byte{
+		ttlBytes, []byte(key), tc.compressValue(value),
 	})
 	return err
 }
 
 // Delete 删除指定 key 的 value。
 func (tc *TCPClient) Delete(key string) error {
-	client, err := tc.clientOf(key)
+	return tc.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext 和 Delete 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+func (tc *TCPClient) DeleteContext(ctx context.Context, key string) error {
+	node, err := tc.nodeOf(key)
 	if err != nil {
 		return err
 	}
 
-	_, err = tc.doCommand(client, deleteCommand, [][]byte{[]byte(key)})
+	_, err = tc.doCommand(ctx, node, deleteCommand, [][]byte{[]byte(key)})
 	return err
 }
 
-// Status 返回缓存的状态。
-func (tc *TCPClient) Status() (*caches.Status, error) {
+// GetMulti 批量获取多个 key 的 value，不存在的 key 不会出现在返回的结果中。
+func (tc *TCPClient) GetMulti(keys []string) (map[string][]byte, error) {
+	return tc.GetMultiContext(context.Background(), keys)
+}
+
+// GetMultiContext 批量获取多个 key 的 value，不存在的 key 不会出现在返回的结果中。
+// 所有的 key 会先按照所属的节点进行分组，然后并发地在每个节点上执行一次批量命令，所以只需要一次往返的时间。
+// 部分 key 执行失败的时候，返回的错误是 *BatchError，其他 key 的结果还是会正常返回。
+func (tc *TCPClient) GetMultiContext(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	err := tc.doBatch(ctx, keys, func(node string, keys []string) ([]batchResult, error) {
+		args := make([][]byte, 0, len(keys))
+		for _, key := range keys {
+			args = append(args, []byte(key))
+		}
+		return tc.doBatchCommand(ctx, node, mgetCommand, args, len(keys))
+	}, func(key string, result batchResult) {
+		if !result.Found {
+			return
+		}
+
+		// 解压失败的 key 就当作不存在，和其他获取失败的 key 一样
+		if value, err := tc.decompressValue(result.Value); err == nil {
+			values[key] = value
+		}
+	})
+	return values, err
+}
+
+// SetMulti 批量添加多个键值对到缓存中，所有的键值对都使用同一个 ttl。
+func (tc *TCPClient) SetMulti(entries map[string][]byte, ttl int64) error {
+	return tc.SetMultiContext(context.Background(), entries, ttl)
+}
+
+// SetMultiContext 批量添加多个键值对到缓存中，所有的键值对都使用同一个 ttl。
+// 和 GetMultiContext 一样，会按照所属的节点分组之后并发执行，部分 key 执行失败的时候，返回的错误是 *BatchError。
+func (tc *TCPClient) SetMultiContext(ctx context.Context, entries map[string][]byte, ttl int64) error {
+	keys := make([]string, 0, len(entries))
+	compressed := make(map[string][]byte, len(entries))
+	for key, value := range entries {
+		keys = append(keys, key)
+		compressed[key] = tc.compressValue(value)
+	}
+	entries = compressed
+
+	return tc.doBatch(ctx, keys, func(node string, keys []string) ([]batchResult, error) {
+		return tc.doBatchCommand(ctx, node, msetCommand, encodeEntries(keys, entries, ttl), len(keys))
+	}, func(key string, result batchResult) {})
+}
+
+// doBatch 将 keys 按照所属的节点进行分组，然后并发地使用 execute 在每个节点上执行，并使用 collect 收集每个 key 的结果。
+// 如果某些 key 不属于执行的节点，会在下一轮到正确的节点上重新执行，最多执行 maxRedirectTimes 轮。
+// collect 会在持有锁的情况下被调用，所以不需要考虑并发安全的问题。
+func (tc *TCPClient) doBatch(ctx context.Context, keys []string, execute func(node string, keys []string) ([]batchResult, error), collect func(key string, result batchResult)) error {
+	groups := make(map[string][]string)
+	for _, key := range keys {
+		node, err := tc.nodeOf(key)
+		if err != nil {
+			return err
+		}
+		groups[node] = append(groups[node], key)
+	}
+
+	errs := make(map[string]error)
+	lock := &sync.Mutex{}
+	for round := 0; len(groups) > 0 && round < maxRedirectTimes; round++ {
+		redirects := make(map[string][]string)
+		wg := &sync.WaitGroup{}
+		for node, nodeKeys := range groups {
+			wg.Add(1)
+			go func(node string, nodeKeys []string) {
+				defer wg.Done()
+				results, err := execute(node, nodeKeys)
+
+				lock.Lock()
+				defer lock.Unlock()
+				for i, key := range nodeKeys {
+					switch {
+					case err != nil:
+						errs[key] = err
+					case results[i].Node != "":
+						redirects[results[i].Node] = append(redirects[results[i].Node], key)
+					case results[i].Error != "":
+						errs[key] = newReplyError(results[i].Error)
+					default:
+						collect(key, results[i])
+					}
+				}
+			}(node, nodeKeys)
+		}
+
+		wg.Wait()
+		groups = redirects
+	}
+
+	for _, nodeKeys := range groups {
+		for _, key := range nodeKeys {
+			errs[key] = errReachedMaxRetriedTimesErr
+		}
+	}
+
+	if len(errs) > 0 {
+		return &BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// doBatchCommand 在 node 上执行批量命令并解析出每个 key 的结果，count 是批量命令中 key 的个数。
+// 如果节点不支持批量命令，就退化成逐个执行单个 key 的命令。
+func (tc *TCPClient) doBatchCommand(ctx context.Context, node string, command byte, args [][]byte, count int) ([]batchResult, error) {
+	body, err := tc.doCommand(ctx, node, command, args)
+	if err != nil && err.Error() == errCommandHandlerNotFound.Error() {
+		return tc.doBatchOneByOne(ctx, node, command, args)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var results []batchResult
+	if err = json.Unmarshal(body, &results); err != nil {
+		return nil, err
+	}
+
+	if len(results) != count {
+		return nil, errInvalidBatch
+	}
+	return results, nil
+}
+
+// doBatchOneByOne 逐个执行批量命令中每个 key 的命令，用于兼容不支持批量命令的节点。
+func (tc *TCPClient) doBatchOneByOne(ctx context.Context, node string, command byte, args [][]byte) ([]batchResult, error) {
+	if command == mgetCommand {
+		results := make([]batchResult, len(args))
+		for i, key := range args {
+			value, err := tc.doCommand(ctx, node, getCommand, [][]byte{key})
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				results[i].Error = err.Error()
+				continue
+			}
+			results[i].Value = value
+			results[i].Found = err == nil
+		}
+		return results, nil
+	}
+
+	ttl, keys, values, err := decodeEntries(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ttlBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
+	results := make([]batchResult, len(keys))
+	for i, key := range keys {
+		_, err = tc.doCommand(ctx, node, setCommand, [][]byte{ttlBytes, []byte(key), values[i]})
+		if err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+	return results, nil
+}
+
+// Exec 原子性地执行事务中的所有操作，并按顺序返回每个操作的结果。
+// 事务中所有的 key 都必须属于同一个节点的同一个 segment，否则会返回错误。
+func (tc *TCPClient) Exec(ops []caches.Operation) ([]caches.Result, error) {
+	if len(ops) < 1 {
+		return nil, nil
+	}
+
+	node, err := tc.nodeOf(ops[0].Key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := tc.doCommand(context.Background(), node, execCommand, encodeOperations(ops))
+	if err != nil {
+		return nil, err
+	}
+
+	var results []caches.Result
+	err = json.Unmarshal(body, &results)
+	return results, err
+}
+
+// ClusterStatus 是整个集群的缓存状态，内嵌的 Status 是所有成功返回的节点的汇总。
+type ClusterStatus struct {
+	caches.Status
+
+	// Nodes 是每个成功返回的节点的状态，key 是节点地址。
+	Nodes map[string]*caches.Status
+
+	// Errors 是每个失败的节点对应的错误，key 是节点地址。
+	Errors map[string]error
+
+	// Partial 表示有节点获取状态失败了，汇总的结果只包含了部分节点的数据。
+	Partial bool
+}
+
+// Status 返回整个集群的缓存状态。
+// 部分节点失败的时候不会中断，而是汇总其他节点的状态，并将结果标记为 Partial，这时候返回的错误是 *NodesError。
+func (tc *TCPClient) Status() (*ClusterStatus, error) {
+	return tc.StatusContext(context.Background())
+}
+
+// StatusContext 和 Status 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+func (tc *TCPClient) StatusContext(ctx context.Context) (*ClusterStatus, error) {
 	// 由于缓存服务可能是一个集群，所以这里需要获取所有节点的状态，然后做一个汇总
-	totalStatus := caches.NewStatus()
+	statuses, err := tc.StatusByNodeContext(ctx)
+	totalStatus := &ClusterStatus{Nodes: statuses, Errors: map[string]error{}}
+	for _, status := range statuses {
+		totalStatus.Add(*status)
+	}
+
+	var nodesErr *NodesError
+	if errors.As(err, &nodesErr) {
+		totalStatus.Errors = nodesErr.Errors
+	}
+	totalStatus.Partial = len(totalStatus.Errors) > 0
+	return totalStatus, err
+}
+
+// NodesError 是在多个节点上执行操作时部分节点失败的错误，Errors 记录着每个失败的节点对应的错误。
+type NodesError struct {
+	// Errors 记录着每个失败的节点对应的错误，key 是节点地址。
+	Errors map[string]error
+}
+
+// Error 返回错误信息，会列出所有失败的节点。
+func (ne *NodesError) Error() string {
+	nodes := make([]string, 0, len(ne.Errors))
+	for node := range ne.Errors {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	messages := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		messages = append(messages, fmt.Sprintf("%s: %v", node, ne.Errors[node]))
+	}
+	return fmt.Sprintf("%d nodes failed (%s)", len(nodes), strings.Join(messages, "; "))
+}
+
+// StatusByNode 返回每个节点的缓存状态，key 是节点地址，用于观察数据在节点之间是否分布均匀。
+func (tc *TCPClient) StatusByNode() (map[string]*caches.Status, error) {
+	return tc.StatusByNodeContext(context.Background())
+}
+
+// StatusByNodeContext 和 StatusByNode 一样，只是在等待的过程中会响应 ctx 的取消和超时。
+// 所有节点会并发地获取状态，部分节点失败的时候，返回的错误是 *NodesError，其他节点的状态还是会正常返回。
+func (tc *TCPClient) StatusByNodeContext(ctx context.Context) (map[string]*caches.Status, error) {
 	nodes := tc.circle.Members()
+	statuses := make(map[string]*caches.Status, len(nodes))
+	errs := make(map[string]error)
+
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
 	for _, node := range nodes {
-		client, err := tc.getOrCreateClient(node)
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			status, err := tc.statusOf(ctx, node)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				errs[node] = err
+				return
+			}
+			statuses[node] = status
+		}(node)
+	}
+
+	wg.Wait()
+	if len(errs) > 0 {
+		return statuses, &NodesError{Errors: errs}
+	}
+	return statuses, nil
+}
+
+// statusOf 返回某个节点的缓存状态。
+func (tc *TCPClient) statusOf(ctx context.Context, node string) (*caches.Status, error) {
+	body, err := tc.do(ctx, node, helpers.NewTraceID(), statusCommand, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	status := caches.NewStatus()
+	if err = json.Unmarshal(body, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Ping 检查指定节点是否存活，如果节点存活就返回 nil。
+func (tc *TCPClient) Ping(node string) error {
+	_, err := tc.do(context.Background(), node, helpers.NewTraceID(), pingCommand, nil)
+	return err
+}
+
+// Reload 让指定节点重新读取配置并热加载，节点没有设置热加载的话会返回错误。
+func (tc *TCPClient) Reload(node string) error {
+	_, err := tc.do(context.Background(), node, helpers.NewTraceID(), reloadCommand, nil)
+	return err
+}
+
+// Config 返回指定节点当前实际使用的选项配置，返回的格式和配置文件一样。
+func (tc *TCPClient) Config(node string) (*EffectiveConfig, error) {
+	body, err := tc.do(context.Background(), node, helpers.NewTraceID(), configCommand, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &EffectiveConfig{}
+	if err = json.Unmarshal(body, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// SetEvictionPolicy 修改指定节点的淘汰策略，可选值有 none、lru、lfu 和 random。
+func (tc *TCPClient) SetEvictionPolicy(node string, policy string) error {
+	_, err := tc.do(context.Background(), node, helpers.NewTraceID(), evictionPolicyCommand, [][]byte{[]byte(policy)})
+	return err
+}
+
+// Clients 返回指定节点上所有打开的客户端连接的统计信息，包括执行过的命令个数、读写的字节数以及最后一次活动的时间。
+func (tc *TCPClient) Clients(node string) ([]ClientInfo, error) {
+	body, err := tc.do(context.Background(), node, helpers.NewTraceID(), clientsCommand, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []ClientInfo
+	err = json.Unmarshal(body, &clients)
+	return clients, err
+}
+
+// SetLogLevel 修改指定节点的日志级别，可选值有 debug、info、warn 和 error。
+func (tc *TCPClient) SetLogLevel(node string, level string) error {
+	_, err := tc.do(context.Background(), node, helpers.NewTraceID(), logLevelCommand, [][]byte{[]byte(level)})
+	return err
+}
+
+// Nodes 返回集群中所有节点的信息，包括地址、角色、状态、版本以及数据情况。
+// 旧版本的节点只会返回节点的地址，这时候每个节点只有地址是有值的。
+func (tc *TCPClient) Nodes() ([]NodeInfo, error) {
+	for _, node := range tc.balancedNodes() {
+		body, err := tc.do(context.Background(), node, helpers.NewTraceID(), nodesCommand, [][]byte{[]byte(verboseArg)})
+		if err == errPoolClosed {
+			return nil, err
+		}
 		if err != nil {
 			continue
 		}
 
-		body, err := client.Do(statusCommand, nil)
-		if err != nil {
-			return nil, err
+		var infos []NodeInfo
+		if err = json.Unmarshal(body, &infos); err == nil {
+			return infos, nil
 		}
-		status := caches.NewStatus()
-		err = json.Unmarshal(body, status)
-		if err != nil {
+
+		var nodes []string
+		if err = json.Unmarshal(body, &nodes); err != nil {
 			return nil, err
 		}
-		totalStatus.Count += status.Count
-		totalStatus.KeySize += status.KeySize
-		totalStatus.ValueSize += status.ValueSize
-	}
-
-	return totalStatus, nil
-}
 
-// Nodes 返回集群中的所有节点名称。
-func (tc *TCPClient) Nodes() ([]string, error) {
-	return tc.nodes()
+		infos = make([]NodeInfo, len(nodes))
+		for i, node := range nodes {
+			infos[i].Address = node
+		}
+		return infos, nil
+	}
+	return nil, errNoClientIsAvailble
 }
 
 // Close 关闭这个客户端。
 func (tc *TCPClient) Close() (err error) {
-	// 当然需要将每一个客户端连接都关闭掉
-	nodes := tc.circle.Members()
-	for _, node := range nodes {
-		client, ok := tc.clients.Get(node)
-		if ok {
-			err = client.(*vex.Client).Close()
+	// 先通知后台的协程退出，然后将每一个节点的连接池都关闭掉
+	tc.cancel()
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	for _, pool := range tc.pools {
+		if closeErr := pool.Close(); closeErr != nil {
+			err = closeErr
 		}
 	}
-	tc.clients.RemoveAll()
 	return err
 }
@@ -0,0 +1,63 @@
+package servers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/helpers"
+	"github.com/herrhu97/go-distributed-cache/tracing"
+)
+
+const (
+	// traceIDHeader 是 HTTP 请求中传递追踪 ID 使用的请求头，响应中也会带上这个头部。
+	traceIDHeader = "X-Request-Id"
+)
+
+// traceIDKey 是追踪 ID 在 context 中使用的 key。
+type traceIDKey struct{}
+
+// ContextWithTraceID 返回一个带有追踪 ID 的 context，客户端会把这个追踪 ID 传递给服务端。
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFrom 返回 context 中的追踪 ID，如果没有就返回空字符串。
+func TraceIDFrom(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// slowLog 在请求的处理时间超过了 SlowLogThreshold 时记录一条慢日志，日志中会带上追踪 ID，方便从应用一路追踪到服务端。
+func slowLog(options *Options, traceID string, operation string, beginTime time.Time) {
+	if options.SlowLogThreshold <= 0 {
+		return
+	}
+
+	cost := time.Since(beginTime)
+	if cost >= options.SlowLogThreshold {
+		helpers.Warn("Slow request", "trace", traceID, "operation", operation, "cost", cost)
+	}
+}
+
+// traceHandler 包装 handler，从请求头中获取追踪 ID，没有的话就生成一个新的，然后放到 context 和响应头中，并记录慢日志。
+func traceHandler(options *liveOptions, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		traceID := request.Header.Get(traceIDHeader)
+		if traceID == "" {
+			traceID = helpers.NewTraceID()
+		}
+
+		// 请求的 context 中带上 span，缓存操作的 span 就会成为它的子 span
+		ctx := ContextWithTraceID(tracing.ContextWithRemoteTraceID(request.Context(), traceID), traceID)
+		ctx, span := tracing.Start(ctx, "kafo.http "+request.Method, tracing.Attr("http.method", request.Method),
+			tracing.Attr("http.target", request.URL.Path), tracing.Attr("kafo.trace_id", traceID))
+		defer span.End(nil)
+
+		beginTime := time.Now()
+		writer.Header().Set(traceIDHeader, traceID)
+		handler.ServeHTTP(writer, request.WithContext(ctx))
+		slowLog(options.load(), traceID, request.Method+" "+request.URL.Path, beginTime)
+		observeRequest(request.Method, beginTime)
+	})
+}
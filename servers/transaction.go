@@ -0,0 +1,77 @@
+package servers
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+)
+
+var (
+	errInvalidTransaction = errors.New("invalid transaction arguments")
+
+	errTransactionCrossNode = errors.New("keys in transaction don't belong to the same node")
+)
+
+// encodeOperations 将事务中的操作编码成命令的参数。
+// 每个操作都以一个字节的操作类型开头，get 和 delete 后面跟着 key，set 后面跟着 ttl、key 和 value。
+func encodeOperations(ops []caches.Operation) [][]byte {
+	args := make([][]byte, 0, len(ops)*4)
+	for _, op := range ops {
+		args = append(args, []byte{op.Type})
+		if op.Type == caches.OpSet {
+			ttl := make([]byte, 8)
+			binary.BigEndian.PutUint64(ttl, uint64(op.Ttl))
+			args = append(args, ttl, []byte(op.Key), op.Value)
+			continue
+		}
+		args = append(args, []byte(op.Key))
+	}
+	return args
+}
+
+// decodeOperations 从命令的参数中解析出事务中的操作。
+func decodeOperations(args [][]byte) ([]caches.Operation, error) {
+	ops := make([]caches.Operation, 0, len(args)/2)
+	for i := 0; i < len(args); {
+		if len(args[i]) != 1 {
+			return nil, errInvalidTransaction
+		}
+
+		op := caches.Operation{Type: args[i][0]}
+		if op.Type == caches.OpSet {
+			if i+3 >= len(args) || len(args[i+1]) != 8 {
+				return nil, errInvalidTransaction
+			}
+			op.Ttl = int64(binary.BigEndian.Uint64(args[i+1]))
+			op.Key = string(args[i+2])
+			op.Value = args[i+3]
+			i += 4
+		} else {
+			if i+1 >= len(args) {
+				return nil, errInvalidTransaction
+			}
+			op.Key = string(args[i+1])
+			i += 2
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// ownerOfOperations 返回事务中所有 key 所属的节点，如果 key 不属于同一个节点就返回错误。
+func (n *node) ownerOfOperations(ops []caches.Operation) (string, error) {
+	owner := ""
+	for _, op := range ops {
+		node, err := n.selectNode(op.Key)
+		if err != nil {
+			return "", err
+		}
+
+		if owner != "" && node != owner {
+			return "", errTransactionCrossNode
+		}
+		owner = node
+	}
+	return owner, nil
+}
@@ -0,0 +1,311 @@
+// Package servertest 在当前进程中启动缓存服务器，用于编写不需要外部进程的集成测试。
+//
+// 服务器监听在随机的空闲端口上，测试结束的时候会自动关闭：
+//
+//	func TestSomething(t *testing.T) {
+//		server := servertest.NewServer(t)
+//		client := server.Client()
+//		client.Set("key", []byte("value"), 0)
+//	}
+//
+// 也可以启动一个小的集群，测试重定向和重新均衡之类的逻辑：
+//
+//	cluster := servertest.NewCluster(t, 3)
+//	client := cluster.Client()
+package servertest
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/herrhu97/go-distributed-cache/caches"
+	"github.com/herrhu97/go-distributed-cache/helpers"
+	"github.com/herrhu97/go-distributed-cache/servers"
+)
+
+const (
+	// startTimeout 是等待服务器开始监听或者集群中的节点互相发现的最长时间。
+	startTimeout = 10 * time.Second
+
+	// startRetries 是创建服务器失败的重试次数，选出来的空闲端口在使用之前可能被别的进程占用，换一个端口重试就可以了。
+	startRetries = 3
+)
+
+// Config 是测试服务器的配置。
+type Config struct {
+	// Cache 是缓存的选项配置，默认是 caches.DefaultOptions()，但是不会持久化。
+	Cache caches.Options
+
+	// Server 是服务器的选项配置，默认是 servers.DefaultOptions()，端口和集群会被替换成测试使用的值。
+	Server servers.Options
+}
+
+// Option 修改测试服务器的配置。
+type Option func(config *Config)
+
+// WithServerType 设置服务器的类型，可以是 tcp 或者 http，默认是 tcp。
+func WithServerType(serverType string) Option {
+	return func(config *Config) {
+		config.Server.ServerType = serverType
+	}
+}
+
+// WithCacheOptions 使用 options 替换缓存的选项配置。
+func WithCacheOptions(options caches.Options) Option {
+	return func(config *Config) {
+		config.Cache = options
+	}
+}
+
+// WithServerOptions 使用 options 替换服务器的选项配置，端口和集群仍然会被替换成测试使用的值。
+func WithServerOptions(options servers.Options) Option {
+	return func(config *Config) {
+		config.Server = options
+	}
+}
+
+// newConfig 返回使用 opts 修改过的默认配置。
+func newConfig(opts []Option) *Config {
+	config := &Config{
+		Cache:  caches.DefaultOptions(),
+		Server: servers.DefaultOptions(),
+	}
+	config.Cache.DumpFile = ""
+
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// Server 是运行在当前进程中的缓存服务器。
+type Server struct {
+	// Address 是服务器的访问地址，比如 127.0.0.1:50123。
+	Address string
+
+	// URL 是 HTTP 服务器的接口地址，比如 http://127.0.0.1:50123/v1，TCP 服务器为空。
+	URL string
+
+	// GossipAddress 是节点之间同步集群信息的地址，其他节点可以通过这个地址加入集群。
+	GossipAddress string
+
+	// Cache 是服务器内部使用的缓存，可以直接用来准备或者检查数据。
+	Cache *caches.Cache
+
+	// Options 是服务器实际使用的选项配置。
+	Options servers.Options
+
+	// tb 用于报告错误以及注册清理函数。
+	tb testing.TB
+
+	// server 是真正提供服务的服务器。
+	server servers.Server
+
+	// done 会在 Run 返回之后收到 Run 的返回值。
+	done chan error
+
+	// closeOnce 保证服务器只会被关闭一次。
+	closeOnce *sync.Once
+}
+
+// NewServer 启动一个单节点的服务器，等到服务器开始监听之后才返回，测试结束的时候会自动关闭。
+func NewServer(tb testing.TB, opts ...Option) *Server {
+	tb.Helper()
+	return startServer(tb, newConfig(opts), nil)
+}
+
+// startServer 使用 config 启动一个服务器，cluster 不为空的话会加入这个集群。
+func startServer(tb testing.TB, config *Config, cluster []string) *Server {
+	tb.Helper()
+
+	var lastErr error
+	for i := 0; i < startRetries; i++ {
+		server, err := tryStartServer(tb, *config, cluster)
+		if err == nil {
+			tb.Cleanup(func() {
+				server.Close()
+			})
+			return server
+		}
+		lastErr = err
+	}
+
+	tb.Fatalf("servertest: failed to start server: %v", lastErr)
+	return nil
+}
+
+// tryStartServer 使用随机的空闲端口启动一个服务器，端口被占用的时候会返回错误，由调用方换一个端口重试。
+func tryStartServer(tb testing.TB, config Config, cluster []string) (*Server, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	gossipPort, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	options := config.Server
+	options.Address = "127.0.0.1"
+	options.Port = port
+	options.GossipPort = gossipPort
+	options.Cluster = cluster
+	if err = options.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err = config.Cache.Validate(); err != nil {
+		return nil, err
+	}
+
+	cache := caches.NewCacheWith(config.Cache)
+	server, err := servers.NewServer(cache, options)
+	if err != nil {
+		cache.Close()
+		return nil, err
+	}
+
+	s := &Server{
+		Address:       helpers.JoinAddressAndPort(options.Address, options.Port),
+		GossipAddress: helpers.JoinAddressAndPort(options.Address, options.GossipPort),
+		Cache:         cache,
+		Options:       options,
+		tb:            tb,
+		server:        server,
+		done:          make(chan error, 1),
+		closeOnce:     &sync.Once{},
+	}
+	if options.ServerType != "tcp" {
+		s.URL = "http://" + s.Address + "/" + servers.APIVersion
+	}
+
+	go func() {
+		s.done <- server.Run()
+	}()
+
+	if err = s.waitForListening(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// waitForListening 等待服务器开始监听，Run 提前返回的话说明启动失败了。
+func (s *Server) waitForListening() error {
+	deadline := time.Now().Add(startTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-s.done:
+			return fmt.Errorf("server stopped before listening: %v", err)
+		default:
+		}
+
+		conn, err := net.DialTimeout("tcp", s.Address, time.Second)
+		if err == nil {
+			return conn.Close()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("server %s is not listening after %v", s.Address, startTimeout)
+}
+
+// Client 返回一个连接到这个服务器的 TCP 客户端，测试结束的时候会自动关闭，只有 TCP 服务器才能使用。
+func (s *Server) Client() *servers.TCPClient {
+	s.tb.Helper()
+	if s.Options.ServerType != "tcp" {
+		s.tb.Fatalf("servertest: %s server doesn't support tcp clients, use URL instead", s.Options.ServerType)
+	}
+
+	client, err := servers.NewTCPClient(s.Address)
+	if err != nil {
+		s.tb.Fatalf("servertest: failed to create client of %s: %v", s.Address, err)
+	}
+
+	s.tb.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+// Nodes 返回这个服务器看到的集群中所有节点的地址。
+func (s *Server) Nodes() []string {
+	switch server := s.server.(type) {
+	case *servers.TCPServer:
+		return server.Nodes()
+	case *servers.HTTPServer:
+		return server.Nodes()
+	default:
+		return nil
+	}
+}
+
+// Close 离开集群并关闭服务器和缓存，可以在测试结束之前调用，用于模拟节点下线。
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		if err := s.server.Close(); err != nil {
+			s.tb.Logf("servertest: failed to close server %s: %v", s.Address, err)
+		}
+		s.Cache.Close()
+	})
+}
+
+// Cluster 是运行在当前进程中的缓存集群。
+type Cluster struct {
+	// Servers 是集群中的所有服务器，第一个服务器是其他服务器加入集群时使用的种子节点。
+	Servers []*Server
+}
+
+// NewCluster 启动一个有 size 个节点的集群，等到每个节点都发现了所有的节点之后才返回，测试结束的时候会自动关闭。
+// 所有的节点都使用 opts 修改之后的配置。
+func NewCluster(tb testing.TB, size int, opts ...Option) *Cluster {
+	tb.Helper()
+	if size <= 0 {
+		tb.Fatalf("servertest: invalid cluster size %d: must be greater than 0", size)
+	}
+
+	config := newConfig(opts)
+	cluster := &Cluster{Servers: make([]*Server, 0, size)}
+	seed := startServer(tb, config, nil)
+	cluster.Servers = append(cluster.Servers, seed)
+	for i := 1; i < size; i++ {
+		cluster.Servers = append(cluster.Servers, startServer(tb, config, []string{seed.GossipAddress}))
+	}
+
+	if err := cluster.waitForMembers(size); err != nil {
+		tb.Fatalf("servertest: %v", err)
+	}
+	return cluster
+}
+
+// waitForMembers 等待每个节点都看到 size 个节点。
+func (c *Cluster) waitForMembers(size int) error {
+	deadline := time.Now().Add(startTimeout)
+	for _, server := range c.Servers {
+		for len(server.Nodes()) != size {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("server %s sees %d nodes instead of %d after %v", server.Address, len(server.Nodes()), size, startTimeout)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// Client 返回一个连接到集群的 TCP 客户端，客户端会自动发现集群中的其他节点，测试结束的时候会自动关闭。
+func (c *Cluster) Client() *servers.TCPClient {
+	return c.Servers[0].Client()
+}
+
+// freePort 返回一个当前空闲的端口。
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
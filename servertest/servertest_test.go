@@ -0,0 +1,66 @@
+package servertest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// go test -v -run=^TestNewServer$
+func TestNewServer(t *testing.T) {
+	server := NewServer(t)
+	client := server.Client()
+	if err := client.Set("key", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := server.Cache.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("value %s is wrong", value)
+	}
+
+	httpServer := NewServer(t, WithServerType("http"))
+	httpServer.Cache.Set("key", []byte("value"))
+	response, err := http.Get(httpServer.URL + "/cache/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusOK || string(body) != "value" {
+		t.Fatalf("response %d %s is wrong", response.StatusCode, body)
+	}
+}
+
+// go test -v -run=^TestNewCluster$
+func TestNewCluster(t *testing.T) {
+	cluster := NewCluster(t, 3)
+	client := cluster.Client()
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := client.Set(key, []byte(key), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 数据会按照一致性哈希分布到每个节点上
+	total := 0
+	for _, server := range cluster.Servers {
+		total += server.Cache.Status().Count
+	}
+
+	if total != 30 {
+		t.Fatalf("total %d should be 30", total)
+	}
+
+	// 节点下线之后其他节点很快就看不到它了
+	cluster.Servers[2].Close()
+	if err := (&Cluster{Servers: cluster.Servers[:2]}).waitForMembers(2); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,100 @@
+// Package tracing 是分布式追踪的埋点接口，缓存、服务器和客户端都会在关键的位置开启 span。
+// 这里没有直接依赖 OpenTelemetry，而是定义了和它一样形状的接口，默认什么都不做，
+// 需要的时候使用 SetTracer 设置一个包装了 OpenTelemetry Tracer 的实现，缓存的耗时就会出现在分布式追踪中了。
+package tracing
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Attribute 是 span 上的一个属性。
+type Attribute struct {
+	Key string
+
+	Value interface{}
+}
+
+// Attr 返回一个 span 的属性。
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer 用于开启 span，实现需要是并发安全的，并且不能有太大的开销。
+type Tracer interface {
+	// Start 开启一个名字是 name 的 span，返回的 context 中需要带上这个 span，这样后续开启的 span 就是它的子 span。
+	Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span)
+}
+
+// Span 是追踪中的一段操作。
+type Span interface {
+	// SetAttributes 给 span 添加属性。
+	SetAttributes(attributes ...Attribute)
+
+	// TraceID 返回 span 所在追踪的 ID，客户端会把它作为追踪 ID 传递给服务端，不支持的话返回空字符串。
+	TraceID() string
+
+	// End 结束这个 span，err 不为 nil 的话需要把 span 标记为失败。
+	End(err error)
+}
+
+// tracerHolder 包装了 Tracer，因为 atomic.Value 要求每次存储的类型都是一样的。
+type tracerHolder struct {
+	tracer Tracer
+}
+
+var (
+	// currentTracer 存储着当前使用的 *tracerHolder。
+	currentTracer atomic.Value
+)
+
+func init() {
+	currentTracer.Store(&tracerHolder{tracer: noopTracer{}})
+}
+
+// SetTracer 设置全局使用的 Tracer，设置为 nil 的话就关闭追踪。
+func SetTracer(tracer Tracer) {
+	if tracer == nil {
+		tracer = noopTracer{}
+	}
+	currentTracer.Store(&tracerHolder{tracer: tracer})
+}
+
+// Start 使用全局的 Tracer 开启一个 span。
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	return currentTracer.Load().(*tracerHolder).tracer.Start(ctx, name, attributes...)
+}
+
+// remoteTraceIDKey 是远程调用方的追踪 ID 在 context 中使用的 key。
+type remoteTraceIDKey struct{}
+
+// ContextWithRemoteTraceID 返回一个带有远程调用方追踪 ID 的 context，服务端在开启 span 之前会调用它，
+// Tracer 的实现可以使用 RemoteTraceIDFrom 取出追踪 ID，把服务端的 span 关联到客户端的追踪中。
+func ContextWithRemoteTraceID(ctx context.Context, traceID string) context.Context {
+	if traceID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteTraceIDKey{}, traceID)
+}
+
+// RemoteTraceIDFrom 返回 context 中远程调用方的追踪 ID，没有的话返回空字符串。
+func RemoteTraceIDFrom(ctx context.Context) string {
+	traceID, _ := ctx.Value(remoteTraceIDKey{}).(string)
+	return traceID
+}
+
+// noopTracer 是什么都不做的 Tracer，没有设置 Tracer 的时候使用。
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+// noopSpan 是什么都不做的 Span。
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attributes ...Attribute) {}
+
+func (noopSpan) TraceID() string { return "" }
+
+func (noopSpan) End(err error) {}